@@ -4,184 +4,261 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
-	"strings"
-	"time"
+	"os/signal"
+	"syscall"
 
 	//
+	"kegos/internal/api"
+	"kegos/internal/config"
 	"kegos/internal/globals"
+	"kegos/internal/health"
+	"kegos/internal/metrics"
 	"kegos/internal/runner"
+	"kegos/internal/version"
 )
 
-var (
-	flagGsuiteCredentials    = flag.String("gsuite-credentials", "", "Path to GSuite JSON credentials file (required)")
-	flagGsuiteDomains        = flag.String("gsuite-domains", "", "Comma-separated list of Google Workspace domains where groups live (required)")
-	flagUserRateLimit        = flag.Int("user-rate-limit", 60, "Max users processed per minute against the Google API (0 disables throttling)")
-	flagKeycloakRealm        = flag.String("keycloak-realm", "", "Keycloak realm (required)")
-	flagKeycloakURI          = flag.String("keycloak-uri", "", "Keycloak URI (required)")
-	flagKeycloakClientID     = flag.String("keycloak-client-id", "", "Keycloak client ID (required)")
-	flagKeycloakClientSecret = flag.String("keycloak-client-secret", "", "Keycloak client secret (required)")
-	flagReconcileInterval    = flag.Duration("reconcile-interval", 10*time.Minute, "Reconcile loop duration")
-	flagSyncedParentGroup    = flag.String("synced-parent-group", "", "Keycloak group where to sync Gsuite groups")
-	flagLogLevel             = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
-	help                     = flag.Bool("help", false, "Show help")
-)
+func main() {
 
-// getValueFromFlagOrEnv returns the value from flag if not empty, otherwise from environment variable
-func getValueFromFlagOrEnv(flagValue *string, envVar string) string {
-	if *flagValue != "" {
-		return *flagValue
+	cfg, err := config.Load(config.LoadOptions{Args: os.Args[1:]})
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if errors.Is(err, config.ErrVersion) {
+		fmt.Println(version.String())
+		os.Exit(0)
 	}
-	return os.Getenv(envVar)
-}
 
-// splitDomains parses a comma-separated domain list into a trimmed, non-empty slice
-func splitDomains(raw string) []string {
-	var domains []string
-	for _, domain := range strings.Split(raw, ",") {
-		domain = strings.TrimSpace(domain)
-		if domain != "" {
-			domains = append(domains, domain)
+	// Quit on errors
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		fmt.Fprintf(os.Stderr, "Error: Invalid arguments:\n")
+		for _, problem := range validationErr.Problems {
+			fmt.Fprintf(os.Stderr, "  * %s\n", problem)
 		}
+		fmt.Fprintf(os.Stderr, "\nUse --help for usage information.\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(2)
 	}
-	return domains
-}
 
-// flagWasSet reports whether the named flag was explicitly provided on the command line.
-func flagWasSet(name string) bool {
-	set := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			set = true
+	// Inline credentials need no file
+	if cfg.GsuiteCredentials != "" {
+		if _, err := os.Stat(cfg.GsuiteCredentials); os.IsNotExist(err) {
+			log.Fatalf("GSuite credentials file does not exist: %s", cfg.GsuiteCredentials)
 		}
-	})
-	return set
-}
-
-// resolveInt applies flag-over-env precedence for an int: an explicit flag wins, otherwise a
-// parseable env var, otherwise the flag default.
-func resolveInt(flagSet bool, flagValue int, envRaw string) int {
-	if flagSet {
-		return flagValue
 	}
-	if parsed, err := strconv.Atoi(envRaw); err == nil {
-		return parsed
+
+	// The diff report and the export own stdout, so logs are moved aside to keep them parseable
+	logOutput := os.Stdout
+	if cfg.Mode == config.ModeDiff || cfg.Mode == config.ModeExport {
+		logOutput = os.Stderr
 	}
-	return flagValue
-}
 
-func main() {
+	// Cancel everything on termination so a reconcile is never killed halfway
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	flag.Parse()
-
-	// Show help when required
-	if *help {
-		fmt.Printf("Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
-		fmt.Printf("\nEnvironment Variables (override flags):\n")
-		fmt.Printf("  GSUITE_CREDENTIALS     - Path to GSuite JSON credentials file\n")
-		fmt.Printf("  GSUITE_DOMAINS         - Comma-separated list of Google Workspace domains where groups live\n")
-		fmt.Printf("  KEYCLOAK_REALM         - Keycloak realm\n")
-		fmt.Printf("  KEYCLOAK_URI           - Keycloak URI\n")
-		fmt.Printf("  KEYCLOAK_CLIENT_ID     - Keycloak client ID\n")
-		fmt.Printf("  KEYCLOAK_CLIENT_SECRET - Keycloak client secret\n")
-		fmt.Printf("  LOG_LEVEL              - Log level (debug, info, warn, error)\n")
-		fmt.Printf("  SYNCED_PARENT_GROUP    - Keycloak group where to sync Gsuite groups\n")
-		fmt.Printf("  USER_RATE_LIMIT        - Max users processed per minute against the Google API\n")
+	//
+	appCtx, err := globals.NewApplicationContext(globals.ApplicationContextOptions{
+		Context:   signalCtx,
+		LogLevel:  cfg.LogLevel,
+		LogFormat: cfg.LogFormat,
+		LogOutput: logOutput,
 
-		os.Exit(0)
+		LogIncludeSource: cfg.LogIncludeSource,
+	})
+	if err != nil {
+		log.Fatalf("failed creating application context: %v", err.Error())
 	}
 
-	// Get final values from flags or environment variables
-	gsuiteCredentials := getValueFromFlagOrEnv(flagGsuiteCredentials, "GSUITE_CREDENTIALS")
-	gsuiteDomains := splitDomains(getValueFromFlagOrEnv(flagGsuiteDomains, "GSUITE_DOMAINS"))
-	keycloakRealm := getValueFromFlagOrEnv(flagKeycloakRealm, "KEYCLOAK_REALM")
-	keycloakURI := getValueFromFlagOrEnv(flagKeycloakURI, "KEYCLOAK_URI")
-	keycloakClientID := getValueFromFlagOrEnv(flagKeycloakClientID, "KEYCLOAK_CLIENT_ID")
-	keycloakClientSecret := getValueFromFlagOrEnv(flagKeycloakClientSecret, "KEYCLOAK_CLIENT_SECRET")
-	logLevel := getValueFromFlagOrEnv(flagLogLevel, "LOG_LEVEL")
-	syncedParentGroup := getValueFromFlagOrEnv(flagSyncedParentGroup, "SYNCED_PARENT_GROUP")
-	userRateLimit := resolveInt(flagWasSet("user-rate-limit"), *flagUserRateLimit, os.Getenv("USER_RATE_LIMIT"))
-
-	// Validate flags compliance
-	var errors []string
-
-	if gsuiteCredentials == "" {
-		errors = append(errors, "--gsuite-credentials is required")
-	}
-	if len(gsuiteDomains) == 0 {
-		errors = append(errors, "--gsuite-domains is required")
-	}
-	if keycloakRealm == "" {
-		errors = append(errors, "--keycloak-realm is required")
-	}
-	if keycloakURI == "" {
-		errors = append(errors, "--keycloak-uri is required")
-	}
-	if keycloakClientID == "" {
-		errors = append(errors, "--keycloak-client-id is required")
-	}
-	if keycloakClientSecret == "" {
-		errors = append(errors, "--keycloak-client-secret is required")
+	appCtx.Logger.Info("starting kegos", "version", version.Version, "commit", version.Commit, "build_date", version.Date)
+	metrics.BuildInfo.WithLabelValues(version.Version, version.Commit, version.Date).Set(1)
+
+	// 1. Expose metrics when requested
+	if cfg.MetricsAddress != "" {
+		metricsServer := metrics.NewServer(metrics.ServerOptions{
+			AppCtx:  appCtx,
+			Address: cfg.MetricsAddress,
+		})
+		go metricsServer.Run()
 	}
 
-	if syncedParentGroup == "" {
-		errors = append(errors, "--synced-parent-group is required")
+	// 2. Expose health probes when requested
+	readiness := health.NewReadiness(cfg.ReadinessFailures)
+	if cfg.HealthAddress != "" {
+		healthServer := health.NewServer(health.ServerOptions{
+			AppCtx:    appCtx,
+			Address:   cfg.HealthAddress,
+			Readiness: readiness,
+		})
+		go healthServer.Run()
 	}
 
-	_, levelFound := globals.LogLevelMap[*flagLogLevel]
-	if !levelFound {
-		errors = append(errors, "--log-level must be one of: debug, info, warn, error")
+	// 3. Launch the runner
+	leRunner, err := runner.NewRunner(runner.RunnerOptions{
+		AppCtx:                    appCtx,
+		GsuiteJsonCredentialsPath: cfg.GsuiteCredentials,
+		GsuiteJsonCredentials:     cfg.GsuiteCredentialsJSON,
+		GsuiteImpersonateSubject:  cfg.GsuiteImpersonateSubject,
+		GsuiteDomains:             cfg.GsuiteDomains,
+		GsuitePrefetch:            cfg.GsuitePrefetch,
+		GsuiteGroupQuery:          cfg.GsuiteGroupQuery,
+		GsuiteMemberRoles:         cfg.IncludeMemberRoles,
+		GsuiteQPS:                 cfg.GsuiteQPS,
+		GsuiteConcurrency:         cfg.GsuiteConcurrency,
+		GsuiteEndpoint:            cfg.GsuiteEndpoint,
+		ResolveNestedGroups:       cfg.ResolveNestedGroups,
+		GroupIncludePatterns:      cfg.GroupIncludeRegex,
+		GroupExcludePatterns:      cfg.GroupExcludeRegex,
+		RestrictToDomain:          cfg.RestrictToDomain,
+		GroupNameStripDomain:      cfg.GroupNameStripDomain,
+		GroupNameMapperName:       cfg.GroupNameMapper,
+		GroupNameSanitize:         cfg.GroupNameSanitize,
+		GroupNamePrefix:           cfg.GroupNamePrefix,
+		GroupHierarchyDelimiter:   cfg.GroupHierarchyDelimiter,
+		GroupMappingFile:          cfg.GroupMappingFile,
+		CopyGroupDescription:      cfg.GroupCopyDescription,
+		UserRateLimit:             cfg.UserRateLimit,
+		UserMatchAttribute:        cfg.UserMatchAttribute,
+		ReportUnmatchedMembers:    cfg.ReportUnmatchedMembers,
+		UserEnabledOnly:           cfg.UserEnabledOnly,
+		UserRequireEmail:          cfg.UserRequireEmail,
+		SkipFederatedUsers:        cfg.SkipFederatedUsers,
+		UserAttributeMatches:      cfg.UserAttributeMatch,
+		ExcludedUsers:             cfg.ExcludeUsers,
+		ExcludedUsersFile:         cfg.ExcludeUsersFile,
+		OnlyGroups:                cfg.OnlyGroups,
+		OnlyGroupsFile:            cfg.OnlyGroupsFile,
+		KeycloakRealms:            cfg.KeycloakRealmTargets(),
+		KeycloakURI:               cfg.KeycloakURI,
+		KeycloakClientID:          cfg.KeycloakClientID,
+		KeycloakAuthRealm:         cfg.KeycloakAuthRealm,
+		KeycloakClientSecret:      cfg.KeycloakClientSecret,
+		KeycloakClientJWTKeyPath:  cfg.KeycloakClientJWTKey,
+		KeycloakTimeout:           cfg.KeycloakTimeout,
+		KeycloakMaxRetryAfterWait: cfg.KeycloakMaxRetryAfter,
+		KeycloakGroupSearchExact:  cfg.KeycloakGroupSearchExact,
+		KeycloakCACertPath:        cfg.KeycloakCACert,
+		KeycloakInsecure:          cfg.KeycloakInsecure,
+		KeycloakConcurrency:       cfg.KeycloakConcurrency,
+		KeycloakUserBatchSize:     cfg.KeycloakUserBatchSize,
+		KeycloakGroupBatchSize:    cfg.KeycloakGroupBatchSize,
+		ReconcileLoopDuration:     cfg.ReconcileInterval,
+		ReconcileJitter:           cfg.ReconcileJitter,
+		BreakerFailures:           cfg.BreakerFailures,
+		BreakerMaxBackoff:         cfg.BreakerMaxBackoff,
+		SyncedParentGroup:         cfg.SyncedParentGroup,
+		SyncedParentGroupPath:     cfg.SyncedParentGroupPath,
+		SyncedParentGroupsFile:    cfg.SyncedParentGroupsFile,
+		SyncTarget:                cfg.SyncTarget,
+		Direction:                 cfg.Direction,
+		DryRun:                    cfg.DryRun,
+		ReadOnly:                  cfg.Mode == config.ModeDiff || cfg.Mode == config.ModeExport,
+		PruneGroups:               cfg.PruneGroups,
+		MaxDeletionsPerCycle:      cfg.MaxDeletionsPerCycle,
+		SoftDelete:                cfg.SoftDelete,
+		SoftDeleteGrace:           cfg.SoftDeleteGrace,
+		DebounceCycles:            cfg.DebounceCycles,
+		AnnotateUsers:             cfg.AnnotateUsers,
+		Incremental:               cfg.Incremental,
+		MaxUsers:                  cfg.MaxUsers,
+		MaxGroups:                 cfg.MaxGroups,
+		MaxRetries:                cfg.MaxRetries,
+		APITimeout:                cfg.APITimeout,
+		HTTPProxy:                 cfg.HTTPProxy,
+		HTTPSProxy:                cfg.HTTPSProxy,
+		RetryBaseDelay:            cfg.RetryBaseDelay,
+		Readiness:                 readiness,
+		FailOnPartial:             cfg.FailOnPartial,
+		HeartbeatFile:             cfg.HeartbeatFile,
+		AuditLogFile:              cfg.AuditLogFile,
+		StateFile:                 cfg.StateFile,
+		NotifyWebhookURL:          cfg.NotifyWebhookURL,
+		NotifyOnFailureOnly:       cfg.NotifyOnFailureOnly,
+	})
+	if err != nil {
+		log.Fatalf("failed creating runner: %v", err.Error())
 	}
 
-	// Validate edge cases
-	if *flagReconcileInterval <= 0 {
-		errors = append(errors, "--reconcile-interval must be positive")
+	// 4. Reconcile single users on demand when requested
+	if cfg.APIAddress != "" {
+		apiServer := api.NewServer(api.ServerOptions{
+			AppCtx:     appCtx,
+			Address:    cfg.APIAddress,
+			Reconciler: leRunner,
+		})
+		go apiServer.Run()
 	}
 
-	// Quit on errors
-	if len(errors) > 0 {
-		fmt.Fprintf(os.Stderr, "Error: Invalid arguments:\n")
-		for _, err := range errors {
-			fmt.Fprintf(os.Stderr, "  * %s\n", err)
+	// Building the runner already checked the access needed, the rest is read without changing anything
+	if cfg.Mode == config.ModeValidate {
+		if err := leRunner.Validate(); err != nil {
+			appCtx.Logger.Error("validation failed", "error", err.Error())
+			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "\nUse --help for usage information.\n")
-		os.Exit(1)
+		appCtx.Logger.Info("validation passed")
+		return
 	}
 
-	//
-	if _, err := os.Stat(gsuiteCredentials); os.IsNotExist(err) {
-		log.Fatalf("GSuite credentials file does not exist: %s", gsuiteCredentials)
+	if cfg.Mode == config.ModeDiff {
+		diffs, err := leRunner.Diff()
+		if diffs != nil {
+			if writeErr := runner.WriteDiffReport(os.Stdout, cfg.ReportFormat, diffs); writeErr != nil {
+				log.Fatalf("failed writing diff: %v", writeErr.Error())
+			}
+		}
+		if err != nil {
+			appCtx.Logger.Error("diff failed", "error", err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	//
-	appCtx, err := globals.NewApplicationContext(globals.ApplicationContextOptions{
-		LogLevel: logLevel,
-	})
-	if err != nil {
-		log.Fatalf("failed creating application context: %v", err.Error())
+	if cfg.Mode == config.ModeExport {
+		groups, err := leRunner.ExportManagedState()
+		if writeErr := runner.WriteExportReport(os.Stdout, cfg.ReportFormat, groups); writeErr != nil {
+			log.Fatalf("failed writing export: %v", writeErr.Error())
+		}
+		if err != nil {
+			appCtx.Logger.Error("export failed", "error", err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	// 1. Launch the runner
-	leRunner, err := runner.NewRunner(runner.RunnerOptions{
-		AppCtx:                    appCtx,
-		GsuiteJsonCredentialsPath: gsuiteCredentials,
-		GsuiteDomains:             gsuiteDomains,
-		UserRateLimit:             userRateLimit,
-		KeycloakRealm:             keycloakRealm,
-		KeycloakURI:               keycloakURI,
-		KeycloakClientID:          keycloakClientID,
-		KeycloakClientSecret:      keycloakClientSecret,
-		ReconcileLoopDuration:     *flagReconcileInterval,
-		SyncedParentGroup:         syncedParentGroup,
-	})
-	if err != nil {
-		log.Fatalf("failed creating runner: %v", err.Error())
+	if cfg.Once {
+		err = leRunner.ReconcileOnce()
+		if closeErr := leRunner.Close(); closeErr != nil {
+			appCtx.Logger.Error("failed closing audit log", "error", closeErr.Error())
+		}
+
+		// A cycle that ran to the end with some failed operations only fails with --fail-on-partial, exiting
+		// apart from an aborted one
+		var cycleErr *runner.CycleError
+		if !runner.CycleAborted(err) && errors.As(err, &cycleErr) {
+			if leRunner.CycleFailed(err) {
+				appCtx.Logger.Error("reconcile finished with failures", "errors", len(cycleErr.Failures))
+				os.Exit(2)
+			}
+			appCtx.Logger.Warn("reconcile finished with failures. Not failing without --fail-on-partial", "errors", len(cycleErr.Failures))
+			return
+		}
+		if err != nil {
+			appCtx.Logger.Error("reconcile failed", "error", err.Error())
+			os.Exit(1)
+		}
+		appCtx.Logger.Info("reconcile finished")
+		return
 	}
 
 	leRunner.PleaseDoYourStuffForever()
+	appCtx.Logger.Info("shutting down")
 }
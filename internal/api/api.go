@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	//
+	"kegos/internal/globals"
+	"kegos/internal/runner"
+)
+
+const (
+	// shutdownTimeout bounds how long in-flight requests may take once the process is exiting
+	shutdownTimeout = 5 * time.Second
+
+	// maxRequestBytes bounds the body of a request, which only carries a user identifier
+	maxRequestBytes = 4 << 10
+)
+
+// UserReconciler reconciles the groups of a single user on demand
+type UserReconciler interface {
+	ReconcileUser(identifier string) error
+}
+
+// ReconcileUserRequest is the body of POST /reconcile/user. User is a username or an email
+type ReconcileUserRequest struct {
+	User string `json:"user"`
+}
+
+type ServerOptions struct {
+	AppCtx *globals.ApplicationContext
+
+	Address    string
+	Reconciler UserReconciler
+}
+
+type Server struct {
+	appCtx *globals.ApplicationContext
+
+	httpServer *http.Server
+}
+
+func NewServer(opts ServerOptions) *Server {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reconcile/user", func(w http.ResponseWriter, req *http.Request) {
+		var request ReconcileUserRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBytes)).Decode(&request)
+		if err != nil || strings.TrimSpace(request.User) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("expected a JSON body like {\"user\": \"alice@example.com\"}\n"))
+			return
+		}
+
+		opts.AppCtx.Logger.Info("reconciling user on demand", "user", request.User)
+		err = opts.Reconciler.ReconcileUser(request.User)
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("ok\n"))
+		case errors.Is(err, runner.ErrUserNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("user not found\n"))
+		default:
+			// Details are kept to the logs, as they may tell more about the realm than callers should know
+			opts.AppCtx.Logger.Error("failed reconciling user on demand", "user", request.User, "error", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("reconcile failed\n"))
+		}
+	})
+
+	return &Server{
+		appCtx: opts.AppCtx,
+		httpServer: &http.Server{
+			Addr:    opts.Address,
+			Handler: mux,
+		},
+	}
+}
+
+// Run serves the API until the application context is done
+func (s *Server) Run() {
+
+	go func() {
+		<-s.appCtx.Context.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil {
+			s.appCtx.Logger.Error("failed shutting down API server", "error", err.Error())
+		}
+	}()
+
+	s.appCtx.Logger.Info("starting API server", "address", s.httpServer.Addr)
+	err := s.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.appCtx.Logger.Error("failed serving API", "error", err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	//
+	"kegos/internal/globals"
+	"kegos/internal/runner"
+)
+
+// fakeReconciler records the users it is asked to reconcile and fails with err.
+type fakeReconciler struct {
+	users []string
+	err   error
+}
+
+func (f *fakeReconciler) ReconcileUser(identifier string) error {
+	f.users = append(f.users, identifier)
+	return f.err
+}
+
+// POST /reconcile/user must reconcile the user in the body, and map the outcome to a status code.
+func TestReconcileUserEndpoint(t *testing.T) {
+	tests := map[string]struct {
+		method       string
+		body         string
+		err          error
+		wantStatus   int
+		wantUsers    []string
+		wantResponse string
+	}{
+		"reconciled": {
+			method: http.MethodPost, body: `{"user":"alice@corp.com"}`,
+			wantStatus: http.StatusOK, wantUsers: []string{"alice@corp.com"}, wantResponse: "ok\n",
+		},
+		"unknown user": {
+			method: http.MethodPost, body: `{"user":"carol@corp.com"}`, err: fmt.Errorf("%w: carol@corp.com", runner.ErrUserNotFound),
+			wantStatus: http.StatusNotFound, wantUsers: []string{"carol@corp.com"}, wantResponse: "user not found\n",
+		},
+		"failed": {
+			method: http.MethodPost, body: `{"user":"alice@corp.com"}`, err: errors.New("keycloak is down"),
+			wantStatus: http.StatusInternalServerError, wantUsers: []string{"alice@corp.com"}, wantResponse: "reconcile failed\n",
+		},
+		"no user": {
+			method: http.MethodPost, body: `{"user":" "}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"malformed body": {
+			method: http.MethodPost, body: `alice@corp.com`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"oversized body": {
+			method: http.MethodPost, body: `{"user":"` + strings.Repeat("a", maxRequestBytes) + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"wrong method": {
+			method: http.MethodGet, body: `{"user":"alice@corp.com"}`,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reconciler := &fakeReconciler{err: tc.err}
+			server := NewServer(ServerOptions{
+				AppCtx: &globals.ApplicationContext{
+					Context: context.Background(),
+					Logger:  slog.New(slog.DiscardHandler),
+				},
+				Reconciler: reconciler,
+			})
+
+			recorder := httptest.NewRecorder()
+			server.httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/reconcile/user", strings.NewReader(tc.body)))
+
+			if recorder.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d", recorder.Code, tc.wantStatus)
+			}
+			if tc.wantResponse != "" && recorder.Body.String() != tc.wantResponse {
+				t.Fatalf("got response %q, want %q", recorder.Body.String(), tc.wantResponse)
+			}
+			if len(reconciler.users) != len(tc.wantUsers) || (len(tc.wantUsers) > 0 && reconciler.users[0] != tc.wantUsers[0]) {
+				t.Fatalf("reconciled %v, want %v", reconciler.users, tc.wantUsers)
+			}
+		})
+	}
+}
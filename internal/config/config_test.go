@@ -0,0 +1,524 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"kegos/internal/runner"
+)
+
+// requiredArgs satisfies every mandatory option so tests only deal with the ones they check.
+var requiredArgs = []string{
+	"--gsuite-credentials=/creds.json",
+	"--gsuite-domains=corp.com",
+	"--keycloak-realm=test",
+	"--keycloak-uri=http://keycloak.test",
+	"--keycloak-client-id=kegos",
+	"--keycloak-client-secret=secret",
+	"--synced-parent-group=google-workspace",
+}
+
+// writeConfigFile stores the content in a temporary file and returns its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+// load runs Load with the given extra arguments on top of the required ones and a fixed environment.
+func load(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	t.Helper()
+
+	return Load(LoadOptions{
+		Args: append(append([]string{}, requiredArgs...), args...),
+		LookupEnv: func(key string) (string, bool) {
+			value, found := env[key]
+			return value, found
+		},
+		Output: io.Discard,
+	})
+}
+
+// Flags must win over env vars, env vars over the config file, and the file over defaults.
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeConfigFile(t, "kegos.yaml", `
+user-rate-limit: 30
+max-retries: 5
+prune-groups: true
+reconcile-interval: 5m
+log-level: debug
+`)
+
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+		want func(cfg *Config) any
+		val  any
+	}{
+		"defaults without any source": {
+			want: func(cfg *Config) any { return cfg.UserRateLimit }, val: 60,
+		},
+		"file beats defaults": {
+			args: []string{"--config=" + configFile},
+			want: func(cfg *Config) any { return cfg.ReconcileInterval }, val: 5 * time.Minute,
+		},
+		"env beats file": {
+			args: []string{"--config=" + configFile},
+			env:  map[string]string{"USER_RATE_LIMIT": "120"},
+			want: func(cfg *Config) any { return cfg.UserRateLimit }, val: 120,
+		},
+		"flag beats env and file": {
+			args: []string{"--config=" + configFile, "--user-rate-limit=10"},
+			env:  map[string]string{"USER_RATE_LIMIT": "120"},
+			want: func(cfg *Config) any { return cfg.UserRateLimit }, val: 10,
+		},
+		"explicit false flag beats file": {
+			args: []string{"--config=" + configFile, "--prune-groups=false"},
+			want: func(cfg *Config) any { return cfg.PruneGroups }, val: false,
+		},
+		"env beats string default": {
+			env:  map[string]string{"LOG_LEVEL": "warn"},
+			want: func(cfg *Config) any { return cfg.LogLevel }, val: "warn",
+		},
+		"empty env is ignored": {
+			args: []string{"--config=" + configFile},
+			env:  map[string]string{"MAX_RETRIES": ""},
+			want: func(cfg *Config) any { return cfg.MaxRetries }, val: 5,
+		},
+		"garbage int env falls back to file": {
+			args: []string{"--config=" + configFile},
+			env:  map[string]string{"MAX_RETRIES": "many"},
+			want: func(cfg *Config) any { return cfg.MaxRetries }, val: 5,
+		},
+		"garbage bool env falls back to default": {
+			env:  map[string]string{"DRY_RUN": "sure"},
+			want: func(cfg *Config) any { return cfg.DryRun }, val: false,
+		},
+		"config path from env": {
+			env:  map[string]string{"CONFIG": configFile},
+			want: func(cfg *Config) any { return cfg.LogLevel }, val: "debug",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := load(t, tc.args, tc.env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := tc.want(cfg); !reflect.DeepEqual(got, tc.val) {
+				t.Fatalf("got %v, want %v", got, tc.val)
+			}
+		})
+	}
+}
+
+// Lists must be accepted as YAML sequences, JSON arrays and comma-separated env vars.
+func TestLoadLists(t *testing.T) {
+	yamlFile := writeConfigFile(t, "kegos.yaml", `
+group-include-regex:
+  - "^team-"
+  - "^ops,infra@"
+`)
+	jsonFile := writeConfigFile(t, "kegos.json", `{"group-include-regex": ["^dev-"], "gsuite-prefetch": true}`)
+
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		"yaml sequence keeps commas": {args: []string{"--config=" + yamlFile}, want: []string{"^team-", "^ops,infra@"}},
+		"json array":                 {args: []string{"--config=" + jsonFile}, want: []string{"^dev-"}},
+		"env is comma-separated":     {env: map[string]string{"GROUP_INCLUDE_REGEX": "^a, ^b"}, want: []string{"^a", "^b"}},
+		"repeated flags":             {args: []string{"--group-include-regex=^a", "--group-include-regex=^b"}, want: []string{"^a", "^b"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := load(t, tc.args, tc.env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.GroupIncludeRegex, tc.want) {
+				t.Fatalf("got %v, want %v", cfg.GroupIncludeRegex, tc.want)
+			}
+		})
+	}
+}
+
+// A zero reconcile interval must reconcile once, while negative ones are rejected.
+func TestLoadReconcileInterval(t *testing.T) {
+	tests := map[string]struct {
+		args        []string
+		env         map[string]string
+		want        time.Duration
+		wantOnce    bool
+		wantProblem string
+	}{
+		"default":        {want: 10 * time.Minute},
+		"positive":       {args: []string{"--reconcile-interval=5m"}, want: 5 * time.Minute},
+		"zero":           {args: []string{"--reconcile-interval=0"}, wantOnce: true},
+		"zero from env":  {env: map[string]string{"RECONCILE_INTERVAL": "0s"}, wantOnce: true},
+		"zero with once": {args: []string{"--reconcile-interval=0", "--once"}, wantOnce: true},
+		"negative":       {args: []string{"--reconcile-interval=-5m"}, wantProblem: "--reconcile-interval must not be negative"},
+		"zero with api":  {args: []string{"--reconcile-interval=0", "--api-address=:8082"}, wantProblem: "--api-address is only supported while reconciling forever"},
+		"positive once":  {args: []string{"--reconcile-interval=5m", "--once"}, want: 5 * time.Minute, wantOnce: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := load(t, tc.args, tc.env)
+			if tc.wantProblem != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantProblem) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantProblem, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.ReconcileInterval != tc.want {
+				t.Fatalf("got interval %s, want %s", cfg.ReconcileInterval, tc.want)
+			}
+			if cfg.Once != tc.wantOnce {
+				t.Fatalf("got once %v, want %v", cfg.Once, tc.wantOnce)
+			}
+		})
+	}
+}
+
+// Bad config files and invalid merged values must be reported, never silently ignored.
+func TestLoadReportsProblems(t *testing.T) {
+	tests := map[string]struct {
+		content     string
+		args        []string
+		wantProblem string
+	}{
+		"unknown key":               {content: "keycloak-url: http://typo", wantProblem: `unknown option "keycloak-url"`},
+		"wrong type":                {content: "max-retries: plenty", wantProblem: `invalid value for "max-retries"`},
+		"list for a single value":   {content: "log-level: [debug]", wantProblem: "expected a single value"},
+		"validation after merging":  {content: "reconcile-interval: -1s", wantProblem: "--reconcile-interval must not be negative"},
+		"flag validation unchanged": {args: []string{"--user-match-attribute=id"}, wantProblem: "--user-match-attribute must be one of"},
+		"unknown member role":       {args: []string{"--include-member-roles=member,admin"}, wantProblem: "--include-member-roles must only contain"},
+		"unknown log format":        {args: []string{"--log-format=xml"}, wantProblem: "--log-format must be one of"},
+		"negative gsuite qps":       {args: []string{"--gsuite-qps=-1"}, wantProblem: "--gsuite-qps must not be negative"},
+		"zero gsuite concurrency":   {args: []string{"--gsuite-concurrency=0"}, wantProblem: "--gsuite-concurrency must be positive"},
+		"negative breaker failures": {args: []string{"--breaker-failures=-1"}, wantProblem: "--breaker-failures must not be negative"},
+		"negative retry after wait": {args: []string{"--keycloak-max-retry-after=-1s"}, wantProblem: "--keycloak-max-retry-after must not be negative"},
+		"relative gsuite endpoint":  {args: []string{"--gsuite-endpoint=localhost:8080"}, wantProblem: "--gsuite-endpoint must be an http or https URL"},
+		"empty user pages":          {args: []string{"--keycloak-user-batch-size=0"}, wantProblem: "--keycloak-user-batch-size must be positive"},
+		"negative group pages":      {args: []string{"--keycloak-group-batch-size=-10"}, wantProblem: "--keycloak-group-batch-size must be positive"},
+		"relative parent path":      {args: []string{"--synced-parent-group=", "--synced-parent-group-path=corp/google"}, wantProblem: "--synced-parent-group-path must be a group path"},
+		"empty parent path level":   {args: []string{"--synced-parent-group=", "--synced-parent-group-path=/corp//google"}, wantProblem: "--synced-parent-group-path must be a group path"},
+		"both parent options":       {args: []string{"--synced-parent-group-path=/corp/google"}, wantProblem: "mutually exclusive"},
+		"unknown sync target":       {args: []string{"--sync-target=users"}, wantProblem: "--sync-target must be one of"},
+		"pruning roles":             {args: []string{"--sync-target=roles", "--prune-groups"}, wantProblem: "--prune-groups is only supported"},
+		"incremental roles":         {args: []string{"--sync-target=roles", "--incremental"}, wantProblem: "--incremental is only supported"},
+		"state file on roles":       {args: []string{"--sync-target=roles", "--state-file=/tmp/kegos.json"}, wantProblem: "--state-file is only supported"},
+		"group mapping on roles":    {args: []string{"--sync-target=roles", "--group-mapping-file=/etc/kegos/mapping.yaml"}, wantProblem: "--group-mapping-file is only supported with --sync-target"},
+		"deletion guard on roles":   {args: []string{"--sync-target=roles", "--max-deletions-per-cycle=10%"}, wantProblem: "--max-deletions-per-cycle is only supported"},
+		"soft delete on roles":      {args: []string{"--sync-target=roles", "--soft-delete"}, wantProblem: "--soft-delete is only supported with --sync-target"},
+		"soft delete without grace": {args: []string{"--soft-delete", "--soft-delete-grace=0s"}, wantProblem: "--soft-delete-grace must be positive"},
+		"debounce on roles":         {args: []string{"--sync-target=roles", "--debounce-cycles=3"}, wantProblem: "--debounce-cycles is only supported with --sync-target"},
+		"negative debounce":         {args: []string{"--debounce-cycles=-1"}, wantProblem: "--debounce-cycles must not be negative"},
+		"annotate users on roles":   {args: []string{"--sync-target=roles", "--annotate-users"}, wantProblem: "--annotate-users is only supported with --sync-target"},
+		"skip federated both ways":  {args: []string{"--direction=bidirectional", "--skip-federated-users"}, wantProblem: "--skip-federated-users is only supported with --direction"},
+		"unknown mode":              {args: []string{"--mode=apply"}, wantProblem: "--mode must be one of"},
+		"unknown group name mapper": {args: []string{"--group-name-mapper=taxonomy"}, wantProblem: "--group-name-mapper must be one of"},
+		"proxy without scheme":      {args: []string{"--http-proxy=proxy:3128"}, wantProblem: "--http-proxy is invalid"},
+		"unsupported proxy scheme":  {args: []string{"--https-proxy=ftp://proxy:21"}, wantProblem: "--https-proxy is invalid"},
+		"relative webhook":          {args: []string{"--notify-webhook-url=hooks.example.com/kegos"}, wantProblem: "--notify-webhook-url must be an http or https URL"},
+		"failure only sans webhook": {args: []string{"--notify-on-failure-only"}, wantProblem: "--notify-on-failure-only requires --notify-webhook-url"},
+		"prefix with a slash":       {args: []string{"--group-name-prefix=g-suite/"}, wantProblem: "--group-name-prefix must not contain slashes"},
+		"unknown direction":         {args: []string{"--direction=both"}, wantProblem: "--direction must be one of"},
+		"writing back roles":        {args: []string{"--sync-target=roles", "--direction=keycloak-to-google"}, wantProblem: "--direction=keycloak-to-google is only supported with --sync-target=groups"},
+		"pruning both ways":         {args: []string{"--direction=bidirectional", "--prune-groups"}, wantProblem: "--prune-groups is only supported with --direction=google-to-keycloak"},
+		"writing back nested":       {args: []string{"--direction=bidirectional", "--resolve-nested-groups"}, wantProblem: "--resolve-nested-groups is only supported"},
+		"mapping both ways":         {args: []string{"--direction=bidirectional", "--group-mapping-file=/etc/kegos/mapping.yaml"}, wantProblem: "--group-mapping-file is only supported with --direction"},
+		"diffing both ways":         {args: []string{"--direction=bidirectional", "--mode=diff"}, wantProblem: "--mode=diff is only supported with --direction"},
+		"api on roles":              {args: []string{"--sync-target=roles", "--api-address=:8082"}, wantProblem: "--api-address is only supported with --sync-target"},
+		"api both ways":             {args: []string{"--direction=bidirectional", "--api-address=:8082"}, wantProblem: "--api-address is only supported with --direction"},
+		"api once":                  {args: []string{"--once", "--api-address=:8082"}, wantProblem: "--api-address is only supported while reconciling forever"},
+		"invalid group query":       {args: []string{"--gsuite-prefetch", "--gsuite-group-query=team-*"}, wantProblem: "--gsuite-group-query is invalid"},
+		"group query per user":      {args: []string{"--gsuite-group-query=email:team-*"}, wantProblem: "--gsuite-group-query is only supported with --gsuite-prefetch"},
+		"negative user cap":         {args: []string{"--max-users=-1"}, wantProblem: "--max-users must not be negative"},
+		"negative group cap":        {args: []string{"--max-groups=-5"}, wantProblem: "--max-groups must not be negative"},
+		"caps both ways":            {args: []string{"--direction=bidirectional", "--max-users=10"}, wantProblem: "--max-users and --max-groups are only supported with --direction"},
+		"unknown report format":     {args: []string{"--mode=diff", "--report-format=yaml"}, wantProblem: "--report-format must be one of"},
+		"report while reconciling":  {args: []string{"--once", "--report-format=csv"}, wantProblem: "--report-format is only supported with --mode=diff"},
+		"hierarchy on roles":        {args: []string{"--sync-target=roles", "--group-hierarchy-delimiter=."}, wantProblem: "--group-hierarchy-delimiter is only supported with --sync-target"},
+		"hierarchy both ways":       {args: []string{"--direction=bidirectional", "--group-hierarchy-delimiter=."}, wantProblem: "--group-hierarchy-delimiter is only supported with --direction"},
+		"hierarchy with state":      {args: []string{"--group-hierarchy-delimiter=.", "--state-file=/tmp/state.json"}, wantProblem: "--group-hierarchy-delimiter is not supported with --state-file"},
+		"parent routes on roles":    {args: []string{"--sync-target=roles", "--synced-parent-groups-file=/etc/kegos/parents.yaml"}, wantProblem: "--synced-parent-groups-file is only supported with --sync-target"},
+		"parent routes both ways":   {args: []string{"--direction=bidirectional", "--synced-parent-groups-file=/etc/kegos/parents.yaml"}, wantProblem: "--synced-parent-groups-file is only supported with --direction"},
+		"parent routes with state":  {args: []string{"--synced-parent-groups-file=/etc/kegos/parents.yaml", "--state-file=/tmp/state.json"}, wantProblem: "--synced-parent-groups-file is not supported with --state-file"},
+		"parent routes nested":      {args: []string{"--synced-parent-groups-file=/etc/kegos/parents.yaml", "--group-hierarchy-delimiter=."}, wantProblem: "--synced-parent-groups-file is not supported with --group-hierarchy-delimiter"},
+		"exporting roles":           {args: []string{"--sync-target=roles", "--mode=export"}, wantProblem: "--mode=export is only supported with --sync-target=groups"},
+		"api while exporting":       {args: []string{"--mode=export", "--api-address=:8082"}, wantProblem: "--api-address is only supported while reconciling forever"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := tc.args
+			if tc.content != "" {
+				args = append(args, "--config="+writeConfigFile(t, "kegos.yaml", tc.content))
+			}
+
+			_, err := load(t, args, nil)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if !strings.Contains(validationErr.Error(), tc.wantProblem) {
+				t.Fatalf("got %q, want it to contain %q", validationErr.Error(), tc.wantProblem)
+			}
+		})
+	}
+}
+
+// Google credentials must come either from a file or inline from GSUITE_CREDENTIALS_JSON, never both.
+func TestLoadGsuiteCredentialsSources(t *testing.T) {
+	const credentials = `{"type":"service_account"}`
+
+	var withoutCredentials []string
+	for _, arg := range requiredArgs {
+		if !strings.HasPrefix(arg, "--gsuite-credentials=") {
+			withoutCredentials = append(withoutCredentials, arg)
+		}
+	}
+
+	tests := map[string]struct {
+		args        []string
+		env         map[string]string
+		wantJSON    string
+		wantProblem string
+	}{
+		"inline from env": {args: withoutCredentials, env: map[string]string{"GSUITE_CREDENTIALS_JSON": credentials}, wantJSON: credentials},
+		"file path":       {args: requiredArgs},
+		"neither":         {args: withoutCredentials, wantProblem: "--gsuite-credentials or --gsuite-credentials-json is required"},
+		"both":            {args: requiredArgs, env: map[string]string{"GSUITE_CREDENTIALS_JSON": credentials}, wantProblem: "mutually exclusive"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := Load(LoadOptions{
+				Args: tc.args,
+				LookupEnv: func(key string) (string, bool) {
+					value, found := tc.env[key]
+					return value, found
+				},
+				Output: io.Discard,
+			})
+
+			if tc.wantProblem != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantProblem) {
+					t.Fatalf("got %v, want it to contain %q", err, tc.wantProblem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.GsuiteCredentialsJSON != tc.wantJSON {
+				t.Fatalf("got %q, want %q", cfg.GsuiteCredentialsJSON, tc.wantJSON)
+			}
+		})
+	}
+}
+
+// Keycloak clients must authenticate either with a secret or with a private key, never both.
+func TestLoadKeycloakClientCredentials(t *testing.T) {
+	var withoutSecret []string
+	for _, arg := range requiredArgs {
+		if !strings.HasPrefix(arg, "--keycloak-client-secret=") {
+			withoutSecret = append(withoutSecret, arg)
+		}
+	}
+
+	tests := map[string]struct {
+		args        []string
+		wantKey     string
+		wantProblem string
+	}{
+		"secret":           {args: requiredArgs},
+		"private key":      {args: append(slices.Clone(withoutSecret), "--keycloak-client-jwt-key=/client.pem"), wantKey: "/client.pem"},
+		"realm own secret": {args: append(slices.Clone(withoutSecret), "--keycloak-realm-client-secret=test=other")},
+		"neither":          {args: withoutSecret, wantProblem: "--keycloak-client-secret or --keycloak-client-jwt-key is required"},
+		"both":             {args: append(slices.Clone(requiredArgs), "--keycloak-client-jwt-key=/client.pem"), wantProblem: "mutually exclusive"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := Load(LoadOptions{Args: tc.args, LookupEnv: func(string) (string, bool) { return "", false }, Output: io.Discard})
+
+			if tc.wantProblem != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantProblem) {
+					t.Fatalf("got %v, want it to contain %q", err, tc.wantProblem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.KeycloakClientJWTKey != tc.wantKey {
+				t.Fatalf("got %q, want %q", cfg.KeycloakClientJWTKey, tc.wantKey)
+			}
+		})
+	}
+}
+
+// Realms must come as a list, each one falling back to the shared credentials unless given its own.
+func TestLoadKeycloakRealmTargets(t *testing.T) {
+	var withoutClientID []string
+	for _, arg := range requiredArgs {
+		if !strings.HasPrefix(arg, "--keycloak-client-id=") {
+			withoutClientID = append(withoutClientID, arg)
+		}
+	}
+
+	tests := map[string]struct {
+		args        []string
+		env         map[string]string
+		want        []runner.KeycloakRealm
+		wantProblem string
+	}{
+		"single realm": {
+			args: requiredArgs,
+			want: []runner.KeycloakRealm{{Name: "test"}},
+		},
+		"comma-separated realms with own credentials": {
+			args: append(append([]string{}, requiredArgs...), "--keycloak-realm=hr,sales",
+				"--keycloak-realm-client-id=hr=kegos-hr", "--keycloak-realm-client-secret=hr=hr-secret"),
+			want: []runner.KeycloakRealm{
+				{Name: "test"},
+				{Name: "hr", ClientID: "kegos-hr", ClientSecret: "hr-secret"},
+				{Name: "sales"},
+			},
+		},
+		"credentials from env": {
+			args: withoutClientID,
+			env:  map[string]string{"KEYCLOAK_REALM_CLIENT_ID": "test=kegos-test"},
+			want: []runner.KeycloakRealm{{Name: "test", ClientID: "kegos-test"}},
+		},
+		"realm without any client id": {
+			args:        append(append([]string{}, withoutClientID...), "--keycloak-realm=hr", "--keycloak-realm-client-id=test=kegos-test"),
+			wantProblem: "--keycloak-client-id is required",
+		},
+		"malformed realm credentials": {
+			args:        append(append([]string{}, requiredArgs...), "--keycloak-realm-client-secret=hr-secret"),
+			wantProblem: "--keycloak-realm-client-secret must look like realm=value",
+		},
+		"credentials for an unknown realm": {
+			args:        append(append([]string{}, requiredArgs...), "--keycloak-realm-client-id=hr=kegos-hr"),
+			wantProblem: `names realm "hr", missing from --keycloak-realm`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := Load(LoadOptions{
+				Args: tc.args,
+				LookupEnv: func(key string) (string, bool) {
+					value, found := tc.env[key]
+					return value, found
+				},
+				Output: io.Discard,
+			})
+
+			if tc.wantProblem != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantProblem) {
+					t.Fatalf("got %v, want it to contain %q", err, tc.wantProblem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := cfg.KeycloakRealmTargets(); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Help must be reported as flag.ErrHelp so the caller can exit cleanly.
+func TestLoadHelp(t *testing.T) {
+	_, err := Load(LoadOptions{Args: []string{"--help"}, Output: io.Discard})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("got %v, want flag.ErrHelp", err)
+	}
+}
+
+// The version must be reported as ErrVersion, by flag or command, before any option is validated.
+func TestLoadVersion(t *testing.T) {
+	for _, args := range [][]string{{"--version"}, {"version"}} {
+		_, err := Load(LoadOptions{Args: args, LookupEnv: func(string) (string, bool) { return "", false }, Output: io.Discard})
+		if !errors.Is(err, ErrVersion) {
+			t.Fatalf("got %v for %v, want ErrVersion", err, args)
+		}
+	}
+}
+
+// The validate command must select its mode, as the flag does, for any sync target.
+func TestLoadValidateMode(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+	}{
+		"command":              {args: []string{"validate"}},
+		"flag":                 {args: []string{"--mode=validate"}},
+		"env":                  {env: map[string]string{"MODE": "validate"}},
+		"command with roles":   {args: []string{"--sync-target=roles", "validate"}},
+		"command over the env": {args: []string{"validate"}, env: map[string]string{"MODE": "diff"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := load(t, tc.args, tc.env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Mode != ModeValidate {
+				t.Fatalf("got mode %q, want %q", cfg.Mode, ModeValidate)
+			}
+		})
+	}
+}
+
+// isEmailAddress must accept bare addresses only.
+func TestIsEmailAddress(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		want  bool
+	}{
+		"plain address":          {value: "admin@example.com", want: true},
+		"missing domain":         {value: "admin", want: false},
+		"address with name":      {value: "Admin <admin@example.com>", want: false},
+		"surrounding whitespace": {value: " admin@example.com", want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isEmailAddress(tc.value); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
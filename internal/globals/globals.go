@@ -5,6 +5,7 @@ package globals
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"os"
 )
@@ -16,10 +17,28 @@ var (
 		"warn":  slog.LevelWarn,
 		"error": slog.LevelError,
 	}
+
+	LogFormatMap = map[string]func(io.Writer, *slog.HandlerOptions) slog.Handler{
+		"json": func(w io.Writer, opts *slog.HandlerOptions) slog.Handler { return slog.NewJSONHandler(w, opts) },
+		"text": func(w io.Writer, opts *slog.HandlerOptions) slog.Handler { return slog.NewTextHandler(w, opts) },
+	}
 )
 
 type ApplicationContextOptions struct {
+	// Context is the parent context, typically cancelled on termination signals.
+	// It defaults to context.Background() when nil
+	Context context.Context
+
 	LogLevel string
+
+	// LogFormat is one of the LogFormatMap keys. It defaults to json when unknown
+	LogFormat string
+
+	// LogOutput receives the logs. It defaults to os.Stdout when nil
+	LogOutput io.Writer
+
+	// LogIncludeSource adds the source file and line of the logging call to every log line
+	LogIncludeSource bool
 }
 
 type ApplicationContext struct {
@@ -34,11 +53,50 @@ func NewApplicationContext(opts ApplicationContextOptions) (*ApplicationContext,
 		logLevel = slog.LevelInfo
 	}
 
+	newLogHandler, logFormatFound := LogFormatMap[opts.LogFormat]
+	if !logFormatFound {
+		newLogHandler = LogFormatMap["json"]
+	}
+
+	logOutput := opts.LogOutput
+	if logOutput == nil {
+		logOutput = os.Stdout
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	appCtx := &ApplicationContext{
-		Context: context.Background(),
-		Logger:  slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})),
+		Context: ctx,
+		Logger: slog.New(newLogHandler(logOutput, &slog.HandlerOptions{
+			Level:     logLevel,
+			AddSource: opts.LogIncludeSource,
+		})),
 	}
 
 	//
 	return appCtx, nil
 }
+
+// loggerKey is the context key holding the logger of the application context
+type loggerKey struct{}
+
+// WithLogAttrs returns a copy of the application context whose logger adds the given attributes to every line.
+// The logger is also stored in the copied context, where LoggerFromContext finds it
+func (a *ApplicationContext) WithLogAttrs(args ...any) *ApplicationContext {
+	logger := a.Logger.With(args...)
+	return &ApplicationContext{
+		Context: context.WithValue(a.Context, loggerKey{}, logger),
+		Logger:  logger,
+	}
+}
+
+// LoggerFromContext returns the logger stored by WithLogAttrs, or the default logger when there is none
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, found := ctx.Value(loggerKey{}).(*slog.Logger); found {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const serviceAccountCredentialsType = "service_account"
+
+// credentialsFile holds the fields of a Google credentials file needed to tell what kind of file it is
+type credentialsFile struct {
+	Type        string          `json:"type"`
+	ClientEmail string          `json:"client_email"`
+	PrivateKey  string          `json:"private_key"`
+	Installed   json.RawMessage `json:"installed"`
+	Web         json.RawMessage `json:"web"`
+}
+
+// validateCredentials makes sure the credentials are a service account key before handing them to Google,
+// whose errors do not say which kind of file was expected
+func validateCredentials(content []byte) error {
+	var credentials credentialsFile
+	if err := json.Unmarshal(content, &credentials); err != nil {
+		return fmt.Errorf("invalid Gsuite credentials: not valid JSON: %v", err)
+	}
+
+	switch {
+	case credentials.Installed != nil || credentials.Web != nil:
+		return fmt.Errorf("invalid Gsuite credentials: got an OAuth client file, expected a service account JSON key")
+	case credentials.Type == "":
+		return fmt.Errorf("invalid Gsuite credentials: missing type, expected a service account JSON key")
+	case credentials.Type != serviceAccountCredentialsType:
+		return fmt.Errorf("invalid Gsuite credentials: got type %q, expected a service account JSON key (type %q)",
+			credentials.Type, serviceAccountCredentialsType)
+	}
+
+	var missing []string
+	if credentials.ClientEmail == "" {
+		missing = append(missing, "client_email")
+	}
+	if credentials.PrivateKey == "" {
+		missing = append(missing, "private_key")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid Gsuite credentials: service account key without %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
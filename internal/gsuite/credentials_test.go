@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Only service account keys must be accepted, and anything else rejected telling which kind of file is expected.
+func TestValidateCredentials(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		wantErr string
+	}{
+		"service account key": {
+			content: testCredentials,
+		},
+		"oauth client file": {
+			content: `{"installed":{"client_id":"id.apps.googleusercontent.com","client_secret":"secret"}}`,
+			wantErr: "got an OAuth client file, expected a service account JSON key",
+		},
+		"authorized user file": {
+			content: `{"type":"authorized_user","client_id":"id","refresh_token":"token"}`,
+			wantErr: `got type "authorized_user", expected a service account JSON key`,
+		},
+		"no type": {
+			content: `{"client_email":"kegos@project.iam.gserviceaccount.com"}`,
+			wantErr: "missing type",
+		},
+		"key without fields": {
+			content: `{"type":"service_account"}`,
+			wantErr: "service account key without client_email, private_key",
+		},
+		"malformed json": {
+			content: `{"type":"service_account",`,
+			wantErr: "not valid JSON",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateCredentials([]byte(tc.content))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// A credentials file of the wrong kind must be reported as such when building the client.
+func TestNewAdminRejectsWrongCredentialsFile(t *testing.T) {
+	credentialsPath := filepath.Join(t.TempDir(), "client_secret.json")
+	if err := os.WriteFile(credentialsPath, []byte(`{"web":{"client_id":"id"}}`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := NewAdmin(AdminOptions{Ctx: context.Background(), JsonFilepath: credentialsPath})
+	if err == nil || !strings.Contains(err.Error(), "expected a service account JSON key") {
+		t.Fatalf("expected an error about the kind of credentials file, got %v", err)
+	}
+}
@@ -4,26 +4,126 @@
 package gsuite
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	//
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 const UnableGetGroupMembersErrorMessage = "unable to get group members: %s"
 
+const (
+	MemberRoleMember  = "MEMBER"
+	MemberRoleManager = "MANAGER"
+	MemberRoleOwner   = "OWNER"
+
+	memberStatusActive = "ACTIVE"
+
+	// defaultMaxConcurrentRequests bounds the group member listings sent at once when no limit is configured
+	defaultMaxConcurrentRequests = 4
+)
+
+// DefaultMemberRoles are the roles counted as membership when none are configured
+var DefaultMemberRoles = []string{MemberRoleMember, MemberRoleManager, MemberRoleOwner}
+
+// ErrNotFound is returned by single lookups, such as GetGroup or GetUser, when Google knows no such key
+var ErrNotFound = errors.New("not found")
+
+type AdminOptions struct {
+	Ctx context.Context
+
+	JsonFilepath string
+
+	// JsonCredentials is the content of the credentials file. It is used instead of JsonFilepath when set
+	JsonCredentials []byte
+
+	// ImpersonateSubject is the admin user the service account acts on behalf of
+	// through domain-wide delegation. Calls run as the service account itself when empty
+	ImpersonateSubject string
+
+	// MemberRoles are the group roles counted as membership. It defaults to DefaultMemberRoles when empty
+	MemberRoles []string
+
+	// QPS caps the requests sent to the Directory API per second. Zero disables the limit
+	QPS float64
+
+	// CallTimeout bounds every call to the Directory API through a context deadline. A listing spanning
+	// several pages is a single call. Zero leaves calls without deadline
+	CallTimeout time.Duration
+
+	// MaxConcurrentRequests bounds the group member listings sent at once by GetGroupsMembers. Every request
+	// still waits on the QPS limit. It defaults to 4 when zero
+	MaxConcurrentRequests int
+
+	// Writable asks for the scope allowing group members to be added and removed, on top of the read-only ones.
+	// With impersonation, the scope must be granted to the service account in the Admin console too
+	Writable bool
+
+	// Endpoint is the base URL of the Directory API, such as a mock server in tests. The public endpoint
+	// is used when empty. Tokens are still minted at the token URI of the credentials
+	Endpoint string
+
+	// Proxy picks the proxy of every request to Google, tokens included. The proxy environment variables are used when nil
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Logger receives the quota Google reports on every response, warning once it runs low. Nothing is logged when nil
+	Logger *slog.Logger
+
+	// GroupQuery filters the groups of a domain on Google's side, such as email:team-*, when listing them all.
+	// Listings of the groups of a user are not filtered
+	GroupQuery string
+}
+
 type Admin struct {
 	Ctx context.Context
 
 	//
-	service      *admin.Service
-	tokenSource  oauth2.TokenSource
-	jsonFilepath string
+	service            *admin.Service
+	tokenSource        oauth2.TokenSource
+	jsonFilepath       string
+	jsonCredentials    []byte
+	impersonateSubject string
+	memberRoles        []string
+	callTimeout        time.Duration
+	writable           bool
+	groupQuery         string
+
+	maxConcurrentRequests int
+}
+
+// Group is a Gsuite group along with the metadata shown for it in the Admin console
+type Group struct {
+	Id          string
+	Email       string
+	Name        string
+	Description string
+}
+
+// User is a Gsuite user along with the details kegos shows about it
+type User struct {
+	Id           string
+	PrimaryEmail string
+	FullName     string
+
+	// Aliases are the other addresses the user receives mail at, each one usable as its key
+	Aliases []string
+
+	Suspended bool
 }
 
 type GroupMembers struct {
@@ -31,35 +131,104 @@ type GroupMembers struct {
 	Users []string
 }
 
-func NewAdmin(ctx context.Context, googleJsonFilepath string) (adminObj Admin, err error) {
-	adminObj.Ctx = ctx
-	adminObj.jsonFilepath = googleJsonFilepath
+// Member is an entry of a Gsuite group, as returned by the Members API
+type Member struct {
+	Email string
+
+	// Role is one of MEMBER, MANAGER or OWNER
+	Role string
+
+	// Type is one of USER, GROUP, CUSTOMER or EXTERNAL
+	Type string
+
+	// Status is ACTIVE for members in good standing. It is empty for members without a status, such as groups
+	Status string
+}
+
+// newMember keeps the fields of an Admin SDK member kegos cares about
+func newMember(member *admin.Member) Member {
+	return Member{
+		Email:  member.Email,
+		Role:   member.Role,
+		Type:   member.Type,
+		Status: member.Status,
+	}
+}
+
+func NewAdmin(opts AdminOptions) (adminObj Admin, err error) {
+	adminObj.Ctx = opts.Ctx
+
+	// oauth2 takes the client to build upon from the context, both to mint tokens and to call the API
+	if opts.Proxy != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = opts.Proxy
+		adminObj.Ctx = context.WithValue(opts.Ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+	adminObj.jsonFilepath = opts.JsonFilepath
+	adminObj.jsonCredentials = opts.JsonCredentials
+	adminObj.impersonateSubject = opts.ImpersonateSubject
+	adminObj.callTimeout = opts.CallTimeout
+	adminObj.writable = opts.Writable
+	adminObj.groupQuery = opts.GroupQuery
+
+	adminObj.maxConcurrentRequests = opts.MaxConcurrentRequests
+	if adminObj.maxConcurrentRequests <= 0 {
+		adminObj.maxConcurrentRequests = defaultMaxConcurrentRequests
+	}
+
+	adminObj.memberRoles = DefaultMemberRoles
+	if len(opts.MemberRoles) > 0 {
+		adminObj.memberRoles = nil
+		for _, role := range opts.MemberRoles {
+			adminObj.memberRoles = append(adminObj.memberRoles, strings.ToUpper(role))
+		}
+	}
 
 	err = adminObj.getAdminTokenSource()
 	if err != nil {
 		return adminObj, err
 	}
 
-	adminObj.service, err = admin.NewService(ctx, option.WithTokenSource(adminObj.tokenSource))
+	// Delegation problems only show up when the first token is requested,
+	// so ask for it now to fail at startup instead of on every user
+	if adminObj.impersonateSubject != "" {
+		_, err = adminObj.tokenSource.Token()
+		if err != nil {
+			return adminObj, explainDelegationError(adminObj.impersonateSubject, err)
+		}
+	}
+
+	httpClient := withQuotaLogging(withRateLimit(oauth2.NewClient(adminObj.Ctx, adminObj.tokenSource), opts.QPS), opts.Logger)
+	serviceOpts := []option.ClientOption{option.WithHTTPClient(httpClient)}
+	if opts.Endpoint != "" {
+		// Request paths are resolved against the endpoint, which only keeps its path with a trailing slash
+		serviceOpts = append(serviceOpts, option.WithEndpoint(strings.TrimSuffix(opts.Endpoint, "/")+"/"))
+	}
+	adminObj.service, err = admin.NewService(adminObj.Ctx, serviceOpts...)
 
 	return adminObj, err
 }
 
-// getAdminTokenSource TODO
+// getAdminTokenSource builds the token source from the inline credentials, or from the credentials file otherwise
 func (a *Admin) getAdminTokenSource() (err error) {
 
-	jsonCredentials, err := os.ReadFile(a.jsonFilepath)
-	if err != nil {
+	jsonCredentials := a.jsonCredentials
+	if len(jsonCredentials) == 0 {
+		jsonCredentials, err = os.ReadFile(a.jsonFilepath)
+		if err != nil {
+			return err
+		}
+	}
+	if err = validateCredentials(jsonCredentials); err != nil {
 		return err
 	}
 
-	config, err := google.JWTConfigFromJSON(jsonCredentials,
-		admin.AdminDirectoryGroupReadonlyScope,
-		admin.AdminDirectoryUserReadonlyScope)
+	config, err := google.JWTConfigFromJSON(jsonCredentials, scopes(a.writable)...)
 	if err != nil {
 		return err
 	}
 
+	config.Subject = a.impersonateSubject
 	a.tokenSource = config.TokenSource(a.Ctx)
 
 	//tokenSource, err := google.DefaultTokenSource(ctx)
@@ -69,12 +238,106 @@ func (a *Admin) getAdminTokenSource() (err error) {
 	return err
 }
 
+// scopes returns the OAuth2 scopes requested to Google, only allowing changes to group members when writable
+func scopes(writable bool) []string {
+	requested := []string{admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryUserReadonlyScope}
+	if writable {
+		requested = append(requested, admin.AdminDirectoryGroupMemberScope)
+	}
+	return requested
+}
+
+// callContext derives the context of a single call from the admin one, so a call timing out
+// never cancels the ones coming after it
+func (a *Admin) callContext() (context.Context, context.CancelFunc) {
+	if a.callTimeout <= 0 {
+		return context.WithCancel(a.Ctx)
+	}
+	return context.WithTimeout(a.Ctx, a.callTimeout)
+}
+
+// CheckToken makes sure a valid token can be had, minting a new one when the current one expired.
+// Tokens are cached, so it only reaches Google when a refresh is due
+func (a *Admin) CheckToken() error {
+	_, err := a.tokenSource.Token()
+	if err != nil && a.impersonateSubject != "" {
+		return explainDelegationError(a.impersonateSubject, err)
+	}
+	return err
+}
+
+// explainDelegationError turns the opaque OAuth2 rejection Google returns when domain-wide
+// delegation is missing into an actionable message
+func explainDelegationError(subject string, err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "unauthorized_client" {
+		return fmt.Errorf("service account is not authorized to impersonate %s: "+
+			"enable domain-wide delegation for its client ID with the directory readonly scopes in the Admin console: %v", subject, err)
+	}
+	return fmt.Errorf("failed getting token impersonating %s: %v", subject, err)
+}
+
+// Validate makes a single cheap call, listing one group of the domain, to make sure the credentials can read
+// the directory. Rejections come back as an actionable message about the scopes and delegation needed, so
+// kegos fails at startup instead of deep in its first cycle
+func (a *Admin) Validate(domain string) error {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	_, err := a.service.Groups.List().Domain(domain).MaxResults(1).Context(ctx).Do()
+	if err == nil {
+		return nil
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) && (googleErr.Code == http.StatusUnauthorized || googleErr.Code == http.StatusForbidden) {
+		setup := "assign the service account an admin role with the Users and Groups read privileges in the Admin console"
+		if a.impersonateSubject != "" {
+			setup = fmt.Sprintf("make sure %s is an admin able to read them, and that domain-wide delegation "+
+				"grants these scopes to the service account client ID in the Admin console", a.impersonateSubject)
+		}
+		return fmt.Errorf("not allowed to list the groups of %s with the scopes %s: %s: %w",
+			domain, strings.Join(scopes(a.writable), ", "), setup, err)
+	}
+	return fmt.Errorf("failed listing the groups of %s: %w", domain, err)
+}
+
+// IsNotFoundError reports whether Google rejected a call because the requested resource,
+// such as a user key, does not exist
+func IsNotFoundError(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	var googleErr *googleapi.Error
+	return errors.As(err, &googleErr) && googleErr.Code == http.StatusNotFound
+}
+
+// countsAsMember reports whether a group member is active and holds one of the configured roles.
+// Members without status, such as other groups, are not considered inactive
+func (a *Admin) countsAsMember(member Member) bool {
+	if member.Status != "" && member.Status != memberStatusActive {
+		return false
+	}
+	return slices.Contains(a.memberRoles, member.Role)
+}
+
+// listDomainGroups returns the listing of the groups of a domain, filtered by the group query when any
+func (a *Admin) listDomainGroups(domain string) *admin.GroupsListCall {
+	call := a.service.Groups.List().Domain(domain)
+	if a.groupQuery != "" {
+		call = call.Query(a.groupQuery)
+	}
+	return call
+}
+
 func (a *Admin) GetAllGroups(domain string) (groups []string, err error) {
 
-	err = a.service.Groups.
-		List().
-		Domain(domain).
-		Pages(a.Ctx, func(adGroups *admin.Groups) error {
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	err = a.listDomainGroups(domain).
+		Pages(ctx, func(adGroups *admin.Groups) error {
 			for _, group := range adGroups.Groups {
 				groups = append(groups, group.Email)
 			}
@@ -84,13 +347,86 @@ func (a *Admin) GetAllGroups(domain string) (groups []string, err error) {
 	return groups, err
 }
 
+// GetAllGroupsDetailed returns every group of the domain along with its metadata
+func (a *Admin) GetAllGroupsDetailed(domain string) (groups []Group, err error) {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	err = a.listDomainGroups(domain).
+		Pages(ctx, func(adGroups *admin.Groups) error {
+			for _, group := range adGroups.Groups {
+				groups = append(groups, Group{
+					Id:          group.Id,
+					Email:       group.Email,
+					Name:        group.Name,
+					Description: group.Description,
+				})
+			}
+			return nil
+		})
+
+	return groups, err
+}
+
+// GetGroup returns the group known by the email, or any of its aliases, wrapping ErrNotFound when there is none
+func (a *Admin) GetGroup(email string) (group Group, err error) {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	adGroup, err := a.service.Groups.Get(email).Context(ctx).Do()
+	if IsNotFoundError(err) {
+		return group, fmt.Errorf("group %s: %w", email, ErrNotFound)
+	}
+	if err != nil {
+		return group, err
+	}
+
+	return Group{
+		Id:          adGroup.Id,
+		Email:       adGroup.Email,
+		Name:        adGroup.Name,
+		Description: adGroup.Description,
+	}, nil
+}
+
+// GetUser returns the user known by the email, or any of their aliases, wrapping ErrNotFound when there is none
+func (a *Admin) GetUser(email string) (user User, err error) {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	adUser, err := a.service.Users.Get(email).Context(ctx).Do()
+	if IsNotFoundError(err) {
+		return user, fmt.Errorf("user %s: %w", email, ErrNotFound)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	user = User{
+		Id:           adUser.Id,
+		PrimaryEmail: adUser.PrimaryEmail,
+		Aliases:      adUser.Aliases,
+		Suspended:    adUser.Suspended,
+	}
+	if adUser.Name != nil {
+		user.FullName = adUser.Name.FullName
+	}
+	return user, nil
+}
+
 // GetAllUsers me das un dominio y te devuelvo la lista de usuarios completa
 func (a *Admin) GetAllUsers(domain string) (users []string, err error) {
 
+	ctx, cancel := a.callContext()
+	defer cancel()
+
 	err = a.service.Users.
 		List().
 		Domain(domain).
-		Pages(a.Ctx, func(adUsers *admin.Users) error {
+		Pages(ctx, func(adUsers *admin.Users) error {
 			for _, user := range adUsers.Users {
 				users = append(users, user.PrimaryEmail)
 			}
@@ -100,49 +436,151 @@ func (a *Admin) GetAllUsers(domain string) (users []string, err error) {
 	return users, err
 }
 
-// GetGroupsFromUser me das un usuario y te doy todos los grupos del usuario
+// GetGroupsFromUser me das un usuario y te doy todos los grupos del usuario.
+// Listing groups by user tells nothing about role or status, so each membership is checked on its own
 func (a *Admin) GetGroupsFromUser(domain string, user string) (groups []string, err error) {
+	var candidates []string
+
+	listCtx, cancelList := a.callContext()
 	err = a.service.Groups.
 		List().
 		Domain(domain).
 		UserKey(user).
-		Pages(a.Ctx, func(groupsReport *admin.Groups) error {
+		Pages(listCtx, func(groupsReport *admin.Groups) error {
 			for _, m := range groupsReport.Groups {
-				groups = append(groups, m.Email)
+				candidates = append(candidates, m.Email)
 			}
 			return nil
 		})
+	cancelList()
+	if err != nil {
+		return nil, err
+	}
 
-	return groups, err
+	for _, group := range candidates {
+		ctx, cancel := a.callContext()
+		member, err := a.service.Members.Get(group, user).Context(ctx).Do()
+		cancel()
+		if IsNotFoundError(err) {
+			// Left the group since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed getting membership of %s in group %s: %w", user, group, err)
+		}
+
+		if a.countsAsMember(newMember(member)) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups, nil
 }
 
-// GetUsersFromGroup me das un grupo y te devuelvo sus miembros
-func (a *Admin) GetUsersFromGroup(group string) (memberList []string, err error) {
+// GetGroupMembersDetailed me das un grupo y te devuelvo todos sus miembros con su rol, tipo y estado,
+// sin filtrar ninguno
+func (a *Admin) GetGroupMembersDetailed(group string) (members []Member, err error) {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
 
 	err = a.service.Members.
 		List(group).
-		Pages(a.Ctx, func(adMembers *admin.Members) error {
+		Pages(ctx, func(adMembers *admin.Members) error {
 			for _, member := range adMembers.Members {
-				memberList = append(memberList, member.Email)
+				members = append(members, newMember(member))
 			}
 			return nil
 		})
 
-	return memberList, err
+	return members, err
+}
+
+// GetUsersFromGroup me das un grupo y te devuelvo sus miembros activos con alguno de los roles configurados
+func (a *Admin) GetUsersFromGroup(group string) (memberList []string, err error) {
+
+	members, err := a.GetGroupMembersDetailed(group)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if a.countsAsMember(member) {
+			memberList = append(memberList, member.Email)
+		}
+	}
+
+	return memberList, nil
 }
 
-// GetGroupsMembers Me das una lista de grupos y te devuelvo una lista de grupos con sus miembros dentro
+// GetGroupsMembers Me das una lista de grupos y te devuelvo una lista de grupos con sus miembros dentro,
+// ordenada por grupo. Los miembros se piden en paralelo, con a lo sumo MaxConcurrentRequests peticiones a la vez.
+// Si falla algún grupo se devuelve el error, ya que una lista parcial provocaría bajas indebidas
 // Ref: https://developers.google.com/admin-sdk/directory/reference/rest/v1/members/list
 func (a *Admin) GetGroupsMembers(groups []string) (groupsMembers []GroupMembers, err error) {
+	groups = slices.Sorted(slices.Values(groups))
+	groupsMembers = make([]GroupMembers, len(groups))
+	errs := make([]error, len(groups))
+	inFlight := make(chan struct{}, max(a.maxConcurrentRequests, 1))
 
-	for _, group := range groups {
-		users, err := a.GetUsersFromGroup(group)
+	// Once a group fails the result is thrown away, so the groups not listed yet are skipped
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		inFlight <- struct{}{}
+		if failed.Load() {
+			<-inFlight
+			break
+		}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			users, err := a.GetUsersFromGroup(group)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed getting members of group %s: %w", group, err)
+				failed.Store(true)
+				return
+			}
+			groupsMembers[i] = GroupMembers{Group: group, Users: users}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
-			log.Printf(UnableGetGroupMembersErrorMessage, err.Error())
-			continue
+			return nil, err
 		}
-		groupsMembers = append(groupsMembers, GroupMembers{Group: group, Users: users})
 	}
+	return groupsMembers, nil
+}
+
+// InsertMember adds the user to the group as a plain member. A user already in the group is not an error
+func (a *Admin) InsertMember(group, email string) error {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
 
-	return groupsMembers, err
+	_, err := a.service.Members.Insert(group, &admin.Member{Email: email, Role: MemberRoleMember}).Context(ctx).Do()
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) && googleErr.Code == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// DeleteMember removes the user from the group. A user no longer in the group is not an error
+func (a *Admin) DeleteMember(group, email string) error {
+
+	ctx, cancel := a.callContext()
+	defer cancel()
+
+	err := a.service.Members.Delete(group, email).Context(ctx).Do()
+	if IsNotFoundError(err) {
+		return nil
+	}
+	return err
 }
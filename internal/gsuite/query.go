@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// groupQueryFields are the fields the Directory API searches groups by
+var groupQueryFields = []string{"email", "name", "memberKey"}
+
+// ValidateGroupQuery catches obvious mistakes in a Directory API group search, such as email:team-*, before
+// Google rejects it on every cycle. Clauses are separated by spaces and are made of a known field, an operator
+// (: or =) and a value, quoted with single quotes when it holds spaces. Google still has the last word
+func ValidateGroupQuery(query string) error {
+	clauses, err := splitGroupQuery(query)
+	if err != nil {
+		return err
+	}
+	if len(clauses) == 0 {
+		return fmt.Errorf("query is empty")
+	}
+
+	for _, clause := range clauses {
+		operator := strings.IndexAny(clause, ":=")
+		if operator <= 0 {
+			return fmt.Errorf("clause %q must look like field:value or field=value", clause)
+		}
+
+		field, value := clause[:operator], strings.Trim(clause[operator+1:], "'")
+		if !slices.Contains(groupQueryFields, field) {
+			return fmt.Errorf("clause %q searches unknown field %q, expected one of: %s",
+				clause, field, strings.Join(groupQueryFields, ", "))
+		}
+		if value == "" {
+			return fmt.Errorf("clause %q has no value", clause)
+		}
+	}
+	return nil
+}
+
+// splitGroupQuery splits a group search into its clauses, keeping spaces within single quotes
+func splitGroupQuery(query string) (clauses []string, err error) {
+	var clause strings.Builder
+	quoted := false
+
+	for _, char := range query {
+		switch {
+		case char == '\'':
+			quoted = !quoted
+			clause.WriteRune(char)
+		case unicode.IsSpace(char) && !quoted:
+			if clause.Len() > 0 {
+				clauses = append(clauses, clause.String())
+				clause.Reset()
+			}
+		default:
+			clause.WriteRune(char)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("query has an unterminated quote")
+	}
+	if clause.Len() > 0 {
+		clauses = append(clauses, clause.String())
+	}
+	return clauses, nil
+}
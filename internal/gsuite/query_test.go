@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"strings"
+	"testing"
+)
+
+// Queries made of known fields, operators and values must be accepted, and obvious mistakes explained.
+func TestValidateGroupQuery(t *testing.T) {
+	tests := map[string]struct {
+		query   string
+		wantErr string
+	}{
+		"email prefix":         {query: "email:team-*"},
+		"exact name":           {query: "name='Sales EMEA'"},
+		"several clauses":      {query: "email:eng-* memberKey=alice@corp.com"},
+		"empty":                {query: "  ", wantErr: "query is empty"},
+		"no operator":          {query: "team-*", wantErr: "must look like field:value"},
+		"no field":             {query: ":team-*", wantErr: "must look like field:value"},
+		"unknown field":        {query: "description:ops", wantErr: `unknown field "description"`},
+		"no value":             {query: "email:", wantErr: "has no value"},
+		"empty quoted value":   {query: "name=''", wantErr: "has no value"},
+		"unterminated quote":   {query: "name='Sales EMEA", wantErr: "unterminated quote"},
+		"sql like":             {query: "email LIKE 'team-%'", wantErr: "must look like field:value"},
+		"space after operator": {query: "email: team-*", wantErr: "has no value"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateGroupQuery(tc.query)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want it to mention %q", err, tc.wantErr)
+			}
+		})
+	}
+}
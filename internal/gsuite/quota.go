@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// quotaWarnRatio is the share of the quota left below which every response is logged as a warning
+const quotaWarnRatio = 0.1
+
+// quotaHeaders are the pairs of headers, remaining and limit, Google may report the quota of a call with
+var quotaHeaders = [][2]string{
+	{"X-RateLimit-Remaining", "X-RateLimit-Limit"},
+	{"RateLimit-Remaining", "RateLimit-Limit"},
+}
+
+// quotaLoggingTransport logs the quota Google reports on every response, at debug, and warns once it runs
+// low or Google asks to slow down, so --gsuite-qps and --reconcile-interval can be tuned
+type quotaLoggingTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+func (t *quotaLoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	ctx := req.Context()
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		t.logger.WarnContext(ctx, "google asked to slow down", "path", req.URL.Path,
+			"status", resp.StatusCode, "retry_after", retryAfter)
+	}
+
+	remaining, limit, found := parseQuota(resp.Header)
+	if !found {
+		return resp, nil
+	}
+
+	if limit > 0 && float64(remaining) < float64(limit)*quotaWarnRatio {
+		t.logger.WarnContext(ctx, "google API quota running low", "path", req.URL.Path,
+			"remaining", remaining, "limit", limit)
+		return resp, nil
+	}
+	t.logger.DebugContext(ctx, "google API quota", "path", req.URL.Path, "remaining", remaining, "limit", limit)
+	return resp, nil
+}
+
+// parseQuota reads the quota left and its limit from the first pair of quota headers holding a remaining
+// count. The limit is zero when missing or malformed
+func parseQuota(header http.Header) (remaining, limit int, found bool) {
+	for _, pair := range quotaHeaders {
+		remaining, err := strconv.Atoi(header.Get(pair[0]))
+		if err != nil {
+			continue
+		}
+		limit, _ := strconv.Atoi(header.Get(pair[1]))
+		return remaining, limit, true
+	}
+	return 0, 0, false
+}
+
+// withQuotaLogging returns a copy of the client logging the quota reported on its responses.
+// A nil logger leaves the client untouched
+func withQuotaLogging(client *http.Client, logger *slog.Logger) *http.Client {
+	if logger == nil {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	logged := *client
+	logged.Transport = &quotaLoggingTransport{base: base, logger: logger}
+	return &logged
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// headerTransport answers every request with the given headers, without reaching any server.
+type headerTransport struct {
+	header http.Header
+	status int
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.status,
+		Header:     t.header,
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}, nil
+}
+
+// Quota headers must be logged at debug, and as a warning once the quota left drops below the threshold.
+func TestWithQuotaLoggingWarnsOnLowQuota(t *testing.T) {
+	tests := map[string]struct {
+		header   http.Header
+		status   int
+		wantWarn string
+		wantLog  bool
+	}{
+		"plenty of quota left": {
+			header:  http.Header{"X-Ratelimit-Remaining": {"900"}, "X-Ratelimit-Limit": {"1000"}},
+			status:  http.StatusOK,
+			wantLog: true,
+		},
+		"quota running low": {
+			header:   http.Header{"X-Ratelimit-Remaining": {"50"}, "X-Ratelimit-Limit": {"1000"}},
+			status:   http.StatusOK,
+			wantWarn: "google API quota running low",
+			wantLog:  true,
+		},
+		"standard headers running low": {
+			header:   http.Header{"Ratelimit-Remaining": {"1"}, "Ratelimit-Limit": {"100"}},
+			status:   http.StatusOK,
+			wantWarn: "google API quota running low",
+			wantLog:  true,
+		},
+		"remaining without limit": {
+			header:  http.Header{"X-Ratelimit-Remaining": {"0"}},
+			status:  http.StatusOK,
+			wantLog: true,
+		},
+		"asked to slow down": {
+			header:   http.Header{"Retry-After": {"30"}},
+			status:   http.StatusTooManyRequests,
+			wantWarn: "google asked to slow down",
+			wantLog:  true,
+		},
+		"no quota headers": {
+			header: http.Header{},
+			status: http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			client := withQuotaLogging(&http.Client{Transport: &headerTransport{header: tc.header, status: tc.status}}, logger)
+
+			resp, err := client.Get("https://admin.googleapis.com/admin/directory/v1/groups")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			warned := strings.Contains(logs.String(), `"level":"WARN"`)
+			if warned != (tc.wantWarn != "") || !strings.Contains(logs.String(), tc.wantWarn) {
+				t.Fatalf("expected warning %q, logs:\n%s", tc.wantWarn, logs.String())
+			}
+			if logged := logs.Len() > 0; logged != tc.wantLog {
+				t.Fatalf("logged %v, want %v; logs:\n%s", logged, tc.wantLog, logs.String())
+			}
+		})
+	}
+}
+
+// No logger must leave the client untouched.
+func TestWithQuotaLoggingDisabled(t *testing.T) {
+	client := &http.Client{}
+	if got := withQuotaLogging(client, nil); got != client {
+		t.Fatalf("expected the same client without logger")
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"net/http"
+
+	//
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport holds every request until the limiter allows it, so all the Directory API calls,
+// pages included, stay under the configured pace. Waiting stops as soon as the request context is done
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiter.Wait(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withRateLimit returns a copy of the client sending at most qps requests per second, without bursts.
+// A qps of zero or below leaves the client unlimited
+func withRateLimit(client *http.Client, qps float64) *http.Client {
+	if qps <= 0 {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	limited := *client
+	limited.Transport = &rateLimitedTransport{
+		base:    base,
+		limiter: rate.NewLimiter(rate.Limit(qps), 1),
+	}
+	return &limited
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package gsuite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	//
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// Requests must leave the client spaced by at least 1/QPS, whatever the caller asks for.
+func TestWithRateLimitSpacesRequests(t *testing.T) {
+	const qps = 20
+	const requests = 5
+
+	var mu sync.Mutex
+	var arrivals []time.Time
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		w.Write([]byte(`{"groups":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := admin.NewService(context.Background(),
+		option.WithHTTPClient(withRateLimit(server.Client(), qps)),
+		option.WithEndpoint(server.URL+"/"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	adminObj := &Admin{Ctx: context.Background(), service: service}
+
+	for range requests {
+		if _, err := adminObj.GetAllGroups("corp.com"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(arrivals) != requests {
+		t.Fatalf("got %d requests, want %d", len(arrivals), requests)
+	}
+
+	// Allow some slack for timer granularity
+	minGap := time.Second / qps * 9 / 10
+	for i := 1; i < len(arrivals); i++ {
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < minGap {
+			t.Fatalf("request %d came %s after the previous one, want at least %s", i, gap, minGap)
+		}
+	}
+}
+
+// A request waiting for its turn must give up as soon as its context is cancelled.
+func TestWithRateLimitStopsOnCancelledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	client := withRateLimit(server.Client(), 0.001)
+
+	// The first request spends the only token, so the second one has to wait
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+	start := time.Now()
+	if _, err := client.Do(req); err == nil {
+		t.Fatalf("expected an error for the cancelled request")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s, the cancellation was ignored", elapsed)
+	}
+}
+
+// No QPS must leave the client untouched.
+func TestWithRateLimitDisabled(t *testing.T) {
+	client := &http.Client{}
+	if got := withRateLimit(client, 0); got != client {
+		t.Fatalf("expected the same client when the limit is disabled")
+	}
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	//
+	"kegos/internal/globals"
+)
+
+const (
+	// shutdownTimeout bounds how long in-flight probes may take once the process is exiting
+	shutdownTimeout = 5 * time.Second
+)
+
+// Readiness tracks whether kegos is doing its job. It is ready once both APIs authenticated
+// and a reconcile cycle finished, until failureThreshold cycles in a row fail.
+// A nil Readiness is valid and ignores every report
+type Readiness struct {
+	mu sync.Mutex
+
+	failureThreshold int
+
+	keycloakAuthenticated bool
+	gsuiteAuthenticated   bool
+	cyclesCompleted       int
+	consecutiveFailures   int
+	breakerOpen           bool
+}
+
+// NewReadiness returns a not-ready state flipping back to not-ready after failureThreshold
+// failed cycles in a row. Thresholds under one are raised to one
+func NewReadiness(failureThreshold int) *Readiness {
+	return &Readiness{
+		failureThreshold: max(failureThreshold, 1),
+	}
+}
+
+// MarkKeycloakAuthenticated records that Keycloak accepted the client credentials
+func (r *Readiness) MarkKeycloakAuthenticated() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keycloakAuthenticated = true
+}
+
+// MarkGsuiteAuthenticated records that Google answered a call with the configured credentials
+func (r *Readiness) MarkGsuiteAuthenticated() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gsuiteAuthenticated = true
+}
+
+// RecordCycle accounts a finished reconcile cycle, failed when err is not nil
+func (r *Readiness) RecordCycle(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cyclesCompleted++
+	if err != nil {
+		r.consecutiveFailures++
+		return
+	}
+	r.consecutiveFailures = 0
+}
+
+// SetBreakerOpen records whether the reconcile loop is backing off after too many aborted cycles
+func (r *Readiness) SetBreakerOpen(open bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakerOpen = open
+}
+
+// BreakerOpen reports whether the reconcile loop is backing off
+func (r *Readiness) BreakerOpen() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.breakerOpen
+}
+
+// Ready reports whether the process should receive traffic
+func (r *Readiness) Ready() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.keycloakAuthenticated && r.gsuiteAuthenticated &&
+		r.cyclesCompleted > 0 && r.consecutiveFailures < r.failureThreshold && !r.breakerOpen
+}
+
+type ServerOptions struct {
+	AppCtx *globals.ApplicationContext
+
+	Address   string
+	Readiness *Readiness
+}
+
+type Server struct {
+	appCtx *globals.ApplicationContext
+
+	httpServer *http.Server
+}
+
+func NewServer(opts ServerOptions) *Server {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if opts.Readiness.BreakerOpen() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("circuit breaker open\n"))
+			return
+		}
+		if !opts.Readiness.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+
+	return &Server{
+		appCtx: opts.AppCtx,
+		httpServer: &http.Server{
+			Addr:    opts.Address,
+			Handler: mux,
+		},
+	}
+}
+
+// Run serves the health endpoints until the application context is done
+func (s *Server) Run() {
+
+	go func() {
+		<-s.appCtx.Context.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil {
+			s.appCtx.Logger.Error("failed shutting down health server", "error", err.Error())
+		}
+	}()
+
+	s.appCtx.Logger.Info("starting health server", "address", s.httpServer.Addr)
+	err := s.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.appCtx.Logger.Error("failed serving health endpoints", "error", err.Error())
+	}
+}
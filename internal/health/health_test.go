@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Readiness needs both APIs authenticated and a finished cycle, and is lost after N failed cycles in a row.
+func TestReadinessReady(t *testing.T) {
+	failed := errors.New("boom")
+
+	tests := map[string]struct {
+		keycloakAuthenticated bool
+		gsuiteAuthenticated   bool
+		cycles                []error
+		breakerOpen           bool
+		want                  bool
+	}{
+		"fresh process":                {want: false},
+		"no cycle finished yet":        {keycloakAuthenticated: true, gsuiteAuthenticated: true, want: false},
+		"keycloak never authenticated": {gsuiteAuthenticated: true, cycles: []error{nil}, want: false},
+		"gsuite never authenticated":   {keycloakAuthenticated: true, cycles: []error{nil}, want: false},
+		"first cycle succeeded":        {keycloakAuthenticated: true, gsuiteAuthenticated: true, cycles: []error{nil}, want: true},
+		"failures under threshold":     {keycloakAuthenticated: true, gsuiteAuthenticated: true, cycles: []error{nil, failed, failed}, want: true},
+		"failures reach threshold":     {keycloakAuthenticated: true, gsuiteAuthenticated: true, cycles: []error{nil, failed, failed, failed}, want: false},
+		"success resets failures":      {keycloakAuthenticated: true, gsuiteAuthenticated: true, cycles: []error{failed, failed, failed, nil}, want: true},
+		"circuit breaker open":         {keycloakAuthenticated: true, gsuiteAuthenticated: true, cycles: []error{nil, failed}, breakerOpen: true, want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			readiness := NewReadiness(3)
+			if tc.keycloakAuthenticated {
+				readiness.MarkKeycloakAuthenticated()
+			}
+			if tc.gsuiteAuthenticated {
+				readiness.MarkGsuiteAuthenticated()
+			}
+			for _, err := range tc.cycles {
+				readiness.RecordCycle(err)
+			}
+			readiness.SetBreakerOpen(tc.breakerOpen)
+
+			if got := readiness.Ready(); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// /healthz must always answer 200, while /readyz must follow the readiness state.
+func TestServerEndpoints(t *testing.T) {
+	readiness := NewReadiness(1)
+	server := NewServer(ServerOptions{Readiness: readiness})
+
+	var body string
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		body = recorder.Body.String()
+		return recorder.Code
+	}
+
+	if got := get("/healthz"); got != http.StatusOK {
+		t.Fatalf("healthz got %d, want %d", got, http.StatusOK)
+	}
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("readyz before the first cycle got %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	readiness.MarkKeycloakAuthenticated()
+	readiness.MarkGsuiteAuthenticated()
+	readiness.RecordCycle(nil)
+
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Fatalf("readyz after a successful cycle got %d, want %d", got, http.StatusOK)
+	}
+
+	readiness.SetBreakerOpen(true)
+	if got := get("/readyz"); got != http.StatusServiceUnavailable || body != "circuit breaker open\n" {
+		t.Fatalf("readyz with the circuit breaker open got %d %q, want %d", got, body, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package keycloak
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// minRetryAfterWait is the shortest wait before retrying a 429. Retry-After values of zero, negative or in the
+// past would otherwise retry right away, never adding up to the cap, and hammer Keycloak forever
+const minRetryAfterWait = time.Second
+
+// backpressureTransport retries the requests Keycloak, or the gateway in front of it, rejects with a 429,
+// after waiting as long as its Retry-After header asks. It sits under both gocloak and the raw calls.
+// Once the waits of a request would go over maxWait, or without Retry-After, the 429 is returned as it is
+type backpressureTransport struct {
+	base    http.RoundTripper
+	maxWait time.Duration
+	logger  *slog.Logger
+
+	// after waits for a duration, as time.After does. It is replaced in tests
+	after func(time.Duration) <-chan time.Time
+}
+
+func (t *backpressureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		wait, found := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		wait = max(wait, minRetryAfterWait)
+		if !found || waited+wait > t.maxWait {
+			return resp, nil
+		}
+
+		// The body has to be sent again, which is only possible when it can be rebuilt
+		retried := req
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			retried = req.Clone(req.Context())
+			retried.Body = body
+		}
+
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodyBytes))
+		resp.Body.Close()
+
+		t.logger.Warn("keycloak asked to slow down. Waiting before retrying...", "method", req.Method,
+			"path", req.URL.Path, "retry_after", wait.String())
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-t.after(wait):
+		}
+		waited += wait
+		req = retried
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given either as seconds or as an HTTP date, into the time
+// to wait from now. Dates in the past wait nothing
+func parseRetryAfter(value string, now time.Time) (wait time.Duration, found bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// withBackpressure wraps the transport so 429 responses are waited for and retried, up to maxWait per request.
+// A maxWait of zero or below leaves the transport untouched
+func withBackpressure(base http.RoundTripper, maxWait time.Duration, logger *slog.Logger) http.RoundTripper {
+	if maxWait <= 0 {
+		return base
+	}
+	return &backpressureTransport{base: base, maxWait: maxWait, logger: logger, after: time.After}
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package keycloak
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/globals"
+)
+
+// throttlingServer rejects the first requests to every path with a 429 asking to wait retryAfter, and answers
+// the following ones. The bodies of the accepted requests are recorded.
+type throttlingServer struct {
+	retryAfter string
+	rejections int
+
+	mu     sync.Mutex
+	hits   map[string]int
+	bodies []string
+}
+
+func (s *throttlingServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := req.Method + " " + req.URL.Path
+	s.hits[key]++
+	if s.hits[key] <= s.rejections {
+		w.Header().Set("Retry-After", s.retryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if len(body) > 0 {
+		s.bodies = append(s.bodies, string(body))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method == http.MethodGet {
+		w.Write([]byte(`[]`))
+	}
+}
+
+// newThrottledKeycloak returns a client of the server waiting up to maxWait per request, whose waits are
+// recorded instead of slept.
+func newThrottledKeycloak(t *testing.T, server *throttlingServer, maxWait time.Duration) (*Keycloak, *[]time.Duration) {
+	t.Helper()
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	kc, err := NewKeycloak(KeycloakOptions{
+		AppCtx: &globals.ApplicationContext{
+			Context: context.Background(),
+			Logger:  slog.New(slog.DiscardHandler),
+		},
+		URI:               httpServer.URL,
+		Realm:             "test",
+		ClientID:          "kegos",
+		ClientSecret:      "secret",
+		MaxRetryAfterWait: maxWait,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var waits []time.Duration
+	kc.httpClient.Transport.(*backpressureTransport).after = func(wait time.Duration) <-chan time.Time {
+		waits = append(waits, wait)
+		fired := make(chan time.Time, 1)
+		fired <- time.Now()
+		return fired
+	}
+	return kc, &waits
+}
+
+// A 429 must be waited for as long as Retry-After asks and retried, both on raw calls and on gocloak ones,
+// bodies included.
+func TestBackpressureRetriesAfterTooManyRequests(t *testing.T) {
+	server := &throttlingServer{retryAfter: "2", rejections: 2, hits: map[string]int{}}
+	kc, waits := newThrottledKeycloak(t, server, time.Minute)
+
+	if _, err := kc.GetChildrenGroups("token", "parent"); err != nil {
+		t.Fatalf("raw call: unexpected error: %v", err)
+	}
+	if err := kc.AddUserToGroup("token", "user", "group"); err != nil {
+		t.Fatalf("gocloak call: unexpected error: %v", err)
+	}
+	if err := kc.UpdateGroup("token", gocloak.Group{ID: gocloak.StringP("group"), Name: gocloak.StringP("dev")}); err != nil {
+		t.Fatalf("gocloak call with body: unexpected error: %v", err)
+	}
+
+	// Every call is rejected twice
+	if want := slices.Repeat([]time.Duration{2 * time.Second}, 6); !reflect.DeepEqual(*waits, want) {
+		t.Fatalf("waited %v, want %v", *waits, want)
+	}
+	if len(server.bodies) != 1 || server.bodies[0] == "" {
+		t.Fatalf("expected the group to be sent once the 429s were over, got bodies %v", server.bodies)
+	}
+}
+
+// A request must give up once waiting again would go over the cap, returning the 429 as a failure.
+func TestBackpressureCapsTheWait(t *testing.T) {
+	tests := map[string]struct {
+		maxWait   time.Duration
+		wantWaits []time.Duration
+	}{
+		"cap below the first wait": {maxWait: time.Second},
+		"cap spent by the first wait": {
+			maxWait:   3 * time.Second,
+			wantWaits: []time.Duration{2 * time.Second},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &throttlingServer{retryAfter: "2", rejections: 5, hits: map[string]int{}}
+			kc, waits := newThrottledKeycloak(t, server, tc.maxWait)
+
+			err := kc.AddUserToGroup("token", "user", "group")
+			if !IsTooManyRequestsError(err) {
+				t.Fatalf("got %v, want the 429 as error", err)
+			}
+			if !reflect.DeepEqual(*waits, tc.wantWaits) {
+				t.Fatalf("waited %v, want %v", *waits, tc.wantWaits)
+			}
+		})
+	}
+}
+
+// A Retry-After asking to wait nothing must still be waited for a while, so the cap is reached instead of
+// retrying forever.
+func TestBackpressureNeverRetriesRightAway(t *testing.T) {
+	server := &throttlingServer{retryAfter: "0", rejections: 100, hits: map[string]int{}}
+	kc, waits := newThrottledKeycloak(t, server, 3*time.Second)
+
+	err := kc.AddUserToGroup("token", "user", "group")
+	if !IsTooManyRequestsError(err) {
+		t.Fatalf("got %v, want the 429 as error", err)
+	}
+	if want := slices.Repeat([]time.Duration{minRetryAfterWait}, 3); !reflect.DeepEqual(*waits, want) {
+		t.Fatalf("waited %v, want %v", *waits, want)
+	}
+}
+
+// Without a cap, 429s must be returned right away, as they always were.
+func TestWithBackpressureDisabled(t *testing.T) {
+	transport := http.DefaultTransport
+	if got := withBackpressure(transport, 0, slog.New(slog.DiscardHandler)); got != transport {
+		t.Fatalf("expected the same transport when disabled")
+	}
+}
+
+// Retry-After must be read both as seconds and as an HTTP date, never waiting a negative time.
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value     string
+		want      time.Duration
+		wantFound bool
+	}{
+		"seconds":       {value: "30", want: 30 * time.Second, wantFound: true},
+		"zero seconds":  {value: "0", want: 0, wantFound: true},
+		"http date":     {value: "Thu, 01 Jan 2026 00:00:10 GMT", want: 10 * time.Second, wantFound: true},
+		"date passed":   {value: "Wed, 31 Dec 2025 23:59:00 GMT", want: 0, wantFound: true},
+		"negative":      {value: "-5", want: 0, wantFound: true},
+		"missing":       {value: ""},
+		"not a number":  {value: "soon"},
+		"decimal value": {value: "1.5"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, found := parseRetryAfter(tc.value, now)
+			if got != tc.want || found != tc.wantFound {
+				t.Fatalf("got %s, %v, want %s, %v", got, found, tc.want, tc.wantFound)
+			}
+		})
+	}
+}
@@ -4,13 +4,51 @@
 package keycloak
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"kegos/internal/globals"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	//
 	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+	"kegos/internal/globals"
+	"kegos/internal/retry"
+)
+
+const (
+	// tokenRenewMargin is how long before expiry a token is considered stale and renewed.
+	tokenRenewMargin = 30 * time.Second
+
+	// defaultTimeout bounds every request to Keycloak when no timeout is configured
+	defaultTimeout = 30 * time.Second
+
+	// defaultMaxConcurrentRequests bounds the membership changes sent at once when no limit is configured
+	defaultMaxConcurrentRequests = 4
+
+	// DefaultBatchSize is how many users or groups are asked for per page of a listing when no size is configured
+	DefaultBatchSize = 100
+
+	// clientAssertionLifetime is how long the JWT signed to log in is accepted by Keycloak
+	clientAssertionLifetime = time.Minute
+
+	// maxErrorBodyBytes bounds how much of a failed response is read into its error
+	maxErrorBodyBytes = 4 << 10
 )
 
 type KeycloakOptions struct {
@@ -20,6 +58,51 @@ type KeycloakOptions struct {
 	Realm        string
 	ClientID     string
 	ClientSecret string
+
+	// AuthRealm is the realm the client logs in to, such as master for a service account administering
+	// other realms. Every other call targets Realm. It defaults to Realm when empty
+	AuthRealm string
+
+	// ClientJWTKeyPath points to the PEM private key, RSA or EC, signing the JWT the client logs in with.
+	// It is used instead of ClientSecret when set
+	ClientJWTKeyPath string
+
+	// Timeout bounds every request to Keycloak. It defaults to 30 seconds when zero
+	Timeout time.Duration
+
+	// CallTimeout bounds every call to Keycloak through a context deadline, each page of a listing on its own.
+	// Zero leaves calls without deadline
+	CallTimeout time.Duration
+
+	// CACertPath points to a PEM bundle trusted on top of the system roots, for Keycloak behind a private CA
+	CACertPath string
+
+	// InsecureSkipVerify disables the verification of Keycloak's certificate. Meant for testing only
+	InsecureSkipVerify bool
+
+	// Proxy picks the proxy of every request to Keycloak. The proxy environment variables are used when nil
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// MaxConcurrentRequests bounds the membership changes sent at once by UpdateGroupMemberships.
+	// It defaults to 4 when zero
+	MaxConcurrentRequests int
+
+	// UserBatchSize and GroupBatchSize are the page sizes of user and group listings, realm roles counting
+	// as groups. They default to DefaultBatchSize when zero
+	UserBatchSize  int
+	GroupBatchSize int
+
+	// MaxRetryAfterWait caps how long a single request may wait, in total, for the Retry-After of the 429
+	// responses Keycloak sends under load before being retried. Zero returns those responses as failures
+	MaxRetryAfterWait time.Duration
+
+	// GroupSearchExact looks top-level groups up by an exact search, instead of listing them all. Servers
+	// ignoring the exact parameter answer other groups too, so results are compared again by name and path
+	GroupSearchExact bool
+
+	// Retry applies to the calls kegos sends through its own HTTP client, such as each page of children groups,
+	// as gocloak does not handle them. Zero disables retries
+	Retry retry.Options
 }
 
 type Keycloak struct {
@@ -27,11 +110,32 @@ type Keycloak struct {
 
 	URI          string
 	Realm        string
+	AuthRealm    string
 	ClientID     string
 	ClientSecret string
 
+	// clientJWTKey signs the login JWT, along with clientJWTMethod, when the client authenticates with a private key
+	clientJWTKey    any
+	clientJWTMethod jwt.SigningMethod
+
 	gocloakCli         *gocloak.GoCloak
+	httpClient         *http.Client
 	gocloakAccessToken *gocloak.JWT
+	tokenExpiry        time.Time
+
+	callTimeout           time.Duration
+	maxConcurrentRequests int
+	userBatchSize         int
+	groupBatchSize        int
+	groupSearchExact      bool
+	retryOpts             retry.Options
+}
+
+// MembershipChange is a user joining, or leaving when Remove is set, a group
+type MembershipChange struct {
+	UserID  string
+	GroupID string
+	Remove  bool
 }
 
 func NewKeycloak(opts KeycloakOptions) (*Keycloak, error) {
@@ -41,27 +145,167 @@ func NewKeycloak(opts KeycloakOptions) (*Keycloak, error) {
 
 		URI:          opts.URI,
 		Realm:        opts.Realm,
+		AuthRealm:    cmp.Or(opts.AuthRealm, opts.Realm),
 		ClientID:     opts.ClientID,
 		ClientSecret: opts.ClientSecret,
+
+		callTimeout:           opts.CallTimeout,
+		maxConcurrentRequests: opts.MaxConcurrentRequests,
+		userBatchSize:         opts.UserBatchSize,
+		groupBatchSize:        opts.GroupBatchSize,
+		groupSearchExact:      opts.GroupSearchExact,
+		retryOpts:             opts.Retry,
+	}
+
+	if object.maxConcurrentRequests <= 0 {
+		object.maxConcurrentRequests = defaultMaxConcurrentRequests
+	}
+	if object.userBatchSize <= 0 {
+		object.userBatchSize = DefaultBatchSize
+	}
+	if object.groupBatchSize <= 0 {
+		object.groupBatchSize = DefaultBatchSize
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
 	}
 
+	if opts.ClientJWTKeyPath != "" {
+		key, method, err := readClientJWTKey(opts.ClientJWTKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		object.clientJWTKey = key
+		object.clientJWTMethod = method
+	}
+
+	tlsConfig, err := newTLSConfig(opts.CACertPath, opts.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	if opts.InsecureSkipVerify {
+		opts.AppCtx.Logger.Warn("Keycloak certificate verification is disabled")
+	}
+
+	// gocloak and the raw calls share the transport, so both trust the same CAs and reuse connections
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if opts.Proxy != nil {
+		transport.Proxy = opts.Proxy
+	}
+
+	roundTripper := withBackpressure(transport, opts.MaxRetryAfterWait, opts.AppCtx.Logger)
+
 	gcClient := gocloak.NewClient(object.URI)
+	gcClient.RestyClient().SetTransport(roundTripper)
+	gcClient.RestyClient().SetTimeout(timeout)
 	object.gocloakCli = gcClient
 
+	object.httpClient = &http.Client{Transport: roundTripper, Timeout: timeout}
+
 	return object, nil
 }
 
-// RenewToken renew JWTs in Keycloak server and store it into Keycloak object
+// newTLSConfig returns the TLS settings to reach Keycloak: the system roots plus the CAs in
+// caCertPath, when given
+func newTLSConfig(caCertPath string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caCertPath == "" {
+		return tlsConfig, nil
+	}
+
+	caCerts, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading Keycloak CA bundle: %w", err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(caCerts) {
+		return nil, errors.New("failed reading Keycloak CA bundle: no PEM certificate found in " + caCertPath)
+	}
+
+	tlsConfig.RootCAs = rootCAs
+	return tlsConfig, nil
+}
+
+// readClientJWTKey reads the PEM private key signing the login JWT, and picks the signing method matching it
+func readClientJWTKey(path string) (key any, method jwt.SigningMethod, err error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading Keycloak client JWT key: %w", err)
+	}
+
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyPEM); err == nil {
+		return rsaKey, jwt.SigningMethodRS256, nil
+	}
+
+	ecKey, err := jwt.ParseECPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, nil, errors.New("failed reading Keycloak client JWT key: no RSA or EC private key found in " + path)
+	}
+	return ecKey, ecSigningMethod(ecKey), nil
+}
+
+// ecSigningMethod returns the ECDSA signing method matching the curve of the key
+func ecSigningMethod(key *ecdsa.PrivateKey) jwt.SigningMethod {
+	switch key.Curve.Params().BitSize {
+	case 384:
+		return jwt.SigningMethodES384
+	case 521:
+		return jwt.SigningMethodES512
+	default:
+		return jwt.SigningMethodES256
+	}
+}
+
+// callContext derives the context of a single call from the application one, so a call timing out
+// never cancels the ones coming after it
+func (k *Keycloak) callContext() (context.Context, context.CancelFunc) {
+	if k.callTimeout <= 0 {
+		return context.WithCancel(k.appCtx.Context)
+	}
+	return context.WithTimeout(k.appCtx.Context, k.callTimeout)
+}
+
+// RenewToken renew JWTs in Keycloak server and store it into Keycloak object, logging in to the auth realm.
+// The client logs in with a signed JWT when it holds a private key, and with its secret otherwise
 func (k *Keycloak) RenewToken() error {
-	tmpToken, err := k.gocloakCli.LoginClient(k.appCtx.Context, k.ClientID, k.ClientSecret, k.Realm)
+	ctx, cancel := k.callContext()
+	defer cancel()
+
+	var tmpToken *gocloak.JWT
+	var err error
+	if k.clientJWTKey != nil {
+		expiresAt := jwt.NewNumericDate(time.Now().Add(clientAssertionLifetime))
+		tmpToken, err = k.gocloakCli.LoginClientSignedJWT(ctx, k.ClientID, k.AuthRealm, k.clientJWTKey, k.clientJWTMethod, expiresAt)
+	} else {
+		tmpToken, err = k.gocloakCli.LoginClient(ctx, k.ClientID, k.ClientSecret, k.AuthRealm)
+	}
 	if err != nil {
 		return fmt.Errorf("failed signing in: %s", err.Error())
 	}
 
 	k.gocloakAccessToken = tmpToken
+	k.tokenExpiry = time.Now().Add(time.Duration(tmpToken.ExpiresIn) * time.Second)
 	return nil
 }
 
+// EnsureToken renews the JWT only when there is none yet or it is about to expire
+func (k *Keycloak) EnsureToken() error {
+	if k.gocloakAccessToken != nil && time.Now().Add(tokenRenewMargin).Before(k.tokenExpiry) {
+		return nil
+	}
+	return k.RenewToken()
+}
+
 // GetToken ...
 func (k *Keycloak) GetToken() *gocloak.JWT {
 	return k.gocloakAccessToken
@@ -72,20 +316,216 @@ func (k *Keycloak) GetGocloakClient() *gocloak.GoCloak {
 	return k.gocloakCli
 }
 
+// GetTopLevelGroup return the top-level group named exactly as given, or nil when there is none.
+// Keycloak searches match substrings and subgroups too, so the whole top-level list is compared instead,
+// unless an exact search was asked for. Its results are compared just the same, as some versions ignore
+// the exact parameter, and the path too, as others return matching subgroups flattened.
+// Several groups sharing the name is reported as an error rather than picking one
+func (k *Keycloak) GetTopLevelGroup(accessToken, name string) (*gocloak.Group, error) {
+	params := gocloak.GetGroupsParams{}
+	if k.groupSearchExact {
+		params.Search = gocloak.StringP(name)
+		params.Exact = gocloak.BoolP(true)
+	}
+
+	groups, err := k.getGroups(accessToken, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *gocloak.Group
+	for _, group := range groups {
+		if group.Name == nil || *group.Name != name {
+			continue
+		}
+		if k.groupSearchExact && group.Path != nil && *group.Path != "/"+name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several top-level groups are named %q", name)
+		}
+		found = group
+	}
+
+	return found, nil
+}
+
+// CreateGroup creates a top-level group and return its ID
+func (k *Keycloak) CreateGroup(accessToken string, group gocloak.Group) (string, error) {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.CreateGroup(ctx, accessToken, k.Realm, group)
+}
+
+// IsNotFoundError reports whether Keycloak rejected a call because the resource, such as a group, does not exist
+func IsNotFoundError(err error) bool {
+	var keycloakErr *gocloak.APIError
+	return errors.As(err, &keycloakErr) && keycloakErr.Code == http.StatusNotFound
+}
+
+// IsConflictError reports whether Keycloak rejected a call because the resource already exists,
+// such as a group created meanwhile by someone else under the same name
+func IsConflictError(err error) bool {
+	var keycloakErr *gocloak.APIError
+	return errors.As(err, &keycloakErr) && keycloakErr.Code == http.StatusConflict
+}
+
+// IsTooManyRequestsError reports whether Keycloak, or a gateway in front of it, rejected a call for being
+// under too much load, once any wait allowed for it ran out
+func IsTooManyRequestsError(err error) bool {
+	var keycloakErr *gocloak.APIError
+	return errors.As(err, &keycloakErr) && keycloakErr.Code == http.StatusTooManyRequests
+}
+
+// CreateChildGroup creates a group under the given parent group and return its ID
+func (k *Keycloak) CreateChildGroup(accessToken, parentID string, group gocloak.Group) (string, error) {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.CreateChildGroup(ctx, accessToken, k.Realm, parentID, group)
+}
+
+// AddUserToGroup attaches a user to a group
+func (k *Keycloak) AddUserToGroup(accessToken, userID, groupID string) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.AddUserToGroup(ctx, accessToken, k.Realm, userID, groupID)
+}
+
+// DeleteUserFromGroup detaches a user from a group
+func (k *Keycloak) DeleteUserFromGroup(accessToken, userID, groupID string) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.DeleteUserFromGroup(ctx, accessToken, k.Realm, userID, groupID)
+}
+
+// UpdateGroupMemberships applies membership changes concurrently, with at most MaxConcurrentRequests in flight,
+// as Keycloak offers no bulk endpoint for them. Each change is retried on its own following retryOpts.
+// The error of every change is returned in the same order, nil for those that succeeded
+func (k *Keycloak) UpdateGroupMemberships(accessToken string, changes []MembershipChange, retryOpts retry.Options) []error {
+	errs := make([]error, len(changes))
+	inFlight := make(chan struct{}, k.maxConcurrentRequests)
+
+	var wg sync.WaitGroup
+	for i, change := range changes {
+		inFlight <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			errs[i] = retry.Do(k.appCtx.Context, retryOpts, func() error {
+				if change.Remove {
+					return k.DeleteUserFromGroup(accessToken, change.UserID, change.GroupID)
+				}
+				return k.AddUserToGroup(accessToken, change.UserID, change.GroupID)
+			})
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// DeleteGroup deletes a group along with its memberships
+func (k *Keycloak) DeleteGroup(accessToken, groupID string) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.DeleteGroup(ctx, accessToken, k.Realm, groupID)
+}
+
+// UpdateGroup overwrites a group, attributes included. The group ID is mandatory
+func (k *Keycloak) UpdateGroup(accessToken string, group gocloak.Group) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.UpdateGroup(ctx, accessToken, k.Realm, group)
+}
+
+// mergeAttributes sets the desired attributes on top of the current ones, keeping any other attribute, and reports
+// whether any value changed
+func mergeAttributes(current *map[string][]string, desired map[string][]string) (merged map[string][]string, changed bool) {
+	merged = map[string][]string{}
+	if current != nil {
+		maps.Copy(merged, *current)
+	}
+
+	for key, values := range desired {
+		if currentValues, found := merged[key]; !found || !slices.Equal(currentValues, values) {
+			merged[key] = values
+			changed = true
+		}
+	}
+	return merged, changed
+}
+
+// EnsureGroupAttributes sets the given attributes on a group, keeping any other attribute it has, such as those
+// set by other tools. The group is only updated when a value differs, and updated reports whether it was
+func (k *Keycloak) EnsureGroupAttributes(accessToken, groupID string, attributes map[string][]string) (updated bool, err error) {
+	ctx, cancel := k.callContext()
+	group, err := k.gocloakCli.GetGroup(ctx, accessToken, k.Realm, groupID)
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("failed getting group %s: %w", groupID, err)
+	}
+
+	merged, changed := mergeAttributes(group.Attributes, attributes)
+	if !changed {
+		return false, nil
+	}
+
+	group.Attributes = &merged
+	err = k.UpdateGroup(accessToken, *group)
+	if err != nil {
+		return false, fmt.Errorf("failed updating attributes of group %s: %w", groupID, err)
+	}
+	return true, nil
+}
+
+// EnsureUserAttributes sets the given attributes on a user, keeping any other attribute it has, such as those
+// set by other tools or federation. The user is only updated when a value differs, and updated reports whether it was
+func (k *Keycloak) EnsureUserAttributes(accessToken, userID string, attributes map[string][]string) (updated bool, err error) {
+	ctx, cancel := k.callContext()
+	user, err := k.gocloakCli.GetUserByID(ctx, accessToken, k.Realm, userID)
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("failed getting user %s: %w", userID, err)
+	}
+
+	merged, changed := mergeAttributes(user.Attributes, attributes)
+	if !changed {
+		return false, nil
+	}
+
+	user.Attributes = &merged
+	ctx, cancel = k.callContext()
+	err = k.gocloakCli.UpdateUser(ctx, accessToken, k.Realm, *user)
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("failed updating attributes of user %s: %w", userID, err)
+	}
+	return true, nil
+}
+
 // GetGroups return all the groups following pagination until the end.
 func (k *Keycloak) GetGroups(accessToken string) ([]*gocloak.Group, error) {
+	return k.getGroups(accessToken, gocloak.GetGroupsParams{})
+}
+
+// getGroups return all the groups matching the given params following pagination until the end
+func (k *Keycloak) getGroups(accessToken string, params gocloak.GetGroupsParams) ([]*gocloak.Group, error) {
 	var allGroups []*gocloak.Group
 	paramFirst := 0
-	paramMax := 100
+	paramMax := k.groupBatchSize
 
 	for {
+		params.First = gocloak.IntP(paramFirst)
+		params.Max = gocloak.IntP(paramMax)
 
-		tmpGroups, err := k.gocloakCli.GetGroups(k.appCtx.Context, accessToken, k.Realm, gocloak.GetGroupsParams{
-			First: gocloak.IntP(paramFirst),
-			Max:   gocloak.IntP(paramMax),
-		})
+		ctx, cancel := k.callContext()
+		tmpGroups, err := k.gocloakCli.GetGroups(ctx, accessToken, k.Realm, params)
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed getting groups: %v", err)
+			return nil, fmt.Errorf("failed getting groups: %w", err)
 		}
 
 		allGroups = append(allGroups, tmpGroups...)
@@ -102,77 +542,164 @@ func (k *Keycloak) GetGroups(accessToken string) ([]*gocloak.Group, error) {
 }
 
 // GetChildrenGroups return all the children groups for a specific group ID following pagination until the end.
+// Each page is retried on its own on transient failures
 func (k *Keycloak) GetChildrenGroups(accessToken, groupID string) ([]*gocloak.Group, error) {
 	var allGroups []*gocloak.Group
 	paramFirst := 0
-	paramMax := 100
+	paramMax := k.groupBatchSize
 
 	for {
-		u := fmt.Sprintf("%s/admin/realms/%s/groups/%s/children?first=%d&max=%d",
-			k.URI, k.Realm, groupID, paramFirst, paramMax)
-
-		//
-		req, err := http.NewRequestWithContext(k.appCtx.Context, "GET", u, nil)
+		var groups []*gocloak.Group
+		err := retry.Do(k.appCtx.Context, k.retryOpts, func() (err error) {
+			groups, err = k.getChildrenGroupsPage(accessToken, groupID, paramFirst, paramMax)
+			return err
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+			return nil, err
 		}
 
-		//
-		req.Header.Set("Authorization", "Bearer "+accessToken)
-		req.Header.Set("Content-Type", "application/json")
+		allGroups = append(allGroups, groups...)
 
-		// Perform the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to make request: %w", err)
+		// When we receive fewer than max, there are no more pages
+		if len(groups) < paramMax {
+			break
 		}
-		defer resp.Body.Close()
 
-		// Verify response
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-		}
+		paramFirst += paramMax
+	}
 
-		//
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read response: %w", err)
-		}
+	return allGroups, nil
+}
 
-		var groups []*gocloak.Group
-		if err := json.Unmarshal(body, &groups); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// getChildrenGroupsPage return one page of the children groups of a group
+func (k *Keycloak) getChildrenGroupsPage(accessToken, groupID string, first, pageSize int) ([]*gocloak.Group, error) {
+
+	u, err := url.JoinPath(k.URI, "admin", "realms", k.Realm, "groups", groupID, "children")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request URL: %w", err)
+	}
+	u += "?" + url.Values{
+		"first":               {strconv.Itoa(first)},
+		"max":                 {strconv.Itoa(pageSize)},
+		"briefRepresentation": {"false"},
+	}.Encode()
+
+	//
+	ctx, cancel := k.callContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	//
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request
+	start := time.Now()
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		k.appCtx.Logger.Debug("keycloak request failed", "method", req.Method, "path", req.URL.Path,
+			"duration", time.Since(start), "error", err.Error())
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	k.appCtx.Logger.Debug("keycloak request", "method", req.Method, "path", req.URL.Path,
+		"status", resp.StatusCode, "duration", time.Since(start))
+
+	// Verify response
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseAPIError(resp)
+	}
+
+	//
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var groups []*gocloak.Group
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return groups, nil
+}
+
+// parseAPIError turns a failed response into an APIError, worded like those returned by gocloak.
+// Keycloak's error body, when any, is decoded into its error and message fields
+func parseAPIError(resp *http.Response) *gocloak.APIError {
+	apiErr := &gocloak.APIError{
+		Code:    resp.StatusCode,
+		Message: resp.Status,
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return apiErr
+	}
+
+	var errorBody gocloak.HTTPErrorResponse
+	if json.Unmarshal(body, &errorBody) == nil && errorBody.String() != "" {
+		apiErr.Message += ": " + errorBody.String()
+	} else {
+		apiErr.Message += ": " + string(body)
+	}
+	apiErr.Type = gocloak.ParseAPIErrType(errors.New(apiErr.Message))
+	return apiErr
+}
+
+// GetUsers return all the children users following pagination until the end.
+func (k *Keycloak) GetUsers(accessToken string) ([]*gocloak.User, error) {
+
+	var allUsers []*gocloak.User
+	paramFirst := 0
+	paramMax := k.userBatchSize
+
+	for {
+		ctx, cancel := k.callContext()
+		tmpUsers, err := k.gocloakCli.GetUsers(ctx, accessToken, k.Realm, gocloak.GetUsersParams{
+			First: gocloak.IntP(paramFirst),
+			Max:   gocloak.IntP(paramMax),
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed getting users: %w", err)
 		}
 
-		allGroups = append(allGroups, groups...)
+		allUsers = append(allUsers, tmpUsers...)
 
 		// When we receive fewer than max, there are no more pages
-		if len(groups) < paramMax {
+		if len(tmpUsers) < paramMax {
 			break
 		}
 
 		paramFirst += paramMax
 	}
 
-	return allGroups, nil
+	return allUsers, nil
 }
 
-// GetUsers return all the children users following pagination until the end.
-func (k *Keycloak) GetUsers(accessToken string) ([]*gocloak.User, error) {
+// GetGroupMembers return all the users directly in a group following pagination until the end.
+func (k *Keycloak) GetGroupMembers(accessToken, groupID string) ([]*gocloak.User, error) {
 
 	var allUsers []*gocloak.User
 	paramFirst := 0
-	paramMax := 100
+	paramMax := k.userBatchSize
 
 	for {
-		tmpUsers, err := k.gocloakCli.GetUsers(k.appCtx.Context, accessToken, k.Realm, gocloak.GetUsersParams{
+		ctx, cancel := k.callContext()
+		tmpUsers, err := k.gocloakCli.GetGroupMembers(ctx, accessToken, k.Realm, groupID, gocloak.GetGroupsParams{
 			First: gocloak.IntP(paramFirst),
 			Max:   gocloak.IntP(paramMax),
 		})
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed getting users: %v", err)
+			return nil, fmt.Errorf("failed getting members of group %s: %w", groupID, err)
 		}
 
 		allUsers = append(allUsers, tmpUsers...)
@@ -188,20 +715,66 @@ func (k *Keycloak) GetUsers(accessToken string) ([]*gocloak.User, error) {
 	return allUsers, nil
 }
 
+// GetUserByUsername return the user with the given username, or nil when there is none
+func (k *Keycloak) GetUserByUsername(accessToken, username string) (*gocloak.User, error) {
+	return k.getUserBy(accessToken, "username", gocloak.GetUsersParams{Username: gocloak.StringP(username)},
+		username, func(user *gocloak.User) *string { return user.Username })
+}
+
+// GetUserByEmail return the user with the given email, or nil when there is none.
+// Realms allowing duplicated emails may hold several, which is reported as an error rather than picking one
+func (k *Keycloak) GetUserByEmail(accessToken, email string) (*gocloak.User, error) {
+	return k.getUserBy(accessToken, "email", gocloak.GetUsersParams{Email: gocloak.StringP(email)},
+		email, func(user *gocloak.User) *string { return user.Email })
+}
+
+// getUserBy searches the users exactly matching a field. Keycloak versions ignoring the exact parameter
+// match substrings, so results are compared again, case-insensitively as Keycloak stores them lowercased
+func (k *Keycloak) getUserBy(accessToken, field string, params gocloak.GetUsersParams, value string,
+	fieldOf func(user *gocloak.User) *string) (*gocloak.User, error) {
+
+	params.Exact = gocloak.BoolP(true)
+
+	ctx, cancel := k.callContext()
+	users, err := k.gocloakCli.GetUsers(ctx, accessToken, k.Realm, params)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user by %s: %w", field, err)
+	}
+
+	var found *gocloak.User
+	for _, user := range users {
+		if !strings.EqualFold(gocloak.PString(fieldOf(user)), value) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several users have %s %q", field, value)
+		}
+		found = user
+	}
+
+	return found, nil
+}
+
 // GetUserGroups return all the groups attached to a user following pagination until the end.
+// Full representations are asked for, and groups some servers still return without name or path are
+// looked up by ID, so callers can rely on both
 func (k *Keycloak) GetUserGroups(userID, accessToken string) ([]*gocloak.Group, error) {
 
 	var allGroups []*gocloak.Group
 	paramFirst := 0
-	paramMax := 100
+	paramMax := k.groupBatchSize
 
 	for {
-		tmpGroups, err := k.gocloakCli.GetUserGroups(k.appCtx.Context, accessToken, k.Realm, userID, gocloak.GetGroupsParams{
-			First: gocloak.IntP(paramFirst),
-			Max:   gocloak.IntP(paramMax),
+		ctx, cancel := k.callContext()
+		tmpGroups, err := k.gocloakCli.GetUserGroups(ctx, accessToken, k.Realm, userID, gocloak.GetGroupsParams{
+			First:               gocloak.IntP(paramFirst),
+			Max:                 gocloak.IntP(paramMax),
+			BriefRepresentation: gocloak.BoolP(false),
 		})
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed getting user groups: %v", err)
+			return nil, fmt.Errorf("failed getting user groups: %w", err)
 		}
 
 		allGroups = append(allGroups, tmpGroups...)
@@ -214,5 +787,94 @@ func (k *Keycloak) GetUserGroups(userID, accessToken string) ([]*gocloak.Group,
 		paramFirst += paramMax
 	}
 
+	for i, group := range allGroups {
+		if group.Name != nil && group.Path != nil {
+			continue
+		}
+		if group.ID == nil {
+			return nil, fmt.Errorf("failed getting user groups: group without ID")
+		}
+
+		ctx, cancel := k.callContext()
+		fullGroup, err := k.gocloakCli.GetGroup(ctx, accessToken, k.Realm, *group.ID)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed getting user group %s: %w", *group.ID, err)
+		}
+		allGroups[i] = fullGroup
+	}
+
 	return allGroups, nil
 }
+
+// GetRealmRoles return all the realm roles, attributes included, following pagination until the end.
+func (k *Keycloak) GetRealmRoles(accessToken string) ([]*gocloak.Role, error) {
+
+	var allRoles []*gocloak.Role
+	paramFirst := 0
+	paramMax := k.groupBatchSize
+
+	for {
+		ctx, cancel := k.callContext()
+		tmpRoles, err := k.gocloakCli.GetRealmRoles(ctx, accessToken, k.Realm, gocloak.GetRoleParams{
+			First:               gocloak.IntP(paramFirst),
+			Max:                 gocloak.IntP(paramMax),
+			BriefRepresentation: gocloak.BoolP(false),
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed getting realm roles: %w", err)
+		}
+
+		allRoles = append(allRoles, tmpRoles...)
+
+		// When we receive fewer than max, there are no more pages
+		if len(tmpRoles) < paramMax {
+			break
+		}
+
+		paramFirst += paramMax
+	}
+
+	return allRoles, nil
+}
+
+// GetRealmRole return a realm role by name
+func (k *Keycloak) GetRealmRole(accessToken, name string) (*gocloak.Role, error) {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.GetRealmRole(ctx, accessToken, k.Realm, name)
+}
+
+// CreateRealmRole creates a realm role. Roles are known by name, so no ID is returned
+func (k *Keycloak) CreateRealmRole(accessToken string, role gocloak.Role) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	_, err := k.gocloakCli.CreateRealmRole(ctx, accessToken, k.Realm, role)
+	return err
+}
+
+// GetUserRealmRoles return the realm roles assigned directly to a user
+func (k *Keycloak) GetUserRealmRoles(userID, accessToken string) ([]*gocloak.Role, error) {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	roles, err := k.gocloakCli.GetRealmRolesByUserID(ctx, accessToken, k.Realm, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user realm roles: %w", err)
+	}
+	return roles, nil
+}
+
+// AddRealmRoleToUser assigns a realm role to a user. The role ID and name are mandatory
+func (k *Keycloak) AddRealmRoleToUser(accessToken, userID string, role gocloak.Role) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.AddRealmRoleToUser(ctx, accessToken, k.Realm, userID, []gocloak.Role{role})
+}
+
+// DeleteRealmRoleFromUser unassigns a realm role from a user. The role ID and name are mandatory
+func (k *Keycloak) DeleteRealmRoleFromUser(accessToken, userID string, role gocloak.Role) error {
+	ctx, cancel := k.callContext()
+	defer cancel()
+	return k.gocloakCli.DeleteRealmRoleFromUser(ctx, accessToken, k.Realm, userID, []gocloak.Role{role})
+}
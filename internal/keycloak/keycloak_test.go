@@ -0,0 +1,1306 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package keycloak
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+	"kegos/internal/globals"
+	"kegos/internal/retry"
+)
+
+// fakeKeycloakServer records the paths it is hit with and answers token and user requests.
+type fakeKeycloakServer struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (f *fakeKeycloakServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.paths = append(f.paths, req.URL.Path)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch req.URL.Path {
+	case "/realms/test/protocol/openid-connect/token", "/realms/master/protocol/openid-connect/token":
+		w.Write([]byte(`{"access_token":"fresh","expires_in":300,"token_type":"Bearer"}`))
+	default:
+		w.Write([]byte(`[]`))
+	}
+}
+
+func newTestKeycloak(t testing.TB, handler http.Handler) *Keycloak {
+	t.Helper()
+	return newTestKeycloakWithOptions(t, handler, "", 0)
+}
+
+// newTestKeycloakWithOptions appends uriSuffix to the server URL and applies the given timeout.
+func newTestKeycloakWithOptions(t testing.TB, handler http.Handler, uriSuffix string, timeout time.Duration) *Keycloak {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	kc, err := NewKeycloak(KeycloakOptions{
+		AppCtx: &globals.ApplicationContext{
+			Context: context.Background(),
+			Logger:  slog.New(slog.DiscardHandler),
+		},
+		URI:          server.URL + uriSuffix,
+		Realm:        "test",
+		ClientID:     "kegos",
+		ClientSecret: "secret",
+		Timeout:      timeout,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return kc
+}
+
+// An expired token must be renewed before the next API call goes out.
+func TestEnsureTokenRenewsExpiredTokenBeforeAPICalls(t *testing.T) {
+	fake := &fakeKeycloakServer{}
+	kc := newTestKeycloak(t, fake)
+
+	kc.gocloakAccessToken = &gocloak.JWT{AccessToken: "stale", ExpiresIn: 60}
+	kc.tokenExpiry = time.Now().Add(-time.Minute)
+
+	if err := kc.EnsureToken(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := kc.GetUsers(kc.GetToken().AccessToken); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.paths) != 2 {
+		t.Fatalf("expected a token request followed by a users request, got %v", fake.paths)
+	}
+	if fake.paths[0] != "/realms/test/protocol/openid-connect/token" {
+		t.Fatalf("expected the token to be renewed first, got %v", fake.paths)
+	}
+	if got := kc.GetToken().AccessToken; got != "fresh" {
+		t.Fatalf("got token %q, want %q", got, "fresh")
+	}
+}
+
+// The client must log in to the auth realm, falling back to the target one, while every other call targets the latter.
+func TestRenewTokenLogsInToAuthRealm(t *testing.T) {
+	tests := map[string]struct {
+		authRealm string
+		wantToken string
+	}{
+		"target realm by default": {wantToken: "/realms/test/protocol/openid-connect/token"},
+		"separate auth realm":     {authRealm: "master", wantToken: "/realms/master/protocol/openid-connect/token"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeKeycloakServer{}
+			server := httptest.NewServer(fake)
+			t.Cleanup(server.Close)
+
+			kc, err := NewKeycloak(KeycloakOptions{
+				AppCtx:       &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+				URI:          server.URL,
+				Realm:        "test",
+				AuthRealm:    tc.authRealm,
+				ClientID:     "kegos",
+				ClientSecret: "secret",
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := kc.RenewToken(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := kc.GetUsers(kc.GetToken().AccessToken); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want := []string{tc.wantToken, "/admin/realms/test/users"}; !reflect.DeepEqual(fake.paths, want) {
+				t.Fatalf("got paths %v, want %v", fake.paths, want)
+			}
+		})
+	}
+}
+
+// EnsureToken must leave a token alone while it is still comfortably valid.
+func TestEnsureTokenKeepsValidToken(t *testing.T) {
+	fake := &fakeKeycloakServer{}
+	kc := newTestKeycloak(t, fake)
+
+	kc.gocloakAccessToken = &gocloak.JWT{AccessToken: "current", ExpiresIn: 300}
+	kc.tokenExpiry = time.Now().Add(5 * time.Minute)
+
+	if err := kc.EnsureToken(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.paths) != 0 {
+		t.Fatalf("expected no requests, got %v", fake.paths)
+	}
+	if got := kc.GetToken().AccessToken; got != "current" {
+		t.Fatalf("got token %q, want %q", got, "current")
+	}
+}
+
+// GetChildrenGroups must follow every page, even when the URI ends with a slash.
+func TestGetChildrenGroupsFollowsPagination(t *testing.T) {
+	const total = 250
+
+	var mu sync.Mutex
+	var pages []string
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/admin/realms/test/groups/parent-id/children" {
+			http.NotFound(w, req)
+			return
+		}
+
+		mu.Lock()
+		pages = append(pages, req.URL.Query().Get("first"))
+		mu.Unlock()
+
+		first, _ := strconv.Atoi(req.URL.Query().Get("first"))
+		pageSize, _ := strconv.Atoi(req.URL.Query().Get("max"))
+
+		var groups []gocloak.Group
+		for i := first; i < min(first+pageSize, total); i++ {
+			groups = append(groups, gocloak.Group{ID: gocloak.StringP(fmt.Sprint(i)), Name: gocloak.StringP(fmt.Sprint(i))})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	})
+
+	kc := newTestKeycloakWithOptions(t, handler, "/", 0)
+
+	groups, err := kc.GetChildrenGroups("token", "parent-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(groups) != total {
+		t.Fatalf("got %d groups, want %d", len(groups), total)
+	}
+	if want := []string{"0", "100", "200"}; fmt.Sprint(pages) != fmt.Sprint(want) {
+		t.Fatalf("got pages %v, want %v", pages, want)
+	}
+}
+
+// GetUsers must follow every page, as Keycloak only returns the first one by default.
+func TestGetUsersFollowsPagination(t *testing.T) {
+	const total = 250
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/admin/realms/test/users" {
+			http.NotFound(w, req)
+			return
+		}
+
+		first, _ := strconv.Atoi(req.URL.Query().Get("first"))
+		pageSize, _ := strconv.Atoi(req.URL.Query().Get("max"))
+
+		var users []gocloak.User
+		for i := first; i < min(first+pageSize, total); i++ {
+			users = append(users, gocloak.User{ID: gocloak.StringP(fmt.Sprint(i)), Username: gocloak.StringP(fmt.Sprint(i))})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	})
+
+	kc := newTestKeycloak(t, handler)
+
+	users, err := kc.GetUsers("token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != total {
+		t.Fatalf("got %d users, want %d", len(users), total)
+	}
+}
+
+// Members of every group must be listed following pagination, without mixing up groups.
+func TestGetGroupMembersFollowsPagination(t *testing.T) {
+	totals := map[string]int{"dev-id": 250, "ops-id": 2}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		groupID, found := strings.CutPrefix(req.URL.Path, "/admin/realms/test/groups/")
+		groupID, found = strings.CutSuffix(groupID, "/members")
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+
+		first, _ := strconv.Atoi(req.URL.Query().Get("first"))
+		pageSize, _ := strconv.Atoi(req.URL.Query().Get("max"))
+
+		users := []gocloak.User{}
+		for i := first; i < min(first+pageSize, totals[groupID]); i++ {
+			users = append(users, gocloak.User{ID: gocloak.StringP(fmt.Sprint(i)), Username: gocloak.StringP(groupID + fmt.Sprint(i))})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	})
+
+	kc := newTestKeycloak(t, handler)
+
+	for groupID, total := range totals {
+		members, err := kc.GetGroupMembers("token", groupID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(members) != total {
+			t.Fatalf("got %d members of %s, want %d", len(members), groupID, total)
+		}
+		if !strings.HasPrefix(*members[0].Username, groupID) {
+			t.Fatalf("got member %s listed in %s", *members[0].Username, groupID)
+		}
+	}
+}
+
+// Users must be looked up by exact username or email, never by a decoy a substring search would return.
+func TestGetUserByUsernameAndEmail(t *testing.T) {
+	users := []gocloak.User{
+		{ID: gocloak.StringP("decoy-id"), Username: gocloak.StringP("alice.smith"), Email: gocloak.StringP("alice.smith@corp.com")},
+		{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice"), Email: gocloak.StringP("alice@corp.com")},
+		{ID: gocloak.StringP("twin-id"), Username: gocloak.StringP("twin"), Email: gocloak.StringP("shared@corp.com")},
+		{ID: gocloak.StringP("other-twin-id"), Username: gocloak.StringP("other-twin"), Email: gocloak.StringP("shared@corp.com")},
+	}
+
+	// The fake answers every search with all the users, as Keycloak versions ignoring exact matches could
+	var queries []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/admin/realms/test/users" {
+			http.NotFound(w, req)
+			return
+		}
+		queries = append(queries, req.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	})
+
+	tests := map[string]struct {
+		lookup    func(kc *Keycloak) (*gocloak.User, error)
+		wantID    string
+		wantQuery string
+		wantErr   bool
+	}{
+		"by username":          {lookup: func(kc *Keycloak) (*gocloak.User, error) { return kc.GetUserByUsername("token", "alice") }, wantID: "alice-id", wantQuery: "exact=true&username=alice"},
+		"by username any case": {lookup: func(kc *Keycloak) (*gocloak.User, error) { return kc.GetUserByUsername("token", "Alice") }, wantID: "alice-id", wantQuery: "exact=true&username=Alice"},
+		"by email":             {lookup: func(kc *Keycloak) (*gocloak.User, error) { return kc.GetUserByEmail("token", "alice@corp.com") }, wantID: "alice-id", wantQuery: "email=alice%40corp.com&exact=true"},
+		"no match":             {lookup: func(kc *Keycloak) (*gocloak.User, error) { return kc.GetUserByUsername("token", "ali") }, wantQuery: "exact=true&username=ali"},
+		"shared email":         {lookup: func(kc *Keycloak) (*gocloak.User, error) { return kc.GetUserByEmail("token", "shared@corp.com") }, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			queries = nil
+			kc := newTestKeycloak(t, handler)
+
+			user, err := tc.lookup(kc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			gotID := ""
+			if user != nil {
+				gotID = *user.ID
+			}
+			if gotID != tc.wantID {
+				t.Fatalf("got user %q, want %q", gotID, tc.wantID)
+			}
+			if len(queries) != 1 || queries[0] != tc.wantQuery {
+				t.Fatalf("got queries %v, want [%s]", queries, tc.wantQuery)
+			}
+		})
+	}
+}
+
+// Full user groups must be asked for, and the ones still coming brief looked up by ID instead of left incomplete.
+func TestGetUserGroupsResolvesBriefGroups(t *testing.T) {
+	var lookups []string
+	var briefParams []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/admin/realms/test/users/alice-id/groups":
+			briefParams = append(briefParams, req.URL.Query().Get("briefRepresentation"))
+			json.NewEncoder(w).Encode([]gocloak.Group{
+				{ID: gocloak.StringP("full-id"), Name: gocloak.StringP("full"), Path: gocloak.StringP("/google-workspace/full")},
+				{ID: gocloak.StringP("brief-id"), Name: gocloak.StringP("brief")},
+				{ID: gocloak.StringP("bare-id")},
+			})
+		case "/admin/realms/test/groups/brief-id", "/admin/realms/test/groups/bare-id":
+			id := strings.TrimPrefix(req.URL.Path, "/admin/realms/test/groups/")
+			lookups = append(lookups, id)
+			name := strings.TrimSuffix(id, "-id")
+			json.NewEncoder(w).Encode(gocloak.Group{ID: gocloak.StringP(id), Name: gocloak.StringP(name), Path: gocloak.StringP("/google-workspace/" + name)})
+		default:
+			http.NotFound(w, req)
+		}
+	})
+
+	kc := newTestKeycloak(t, handler)
+
+	groups, err := kc.GetUserGroups("alice-id", "token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var paths []string
+	for _, group := range groups {
+		paths = append(paths, *group.ID+":"+gocloak.PString(group.Name)+":"+gocloak.PString(group.Path))
+	}
+	want := []string{"full-id:full:/google-workspace/full", "brief-id:brief:/google-workspace/brief", "bare-id:bare:/google-workspace/bare"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %v, want %v", paths, want)
+	}
+	if want := []string{"brief-id", "bare-id"}; !reflect.DeepEqual(lookups, want) {
+		t.Fatalf("looked up %v, want %v", lookups, want)
+	}
+	if want := []string{"false"}; !reflect.DeepEqual(briefParams, want) {
+		t.Fatalf("got briefRepresentation %v, want %v", briefParams, want)
+	}
+}
+
+// The parent group must be matched by its exact name, never by a decoy a substring search would return first.
+func TestGetTopLevelGroupMatchesExactName(t *testing.T) {
+	decoys := []string{"super-admins", "admins-old", "Admins", "org"}
+
+	tests := map[string]struct {
+		groups  []string
+		wantID  string
+		wantErr bool
+	}{
+		"exact match among decoys":    {groups: append(decoys, "admins"), wantID: "admins-id"},
+		"no exact match":              {groups: decoys},
+		"exact match on a later page": {groups: append(slices.Repeat([]string{"filler"}, 150), "admins"), wantID: "admins-id"},
+		"ambiguous name":              {groups: append(decoys, "admins", "admins"), wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/admin/realms/test/groups" {
+					http.NotFound(w, req)
+					return
+				}
+
+				first, _ := strconv.Atoi(req.URL.Query().Get("first"))
+				pageSize, _ := strconv.Atoi(req.URL.Query().Get("max"))
+
+				var groups []gocloak.Group
+				for i := first; i < min(first+pageSize, len(tc.groups)); i++ {
+					groups = append(groups, gocloak.Group{ID: gocloak.StringP(tc.groups[i] + "-id"), Name: gocloak.StringP(tc.groups[i])})
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(groups)
+			})
+
+			kc := newTestKeycloak(t, handler)
+
+			group, err := kc.GetTopLevelGroup("token", "admins")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			gotID := ""
+			if group != nil {
+				gotID = *group.ID
+			}
+			if gotID != tc.wantID {
+				t.Fatalf("got group %q, want %q", gotID, tc.wantID)
+			}
+		})
+	}
+}
+
+// With an exact search, the parent group must be searched for by name, and still be matched by its exact
+// name and top-level path on servers ignoring the exact parameter.
+func TestGetTopLevelGroupExactSearch(t *testing.T) {
+	decoys := []gocloak.Group{
+		{ID: gocloak.StringP("super-admins-id"), Name: gocloak.StringP("super-admins"), Path: gocloak.StringP("/super-admins")},
+		{ID: gocloak.StringP("Admins-id"), Name: gocloak.StringP("Admins"), Path: gocloak.StringP("/Admins")},
+		{ID: gocloak.StringP("nested-admins-id"), Name: gocloak.StringP("admins"), Path: gocloak.StringP("/org/admins")},
+	}
+	admins := gocloak.Group{ID: gocloak.StringP("admins-id"), Name: gocloak.StringP("admins"), Path: gocloak.StringP("/admins")}
+
+	tests := map[string]struct {
+		groups  []gocloak.Group
+		wantID  string
+		wantErr bool
+	}{
+		"server honoring exact":          {groups: []gocloak.Group{admins}, wantID: "admins-id"},
+		"server ignoring exact":          {groups: append(slices.Clone(decoys), admins), wantID: "admins-id"},
+		"only decoys":                    {groups: decoys},
+		"ambiguous name ignoring exact":  {groups: append(slices.Clone(decoys), admins, admins), wantErr: true},
+		"top-level group without a path": {groups: []gocloak.Group{{ID: gocloak.StringP("admins-id"), Name: gocloak.StringP("admins")}}, wantID: "admins-id"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var queries []url.Values
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/admin/realms/test/groups" {
+					http.NotFound(w, req)
+					return
+				}
+				queries = append(queries, req.URL.Query())
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(tc.groups)
+			})
+
+			kc := newTestKeycloak(t, handler)
+			kc.groupSearchExact = true
+
+			group, err := kc.GetTopLevelGroup("token", "admins")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if len(queries) != 1 || queries[0].Get("search") != "admins" || queries[0].Get("exact") != "true" {
+				t.Fatalf("got queries %v, want a single exact search of admins", queries)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			gotID := ""
+			if group != nil {
+				gotID = *group.ID
+			}
+			if gotID != tc.wantID {
+				t.Fatalf("got group %q, want %q", gotID, tc.wantID)
+			}
+		})
+	}
+}
+
+// A hung Keycloak must not block the caller past the configured timeout.
+func TestGetChildrenGroupsTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	})
+
+	kc := newTestKeycloakWithOptions(t, handler, "", 50*time.Millisecond)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	_, err := kc.GetChildrenGroups("token", "parent-id")
+	if err == nil {
+		t.Fatalf("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s, the timeout was ignored", elapsed)
+	}
+}
+
+// The request timeout must bound both gocloak calls and the raw ones, defaulting when unset.
+func TestRequestTimeoutIsConfigured(t *testing.T) {
+	tests := map[string]struct {
+		timeout time.Duration
+		want    time.Duration
+	}{
+		"default":    {want: defaultTimeout},
+		"configured": {timeout: 10 * time.Second, want: 10 * time.Second},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc := newTestKeycloakWithOptions(t, &fakeKeycloakServer{}, "", tc.timeout)
+
+			if got := kc.gocloakCli.RestyClient().GetClient().Timeout; got != tc.want {
+				t.Fatalf("got gocloak timeout %s, want %s", got, tc.want)
+			}
+			if got := kc.httpClient.Timeout; got != tc.want {
+				t.Fatalf("got raw client timeout %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// Pages of children groups must be retried on 5xx, and Keycloak's error body must end up in the error otherwise.
+func TestGetChildrenGroupsRetriesAndParsesErrors(t *testing.T) {
+	tests := map[string]struct {
+		statuses  []int
+		errorBody string
+		wantCalls int
+		wantCode  int
+		wantErr   string
+	}{
+		"500 then 200": {
+			statuses:  []int{http.StatusInternalServerError, http.StatusOK},
+			wantCalls: 2,
+		},
+		"structured 403": {
+			statuses:  []int{http.StatusForbidden},
+			errorBody: `{"error":"unknown_error","errorMessage":"HTTP 403 Forbidden"}`,
+			wantCalls: 1,
+			wantCode:  http.StatusForbidden,
+			wantErr:   "403 Forbidden: unknown_error: HTTP 403 Forbidden",
+		},
+		"unstructured 404": {
+			statuses:  []int{http.StatusNotFound},
+			errorBody: `Not Found`,
+			wantCalls: 1,
+			wantCode:  http.StatusNotFound,
+			wantErr:   "404 Not Found: Not Found",
+		},
+		"persistent 503": {
+			statuses:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			wantCalls: 3,
+			wantCode:  http.StatusServiceUnavailable,
+			wantErr:   "503 Service Unavailable",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				status := tc.statuses[min(calls, len(tc.statuses)-1)]
+				calls++
+				mu.Unlock()
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					w.Write([]byte(`[{"id":"child-id","name":"child"}]`))
+					return
+				}
+				w.Write([]byte(tc.errorBody))
+			})
+
+			kc := newTestKeycloak(t, handler)
+			kc.retryOpts = retry.Options{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+			groups, err := kc.GetChildrenGroups("token", "parent-id")
+			if calls != tc.wantCalls {
+				t.Fatalf("got %d calls, want %d", calls, tc.wantCalls)
+			}
+			if tc.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(groups) != 1 || *groups[0].ID != "child-id" {
+					t.Fatalf("got groups %v, want the single child", groups)
+				}
+				return
+			}
+
+			var apiErr *gocloak.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want an APIError", err)
+			}
+			if apiErr.Code != tc.wantCode {
+				t.Fatalf("got code %d, want %d", apiErr.Code, tc.wantCode)
+			}
+			if apiErr.Message != tc.wantErr {
+				t.Fatalf("got message %q, want %q", apiErr.Message, tc.wantErr)
+			}
+		})
+	}
+}
+
+// groupAttributesServer serves a single group, group-id, recording the attributes every update sends.
+type groupAttributesServer struct {
+	attributes map[string][]string
+
+	mu      sync.Mutex
+	updates []map[string][]string
+}
+
+func (g *groupAttributesServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/admin/realms/test/groups/group-id" {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gocloak.Group{ID: gocloak.StringP("group-id"), Name: gocloak.StringP("team"), Attributes: &g.attributes})
+	case http.MethodPut:
+		var group gocloak.Group
+		json.NewDecoder(req.Body).Decode(&group)
+
+		g.mu.Lock()
+		g.updates = append(g.updates, *group.Attributes)
+		g.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Group attributes must be merged into the existing ones, and the group only updated when any of them changed.
+func TestEnsureGroupAttributes(t *testing.T) {
+	existing := map[string][]string{"kegos/managed": {"true"}, "owner": {"platform"}}
+
+	tests := map[string]struct {
+		attributes  map[string][]string
+		wantUpdated bool
+		wantUpdates []map[string][]string
+	}{
+		"no-op": {
+			attributes: map[string][]string{"kegos/managed": {"true"}},
+		},
+		"add new key": {
+			attributes:  map[string][]string{"kegos/source-group": {"team@corp.com"}},
+			wantUpdated: true,
+			wantUpdates: []map[string][]string{
+				{"kegos/managed": {"true"}, "owner": {"platform"}, "kegos/source-group": {"team@corp.com"}},
+			},
+		},
+		"update existing key": {
+			attributes:  map[string][]string{"owner": {"security", "platform"}},
+			wantUpdated: true,
+			wantUpdates: []map[string][]string{
+				{"kegos/managed": {"true"}, "owner": {"security", "platform"}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &groupAttributesServer{attributes: existing}
+			kc := newTestKeycloak(t, server)
+
+			updated, err := kc.EnsureGroupAttributes("token", "group-id", tc.attributes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated != tc.wantUpdated {
+				t.Fatalf("got updated %t, want %t", updated, tc.wantUpdated)
+			}
+			if !reflect.DeepEqual(server.updates, tc.wantUpdates) {
+				t.Fatalf("got updates %v, want %v", server.updates, tc.wantUpdates)
+			}
+		})
+	}
+}
+
+// userAttributesServer serves a single user, user-id, recording every update it gets.
+type userAttributesServer struct {
+	attributes map[string][]string
+
+	mu      sync.Mutex
+	updates []gocloak.User
+}
+
+func (u *userAttributesServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/admin/realms/test/users/user-id" {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gocloak.User{ID: gocloak.StringP("user-id"), Username: gocloak.StringP("alice"),
+			Email: gocloak.StringP("alice@corp.com"), Attributes: &u.attributes})
+	case http.MethodPut:
+		var user gocloak.User
+		json.NewDecoder(req.Body).Decode(&user)
+
+		u.mu.Lock()
+		u.updates = append(u.updates, user)
+		u.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// User attributes must be merged into the existing ones, the rest of the user sent back as it was, and the
+// user only updated when any of them changed.
+func TestEnsureUserAttributes(t *testing.T) {
+	existing := map[string][]string{"kegos/last-synced": {"2026-01-01T00:00:00Z"}, "department": {"platform"}}
+
+	tests := map[string]struct {
+		attributes  map[string][]string
+		wantUpdated bool
+		wantUpdates []map[string][]string
+	}{
+		"no-op": {
+			attributes: map[string][]string{"kegos/last-synced": {"2026-01-01T00:00:00Z"}},
+		},
+		"add new key": {
+			attributes:  map[string][]string{"locale": {"es"}},
+			wantUpdated: true,
+			wantUpdates: []map[string][]string{
+				{"kegos/last-synced": {"2026-01-01T00:00:00Z"}, "department": {"platform"}, "locale": {"es"}},
+			},
+		},
+		"update existing key": {
+			attributes:  map[string][]string{"kegos/last-synced": {"2026-01-02T00:00:00Z"}},
+			wantUpdated: true,
+			wantUpdates: []map[string][]string{
+				{"kegos/last-synced": {"2026-01-02T00:00:00Z"}, "department": {"platform"}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &userAttributesServer{attributes: existing}
+			kc := newTestKeycloak(t, server)
+
+			updated, err := kc.EnsureUserAttributes("token", "user-id", tc.attributes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated != tc.wantUpdated {
+				t.Fatalf("got updated %t, want %t", updated, tc.wantUpdated)
+			}
+
+			var gotUpdates []map[string][]string
+			for _, user := range server.updates {
+				if gocloak.PString(user.Username) != "alice" || gocloak.PString(user.Email) != "alice@corp.com" {
+					t.Fatalf("expected the rest of the user sent back as it was, got %+v", user)
+				}
+				gotUpdates = append(gotUpdates, *user.Attributes)
+			}
+			if !reflect.DeepEqual(gotUpdates, tc.wantUpdates) {
+				t.Fatalf("got updates %v, want %v", gotUpdates, tc.wantUpdates)
+			}
+		})
+	}
+}
+
+// membershipServer answers membership changes after a delay, rejecting those on the failing group.
+type membershipServer struct {
+	latency      time.Duration
+	failingGroup string
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	requests []string
+}
+
+func (m *membershipServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	m.inFlight++
+	m.peak = max(m.peak, m.inFlight)
+	m.requests = append(m.requests, req.Method+" "+req.URL.Path)
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+
+	time.Sleep(m.latency)
+	if m.failingGroup != "" && strings.HasSuffix(req.URL.Path, "/groups/"+m.failingGroup) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// membershipChanges returns count changes alternating between additions and removals.
+func membershipChanges(count int) (changes []MembershipChange) {
+	for i := range count {
+		changes = append(changes, MembershipChange{
+			UserID:  "user",
+			GroupID: fmt.Sprintf("group-%d", i),
+			Remove:  i%2 == 1,
+		})
+	}
+	return changes
+}
+
+// Every change must be sent, never exceeding the concurrency limit, and errors must keep the order of the changes.
+func TestUpdateGroupMemberships(t *testing.T) {
+	tests := map[string]struct {
+		maxConcurrentRequests int
+	}{
+		"sequential": {maxConcurrentRequests: 1},
+		"concurrent": {maxConcurrentRequests: 4},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := &membershipServer{latency: 5 * time.Millisecond, failingGroup: "group-3"}
+			kc := newTestKeycloak(t, server)
+			kc.maxConcurrentRequests = test.maxConcurrentRequests
+
+			errs := kc.UpdateGroupMemberships("token", membershipChanges(10), retry.Options{})
+			if len(errs) != 10 {
+				t.Fatalf("got %d errors, want one per change", len(errs))
+			}
+			for i, err := range errs {
+				if (err != nil) != (i == 3) {
+					t.Errorf("change %d: unexpected error %v", i, err)
+				}
+			}
+
+			want := []string{}
+			for i := range 10 {
+				method := http.MethodPut
+				if i%2 == 1 {
+					method = http.MethodDelete
+				}
+				want = append(want, fmt.Sprintf("%s /admin/realms/test/users/user/groups/group-%d", method, i))
+			}
+			slices.Sort(want)
+			slices.Sort(server.requests)
+			if !slices.Equal(server.requests, want) {
+				t.Errorf("requests = %v, want %v", server.requests, want)
+			}
+			if server.peak > test.maxConcurrentRequests {
+				t.Errorf("%d requests in flight, limit was %d", server.peak, test.maxConcurrentRequests)
+			}
+		})
+	}
+}
+
+func benchmarkUpdateGroupMemberships(b *testing.B, maxConcurrentRequests int) {
+	kc := newTestKeycloak(b, &membershipServer{latency: time.Millisecond})
+	kc.maxConcurrentRequests = maxConcurrentRequests
+	changes := membershipChanges(20)
+
+	for b.Loop() {
+		for _, err := range kc.UpdateGroupMemberships("token", changes, retry.Options{}) {
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkUpdateGroupMembershipsSequential(b *testing.B) {
+	benchmarkUpdateGroupMemberships(b, 1)
+}
+
+func BenchmarkUpdateGroupMembershipsBatched(b *testing.B) {
+	benchmarkUpdateGroupMemberships(b, defaultMaxConcurrentRequests)
+}
+
+// A private CA bundle, or disabling verification, must apply to both gocloak and the raw client.
+func TestNewKeycloakAppliesTLSSettings(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeKeycloakServer{})
+	t.Cleanup(server.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]struct {
+		caCertPath string
+		insecure   bool
+		wantErr    bool
+	}{
+		"system roots reject the private CA": {wantErr: true},
+		"custom CA bundle is trusted":        {caCertPath: caPath},
+		"verification disabled":              {insecure: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, err := NewKeycloak(KeycloakOptions{
+				AppCtx: &globals.ApplicationContext{
+					Context: context.Background(),
+					Logger:  slog.New(slog.DiscardHandler),
+				},
+				URI:                server.URL,
+				Realm:              "test",
+				ClientID:           "kegos",
+				ClientSecret:       "secret",
+				CACertPath:         tc.caCertPath,
+				InsecureSkipVerify: tc.insecure,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tokenErr := kc.RenewToken()
+			if (tokenErr != nil) != tc.wantErr {
+				t.Fatalf("gocloak got error %v, wantErr %v", tokenErr, tc.wantErr)
+			}
+
+			_, childrenErr := kc.GetChildrenGroups("token", "parent-id")
+			if (childrenErr != nil) != tc.wantErr {
+				t.Fatalf("raw client got error %v, wantErr %v", childrenErr, tc.wantErr)
+			}
+		})
+	}
+}
+
+// A CA bundle without certificates must be reported at startup.
+func TestNewKeycloakRejectsInvalidCABundle(t *testing.T) {
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := NewKeycloak(KeycloakOptions{
+		AppCtx:     &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+		URI:        "https://keycloak.test",
+		CACertPath: caPath,
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a bundle without certificates")
+	}
+}
+
+// tokenRequestRecorder answers token requests, keeping the form and client secret of the last one.
+type tokenRequestRecorder struct {
+	mu     sync.Mutex
+	form   url.Values
+	secret string
+}
+
+func (r *tokenRequestRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	r.mu.Lock()
+	r.form = req.PostForm
+	_, r.secret, _ = req.BasicAuth()
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"access_token":"fresh","expires_in":300,"token_type":"Bearer"}`))
+}
+
+// writeClientJWTKey stores the key as PEM in a temporary file and returns its path.
+func writeClientJWTKey(t *testing.T, key any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "client.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+// A client holding a private key must log in with a JWT signed by it, and with its secret otherwise.
+func TestRenewTokenPicksClientAuthentication(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]struct {
+		keyPath    string
+		publicKey  any
+		wantMethod string
+	}{
+		"client secret": {},
+		"rsa key":       {keyPath: writeClientJWTKey(t, rsaKey), publicKey: &rsaKey.PublicKey, wantMethod: "RS256"},
+		"ec key":        {keyPath: writeClientJWTKey(t, ecKey), publicKey: &ecKey.PublicKey, wantMethod: "ES384"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := &tokenRequestRecorder{}
+			server := httptest.NewServer(recorder)
+			t.Cleanup(server.Close)
+
+			kc, err := NewKeycloak(KeycloakOptions{
+				AppCtx:           &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+				URI:              server.URL,
+				Realm:            "test",
+				ClientID:         "kegos",
+				ClientSecret:     "secret",
+				ClientJWTKeyPath: tc.keyPath,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := kc.RenewToken(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tc.keyPath == "" {
+				if got := recorder.secret; got != "secret" {
+					t.Fatalf("got client secret %q, want %q", got, "secret")
+				}
+				if recorder.form.Has("client_assertion") {
+					t.Fatalf("expected no client assertion when logging in with a secret")
+				}
+				return
+			}
+
+			if recorder.secret != "" {
+				t.Fatalf("expected no client secret when logging in with a private key")
+			}
+			if got := recorder.form.Get("client_assertion_type"); got != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+				t.Fatalf("got assertion type %q", got)
+			}
+
+			assertion, err := jwt.Parse(recorder.form.Get("client_assertion"), func(*jwt.Token) (any, error) {
+				return tc.publicKey, nil
+			})
+			if err != nil {
+				t.Fatalf("assertion not signed by the client key: %v", err)
+			}
+			if got := assertion.Method.Alg(); got != tc.wantMethod {
+				t.Fatalf("got signing method %q, want %q", got, tc.wantMethod)
+			}
+			if subject, _ := assertion.Claims.GetSubject(); subject != "kegos" {
+				t.Fatalf("got subject %q, want %q", subject, "kegos")
+			}
+		})
+	}
+}
+
+// A key file without a private key must be reported at startup.
+func TestNewKeycloakRejectsInvalidClientJWTKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "client.pem")
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := NewKeycloak(KeycloakOptions{
+		AppCtx:           &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+		URI:              "https://keycloak.test",
+		ClientJWTKeyPath: keyPath,
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a file without a private key")
+	}
+}
+
+// hangOnceServer leaves the first request hanging until the client gives up, and answers the rest right away.
+type hangOnceServer struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (h *hangOnceServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	h.requests++
+	first := h.requests == 1
+	h.mu.Unlock()
+
+	if first {
+		<-req.Context().Done()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`[]`))
+}
+
+// A stuck call must end once its own deadline passes, and never shorten the deadline of the following calls.
+func TestCallTimeoutBoundsEachCall(t *testing.T) {
+	server := httptest.NewServer(&hangOnceServer{})
+	t.Cleanup(server.Close)
+
+	kc, err := NewKeycloak(KeycloakOptions{
+		AppCtx:      &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+		URI:         server.URL,
+		Realm:       "test",
+		Timeout:     time.Minute,
+		CallTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	_, err = kc.GetChildrenGroups("token", "parent-id")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want a deadline exceeded error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("call took %s, the timeout was ignored", elapsed)
+	}
+
+	if _, err := kc.GetChildrenGroups("token", "parent-id"); err != nil {
+		t.Fatalf("expected the following call to succeed, got %v", err)
+	}
+}
+
+// recordingProxy stands for a forward proxy: it keeps the URL of every request it relays and answers them itself.
+type recordingProxy struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (p *recordingProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	p.urls = append(p.urls, req.URL.String())
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(req.URL.Path, "/token") {
+		w.Write([]byte(`{"access_token":"fresh","expires_in":300,"token_type":"Bearer"}`))
+		return
+	}
+	w.Write([]byte(`[]`))
+}
+
+// Both gocloak and the raw client must send their requests through the configured proxy.
+func TestNewKeycloakHonorsProxy(t *testing.T) {
+	recorder := &recordingProxy{}
+	proxyServer := httptest.NewServer(recorder)
+	t.Cleanup(proxyServer.Close)
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kc, err := NewKeycloak(KeycloakOptions{
+		AppCtx:       &globals.ApplicationContext{Context: context.Background(), Logger: slog.New(slog.DiscardHandler)},
+		URI:          "http://keycloak.test",
+		Realm:        "test",
+		ClientID:     "kegos",
+		ClientSecret: "secret",
+		Proxy:        http.ProxyURL(proxyURL),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := kc.RenewToken(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := kc.GetChildrenGroups("token", "parent-id"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"http://keycloak.test/realms/test/protocol/openid-connect/token",
+		"http://keycloak.test/admin/realms/test/groups/parent-id/children?briefRepresentation=false&first=0&max=100",
+	}
+	if !slices.Equal(recorder.urls, want) {
+		t.Fatalf("proxied %v, want %v", recorder.urls, want)
+	}
+}
+
+// Every listing must ask for pages of the configured size, users and groups each with their own.
+func TestListingsUseConfiguredBatchSizes(t *testing.T) {
+	tests := map[string]struct {
+		userBatchSize  int
+		groupBatchSize int
+		want           map[string]string
+	}{
+		"defaults": {
+			want: map[string]string{
+				"/admin/realms/test/groups":                    "100",
+				"/admin/realms/test/groups/parent-id/children": "100",
+				"/admin/realms/test/users":                     "100",
+				"/admin/realms/test/users/alice-id/groups":     "100",
+				"/admin/realms/test/roles":                     "100",
+			},
+		},
+		"configured": {
+			userBatchSize:  500,
+			groupBatchSize: 25,
+			want: map[string]string{
+				"/admin/realms/test/groups":                    "25",
+				"/admin/realms/test/groups/parent-id/children": "25",
+				"/admin/realms/test/users":                     "500",
+				"/admin/realms/test/users/alice-id/groups":     "25",
+				"/admin/realms/test/roles":                     "25",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			pageSizes := map[string]string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				pageSizes[req.URL.Path] = req.URL.Query().Get("max")
+				mu.Unlock()
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[]`))
+			}))
+			t.Cleanup(server.Close)
+
+			kc, err := NewKeycloak(KeycloakOptions{
+				AppCtx: &globals.ApplicationContext{
+					Context: context.Background(),
+					Logger:  slog.New(slog.DiscardHandler),
+				},
+				URI:            server.URL,
+				Realm:          "test",
+				UserBatchSize:  tc.userBatchSize,
+				GroupBatchSize: tc.groupBatchSize,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			listings := []func() error{
+				func() error { _, err := kc.GetGroups("token"); return err },
+				func() error { _, err := kc.GetChildrenGroups("token", "parent-id"); return err },
+				func() error { _, err := kc.GetUsers("token"); return err },
+				func() error { _, err := kc.GetUserGroups("alice-id", "token"); return err },
+				func() error { _, err := kc.GetRealmRoles("token"); return err },
+			}
+			for _, listing := range listings {
+				if err := listing(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if !reflect.DeepEqual(pageSizes, tc.want) {
+				t.Fatalf("got page sizes %v, want %v", pageSizes, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package keycloak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Client roles of the realm management client, as needed by the service account of kegos
+const (
+	RoleViewUsers   = "view-users"
+	RoleManageUsers = "manage-users"
+	RoleViewRealm   = "view-realm"
+	RoleManageRealm = "manage-realm"
+)
+
+// realmManagementClient is the client holding the admin roles of a realm within the realm itself
+const realmManagementClient = "realm-management"
+
+// ValidatePermissions logs in and makes a few cheap reads, a single user and group, to make sure the client can
+// reach the realm. Roles the writes need are checked against those granted in the access token, without writing
+// anything. Missing permissions come back as an actionable message listing the client roles to assign, so kegos
+// fails at startup instead of deep in its first cycle
+func (k *Keycloak) ValidatePermissions(requiredRoles []string) error {
+	err := k.EnsureToken()
+	if err != nil {
+		return fmt.Errorf("failed logging in to realm %s as client %s: %w", k.AuthRealm, k.ClientID, err)
+	}
+	accessToken := k.GetToken().AccessToken
+
+	probes := []struct {
+		what string
+		call func() error
+	}{
+		{what: "list users", call: func() error {
+			ctx, cancel := k.callContext()
+			defer cancel()
+			_, err := k.gocloakCli.GetUsers(ctx, accessToken, k.Realm, gocloak.GetUsersParams{Max: gocloak.IntP(1)})
+			return err
+		}},
+		{what: "list groups", call: func() error {
+			ctx, cancel := k.callContext()
+			defer cancel()
+			_, err := k.gocloakCli.GetGroups(ctx, accessToken, k.Realm, gocloak.GetGroupsParams{Max: gocloak.IntP(1)})
+			return err
+		}},
+	}
+	for _, probe := range probes {
+		err = probe.call()
+		if isPermissionError(err) {
+			return k.permissionError(probe.what, requiredRoles, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed trying to %s in realm %s: %w", probe.what, k.Realm, err)
+		}
+	}
+
+	// Tokens without the claim, such as those slimmed down by a client scope, can not tell anything more
+	grantedRoles, found := k.grantedManagementRoles(accessToken)
+	if !found {
+		k.appCtx.Logger.Debug("access token lists no realm management roles. Skipping their check...", "realm", k.Realm)
+		return nil
+	}
+
+	var missingRoles []string
+	for _, role := range requiredRoles {
+		if !slices.Contains(grantedRoles, role) {
+			missingRoles = append(missingRoles, role)
+		}
+	}
+	if len(missingRoles) > 0 {
+		return k.permissionError("manage the realm as configured", requiredRoles,
+			fmt.Errorf("missing client roles %s", strings.Join(missingRoles, ", ")))
+	}
+	return nil
+}
+
+// managementClient returns the client the admin roles of the realm are assigned from. Clients logging in to
+// another realm, such as master, get them from the client named after the realm there
+func (k *Keycloak) managementClient() string {
+	if k.AuthRealm != k.Realm {
+		return k.Realm + "-realm"
+	}
+	return realmManagementClient
+}
+
+// grantedManagementRoles returns the roles of the management client the access token carries. Its signature
+// is not checked, as it is only read to explain what is missing
+func (k *Keycloak) grantedManagementRoles(accessToken string) (roles []string, found bool) {
+	claims := struct {
+		jwt.RegisteredClaims
+		ResourceAccess map[string]struct {
+			Roles []string `json:"roles"`
+		} `json:"resource_access"`
+	}{}
+
+	_, _, err := jwt.NewParser().ParseUnverified(accessToken, &claims)
+	if err != nil {
+		return nil, false
+	}
+
+	access, found := claims.ResourceAccess[k.managementClient()]
+	return access.Roles, found
+}
+
+// permissionError explains which client roles the service account of the client needs
+func (k *Keycloak) permissionError(what string, requiredRoles []string, err error) error {
+	return fmt.Errorf("client %s is not allowed to %s in realm %s: assign its service account the client roles %s "+
+		"of the %s client in realm %s: %w",
+		k.ClientID, what, k.Realm, strings.Join(requiredRoles, ", "), k.managementClient(), k.AuthRealm, err)
+}
+
+// isPermissionError reports whether Keycloak rejected a call for lack of authentication or permissions
+func isPermissionError(err error) bool {
+	var keycloakErr *gocloak.APIError
+	return errors.As(err, &keycloakErr) &&
+		(keycloakErr.Code == http.StatusUnauthorized || keycloakErr.Code == http.StatusForbidden)
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package keycloak
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// permissionsServer hands out a token granting the given management roles, and answers listings with
+// the status of their path, 200 by default.
+type permissionsServer struct {
+	t        *testing.T
+	roles    map[string][]string
+	statuses map[string]int
+}
+
+func (p *permissionsServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasSuffix(req.URL.Path, "/protocol/openid-connect/token") {
+		claims := jwt.MapClaims{"sub": "service-account"}
+		if p.roles != nil {
+			resourceAccess := map[string]any{}
+			for client, roles := range p.roles {
+				resourceAccess[client] = map[string]any{"roles": roles}
+			}
+			claims["resource_access"] = resourceAccess
+		}
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			p.t.Errorf("failed signing token: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": token, "expires_in": 300, "token_type": "Bearer"})
+		return
+	}
+
+	if status, found := p.statuses[req.URL.Path]; found {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"unknown_error","error_description":"For more on this error consult the server log."}`))
+		return
+	}
+	w.Write([]byte(`[]`))
+}
+
+// Missing permissions must be caught at startup with guidance on the client roles to assign.
+func TestValidatePermissions(t *testing.T) {
+	required := []string{RoleViewUsers, RoleManageUsers}
+
+	tests := map[string]struct {
+		roles    map[string][]string
+		statuses map[string]int
+		wantErr  []string
+	}{
+		"every role granted": {
+			roles: map[string][]string{"realm-management": {"view-users", "manage-users", "query-groups"}},
+		},
+		"roles not in the token": {},
+		"users probe forbidden": {
+			statuses: map[string]int{"/admin/realms/test/users": http.StatusForbidden},
+			wantErr: []string{"client kegos is not allowed to list users in realm test",
+				"client roles view-users, manage-users of the realm-management client", "403"},
+		},
+		"groups probe forbidden": {
+			statuses: map[string]int{"/admin/realms/test/groups": http.StatusForbidden},
+			wantErr:  []string{"not allowed to list groups", "view-users, manage-users"},
+		},
+		"write role missing": {
+			roles:   map[string][]string{"realm-management": {"view-users"}},
+			wantErr: []string{"not allowed to manage the realm as configured", "missing client roles manage-users"},
+		},
+		"unrelated failure": {
+			statuses: map[string]int{"/admin/realms/test/users": http.StatusInternalServerError},
+			wantErr:  []string{"failed trying to list users in realm test"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc := newTestKeycloak(t, &permissionsServer{t: t, roles: tc.roles, statuses: tc.statuses})
+
+			err := kc.ValidatePermissions(required)
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error mentioning %q", tc.wantErr)
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("got error %q, want it to mention %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+// Clients logging in to another realm get the admin roles of the target realm from its client there.
+func TestManagementClient(t *testing.T) {
+	tests := map[string]struct {
+		authRealm string
+		want      string
+	}{
+		"same realm":    {authRealm: "test", want: "realm-management"},
+		"master client": {authRealm: "master", want: "test-realm"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc := &Keycloak{Realm: "test", AuthRealm: tc.authRealm}
+			if got := kc.managementClient(); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
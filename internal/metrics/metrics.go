@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	//
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"kegos/internal/globals"
+)
+
+const (
+	StageGsuite   = "gsuite"
+	StageKeycloak = "keycloak"
+
+	// shutdownTimeout bounds how long in-flight scrapes may take once the process is exiting
+	shutdownTimeout = 5 * time.Second
+)
+
+var (
+	ReconcileRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_reconcile_runs_total",
+		Help: "Total number of reconcile cycles started",
+	})
+
+	ReconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kegos_reconcile_duration_seconds",
+		Help:    "Time spent on each reconcile cycle",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	UsersProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_users_processed_total",
+		Help: "Total number of Keycloak users whose groups were reconciled",
+	})
+
+	GroupAdditions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_group_additions_total",
+		Help: "Total number of users added to a synced group",
+	})
+
+	GroupDeletions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_group_deletions_total",
+		Help: "Total number of users removed from a synced group",
+	})
+
+	GsuiteMemberAdditions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_gsuite_member_additions_total",
+		Help: "Total number of users added to a Gsuite group from Keycloak",
+	})
+
+	GsuiteMemberDeletions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_gsuite_member_deletions_total",
+		Help: "Total number of users removed from a Gsuite group from Keycloak",
+	})
+
+	GroupCreations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_group_creations_total",
+		Help: "Total number of synced groups created in Keycloak",
+	})
+
+	GroupPrunes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kegos_group_prunes_total",
+		Help: "Total number of orphaned synced groups deleted from Keycloak",
+	})
+
+	ManagedGroups = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kegos_managed_groups",
+		Help: "Number of groups hanging from the synced parent group in the last cycle",
+	})
+
+	GroupMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kegos_group_members",
+		Help: "Realm users Gsuite puts in each synced group, by source group, as of the last cycle comparing every user",
+	}, []string{"realm", "group"})
+
+	LastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kegos_last_success_timestamp_seconds",
+		Help: "Unix time of the end of the last reconcile cycle that ran to the end",
+	})
+
+	BreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kegos_circuit_breaker_open",
+		Help: "Whether the reconcile loop is backing off after too many aborted cycles in a row, 1 when it is",
+	})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kegos_errors_total",
+		Help: "Total number of failed API calls, split by the stage where they happened",
+	}, []string{"stage"})
+
+	BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kegos_build_info",
+		Help: "Always 1, labelled with the version, git commit and build date of the running binary",
+	}, []string{"version", "commit", "build_date"})
+)
+
+type ServerOptions struct {
+	AppCtx *globals.ApplicationContext
+
+	Address string
+}
+
+type Server struct {
+	appCtx *globals.ApplicationContext
+
+	httpServer *http.Server
+}
+
+func NewServer(opts ServerOptions) *Server {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &Server{
+		appCtx: opts.AppCtx,
+		httpServer: &http.Server{
+			Addr:    opts.Address,
+			Handler: mux,
+		},
+	}
+}
+
+// Run serves the metrics endpoint until the application context is done
+func (s *Server) Run() {
+
+	go func() {
+		<-s.appCtx.Context.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil {
+			s.appCtx.Logger.Error("failed shutting down metrics server", "error", err.Error())
+		}
+	}()
+
+	s.appCtx.Logger.Info("starting metrics server", "address", s.httpServer.Addr)
+	err := s.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.appCtx.Logger.Error("failed serving metrics", "error", err.Error())
+	}
+}
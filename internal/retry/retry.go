@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"google.golang.org/api/googleapi"
+)
+
+type Options struct {
+	// MaxRetries is how many times a failed call is retried after the first attempt.
+	// Zero disables retries
+	MaxRetries int
+
+	// BaseDelay is the pause before the first retry. It doubles on every following one
+	BaseDelay time.Duration
+}
+
+// Do runs fn until it succeeds, fails with a non-transient error, runs out of retries
+// or the context is done. The last error seen is returned
+func Do(ctx context.Context, opts Options, fn func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !IsTransient(err) || attempt >= opts.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff(opts.BaseDelay, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the exponential delay for the given attempt, jittered within its upper half
+// so concurrent callers do not retry in lockstep
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// IsTransient reports whether an error is worth retrying: network failures and 5xx responses
+// from either Keycloak or Google, and Google quota rejections. Other client errors (4xx) are considered permanent
+func IsTransient(err error) bool {
+	var keycloakErr *gocloak.APIError
+	if errors.As(err, &keycloakErr) {
+		// gocloak reports transport failures with a zero code
+		return keycloakErr.Code == 0 || keycloakErr.Code >= http.StatusInternalServerError
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code >= http.StatusInternalServerError || isGoogleRateLimit(googleErr)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isGoogleRateLimit reports whether Google rejected a call for exceeding a quota. The Directory API
+// signals it with a 403 carrying a rate limit reason, other APIs with a plain 429
+func isGoogleRateLimit(err *googleapi.Error) bool {
+	if err.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if err.Code != http.StatusForbidden {
+		return false
+	}
+
+	for _, item := range err.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
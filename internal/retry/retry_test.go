@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// flakyTransport answers with failStatus for the first failures requests, then with okBody.
+type flakyTransport struct {
+	failures   int
+	failStatus int
+	okBody     string
+
+	calls int
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+
+	status, body := http.StatusOK, f.okBody
+	if f.calls <= f.failures {
+		status, body = f.failStatus, `{"error":{"code":`+fmt.Sprint(f.failStatus)+`,"message":"boom"}}`
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+var testOptions = Options{MaxRetries: 3, BaseDelay: time.Millisecond}
+
+// Keycloak calls must be retried on 5xx until they succeed, and never on 4xx.
+func TestDoRetriesKeycloakTransientErrors(t *testing.T) {
+	tests := map[string]struct {
+		failures   int
+		failStatus int
+		wantCalls  int
+		wantErr    bool
+	}{
+		"succeeds after two 503":        {failures: 2, failStatus: http.StatusServiceUnavailable, wantCalls: 3},
+		"gives up after max retries":    {failures: 10, failStatus: http.StatusInternalServerError, wantCalls: 4, wantErr: true},
+		"client errors are not retried": {failures: 1, failStatus: http.StatusNotFound, wantCalls: 1, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := &flakyTransport{failures: tc.failures, failStatus: tc.failStatus, okBody: `[]`}
+			client := gocloak.NewClient("http://keycloak.test")
+			client.RestyClient().SetTransport(transport)
+
+			err := Do(context.Background(), testOptions, func() error {
+				_, err := client.GetUsers(context.Background(), "token", "test", gocloak.GetUsersParams{})
+				return err
+			})
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if transport.calls != tc.wantCalls {
+				t.Fatalf("got %d calls, want %d", transport.calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+// Google calls must be retried on 5xx until they succeed, and never on 4xx.
+func TestDoRetriesGoogleTransientErrors(t *testing.T) {
+	tests := map[string]struct {
+		failures   int
+		failStatus int
+		wantCalls  int
+		wantErr    bool
+	}{
+		"succeeds after one 502":        {failures: 1, failStatus: http.StatusBadGateway, wantCalls: 2},
+		"client errors are not retried": {failures: 1, failStatus: http.StatusForbidden, wantCalls: 1, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := &flakyTransport{failures: tc.failures, failStatus: tc.failStatus, okBody: `{"groups":[]}`}
+			service, err := admin.NewService(context.Background(),
+				option.WithHTTPClient(&http.Client{Transport: transport}),
+				option.WithEndpoint("http://google.test/"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = Do(context.Background(), testOptions, func() error {
+				_, err := service.Groups.List().Domain("example.com").Do()
+				return err
+			})
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if transport.calls != tc.wantCalls {
+				t.Fatalf("got %d calls, want %d", transport.calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+// IsTransient must classify network failures and 5xx as retryable, everything else as permanent.
+func TestIsTransient(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"keycloak 500":         {err: &gocloak.APIError{Code: 500}, want: true},
+		"keycloak network":     {err: &gocloak.APIError{Code: 0}, want: true},
+		"keycloak 409":         {err: &gocloak.APIError{Code: 409}, want: false},
+		"wrapped keycloak 503": {err: fmt.Errorf("failed getting users: %w", &gocloak.APIError{Code: 503}), want: true},
+		"google 503":           {err: &googleapi.Error{Code: 503}, want: true},
+		"google 404":           {err: &googleapi.Error{Code: 404}, want: false},
+		"google 429":           {err: &googleapi.Error{Code: 429}, want: true},
+		"google rate limit":    {err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, want: true},
+		"google forbidden":     {err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, want: false},
+		"plain error":          {err: errors.New("boom"), want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsTransient(tc.err); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// A cancelled context must stop retrying right away.
+func TestDoStopsOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := Do(ctx, Options{MaxRetries: 5, BaseDelay: time.Hour}, func() error {
+		calls++
+		return &gocloak.APIError{Code: 500}
+	})
+
+	if err == nil {
+		t.Fatalf("expected the last error to be returned")
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, want 1", calls)
+	}
+}
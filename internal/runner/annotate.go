@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	//
+	"kegos/internal/metrics"
+)
+
+// UserAttributeLastSynced holds, on the Keycloak users annotated, the RFC3339 time of the last cycle that
+// reconciled them without failures, so users kegos stopped touching can be told apart
+const UserAttributeLastSynced = "kegos/last-synced"
+
+// annotateSyncedUsers stamps the time of the cycle on the given users, by username, keeping any other attribute
+// they have. Users with a failure recorded this cycle are left out, and so are those whose deletions were blocked
+func (r *Runner) annotateSyncedUsers(users map[string]string, pending []pendingDeletions, deletionsBlocked bool) {
+	if !r.annotateUsers || r.dryRun {
+		return
+	}
+
+	unsynced := map[string]struct{}{}
+	for _, failure := range r.cycleFailures {
+		unsynced[failure.User] = struct{}{}
+	}
+	if deletionsBlocked {
+		for _, userDeletions := range pending {
+			unsynced[userDeletions.username] = struct{}{}
+		}
+	}
+
+	attributes := map[string][]string{UserAttributeLastSynced: {r.clock.Now().UTC().Format(time.RFC3339)}}
+	for _, username := range slices.Sorted(maps.Keys(users)) {
+		if _, found := unsynced[username]; found {
+			continue
+		}
+
+		err := r.withRetry(func() error {
+			_, err := r.keycloak.EnsureUserAttributes(r.keycloak.GetToken().AccessToken, users[username], attributes)
+			return err
+		})
+		if err != nil {
+			r.appCtx.Logger.Error("failed updating user attributes", "user", username, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "update user attributes", User: username, Err: err})
+		}
+	}
+}
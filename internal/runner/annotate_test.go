@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Users reconciled without failures must be stamped with the time of the cycle, when enabled and not on dry-run.
+func TestReconcileUserGroupsAnnotatesUsers(t *testing.T) {
+	tests := map[string]struct {
+		annotateUsers  bool
+		dryRun         bool
+		membershipErrs map[string]error
+		wantAnnotated  []string
+	}{
+		"disabled": {},
+		"every user in sync": {
+			annotateUsers: true,
+			wantAnnotated: []string{"alice-id", "bob-id"},
+		},
+		"failed membership change": {
+			annotateUsers:  true,
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			wantAnnotated:  []string{"bob-id"},
+		},
+		"dry run": {
+			annotateUsers: true,
+			dryRun:        true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+
+			// Bob is already in sync, as he belongs to the new group alone
+			newGroup := &gocloak.Group{ID: gocloak.StringP("id-new@corp.com"), Name: gocloak.StringP("new@corp.com"),
+				Attributes: withProvenance(nil, "new@corp.com", time.Now())}
+			kc.children = append(kc.children, newGroup)
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"),
+				Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+			kc.userGroups["bob-id"] = []*gocloak.Group{newGroup}
+			kc.membershipErrs = tc.membershipErrs
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, tc.dryRun)
+			clock := newFakeClock()
+			r.clock = clock
+			r.annotateUsers = tc.annotateUsers
+
+			r.reconcileUserGroups()
+
+			var annotated []string
+			want := []string{clock.now.UTC().Format(time.RFC3339)}
+			for _, userID := range []string{"alice-id", "bob-id"} {
+				attributes, found := kc.userAttributes[userID]
+				if !found {
+					continue
+				}
+				if got := attributes[UserAttributeLastSynced]; !reflect.DeepEqual(got, want) {
+					t.Fatalf("got %s %v on %s, want %v", UserAttributeLastSynced, got, userID, want)
+				}
+				annotated = append(annotated, userID)
+			}
+			if !reflect.DeepEqual(annotated, tc.wantAnnotated) {
+				t.Fatalf("annotated %v, want %v", annotated, tc.wantAnnotated)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"maps"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+const (
+	// GroupAttributeManaged marks the Keycloak groups owned by kegos. Only their memberships
+	// are ever removed, wherever the group lives
+	GroupAttributeManaged = "kegos/managed"
+
+	// GroupAttributeSourceGroup is the Keycloak group attribute holding the Gsuite group email
+	// a synced group mirrors, so the name transformation can always be reversed
+	GroupAttributeSourceGroup = "kegos/source-group"
+
+	// GroupAttributeMappedGroup holds, on the Keycloak groups granted through the group mapping, the name
+	// the mapping gives them. Along with the source group, it identifies them whatever their current name
+	GroupAttributeMappedGroup = "kegos/mapped-group"
+
+	// GroupAttributeLastSynced holds the RFC3339 time of the last cycle that reconciled the group
+	GroupAttributeLastSynced = "kegos/last-synced"
+)
+
+// isManaged reports whether a Keycloak group carries the kegos ownership mark
+func isManaged(group *gocloak.Group) bool {
+	return hasManagedMark(group.Attributes)
+}
+
+// hasManagedMark reports whether the attributes of a Keycloak group or role carry the kegos ownership mark
+func hasManagedMark(attributes *map[string][]string) bool {
+	if attributes == nil {
+		return false
+	}
+	values := (*attributes)[GroupAttributeManaged]
+	return len(values) > 0 && values[0] == "true"
+}
+
+// withProvenance returns the attributes of a group with the kegos provenance set on top,
+// keeping any other attribute defined by hand
+func withProvenance(attributes *map[string][]string, sourceGroup string, syncedAt time.Time) *map[string][]string {
+	result := map[string][]string{}
+	if attributes != nil {
+		maps.Copy(result, *attributes)
+	}
+	maps.Copy(result, provenanceAttributes(sourceGroup, syncedAt))
+
+	return &result
+}
+
+// provenanceAttributes returns the kegos provenance of a group mirroring the given Gsuite group
+func provenanceAttributes(sourceGroup string, syncedAt time.Time) map[string][]string {
+	return map[string][]string{
+		GroupAttributeManaged:     {"true"},
+		GroupAttributeSourceGroup: {sourceGroup},
+		GroupAttributeLastSynced:  {syncedAt.UTC().Format(time.RFC3339)},
+	}
+}
+
+// refreshProvenance stamps the provenance attributes on every synced group seen this cycle.
+// Groups created before the attributes existed are adopted this way the first time they are seen.
+// The attributes are merged into the group as Keycloak has it now, as the given groups may come from
+// the state file, and writing them back would revert renames and attributes changed by hand since
+func (r *Runner) refreshProvenance(kcChildrenGroups map[string]*gocloak.Group, seenGroups map[string]string) {
+	syncedAt := r.clock.Now()
+
+	for identity, sourceGroup := range seenGroups {
+
+		// Groups whose creation failed are not there to be updated
+		kcGroup, found := kcChildrenGroups[identity]
+		if !found {
+			continue
+		}
+
+		err := r.withRetry(func() error {
+			_, err := r.keycloak.EnsureGroupAttributes(r.keycloak.GetToken().AccessToken, *kcGroup.ID,
+				provenanceAttributes(sourceGroup, syncedAt))
+			return err
+		})
+		if err != nil {
+			r.appCtx.Logger.Error("failed updating group attributes", "group", *kcGroup.Name, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "update group attributes", Group: *kcGroup.Name, Err: err})
+			continue
+		}
+
+		updatedGroup := *kcGroup
+		updatedGroup.Attributes = withProvenance(kcGroup.Attributes, sourceGroup, syncedAt)
+		kcChildrenGroups[identity] = &updatedGroup
+	}
+}
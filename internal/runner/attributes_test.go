@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// assertProvenance fails unless the group carries the full kegos provenance for the given source.
+func assertProvenance(t *testing.T, group gocloak.Group, sourceGroup string) {
+	t.Helper()
+
+	if !isManaged(&group) {
+		t.Fatalf("group %q is not marked as managed: %v", *group.Name, group.Attributes)
+	}
+	if got := sourceGroupOf(&group); got != sourceGroup {
+		t.Fatalf("group %q has source %q, want %q", *group.Name, got, sourceGroup)
+	}
+
+	lastSynced := (*group.Attributes)[GroupAttributeLastSynced]
+	if len(lastSynced) != 1 {
+		t.Fatalf("group %q has no last synced time: %v", *group.Name, group.Attributes)
+	}
+	if _, err := time.Parse(time.RFC3339, lastSynced[0]); err != nil {
+		t.Fatalf("group %q has an invalid last synced time: %v", *group.Name, err)
+	}
+}
+
+// Groups must be born with their provenance, and every synced group must get it refreshed each cycle.
+// The fake realm never persists creations, so the missing group is created again on every cycle.
+func TestReconcileUserGroupsWritesProvenance(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.children = append(kc.children, &gocloak.Group{
+		ID:         gocloak.StringP("id-ops@corp.com"),
+		Name:       gocloak.StringP("ops@corp.com"),
+		Attributes: &map[string][]string{"owner": {"platform"}},
+	})
+	gs.groupsByDomain["corp.com"] = []string{"new@corp.com", "ops@corp.com"}
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		kc.updated = nil
+
+		if err := r.reconcileUserGroups(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		updated := map[string]gocloak.Group{}
+		for _, group := range kc.updated {
+			updated[*group.Name] = group
+		}
+		if len(updated) != 2 {
+			t.Fatalf("cycle %d: updated %d groups, want 2", cycle, len(updated))
+		}
+		for name, group := range updated {
+			assertProvenance(t, group, name)
+		}
+		if owner := (*updated["ops@corp.com"].Attributes)["owner"]; len(owner) != 1 || owner[0] != "platform" {
+			t.Fatalf("cycle %d: hand-made attributes were lost: %v", cycle, updated["ops@corp.com"].Attributes)
+		}
+
+		if len(kc.createdGroups) != cycle {
+			t.Fatalf("cycle %d: created %d groups, want %d", cycle, len(kc.createdGroups), cycle)
+		}
+		assertProvenance(t, kc.createdGroups[cycle-1], "new@corp.com")
+	}
+}
+
+// Memberships of groups without the managed mark must be left alone, even under the synced parent.
+func TestReconcileUserGroupsKeepsUnmanagedMemberships(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.children[0].Attributes = nil
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.deletions)+len(kc.pruned) > 0 {
+		t.Fatalf("expected no removals, got deletions %v, pruned %v", kc.deletions, kc.pruned)
+	}
+}
+
+// newRealmWithAdminGroup returns the fake realm plus a group an admin created by hand under the synced parent,
+// which alice belongs to although no Gsuite group maps to it.
+func newRealmWithAdminGroup() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc, gs := newFakeRealm()
+	kc.children = append(kc.children,
+		&gocloak.Group{ID: gocloak.StringP("id-admins"), Name: gocloak.StringP("admins")})
+	kc.userGroups["alice-id"] = append(kc.userGroups["alice-id"],
+		&gocloak.Group{ID: gocloak.StringP("id-admins"), Name: gocloak.StringP("admins"), Path: gocloak.StringP("/google-workspace/admins")})
+	return kc, gs
+}
+
+// A group created by hand under the synced parent must keep its members and never be pruned nor marked,
+// while the stale managed group next to it is still cleaned up.
+func TestReconcileUserGroupsLeavesAdminCreatedGroupsAlone(t *testing.T) {
+	kc, gs := newRealmWithAdminGroup()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+	if want := []string{"id-old@corp.com"}; !reflect.DeepEqual(kc.pruned, want) {
+		t.Fatalf("pruned %v, want %v", kc.pruned, want)
+	}
+	for _, group := range kc.updated {
+		if *group.ID == "id-admins" {
+			t.Fatalf("expected the admin group attributes untouched, got %v", group.Attributes)
+		}
+	}
+}
+
+// The drift report must not count memberships of groups created by hand as removals either.
+func TestDiffLeavesAdminCreatedGroupsAlone(t *testing.T) {
+	kc, gs := newRealmWithAdminGroup()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(diffs) != 1 {
+		t.Fatalf("got %d diffs, want 1", len(diffs))
+	}
+	if want := []string{"old@corp.com"}; !reflect.DeepEqual(diffs[0].Remove, want) {
+		t.Fatalf("removals %v, want %v", diffs[0].Remove, want)
+	}
+}
+
+// Dry-run must not touch attributes either.
+func TestReconcileUserGroupsDryRunKeepsProvenance(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.updated) > 0 {
+		t.Fatalf("expected no updates, got %v", kc.updated)
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AuditActionAdd    = "add"
+	AuditActionRemove = "remove"
+	AuditActionCreate = "create"
+	AuditActionDelete = "delete"
+
+	AuditTargetKeycloak = "keycloak"
+	AuditTargetGsuite   = "gsuite"
+
+	AuditResultSuccess = "success"
+	AuditResultError   = "error"
+)
+
+// AuditRecord is a line of the audit log, telling about a single change sent to Keycloak or, when writing
+// back, to Gsuite. Group holds the group, or the role with the roles target, the change was about.
+// User is empty for changes on groups themselves
+type AuditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Realm     string    `json:"realm"`
+	Target    string    `json:"target"`
+	User      string    `json:"user,omitempty"`
+	Group     string    `json:"group"`
+	Action    string    `json:"action"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends records to a file, one JSON object per line, syncing each one to disk before going on
+type auditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// openAuditLog opens the file for appending, creating it when missing. Existing records are kept
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+// Close closes the audit log, when there is one, waiting for the running cycle to end. Changes made afterwards,
+// such as by single users reconciled meanwhile, are no longer audited
+func (r *Runner) Close() error {
+	r.cycleMu.Lock()
+	defer r.cycleMu.Unlock()
+
+	if r.auditLog == nil {
+		return nil
+	}
+	err := r.auditLog.file.Close()
+	r.auditLog = nil
+	return err
+}
+
+// write appends the record and syncs the file, so it survives a crash right after the change
+func (a *auditLog) write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// audit records a change sent to the target, Keycloak or Gsuite, while reconciling the current realm,
+// along with its outcome, and publishes it when successful. It records nothing when the audit log is disabled
+func (r *Runner) audit(target, action, user, group string, err error) {
+	r.publishChange(target, action, user, group, err)
+	if r.auditLog == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Timestamp: r.clock.Now().UTC(),
+		Realm:     r.realm,
+		Target:    target,
+		User:      user,
+		Group:     group,
+		Action:    action,
+		Result:    AuditResultSuccess,
+	}
+	if err != nil {
+		record.Result = AuditResultError
+		record.Error = err.Error()
+	}
+
+	if writeErr := r.auditLog.write(record); writeErr != nil {
+		r.appCtx.Logger.Error("failed writing audit record", "target", target, "action", action, "user", user, "group", group,
+			"error", writeErr.Error())
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readAuditLog decodes every line of the audit log at path.
+func readAuditLog(t *testing.T, path string) (records []AuditRecord) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q is not an audit record: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return records
+}
+
+// Every change sent to Keycloak during a cycle must be appended to the audit log, failed ones included.
+func TestReconcileOnceWritesAuditLog(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		breakRealm  func(kc *fakeKeycloakClient)
+		wantRecords []AuditRecord
+	}{
+		"successful cycle": {
+			breakRealm: func(*fakeKeycloakClient) {},
+			wantRecords: []AuditRecord{
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, Group: "new@corp.com", Action: AuditActionCreate, Result: AuditResultSuccess},
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, User: "alice@corp.com", Group: "new@corp.com", Action: AuditActionAdd, Result: AuditResultSuccess},
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, User: "alice@corp.com", Group: "old@corp.com", Action: AuditActionRemove, Result: AuditResultSuccess},
+			},
+		},
+		"failed removal": {
+			breakRealm: func(kc *fakeKeycloakClient) {
+				kc.membershipErrs = map[string]error{"id-old@corp.com": errors.New("boom")}
+			},
+			wantRecords: []AuditRecord{
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, Group: "new@corp.com", Action: AuditActionCreate, Result: AuditResultSuccess},
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, User: "alice@corp.com", Group: "new@corp.com", Action: AuditActionAdd, Result: AuditResultSuccess},
+				{Timestamp: at, Realm: "test", Target: AuditTargetKeycloak, User: "alice@corp.com", Group: "old@corp.com", Action: AuditActionRemove, Result: AuditResultError, Error: "boom"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			tc.breakRealm(kc)
+
+			path := filepath.Join(t.TempDir(), "audit.log")
+			auditLog, err := openAuditLog(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			t.Cleanup(func() { auditLog.file.Close() })
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.clock = newFakeClock()
+			r.auditLog = auditLog
+
+			r.ReconcileOnce()
+
+			if got := readAuditLog(t, path); !reflect.DeepEqual(got, tc.wantRecords) {
+				t.Fatalf("got %+v, want %+v", got, tc.wantRecords)
+			}
+		})
+	}
+}
+
+// Dry-runs change nothing, so they must leave the audit log empty.
+func TestReconcileOnceDryRunLeavesAuditLogEmpty(t *testing.T) {
+	kc, gs := newFakeRealm()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { auditLog.file.Close() })
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	r.auditLog = auditLog
+
+	r.ReconcileOnce()
+
+	if got := readAuditLog(t, path); len(got) != 0 {
+		t.Fatalf("expected no audit record, got %+v", got)
+	}
+}
+
+// Reopening the audit log, as on a restart, must keep the records already in it.
+func TestOpenAuditLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	record := AuditRecord{Realm: "test", Target: AuditTargetKeycloak, Group: "dev@corp.com", Action: AuditActionCreate, Result: AuditResultSuccess}
+
+	for range 2 {
+		auditLog, err := openAuditLog(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := auditLog.write(record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		auditLog.file.Close()
+	}
+
+	if got := readAuditLog(t, path); !reflect.DeepEqual(got, []AuditRecord{record, record}) {
+		t.Fatalf("got %+v, want the record twice", got)
+	}
+}
+
+// The audit log must be closed once the reconcile loop returns, and closing it again must do nothing.
+func TestPleaseDoYourStuffForeverClosesAuditLog(t *testing.T) {
+	kc, gs := newFakeRealm()
+	auditLog, err := openAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	r.reconcileLoopDuration = time.Hour
+	r.auditLog = auditLog
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.appCtx.Context = ctx
+
+	r.PleaseDoYourStuffForever()
+
+	if r.auditLog != nil {
+		t.Fatalf("expected the audit log to be released")
+	}
+	if _, err := auditLog.file.Write([]byte("{}\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("got %v, want the audit log closed", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error closing again: %v", err)
+	}
+}
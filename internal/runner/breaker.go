@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"time"
+
+	//
+	"kegos/internal/metrics"
+)
+
+// circuitBreaker backs the reconcile loop off once cycles keep being aborted, such as while Keycloak is down,
+// so it is not hammered every interval. Cycles that ran to the end, even with failed operations, close it
+type circuitBreaker struct {
+	// threshold is how many aborted cycles in a row open the breaker. Zero disables it
+	threshold int
+
+	// maxBackoff caps the wait between cycles while open
+	maxBackoff time.Duration
+
+	consecutiveFailures int
+	open                bool
+}
+
+// record accounts the outcome of a cycle, reporting whether the breaker opened or closed with it
+func (b *circuitBreaker) record(err error) (changed bool) {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	if !CycleAborted(err) {
+		b.consecutiveFailures = 0
+		changed = b.open
+		b.open = false
+		return changed
+	}
+
+	b.consecutiveFailures++
+	if b.open || b.consecutiveFailures < b.threshold {
+		return false
+	}
+	b.open = true
+	return true
+}
+
+// backoff returns the wait before the next cycle while open, doubling the interval for every aborted cycle
+// past the threshold up to maxBackoff. It is never shorter than the interval
+func (b *circuitBreaker) backoff(interval time.Duration) time.Duration {
+	limit := max(b.maxBackoff, interval)
+
+	wait := interval
+	for range b.consecutiveFailures - b.threshold + 1 {
+		if wait >= limit/2 {
+			return limit
+		}
+		wait *= 2
+	}
+	return wait
+}
+
+// nextWait feeds the outcome of a cycle to the circuit breaker and returns how long to wait before the
+// next one: the usual interval while closed, a growing backoff while open
+func (r *Runner) nextWait(err error) time.Duration {
+	if r.breaker.record(err) {
+		r.readiness.SetBreakerOpen(r.breaker.open)
+		if r.breaker.open {
+			metrics.BreakerOpen.Set(1)
+			r.appCtx.Logger.Warn("reconcile cycles keep failing. Opening circuit breaker...",
+				"failures", r.breaker.consecutiveFailures)
+		} else {
+			metrics.BreakerOpen.Set(0)
+			r.appCtx.Logger.Info("reconcile cycle succeeded. Closing circuit breaker...")
+		}
+	}
+
+	if !r.breaker.open {
+		return withJitter(r.reconcileLoopDuration, r.reconcileJitter)
+	}
+
+	wait := r.breaker.backoff(r.reconcileLoopDuration)
+	r.appCtx.Logger.Warn("circuit breaker open. Backing off...",
+		"failures", r.breaker.consecutiveFailures, "backoff", wait.String())
+	return wait
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"kegos/internal/health"
+)
+
+// The breaker must open after the threshold of aborted cycles only, and close on the first one running to the end.
+func TestCircuitBreakerRecord(t *testing.T) {
+	aborted := errors.New("keycloak is down")
+	partial := &CycleError{Failures: []OperationFailure{{Operation: "add membership", Err: aborted}}}
+
+	tests := map[string]struct {
+		threshold   int
+		cycles      []error
+		wantOpen    bool
+		wantChanged bool
+	}{
+		"disabled":                     {threshold: 0, cycles: []error{aborted, aborted, aborted}},
+		"under threshold":              {threshold: 3, cycles: []error{aborted, aborted}},
+		"threshold reached":            {threshold: 3, cycles: []error{aborted, aborted, aborted}, wantOpen: true, wantChanged: true},
+		"still open":                   {threshold: 3, cycles: []error{aborted, aborted, aborted, aborted}, wantOpen: true},
+		"success in between":           {threshold: 3, cycles: []error{aborted, aborted, nil, aborted}},
+		"success closes":               {threshold: 2, cycles: []error{aborted, aborted, nil}, wantChanged: true},
+		"failed operations close":      {threshold: 2, cycles: []error{aborted, aborted, partial}, wantChanged: true},
+		"failed operations never open": {threshold: 2, cycles: []error{partial, partial, partial}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			breaker := circuitBreaker{threshold: tc.threshold, maxBackoff: time.Hour}
+
+			var changed bool
+			for _, err := range tc.cycles {
+				changed = breaker.record(err)
+			}
+
+			if breaker.open != tc.wantOpen {
+				t.Fatalf("got open %v, want %v", breaker.open, tc.wantOpen)
+			}
+			if changed != tc.wantChanged {
+				t.Fatalf("got changed %v on the last cycle, want %v", changed, tc.wantChanged)
+			}
+		})
+	}
+}
+
+// The backoff must double the interval for every aborted cycle past the threshold, up to the cap.
+func TestCircuitBreakerBackoff(t *testing.T) {
+	tests := map[string]struct {
+		failures   int
+		maxBackoff time.Duration
+		want       time.Duration
+	}{
+		"just opened":            {failures: 3, maxBackoff: time.Hour, want: 20 * time.Minute},
+		"one more failure":       {failures: 4, maxBackoff: time.Hour, want: 40 * time.Minute},
+		"capped":                 {failures: 5, maxBackoff: time.Hour, want: time.Hour},
+		"capped for good":        {failures: 500, maxBackoff: time.Hour, want: time.Hour},
+		"cap under the interval": {failures: 4, maxBackoff: time.Minute, want: 10 * time.Minute},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			breaker := circuitBreaker{threshold: 3, maxBackoff: tc.maxBackoff, consecutiveFailures: tc.failures}
+			if got := breaker.backoff(10 * time.Minute); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// The loop must back off while Keycloak keeps failing, report it through readiness, and resume its
+// interval once a cycle runs to the end.
+func TestPleaseDoYourStuffForeverBacksOffWhileFailing(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.tokenErr = errors.New("connection refused")
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.appCtx.Context = ctx
+
+	clock := newFakeClock()
+	r.clock = clock
+	r.reconcileLoopDuration = 10 * time.Minute
+	r.readiness = health.NewReadiness(10)
+	r.breaker = circuitBreaker{threshold: 2, maxBackoff: 30 * time.Minute}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.PleaseDoYourStuffForever()
+	}()
+
+	// Keycloak is back once the breaker had to back off as far as it goes
+	wantWaits := []time.Duration{10 * time.Minute, 20 * time.Minute, 30 * time.Minute, 30 * time.Minute, 10 * time.Minute}
+	for cycle, want := range wantWaits {
+		select {
+		case wait := <-clock.waits:
+			if wait.duration != want {
+				t.Fatalf("cycle %d waited %s, want %s", cycle+1, wait.duration, want)
+			}
+			if breakerOpen := r.readiness.BreakerOpen(); breakerOpen != (cycle >= 1 && cycle <= 3) {
+				t.Fatalf("cycle %d left the breaker open %v", cycle+1, breakerOpen)
+			}
+			if cycle == len(wantWaits)-1 {
+				cancel()
+				break
+			}
+			if cycle == len(wantWaits)-2 {
+				kc.tokenErr = nil
+			}
+			clock.advance(wait)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cycle %d never finished", cycle+1)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the loop did not stop once cancelled")
+	}
+
+	if opened := strings.Count(logs.String(), "Opening circuit breaker..."); opened != 1 {
+		t.Fatalf("logged opening the breaker %d times, want once", opened)
+	}
+	if closed := strings.Count(logs.String(), "Closing circuit breaker..."); closed != 1 {
+		t.Fatalf("logged closing the breaker %d times, want once", closed)
+	}
+}
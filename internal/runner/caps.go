@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// cycleCaps bounds how many users and groups a cycle processes, so a realm can be experimented on
+// a small part at a time. A zero cap disables it
+type cycleCaps struct {
+	maxUsers  int
+	maxGroups int
+
+	// admittedGroups holds the identities of the Gsuite groups processed by the running cycle
+	admittedGroups map[string]struct{}
+
+	// skippedUsers and skippedGroups count what the caps left out of the running cycle
+	skippedUsers  int
+	skippedGroups map[string]struct{}
+}
+
+// enabled reports whether any cap is set
+func (c *cycleCaps) enabled() bool {
+	return c.maxUsers > 0 || c.maxGroups > 0
+}
+
+// reset forgets the users and groups of the previous cycle
+func (c *cycleCaps) reset() {
+	c.admittedGroups = map[string]struct{}{}
+	c.skippedUsers = 0
+	c.skippedGroups = map[string]struct{}{}
+}
+
+// partial reports whether the caps left any user or group out of the running cycle, in which case
+// what spans the whole realm, such as pruning, can not be trusted
+func (c *cycleCaps) partial() bool {
+	return c.skippedUsers > 0 || len(c.skippedGroups) > 0
+}
+
+// capUsers keeps the first users, which come sorted by username
+func (c *cycleCaps) capUsers(users []*gocloak.User) []*gocloak.User {
+	if c.maxUsers <= 0 || len(users) <= c.maxUsers {
+		return users
+	}
+	c.skippedUsers += len(users) - c.maxUsers
+	return users[:c.maxUsers]
+}
+
+// admitsGroup reports whether the Gsuite group can be processed. Groups are admitted in the order they
+// are first seen, users and their groups being processed sorted, until the cap is reached
+func (c *cycleCaps) admitsGroup(gsuiteGroup string) bool {
+	if c.maxGroups <= 0 {
+		return true
+	}
+
+	identity := groupIdentity(gsuiteGroup)
+	if _, admitted := c.admittedGroups[identity]; admitted {
+		return true
+	}
+	if len(c.admittedGroups) < c.maxGroups {
+		c.admittedGroups[identity] = struct{}{}
+		return true
+	}
+	c.skippedGroups[identity] = struct{}{}
+	return false
+}
+
+// capGroups keeps the Gsuite groups admitted by the cap, which are expected sorted
+func (c *cycleCaps) capGroups(gsuiteGroups []string) (admitted []string) {
+	if c.maxGroups <= 0 {
+		return gsuiteGroups
+	}
+	for _, gsuiteGroup := range gsuiteGroups {
+		if c.admitsGroup(gsuiteGroup) {
+			admitted = append(admitted, gsuiteGroup)
+		}
+	}
+	return admitted
+}
+
+// logPartialSync warns when the caps left anything out of the cycle that just ended
+func (r *Runner) logPartialSync() {
+	if !r.caps.partial() {
+		return
+	}
+	r.appCtx.Logger.Warn("caps left part of the realm out of the cycle. Partial sync performed",
+		"max_users", r.caps.maxUsers, "skipped_users", r.caps.skippedUsers,
+		"max_groups", r.caps.maxGroups, "skipped_groups", len(r.caps.skippedGroups))
+}
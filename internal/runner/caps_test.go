@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Only the first users and groups, in sorted order, must be processed when capped, leaving the rest untouched.
+func TestReconcileUserGroupsCaps(t *testing.T) {
+	tests := map[string]struct {
+		caps          cycleCaps
+		wantLookups   []string
+		wantCreated   []string
+		wantAdditions int
+		wantDeletions []string
+		wantPartial   bool
+	}{
+		"no caps": {
+			wantLookups:   []string{"alice@corp.com", "bob@corp.com", "carol@corp.com"},
+			wantCreated:   []string{"a@corp.com", "b@corp.com", "c@corp.com"},
+			wantAdditions: 9,
+			wantDeletions: []string{"alice-id:id-old@corp.com"},
+		},
+		"users capped": {
+			caps:          cycleCaps{maxUsers: 2},
+			wantLookups:   []string{"alice@corp.com", "bob@corp.com"},
+			wantCreated:   []string{"a@corp.com", "b@corp.com", "c@corp.com"},
+			wantAdditions: 6,
+			wantDeletions: []string{"alice-id:id-old@corp.com"},
+			wantPartial:   true,
+		},
+		"groups capped": {
+			caps:          cycleCaps{maxGroups: 2},
+			wantLookups:   []string{"alice@corp.com", "bob@corp.com", "carol@corp.com"},
+			wantCreated:   []string{"a@corp.com", "b@corp.com"},
+			wantAdditions: 6,
+			wantPartial:   true,
+		},
+		"caps not reached": {
+			caps:          cycleCaps{maxUsers: 10, maxGroups: 10},
+			wantLookups:   []string{"alice@corp.com", "bob@corp.com", "carol@corp.com"},
+			wantCreated:   []string{"a@corp.com", "b@corp.com", "c@corp.com"},
+			wantAdditions: 9,
+			wantDeletions: []string{"alice-id:id-old@corp.com"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			for _, username := range []string{"carol", "bob"} {
+				kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP(username + "-id"),
+					Username: gocloak.StringP(username + "@corp.com"), Email: gocloak.StringP(username + "@corp.com")})
+			}
+			gs.groupsByDomain["corp.com"] = []string{"c@corp.com", "a@corp.com", "b@corp.com"}
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			r.caps = tc.caps
+			r.pruneGroups = true
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(gs.lookups, tc.wantLookups) {
+				t.Fatalf("looked up %v, want %v", gs.lookups, tc.wantLookups)
+			}
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if len(kc.additions) != tc.wantAdditions {
+				t.Fatalf("got %d additions, want %d: %v", len(kc.additions), tc.wantAdditions, kc.additions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+			if len(kc.pruned) != 0 && tc.caps.enabled() {
+				t.Fatalf("pruned %v while capped", kc.pruned)
+			}
+			if partial := strings.Contains(logs.String(), "Partial sync performed"); partial != tc.wantPartial {
+				t.Fatalf("partial sync logged %t, want %t", partial, tc.wantPartial)
+			}
+		})
+	}
+}
+
+// Groups must be admitted in the order they are first seen until the cap is reached, then only those admitted.
+func TestCycleCapsAdmitsGroups(t *testing.T) {
+	caps := cycleCaps{maxGroups: 2}
+	caps.reset()
+
+	if got, want := caps.capGroups([]string{"a@corp.com", "b@corp.com", "c@corp.com"}), []string{"a@corp.com", "b@corp.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !caps.admitsGroup("B@corp.com") {
+		t.Fatalf("an admitted group must stay admitted whatever its case")
+	}
+	if caps.admitsGroup("d@corp.com") {
+		t.Fatalf("a new group must not be admitted once the cap is reached")
+	}
+	if !caps.partial() {
+		t.Fatalf("the cycle must be partial once a group was left out")
+	}
+}
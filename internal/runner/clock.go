@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"time"
+)
+
+// Clock tells the time and waits for it to pass. Tests replace it to drive the reconcile loop
+type Clock interface {
+	Now() time.Time
+
+	// After sends the current time on the returned channel once the duration has elapsed
+	After(duration time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock backed by the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(duration time.Duration) <-chan time.Time {
+	return time.After(duration)
+}
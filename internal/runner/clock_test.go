@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands still until told otherwise. Every wait is handed over on waits, so the test decides
+// when, and whether, it ends.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+
+	waits chan fakeWait
+}
+
+// fakeWait is a pending call to After.
+type fakeWait struct {
+	duration time.Duration
+	fire     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		waits: make(chan fakeWait),
+	}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(duration time.Duration) <-chan time.Time {
+	wait := fakeWait{duration: duration, fire: make(chan time.Time, 1)}
+	f.waits <- wait
+	return wait.fire
+}
+
+// advance moves the clock forward and ends the given wait.
+func (f *fakeClock) advance(wait fakeWait) {
+	f.mu.Lock()
+	f.now = f.now.Add(wait.duration)
+	now := f.now
+	f.mu.Unlock()
+
+	wait.fire <- now
+}
+
+// The loop must wait the configured interval between cycles, and stop once cancelled while waiting.
+func TestPleaseDoYourStuffForeverRunsUntilCancelled(t *testing.T) {
+	kc, gs := newFakeRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.appCtx.Context = ctx
+
+	clock := newFakeClock()
+	start := clock.Now()
+	r.clock = clock
+	r.reconcileLoopDuration = 10 * time.Minute
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.PleaseDoYourStuffForever()
+	}()
+
+	// Every cycle ends waiting for the next one
+	for cycle := 1; cycle <= 2; cycle++ {
+		select {
+		case wait := <-clock.waits:
+			if wait.duration != r.reconcileLoopDuration {
+				t.Fatalf("cycle %d waited %s, want %s", cycle, wait.duration, r.reconcileLoopDuration)
+			}
+			if cycle == 2 {
+				cancel()
+				break
+			}
+			clock.advance(wait)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cycle %d never finished", cycle)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the loop did not stop once cancelled")
+	}
+
+	if cycles := strings.Count(logs.String(), `"msg":"reconcile cycle summary"`); cycles != 2 {
+		t.Fatalf("got %d cycles, want 2", cycles)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != r.reconcileLoopDuration {
+		t.Fatalf("clock advanced %s, want a single interval", elapsed)
+	}
+}
@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// startCycle tags every log line of the runner with a fresh cycle id, so the lines of a single pass can be
+// grouped together. The returned function brings the previous logger back
+func (r *Runner) startCycle() (end func()) {
+	appCtx := r.appCtx
+	r.cycleID = newCycleID()
+	r.appCtx = appCtx.WithLogAttrs("cycle_id", r.cycleID)
+	return func() {
+		r.appCtx = appCtx
+	}
+}
+
+// newCycleID returns a random id, short enough to be read in logs
+func newCycleID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	//
+	"kegos/internal/globals"
+)
+
+// cycleIDs returns the cycle id of every log line, in order, failing on lines without one.
+func cycleIDs(t *testing.T, logs *bytes.Buffer) (ids []string) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		id, _ := line["cycle_id"].(string)
+		if id == "" {
+			t.Fatalf("log line without cycle id: %s", scanner.Text())
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Every line of a cycle, nested realm and user lines included, must share one cycle id, different on each cycle.
+func TestReconcileOnceTagsLogsWithCycleID(t *testing.T) {
+	kc, gs := newFakeRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := cycleIDs(t, logs)
+	if len(first) < 2 {
+		t.Fatalf("expected several log lines, got %d", len(first))
+	}
+	for _, id := range first {
+		if id != first[0] {
+			t.Fatalf("got cycle ids %q and %q within one cycle", first[0], id)
+		}
+	}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := cycleIDs(t, logs)
+	if second[0] == first[0] {
+		t.Fatalf("expected a new cycle id, got %q again", second[0])
+	}
+}
+
+// The cycle logger must also reach whatever reads it from the context, and be gone once the cycle ends.
+func TestStartCycleStoresLoggerInContext(t *testing.T) {
+	kc, gs := newFakeRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+	appCtx := r.appCtx
+
+	// Logging through the context from within a realm stands for any nested call
+	realmFn := func(string) error {
+		globals.LoggerFromContext(r.appCtx.Context).Info("nested call")
+		return nil
+	}
+
+	restore := r.startCycle()
+	if err := r.forEachRealm(realmFn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restore()
+
+	ids := cycleIDs(t, logs)
+	if len(ids) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(ids))
+	}
+	if r.appCtx != appCtx {
+		t.Fatalf("expected the logger without cycle id back once the cycle ended")
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"strings"
+)
+
+// changeKey returns the key a debounced membership change is tracked by. Additions and removals of the
+// same membership are tracked apart, so a change turning around starts over
+func changeKey(userID, identity string, remove bool) string {
+	action := "add"
+	if remove {
+		action = "remove"
+	}
+	return userID + "/" + action + "/" + identity
+}
+
+// changeSettled reports whether a membership change has been planned on enough consecutive cycles to be
+// applied, counting this one. Counts are not recorded on dry-run, where changes only settle without debouncing.
+// The cycles it has been planned on are returned as well
+func (r *Runner) changeSettled(key string) (settled bool, seen int) {
+	if r.debounceCycles <= 1 {
+		return true, 1
+	}
+
+	seen = r.pendingChanges[r.realm][key] + 1
+	if !r.dryRun {
+		if r.pendingChanges[r.realm] == nil {
+			r.pendingChanges[r.realm] = map[string]int{}
+		}
+		r.pendingChanges[r.realm][key] = min(seen, r.debounceCycles)
+	}
+	return seen >= r.debounceCycles, seen
+}
+
+// removalDebounced reports whether the removal of any membership of the group, by identity, is held back
+// waiting for more cycles. Such a group must not be pruned meanwhile, as that would drop the membership anyway
+func (r *Runner) removalDebounced(identity string) bool {
+	for key, seen := range r.pendingChanges[r.realm] {
+		if seen >= r.debounceCycles {
+			continue
+		}
+		if _, change, _ := strings.Cut(key, "/"); change == "remove/"+identity {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetUnplannedChanges drops the counts of the changes of the compared users the cycle did not plan again,
+// so a change has to be planned on consecutive cycles to settle. Users not compared keep theirs
+func (r *Runner) forgetUnplannedChanges(comparedUsers map[string]struct{}, plannedChanges map[string]struct{}) {
+	if r.dryRun {
+		return
+	}
+	for key := range r.pendingChanges[r.realm] {
+		userID, _, _ := strings.Cut(key, "/")
+		if _, compared := comparedUsers[userID]; !compared {
+			continue
+		}
+		if _, planned := plannedChanges[key]; !planned {
+			delete(r.pendingChanges[r.realm], key)
+		}
+	}
+}
+
+// forgetUnlistedChanges drops the counts of the changes of the users no longer listed, such as those deleted
+// meanwhile. They are never compared again, so their counts would never start over, holding the pruning of their
+// groups back. It must only run on cycles listing every user
+func (r *Runner) forgetUnlistedChanges(listedUsers map[string]struct{}) {
+	if r.dryRun {
+		return
+	}
+	for key := range r.pendingChanges[r.realm] {
+		userID, _, _ := strings.Cut(key, "/")
+		if _, listed := listedUsers[userID]; !listed {
+			delete(r.pendingChanges[r.realm], key)
+		}
+	}
+}
+
+// logDebouncedChange logs at debug a membership change held back until planned on enough consecutive cycles
+func (r *Runner) logDebouncedChange(user, group string, seen int) {
+	r.appCtx.Logger.Debug("membership change debounced", "user", user, "group", group, "seen_cycles", seen,
+		"required_cycles", r.debounceCycles, "reason", skipReasonDebounced)
+	r.cycleStats.changesDebounced++
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Membership changes must only be applied once planned on enough consecutive cycles, so memberships
+// flapping in Gsuite are left alone.
+func TestReconcileUserGroupsDebouncesChanges(t *testing.T) {
+	tests := map[string]struct {
+		debounceCycles int
+
+		// cycles holds the Gsuite groups of alice on every cycle. She starts in old@corp.com only
+		cycles [][]string
+
+		// wantAdditionAt and wantRemovalAt are the cycles, counting from one, joining new@corp.com and
+		// leaving old@corp.com. Zero is never
+		wantAdditionAt int
+		wantRemovalAt  int
+	}{
+		"debouncing disabled": {
+			cycles:         [][]string{{"new@corp.com"}},
+			wantAdditionAt: 1,
+			wantRemovalAt:  1,
+		},
+		"steady changes": {
+			debounceCycles: 3,
+			cycles:         [][]string{{"new@corp.com"}, {"new@corp.com"}, {"new@corp.com"}},
+			wantAdditionAt: 3,
+			wantRemovalAt:  3,
+		},
+		"flapping removal": {
+			debounceCycles: 3,
+			cycles: [][]string{
+				{"new@corp.com"}, {"new@corp.com", "old@corp.com"}, {"new@corp.com"}, {"new@corp.com"},
+			},
+			wantAdditionAt: 3,
+		},
+		"flapping addition": {
+			debounceCycles: 3,
+			cycles:         [][]string{{"new@corp.com"}, {}, {"new@corp.com"}, {"new@corp.com"}},
+			wantRemovalAt:  3,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.debounceCycles = tc.debounceCycles
+
+			for i, groups := range tc.cycles {
+				cycle := i + 1
+				gs.groupsByDomain["corp.com"] = groups
+				if err := r.reconcileUserGroups(); err != nil {
+					t.Fatalf("cycle %d: unexpected error: %v", cycle, err)
+				}
+
+				added := len(kc.additions) > 0
+				if want := tc.wantAdditionAt != 0 && cycle >= tc.wantAdditionAt; added != want {
+					t.Fatalf("cycle %d: added %v, want %v; additions %v", cycle, added, want, kc.additions)
+				}
+				removed := len(kc.deletions) > 0
+				if want := tc.wantRemovalAt != 0 && cycle >= tc.wantRemovalAt; removed != want {
+					t.Fatalf("cycle %d: removed %v, want %v; deletions %v", cycle, removed, want, kc.deletions)
+				}
+			}
+		})
+	}
+}
+
+// Dry-runs must report the changes held back without recording them, nor creating any group.
+func TestReconcileUserGroupsDebounceOnDryRun(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	r.debounceCycles = 2
+
+	for range 3 {
+		if err := r.reconcileUserGroups(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(r.pendingChanges[r.realm]) > 0 {
+		t.Fatalf("expected no pending changes recorded on dry-run, got %v", r.pendingChanges[r.realm])
+	}
+	if len(kc.created) > 0 {
+		t.Fatalf("unexpected groups created: %v", kc.created)
+	}
+	if r.cycleStats.changesDebounced != 2 {
+		t.Fatalf("got %d debounced changes, want 2", r.cycleStats.changesDebounced)
+	}
+}
+
+// A group Gsuite no longer has must not be pruned while the removal of its members is debounced, as pruning
+// would drop the memberships held back anyway.
+func TestReconcileUserGroupsDebounceDefersPruning(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.debounceCycles = 3
+	r.pruneGroups = true
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		if err := r.reconcileUserGroups(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(kc.deletions)+len(kc.pruned) > 0 {
+			t.Fatalf("expected the removal and the pruning to be held back on cycle %d, got deletions %v, pruned %v",
+				cycle, kc.deletions, kc.pruned)
+		}
+	}
+
+	// The removal settles on the third cycle, after which nothing holds the group back anymore
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.deletions) != 1 {
+		t.Fatalf("expected the removal to be applied, got deletions %v", kc.deletions)
+	}
+	if len(kc.pruned) != 1 || kc.pruned[0] != "id-old@corp.com" {
+		t.Fatalf("pruned %v, want [id-old@corp.com]", kc.pruned)
+	}
+}
+
+// Changes held back for users deleted meanwhile must be dropped, so they never hold the pruning back.
+func TestReconcileUserGroupsDebounceForgetsDeletedUsers(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.debounceCycles = 3
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.pendingChanges[r.realm]) == 0 {
+		t.Fatalf("expected the changes to be held back")
+	}
+
+	kc.users = nil
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.pendingChanges[r.realm]) > 0 {
+		t.Fatalf("expected no changes held back, got %v", r.pendingChanges[r.realm])
+	}
+	if len(kc.pruned) != 1 || kc.pruned[0] != "id-old@corp.com" {
+		t.Fatalf("pruned %v, want [id-old@corp.com]", kc.pruned)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/keycloak"
+	"kegos/internal/metrics"
+)
+
+// deletionLimit caps the membership deletions applied in a single cycle, either as an absolute count
+// or as a percentage of the managed memberships found when the cycle started. The zero value sets no cap
+type deletionLimit struct {
+	count   int
+	percent float64
+}
+
+// parseDeletionLimit reads a limit written as a count, such as 50, or as a percentage, such as 10%.
+// Empty and zero values disable the limit
+func parseDeletionLimit(raw string) (limit deletionLimit, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return limit, nil
+	}
+
+	if percent, isPercent := strings.CutSuffix(raw, "%"); isPercent {
+		limit.percent, err = strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || limit.percent < 0 || limit.percent > 100 {
+			return deletionLimit{}, fmt.Errorf("invalid max deletions per cycle %q: percentage must be between 0 and 100", raw)
+		}
+		return limit, nil
+	}
+
+	limit.count, err = strconv.Atoi(raw)
+	if err != nil || limit.count < 0 {
+		return deletionLimit{}, fmt.Errorf("invalid max deletions per cycle %q: must be a non-negative count or a percentage", raw)
+	}
+	return limit, nil
+}
+
+// enabled reports whether the limit caps anything
+func (l deletionLimit) enabled() bool {
+	return l.count > 0 || l.percent > 0
+}
+
+// exceeded reports whether deleting that many of the existing memberships goes over the limit
+func (l deletionLimit) exceeded(deletions, memberships int) bool {
+	if l.count > 0 && deletions > l.count {
+		return true
+	}
+	if l.percent > 0 && float64(deletions) > float64(memberships)*l.percent/100 {
+		return true
+	}
+	return false
+}
+
+func (l deletionLimit) String() string {
+	if l.percent > 0 {
+		return strconv.FormatFloat(l.percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(l.count)
+}
+
+// pendingDeletions are the memberships of a user to remove once every user has been compared
+type pendingDeletions struct {
+	username   string
+	changes    []keycloak.MembershipChange
+	groupNames []string
+}
+
+// countManagedMemberships counts the memberships users hold in synced groups the runner is allowed to change
+func (r *Runner) countManagedMemberships(kcUsersGroupsMap map[string]KeycloakUserGroups, kcChildrenGroupsByID map[string]*gocloak.Group) (count int) {
+	for _, kcUserGroups := range kcUsersGroupsMap {
+		for groupID := range kcUserGroups.Groups {
+			managedGroup, found := kcChildrenGroupsByID[groupID]
+			if found && isManaged(managedGroup) && r.groupFilter.allows(sourceGroupOf(managedGroup)) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// applyDeletions removes the pending memberships, unless there are more than the limit allows.
+// Then none is applied, as such a wave is more likely a Gsuite outage than a real change. A single user
+// can not make such a wave, so cycles scoped to one are never held back. It reports whether the deletions were
+func (r *Runner) applyDeletions(pending []pendingDeletions, deletions, memberships int) (blocked bool) {
+	if r.scopedUser == nil && r.deletionLimit.exceeded(deletions, memberships) {
+		if r.dryRun {
+			r.appCtx.Logger.Error("dry-run: too many membership deletions for a single cycle. Deletions would be skipped",
+				"deletions", deletions, "managed_memberships", memberships, "max_deletions", r.deletionLimit.String())
+			return true
+		}
+
+		r.appCtx.Logger.Error("too many membership deletions for a single cycle. Skipping every deletion...",
+			"deletions", deletions, "managed_memberships", memberships, "max_deletions", r.deletionLimit.String())
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "remove memberships",
+			Err: fmt.Errorf("%d deletions exceed the limit of %s per cycle", deletions, r.deletionLimit)})
+		return true
+	}
+
+	for _, userDeletions := range pending {
+		r.applyMembershipChanges(userDeletions.username, userDeletions.changes, userDeletions.groupNames)
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Limits are written either as a count or as a percentage, and empty or zero ones cap nothing.
+func TestParseDeletionLimit(t *testing.T) {
+	tests := map[string]struct {
+		raw     string
+		want    deletionLimit
+		wantErr bool
+	}{
+		"empty disables":         {raw: "", want: deletionLimit{}},
+		"zero disables":          {raw: "0", want: deletionLimit{}},
+		"absolute count":         {raw: "50", want: deletionLimit{count: 50}},
+		"percentage":             {raw: "10%", want: deletionLimit{percent: 10}},
+		"fractional percentage":  {raw: "2.5%", want: deletionLimit{percent: 2.5}},
+		"negative count":         {raw: "-1", wantErr: true},
+		"percentage over 100":    {raw: "150%", wantErr: true},
+		"not a number":           {raw: "many", wantErr: true},
+		"not a number with sign": {raw: "many%", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseDeletionLimit(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", tc.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// The limit is only exceeded when strictly going over it.
+func TestDeletionLimitExceeded(t *testing.T) {
+	tests := map[string]struct {
+		limit       deletionLimit
+		deletions   int
+		memberships int
+		want        bool
+	}{
+		"disabled never trips":     {limit: deletionLimit{}, deletions: 1000, memberships: 1000, want: false},
+		"count below limit":        {limit: deletionLimit{count: 5}, deletions: 4, memberships: 100, want: false},
+		"count on the limit":       {limit: deletionLimit{count: 5}, deletions: 5, memberships: 100, want: false},
+		"count over limit":         {limit: deletionLimit{count: 5}, deletions: 6, memberships: 100, want: true},
+		"percentage on the limit":  {limit: deletionLimit{percent: 10}, deletions: 10, memberships: 100, want: false},
+		"percentage over limit":    {limit: deletionLimit{percent: 10}, deletions: 11, memberships: 100, want: true},
+		"percentage without peers": {limit: deletionLimit{percent: 10}, deletions: 0, memberships: 0, want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.limit.exceeded(tc.deletions, tc.memberships); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// newFakeRealmWithTwoStaleMembers returns the fake realm with bob also holding the stale managed group,
+// so a cycle plans two deletions out of two managed memberships.
+func newFakeRealmWithTwoStaleMembers() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc, gs := newFakeRealm()
+	kc.users = append(kc.users,
+		&gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+	kc.userGroups["bob-id"] = []*gocloak.Group{
+		{ID: gocloak.StringP("id-old@corp.com"), Name: gocloak.StringP("old@corp.com"), Path: gocloak.StringP("/google-workspace/old@corp.com")},
+	}
+	return kc, gs
+}
+
+// Deletions must all be held back when they go over the limit, and applied as usual otherwise.
+func TestReconcileUserGroupsGuardsDeletions(t *testing.T) {
+	tests := map[string]struct {
+		limit         deletionLimit
+		wantDeletions []string
+		wantTripped   bool
+	}{
+		"no limit applies deletions":            {limit: deletionLimit{}, wantDeletions: []string{"alice-id:id-old@corp.com", "bob-id:id-old@corp.com"}},
+		"count within limit applies":            {limit: deletionLimit{count: 2}, wantDeletions: []string{"alice-id:id-old@corp.com", "bob-id:id-old@corp.com"}},
+		"percentage within limit applies":       {limit: deletionLimit{percent: 100}, wantDeletions: []string{"alice-id:id-old@corp.com", "bob-id:id-old@corp.com"}},
+		"count over limit skips deletions":      {limit: deletionLimit{count: 1}, wantTripped: true},
+		"percentage over limit skips deletions": {limit: deletionLimit{percent: 50}, wantTripped: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealmWithTwoStaleMembers()
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			r.deletionLimit = tc.limit
+			r.pruneGroups = true
+
+			err := r.reconcileUserGroups()
+
+			slices.Sort(kc.deletions)
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+
+			// Additions are never held back
+			if want := []string{"alice-id:id-new@corp.com", "bob-id:id-new@corp.com"}; !reflect.DeepEqual(slices.Sorted(slices.Values(kc.additions)), want) {
+				t.Fatalf("additions %v, want %v", kc.additions, want)
+			}
+
+			tripped := strings.Contains(logs.String(), "too many membership deletions")
+			if tripped != tc.wantTripped {
+				t.Fatalf("guard tripped %v, want %v; logs:\n%s", tripped, tc.wantTripped, logs.String())
+			}
+
+			var cycleErr *CycleError
+			if tc.wantTripped {
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("expected a cycle error, got %v", err)
+				}
+				if len(kc.pruned) > 0 {
+					t.Fatalf("expected no pruning while deletions are held back, got %v", kc.pruned)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// A dry-run must warn that the guard would trip without recording a failure.
+func TestReconcileUserGroupsDryRunReportsGuardedDeletions(t *testing.T) {
+	kc, gs := newFakeRealmWithTwoStaleMembers()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+	r.deletionLimit = deletionLimit{count: 1}
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "dry-run: too many membership deletions") {
+		t.Fatalf("expected the guard to be reported, logs:\n%s", logs.String())
+	}
+}
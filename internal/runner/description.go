@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	//
+	"kegos/internal/gsuite"
+	"kegos/internal/metrics"
+)
+
+const (
+	// GroupAttributeDescription holds the description of the Gsuite group a synced group was created from,
+	// as Keycloak groups have no description of their own
+	GroupAttributeDescription = "description"
+)
+
+// gsuiteGroupDescription returns the description of a Gsuite group. The groups of every domain are listed
+// the first time a description is needed in a cycle. A failed listing only costs the descriptions
+func (r *Runner) gsuiteGroupDescription(gsuiteGroup string) string {
+	if r.gsuiteGroupDescriptions == nil {
+		r.gsuiteGroupDescriptions = map[string]string{}
+
+		for _, domain := range r.gsuiteDomains {
+			var groups []gsuite.Group
+			err := r.withRetry(func() (err error) {
+				groups, err = r.gsuiteCli.GetAllGroupsDetailed(domain)
+				return err
+			})
+			if err != nil {
+				r.appCtx.Logger.Warn("failed getting groups metadata from Gsuite. Creating groups without description...",
+					"domain", domain, "error", err.Error())
+				metrics.Errors.WithLabelValues(metrics.StageGsuite).Inc()
+				continue
+			}
+
+			for _, group := range groups {
+				r.gsuiteGroupDescriptions[groupIdentity(group.Email)] = group.Description
+			}
+		}
+	}
+
+	return r.gsuiteGroupDescriptions[groupIdentity(gsuiteGroup)]
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	//
+	"kegos/internal/gsuite"
+)
+
+// Groups created for a Gsuite group must carry its description only when asked to, and a failed
+// metadata listing must cost the description but never the group.
+func TestReconcileUserGroupsCopiesDescription(t *testing.T) {
+	tests := map[string]struct {
+		copyDescription bool
+		metadataErr     error
+		wantDescription []string
+	}{
+		"description copied":            {copyDescription: true, wantDescription: []string{"The new team"}},
+		"description left out":          {copyDescription: false},
+		"failed listing keeps creating": {copyDescription: true, metadataErr: errors.New("api unavailable")},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			gs.detailsByDomain = map[string][]gsuite.Group{
+				"corp.com": {{Id: "01new", Email: "New@corp.com", Name: "New", Description: "The new team"}},
+			}
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.copyGroupDescription = tc.copyDescription
+			if tc.metadataErr != nil {
+				r.gsuiteCli = &failingDetailsClient{fakeGsuiteClient: gs, err: tc.metadataErr}
+			}
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(kc.createdGroups) != 1 {
+				t.Fatalf("created groups %v, want new@corp.com only", kc.created)
+			}
+			got := (*kc.createdGroups[0].Attributes)[GroupAttributeDescription]
+			if !reflect.DeepEqual(got, tc.wantDescription) {
+				t.Fatalf("got description %v, want %v", got, tc.wantDescription)
+			}
+		})
+	}
+}
+
+// Realm roles have a description of their own, which must be set from the Gsuite group.
+func TestReconcileUserRolesCopiesDescription(t *testing.T) {
+	kc, gs := newFakeRoleRealm()
+	gs.detailsByDomain = map[string][]gsuite.Group{
+		"corp.com": {{Email: "new@corp.com", Description: "The new team"}},
+	}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.copyGroupDescription = true
+
+	if err := r.reconcileUserRoles(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.createdRoles) != 1 || kc.createdRoles[0].Description == nil || *kc.createdRoles[0].Description != "The new team" {
+		t.Fatalf("created roles %+v, want new@corp.com described as the Gsuite group", kc.createdRoles)
+	}
+}
+
+// failingDetailsClient fails group metadata listings only.
+type failingDetailsClient struct {
+	*fakeGsuiteClient
+	err error
+}
+
+func (f *failingDetailsClient) GetAllGroupsDetailed(_ string) ([]gsuite.Group, error) {
+	return nil, f.err
+}
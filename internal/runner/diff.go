@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+// UserDiff lists the synced groups a Keycloak user has to join and leave to match Gsuite
+type UserDiff struct {
+	Realm  string   `json:"realm"`
+	User   string   `json:"user"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// Diff compares the synced groups of every Keycloak user with their Gsuite groups without writing
+// anything to Keycloak. Only users with drift are reported, sorted by realm and username. Users that could not
+// be looked up are left out of the report and counted in the returned error
+func (r *Runner) Diff() (diffs []UserDiff, err error) {
+	diffs = []UserDiff{}
+	defer r.startCycle()()
+
+	err = r.ensureGsuiteToken()
+	if err != nil {
+		return diffs, err
+	}
+
+	err = r.forEachRealm(func(realm string) error {
+		realmDiffs, err := r.diffRealm()
+		for _, userDiff := range realmDiffs {
+			userDiff.Realm = realm
+			diffs = append(diffs, userDiff)
+		}
+		return err
+	})
+
+	return diffs, err
+}
+
+// diffRealm compares the users of the realm the runner currently points to
+func (r *Runner) diffRealm() (diffs []UserDiff, err error) {
+
+	r.cycleFailures = nil
+	r.caps.reset()
+	r.gsuiteParentGroups = nil
+	diffs = []UserDiff{}
+	defer r.logPartialSync()
+
+	err = r.keycloak.EnsureToken()
+	if err != nil {
+		metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+		return nil, withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
+	}
+
+	// 1. Retrieve Keycloak groups. A missing parent simply has no children yet
+	kcChildrenGroups, err := r.findSyncedChildrenGroups()
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get synced groups", Err: err})
+		return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting groups from Keycloak: %w", err))
+	}
+
+	kcChildrenGroupsByID := map[string]*gocloak.Group{}
+	for _, kcGroup := range kcChildrenGroups {
+		kcChildrenGroupsByID[*kcGroup.ID] = kcGroup
+	}
+
+	// 2. Get users groups in a map like: username->{userProfile, userGroups}
+	kcUsersGroupsMap, err := r.getKeycloakUsersGroups()
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get users groups", Err: err})
+		return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting users groups from Keycloak: %w", err))
+	}
+
+	// 3. Optionally resolve the whole Gsuite membership upfront instead of querying per user
+	var gsuiteMemberships map[string][]string
+	if r.gsuitePrefetch {
+		gsuiteMemberships, err = r.prefetchGsuiteMemberships()
+		if err != nil {
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "prefetch memberships", Err: err})
+			return nil, withStage(ErrGsuite, fmt.Errorf("failed prefetching groups from Gsuite: %w", err))
+		}
+	}
+
+	// 4. Compare every user, in a stable order so reports can be diffed between runs
+	for _, kcUsername := range slices.Sorted(maps.Keys(kcUsersGroupsMap)) {
+		kcUserGroups := kcUsersGroupsMap[kcUsername]
+
+		userKey := r.getUserMatchKey(kcUserGroups.User)
+		if userKey == "" {
+			r.appCtx.Logger.Warn("user has no value for the match attribute. Ignoring user...",
+				"user", kcUsername, "attribute", r.userMatchAttribute)
+			continue
+		}
+
+		userDelay := r.userDelay
+		if r.gsuitePrefetch {
+			userDelay = 0
+		}
+		if !r.sleep(userDelay) {
+			return nil, r.appCtx.Context.Err()
+		}
+
+		gsuiteGroups, err := r.getGsuiteGroups(userKey, gsuiteMemberships)
+		if err != nil {
+			r.appCtx.Logger.Error("failed getting groups from Gsuite. Ignoring user...", "user", kcUsername, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "get user groups", User: kcUsername, Err: err})
+			continue
+		}
+		gsuiteGroups = r.routedGroups(r.groupFilter.filter(gsuiteGroups))
+		slices.Sort(gsuiteGroups)
+		gsuiteGroups = r.caps.capGroups(gsuiteGroups)
+
+		userDiff := r.diffUserGroups(kcUserGroups, gsuiteGroups, kcChildrenGroups, kcChildrenGroupsByID)
+		if len(userDiff.Add)+len(userDiff.Remove) == 0 {
+			continue
+		}
+		userDiff.User = kcUsername
+		diffs = append(diffs, userDiff)
+	}
+
+	if len(r.cycleFailures) > 0 {
+		return diffs, fmt.Errorf("%d operations failed during diff", len(r.cycleFailures))
+	}
+	return diffs, nil
+}
+
+// diffUserGroups returns the names of the groups a user would be added to and removed from by a reconcile.
+// Groups that would be created are remembered in kcChildrenGroups, without ID, so they are named consistently
+// and collisions are detected across users as a reconcile would
+func (r *Runner) diffUserGroups(kcUserGroups KeycloakUserGroups, gsuiteGroups []string,
+	kcChildrenGroups, kcChildrenGroupsByID map[string]*gocloak.Group) (userDiff UserDiff) {
+
+	userDiff = UserDiff{Add: []string{}, Remove: []string{}}
+	desiredGroups := r.resolveGroupNames(gsuiteGroups, kcChildrenGroups)
+
+	// Managed groups the user is in, but not in Gsuite
+	for _, kcUserGroup := range kcUserGroups.Groups {
+		managedGroup, found := kcChildrenGroupsByID[*kcUserGroup.ID]
+		if !found || !isManaged(managedGroup) || !r.groupFilter.allows(sourceGroupOf(managedGroup)) ||
+			!r.caps.admitsGroup(sourceGroupOf(managedGroup)) {
+			continue
+		}
+
+		if _, desired := desiredGroups[identityOf(managedGroup)]; !desired {
+			userDiff.Remove = append(userDiff.Remove, r.hierarchyName(managedGroup))
+		}
+	}
+
+	// Gsuite groups the user is not in yet, existing or not
+	for _, gsuiteGroup := range gsuiteGroups {
+		// Groups that can not be named were reported while resolving names, and are only joined when they exist
+		targets, _ := r.groupTargets(gsuiteGroup)
+		for _, target := range targets {
+			if desiredGroups[target.identity] != gsuiteGroup {
+				continue
+			}
+
+			kcGroup, found := kcChildrenGroups[target.identity]
+			if !found {
+				kcGroup = r.newSyncedGroup(gsuiteGroup, target)
+				kcChildrenGroups[target.identity] = kcGroup
+			}
+
+			if kcGroup.ID != nil {
+				if _, isMember := kcUserGroups.Groups[*kcGroup.ID]; isMember {
+					continue
+				}
+			}
+			userDiff.Add = append(userDiff.Add, r.hierarchyName(kcGroup))
+		}
+	}
+
+	slices.Sort(userDiff.Add)
+	slices.Sort(userDiff.Remove)
+	return userDiff
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Diff must report the groups each user would join and leave without writing anything to Keycloak.
+func TestDiffReportsDriftWithoutMutations(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users = append(kc.users,
+		&gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []UserDiff{
+		{Realm: "test", User: "alice@corp.com", Add: []string{"new@corp.com"}, Remove: []string{"old@corp.com"}},
+		{Realm: "test", User: "bob@corp.com", Add: []string{"new@corp.com"}, Remove: []string{}},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("got %+v, want %+v", diffs, want)
+	}
+
+	if len(kc.created)+len(kc.additions)+len(kc.deletions)+len(kc.pruned)+len(kc.updated) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v, pruned %v, updated %v",
+			kc.created, kc.additions, kc.deletions, kc.pruned, kc.updated)
+	}
+}
+
+// Users already in sync are left out, and a missing parent group is never created.
+func TestDiffSkipsUsersInSync(t *testing.T) {
+	kc, _ := newFakeRealm()
+	kc.parent = nil
+	kc.userGroups = nil
+	r := newTestRunner(kc, &fakeGsuiteClient{}, &bytes.Buffer{}, false)
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("expected no drift, got %+v", diffs)
+	}
+	if len(kc.created) > 0 {
+		t.Fatalf("expected the parent group not to be created, got %v", kc.created)
+	}
+}
+
+// Users that can not be looked up in Gsuite are left out of the report and fail the diff.
+func TestDiffReportsGsuiteFailures(t *testing.T) {
+	kc, _ := newFakeRealm()
+	gs := &fakeGsuiteClient{errByDomain: map[string]error{"corp.com": errors.New("boom")}}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	diffs, err := r.Diff()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("expected no drift for users that failed, got %+v", diffs)
+	}
+}
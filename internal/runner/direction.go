@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/keycloak"
+	"kegos/internal/metrics"
+)
+
+const (
+	DirectionGoogleToKeycloak = "google-to-keycloak"
+	DirectionKeycloakToGoogle = "keycloak-to-google"
+	DirectionBidirectional    = "bidirectional"
+)
+
+// memberSet holds the lowercased keys of the users belonging to a group
+type memberSet map[string]struct{}
+
+// membershipDelta are the users to add to and remove from a synced group on each side
+type membershipDelta struct {
+	keycloakAdditions []string
+	keycloakRemovals  []string
+	gsuiteAdditions   []string
+	gsuiteRemovals    []string
+}
+
+// mergeGroupMembers compares the members of a synced group on both sides. Keycloak wins when writing to Google only.
+// Both sides win when syncing both ways: a member missing on one side was either removed there, when it was
+// there the last time both sides matched, or added on the other side. Without such a baseline, as on the first
+// cycle, both sides are merged and nobody is removed
+func mergeGroupMembers(direction string, kcMembers, gsuiteMembers, baseline memberSet) (delta membershipDelta) {
+	for member := range kcMembers {
+		if _, found := gsuiteMembers[member]; found {
+			continue
+		}
+		if _, synced := baseline[member]; synced && direction == DirectionBidirectional {
+			delta.keycloakRemovals = append(delta.keycloakRemovals, member)
+			continue
+		}
+		delta.gsuiteAdditions = append(delta.gsuiteAdditions, member)
+	}
+
+	for member := range gsuiteMembers {
+		if _, found := kcMembers[member]; found {
+			continue
+		}
+		if _, synced := baseline[member]; synced || direction == DirectionKeycloakToGoogle {
+			delta.gsuiteRemovals = append(delta.gsuiteRemovals, member)
+			continue
+		}
+		delta.keycloakAdditions = append(delta.keycloakAdditions, member)
+	}
+
+	slices.Sort(delta.keycloakAdditions)
+	slices.Sort(delta.keycloakRemovals)
+	slices.Sort(delta.gsuiteAdditions)
+	slices.Sort(delta.gsuiteRemovals)
+	return delta
+}
+
+// writesToGsuite reports whether memberships are written to Gsuite, either from Keycloak only or both ways
+func (r *Runner) writesToGsuite() bool {
+	return r.direction == DirectionKeycloakToGoogle || r.direction == DirectionBidirectional
+}
+
+// syncedMembers returns the members both sides share once the delta is applied
+func syncedMembers(kcMembers, gsuiteMembers memberSet, delta membershipDelta) memberSet {
+	synced := memberSet{}
+	for member := range kcMembers {
+		if _, found := gsuiteMembers[member]; found {
+			synced[member] = struct{}{}
+		}
+	}
+	for _, member := range slices.Concat(delta.keycloakAdditions, delta.gsuiteAdditions) {
+		synced[member] = struct{}{}
+	}
+	return synced
+}
+
+// reconcileGroupMembers runs a reconcile cycle writing memberships to Gsuite, either from Keycloak only or both ways.
+// It goes group by group over the synced groups already mirrored on both sides, never creating any. Only Gsuite
+// members matching a Keycloak user are considered, so members unknown to the realm, such as nested groups or
+// external accounts, are never removed
+func (r *Runner) reconcileGroupMembers() (err error) {
+
+	r.cycleFailures = nil
+	r.cycleStats = cycleStats{}
+	metrics.ReconcileRuns.Inc()
+	reconcileStart := r.clock.Now()
+	defer func() {
+		duration := r.clock.Now().Sub(reconcileStart)
+		metrics.ReconcileDuration.Observe(duration.Seconds())
+		r.logCycleSummary(duration, err)
+	}()
+
+	// 1. Retrieve Keycloak groups
+	_, kcChildrenGroups, err := r.getKeycloakChildrenGroups()
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get synced groups", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting groups from Keycloak: %w", err))
+	}
+	metrics.ManagedGroups.Set(float64(len(kcChildrenGroups)))
+
+	// 2. Get users groups, keyed by the address Gsuite knows each user by
+	kcUsersGroupsMap, err := r.getKeycloakUsersGroups()
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get users groups", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting users groups from Keycloak: %w", err))
+	}
+
+	kcUsersByKey := map[string]KeycloakUserGroups{}
+	for _, kcUserGroups := range kcUsersGroupsMap {
+		if userKey := r.getUserMatchKey(kcUserGroups.User); userKey != "" {
+			kcUsersByKey[strings.ToLower(userKey)] = kcUserGroups
+		}
+	}
+
+	// Members of every group the last time both sides matched, replacing the previous ones once the cycle ends
+	previousBaselines := r.memberBaselines[r.realm]
+	baselines := map[string]memberSet{}
+
+	// 3. Compare every synced group on both sides
+	for _, identity := range slices.Sorted(maps.Keys(kcChildrenGroups)) {
+		kcGroup := kcChildrenGroups[identity]
+		if !isManaged(kcGroup) || !r.groupFilter.allows(sourceGroupOf(kcGroup)) {
+			continue
+		}
+		gsuiteGroup := sourceGroupOf(kcGroup)
+
+		var gsuiteGroupMembers []string
+		err = r.withRetry(func() (err error) {
+			gsuiteGroupMembers, err = r.gsuiteCli.GetUsersFromGroup(gsuiteGroup)
+			return err
+		})
+		if err != nil {
+			r.appCtx.Logger.Error("failed getting group members from Gsuite. Ignoring group...", "group", gsuiteGroup, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "get group members", Group: gsuiteGroup, Err: err})
+			continue
+		}
+		r.readiness.MarkGsuiteAuthenticated()
+
+		kcMembers := memberSet{}
+		for userKey, kcUserGroups := range kcUsersByKey {
+			if _, member := kcUserGroups.Groups[*kcGroup.ID]; member {
+				kcMembers[userKey] = struct{}{}
+			}
+		}
+
+		gsuiteMembers := memberSet{}
+		for _, member := range gsuiteGroupMembers {
+			if _, known := kcUsersByKey[strings.ToLower(member)]; known {
+				gsuiteMembers[strings.ToLower(member)] = struct{}{}
+			}
+		}
+
+		delta := mergeGroupMembers(r.direction, kcMembers, gsuiteMembers, previousBaselines[identity])
+
+		if r.dryRun {
+			if len(delta.keycloakAdditions)+len(delta.keycloakRemovals)+len(delta.gsuiteAdditions)+len(delta.gsuiteRemovals) > 0 {
+				r.appCtx.Logger.Info("dry-run: would reconcile group members", "group", gsuiteGroup,
+					"keycloak_additions", delta.keycloakAdditions, "keycloak_deletions", delta.keycloakRemovals,
+					"gsuite_additions", delta.gsuiteAdditions, "gsuite_deletions", delta.gsuiteRemovals)
+			}
+			continue
+		}
+
+		// A group only gets a baseline when every change went through, so a failed one is never taken as removed
+		failures := len(r.cycleFailures)
+		r.applyGsuiteMemberChanges(gsuiteGroup, delta.gsuiteAdditions, delta.gsuiteRemovals)
+		r.applyKeycloakMemberChanges(kcGroup, kcUsersByKey, delta.keycloakAdditions, delta.keycloakRemovals)
+		if len(r.cycleFailures) == failures {
+			baselines[identity] = syncedMembers(kcMembers, gsuiteMembers, delta)
+		}
+	}
+
+	if r.direction == DirectionBidirectional && !r.dryRun {
+		r.memberBaselines[r.realm] = baselines
+	}
+
+	return r.cycleError()
+}
+
+// applyGsuiteMemberChanges adds and removes the members of a Gsuite group one by one, logging and counting each change
+func (r *Runner) applyGsuiteMemberChanges(gsuiteGroup string, additions, removals []string) {
+	for _, member := range additions {
+		r.appCtx.Logger.Debug("adding user to Gsuite group", "user", member, "group", gsuiteGroup)
+		err := r.withRetry(func() error {
+			return r.gsuiteCli.InsertMember(gsuiteGroup, member)
+		})
+		r.audit(AuditTargetGsuite, AuditActionAdd, member, gsuiteGroup, err)
+		if err != nil {
+			r.appCtx.Logger.Error("failed adding user to Gsuite group", "user", member, "group", gsuiteGroup, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "add gsuite member", User: member, Group: gsuiteGroup, Err: err})
+			continue
+		}
+		metrics.GsuiteMemberAdditions.Inc()
+		r.cycleStats.gsuiteMembersAdded++
+	}
+
+	for _, member := range removals {
+		r.appCtx.Logger.Debug("deleting user from Gsuite group", "user", member, "group", gsuiteGroup)
+		err := r.withRetry(func() error {
+			return r.gsuiteCli.DeleteMember(gsuiteGroup, member)
+		})
+		r.audit(AuditTargetGsuite, AuditActionRemove, member, gsuiteGroup, err)
+		if err != nil {
+			r.appCtx.Logger.Error("failed deleting user from Gsuite group", "user", member, "group", gsuiteGroup, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "remove gsuite member", User: member, Group: gsuiteGroup, Err: err})
+			continue
+		}
+		metrics.GsuiteMemberDeletions.Inc()
+		r.cycleStats.gsuiteMembersRemoved++
+	}
+}
+
+// applyKeycloakMemberChanges adds and removes the given users, by key, to and from a synced Keycloak group
+func (r *Runner) applyKeycloakMemberChanges(kcGroup *gocloak.Group, kcUsersByKey map[string]KeycloakUserGroups, additions, removals []string) {
+	for _, member := range additions {
+		kcUser := kcUsersByKey[member].User
+		r.appCtx.Logger.Debug("adding user to group", "user", *kcUser.Username, "group", *kcGroup.Name)
+		r.applyMembershipChanges(*kcUser.Username,
+			[]keycloak.MembershipChange{{UserID: *kcUser.ID, GroupID: *kcGroup.ID}}, []string{*kcGroup.Name})
+	}
+
+	for _, member := range removals {
+		kcUser := kcUsersByKey[member].User
+		r.appCtx.Logger.Debug("deleting user from group", "user", *kcUser.Username, "group", *kcGroup.Name)
+		r.applyMembershipChanges(*kcUser.Username,
+			[]keycloak.MembershipChange{{UserID: *kcUser.ID, GroupID: *kcGroup.ID, Remove: true}}, []string{*kcGroup.Name})
+	}
+}
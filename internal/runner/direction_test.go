@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newMembers returns the member set holding the given keys.
+func newMembers(members ...string) memberSet {
+	set := memberSet{}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return set
+}
+
+// Keycloak must win when writing to Google only, while both ways a baseline tells removals from additions.
+func TestMergeGroupMembers(t *testing.T) {
+	tests := map[string]struct {
+		direction string
+		keycloak  memberSet
+		gsuite    memberSet
+		baseline  memberSet
+		want      membershipDelta
+	}{
+		"keycloak to google": {
+			direction: DirectionKeycloakToGoogle,
+			keycloak:  newMembers("alice", "carol"),
+			gsuite:    newMembers("bob", "carol"),
+			want:      membershipDelta{gsuiteAdditions: []string{"alice"}, gsuiteRemovals: []string{"bob"}},
+		},
+		"keycloak to google ignores the baseline": {
+			direction: DirectionKeycloakToGoogle,
+			keycloak:  newMembers("alice", "carol"),
+			gsuite:    newMembers("bob", "carol"),
+			baseline:  newMembers("alice", "bob", "carol"),
+			want:      membershipDelta{gsuiteAdditions: []string{"alice"}, gsuiteRemovals: []string{"bob"}},
+		},
+		"bidirectional without baseline merges both sides": {
+			direction: DirectionBidirectional,
+			keycloak:  newMembers("alice", "carol"),
+			gsuite:    newMembers("bob", "carol"),
+			want:      membershipDelta{keycloakAdditions: []string{"bob"}, gsuiteAdditions: []string{"alice"}},
+		},
+		"bidirectional propagates removals": {
+			direction: DirectionBidirectional,
+			keycloak:  newMembers("alice", "carol"),
+			gsuite:    newMembers("bob", "carol"),
+			baseline:  newMembers("alice", "bob", "carol"),
+			want:      membershipDelta{keycloakRemovals: []string{"alice"}, gsuiteRemovals: []string{"bob"}},
+		},
+		"bidirectional propagates additions": {
+			direction: DirectionBidirectional,
+			keycloak:  newMembers("alice", "carol"),
+			gsuite:    newMembers("bob", "carol"),
+			baseline:  newMembers("carol"),
+			want:      membershipDelta{keycloakAdditions: []string{"bob"}, gsuiteAdditions: []string{"alice"}},
+		},
+		"in sync": {
+			direction: DirectionBidirectional,
+			keycloak:  newMembers("alice"),
+			gsuite:    newMembers("alice"),
+			baseline:  newMembers("alice", "bob"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := mergeGroupMembers(tc.direction, tc.keycloak, tc.gsuite, tc.baseline)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// newWritableRealm returns a realm where alice and carol belong to the synced dev@corp.com group, while in Gsuite
+// its members are bob, carol and an account unknown to Keycloak.
+func newWritableRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	devGroup := &gocloak.Group{ID: gocloak.StringP("id-dev@corp.com"), Name: gocloak.StringP("dev@corp.com"),
+		Path: gocloak.StringP("/google-workspace/dev@corp.com")}
+
+	kc := &fakeKeycloakClient{
+		parent: &gocloak.Group{ID: gocloak.StringP("id-parent"), Name: gocloak.StringP("google-workspace")},
+		children: []*gocloak.Group{
+			{ID: devGroup.ID, Name: devGroup.Name,
+				Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"dev@corp.com"}}},
+			{ID: gocloak.StringP("id-manual"), Name: gocloak.StringP("manual")},
+		},
+		users: []*gocloak.User{
+			{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice"), Email: gocloak.StringP("alice@corp.com")},
+			{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob"), Email: gocloak.StringP("bob@corp.com")},
+			{ID: gocloak.StringP("carol-id"), Username: gocloak.StringP("carol"), Email: gocloak.StringP("carol@corp.com")},
+		},
+		userGroups: map[string][]*gocloak.Group{
+			"alice-id": {devGroup},
+			"carol-id": {devGroup},
+		},
+	}
+	gs := &fakeGsuiteClient{membersByGroup: map[string][]string{
+		"dev@corp.com": {"bob@corp.com", "Carol@corp.com", "partner@external.com"},
+	}}
+	return kc, gs
+}
+
+// Writing to Google only must push Keycloak members of synced groups, leaving Keycloak and unknown accounts alone.
+func TestReconcileGroupMembersKeycloakToGoogle(t *testing.T) {
+	kc, gs := newWritableRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.direction = DirectionKeycloakToGoogle
+	r.memberBaselines = map[string]map[string]memberSet{}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"dev@corp.com:alice@corp.com"}; !reflect.DeepEqual(gs.insertedMembers, want) {
+		t.Fatalf("inserted %v, want %v", gs.insertedMembers, want)
+	}
+	if want := []string{"dev@corp.com:bob@corp.com"}; !reflect.DeepEqual(gs.deletedMembers, want) {
+		t.Fatalf("deleted %v, want %v", gs.deletedMembers, want)
+	}
+	if len(kc.additions)+len(kc.deletions)+len(kc.created) > 0 {
+		t.Fatalf("expected Keycloak untouched, got additions %v, deletions %v, created %v", kc.additions, kc.deletions, kc.created)
+	}
+}
+
+// Syncing both ways must merge both sides first, then carry the removals made on either side.
+func TestReconcileGroupMembersBidirectional(t *testing.T) {
+	kc, gs := newWritableRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.direction = DirectionBidirectional
+	r.memberBaselines = map[string]map[string]memberSet{}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"dev@corp.com:alice@corp.com"}; !reflect.DeepEqual(gs.insertedMembers, want) {
+		t.Fatalf("inserted %v, want %v", gs.insertedMembers, want)
+	}
+	if want := []string{"bob-id:id-dev@corp.com"}; !reflect.DeepEqual(kc.additions, want) {
+		t.Fatalf("additions %v, want %v", kc.additions, want)
+	}
+	if len(gs.deletedMembers)+len(kc.deletions) > 0 {
+		t.Fatalf("expected no removal on the first cycle, got %v and %v", gs.deletedMembers, kc.deletions)
+	}
+
+	// Bob leaves the group in Keycloak while carol leaves it in Gsuite
+	devGroup := kc.userGroups["alice-id"][0]
+	kc.userGroups = map[string][]*gocloak.Group{"alice-id": {devGroup}, "carol-id": {devGroup}}
+	gs.membersByGroup["dev@corp.com"] = []string{"alice@corp.com", "bob@corp.com"}
+	kc.additions, gs.insertedMembers = nil, nil
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"dev@corp.com:bob@corp.com"}; !reflect.DeepEqual(gs.deletedMembers, want) {
+		t.Fatalf("deleted %v, want %v", gs.deletedMembers, want)
+	}
+	if want := []string{"carol-id:id-dev@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+	if len(gs.insertedMembers)+len(kc.additions) > 0 {
+		t.Fatalf("expected no addition on the second cycle, got %v and %v", gs.insertedMembers, kc.additions)
+	}
+}
+
+// A group whose changes failed must get no baseline, so the next cycle never takes a failed addition as a removal.
+func TestReconcileGroupMembersKeepsNoBaselineOnFailure(t *testing.T) {
+	kc, gs := newWritableRealm()
+	gs.memberWriteErr = errors.New("insufficient permissions")
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.direction = DirectionBidirectional
+	r.memberBaselines = map[string]map[string]memberSet{}
+
+	var cycleErr *CycleError
+	if err := r.ReconcileOnce(); !errors.As(err, &cycleErr) {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+	if baseline, found := r.memberBaselines["test"]["dev@corp.com"]; found {
+		t.Fatalf("expected no baseline for the failed group, got %v", baseline)
+	}
+}
+
+// Dry-runs must only report the changes of each group, on both sides.
+func TestReconcileGroupMembersDryRun(t *testing.T) {
+	kc, gs := newWritableRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+	r.direction = DirectionBidirectional
+	r.memberBaselines = map[string]map[string]memberSet{}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gs.insertedMembers)+len(gs.deletedMembers)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected no change on dry-run")
+	}
+	if !strings.Contains(logs.String(), "dry-run: would reconcile group members") {
+		t.Fatalf("expected the planned changes to be reported, got logs %s", logs.String())
+	}
+	if len(r.memberBaselines) > 0 {
+		t.Fatalf("expected no baseline on dry-run, got %v", r.memberBaselines)
+	}
+}
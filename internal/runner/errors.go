@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"errors"
+	"strings"
+
+	//
+	"kegos/internal/metrics"
+)
+
+// Kinds of failure, matched with errors.Is on the errors aborting a cycle, a diff or an export, as well as on
+// every OperationFailure of a CycleError. The API error behind them is still reachable through errors.As
+var (
+	// ErrGsuite is any failed call to the Google Directory API, authentication included
+	ErrGsuite = errors.New("gsuite failure")
+
+	// ErrKeycloakAuth is a failed login to Keycloak, such as when renewing the token
+	ErrKeycloakAuth = errors.New("keycloak authentication failure")
+
+	// ErrKeycloakRead is a failed Keycloak listing or lookup, such as users or synced groups
+	ErrKeycloakRead = errors.New("keycloak read failure")
+
+	// ErrKeycloakWrite is a failed change sent to Keycloak, such as a membership or a group creation
+	ErrKeycloakWrite = errors.New("keycloak write failure")
+)
+
+// StageError tells the kind of failure of an error, keeping its message as it is
+type StageError struct {
+	// Kind is one of ErrGsuite, ErrKeycloakAuth, ErrKeycloakRead or ErrKeycloakWrite
+	Kind error
+
+	Err error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// withStage marks an error with its kind of failure. Errors already marked deeper down keep the kind they were
+// given there, as it is closer to the call that failed, such as a write failing while gathering groups.
+// A nil error stays nil
+func withStage(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		kind = stageErr.Kind
+	}
+	return &StageError{Kind: kind, Err: err}
+}
+
+// kind returns the kind of failure of the operation, worked out from the API it talked to and what it attempted
+func (f OperationFailure) kind() error {
+	var stageErr *StageError
+	switch {
+	case errors.As(f.Err, &stageErr):
+		return stageErr.Kind
+	case f.Stage == metrics.StageGsuite:
+		return ErrGsuite
+	case f.Operation == "renew token":
+		return ErrKeycloakAuth
+	case strings.HasPrefix(f.Operation, "get "):
+		return ErrKeycloakRead
+	default:
+		return ErrKeycloakWrite
+	}
+}
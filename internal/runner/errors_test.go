@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Every error a cycle returns must tell the kind of failure behind it, while keeping the API error reachable.
+func TestReconcileUserGroupsErrorKinds(t *testing.T) {
+	apiErr := errors.New("boom")
+	tests := map[string]struct {
+		tokenErr       error
+		membershipErrs map[string]error
+		gsuiteErr      error
+		wantKind       error
+	}{
+		"failed login": {
+			tokenErr: apiErr,
+			wantKind: ErrKeycloakAuth,
+		},
+		"failed membership removal": {
+			membershipErrs: map[string]error{"id-old@corp.com": apiErr},
+			wantKind:       ErrKeycloakWrite,
+		},
+		"failed gsuite lookups": {
+			gsuiteErr: apiErr,
+			wantKind:  ErrGsuite,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.tokenErr = tc.tokenErr
+			kc.membershipErrs = tc.membershipErrs
+			if tc.gsuiteErr != nil {
+				gs.errByDomain = map[string]error{"corp.com": tc.gsuiteErr}
+			}
+
+			err := newTestRunner(kc, gs, &bytes.Buffer{}, false).reconcileUserGroups()
+			if !errors.Is(err, tc.wantKind) {
+				t.Fatalf("got error %v, want kind %v", err, tc.wantKind)
+			}
+			if !errors.Is(err, apiErr) {
+				t.Fatalf("got error %v, want the API error to be reachable", err)
+			}
+			for _, kind := range []error{ErrGsuite, ErrKeycloakAuth, ErrKeycloakRead, ErrKeycloakWrite} {
+				if kind != tc.wantKind && errors.Is(err, kind) {
+					t.Fatalf("got error %v, unexpectedly of kind %v", err, kind)
+				}
+			}
+		})
+	}
+}
+
+// Kinds must be kept from the deepest call that failed, and nil errors must stay nil.
+func TestWithStage(t *testing.T) {
+	apiErr := errors.New("boom")
+	tests := map[string]struct {
+		kind     error
+		err      error
+		wantKind error
+	}{
+		"plain error": {
+			kind:     ErrKeycloakRead,
+			err:      apiErr,
+			wantKind: ErrKeycloakRead,
+		},
+		"error marked deeper down": {
+			kind:     ErrKeycloakRead,
+			err:      fmt.Errorf("failed creating group: %w", withStage(ErrKeycloakWrite, apiErr)),
+			wantKind: ErrKeycloakWrite,
+		},
+		"no error": {
+			kind: ErrGsuite,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := withStage(tc.kind, tc.err)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+				return
+			}
+
+			var stageErr *StageError
+			if !errors.As(err, &stageErr) || stageErr.Kind != tc.wantKind {
+				t.Fatalf("got error %v, want kind %v", err, tc.wantKind)
+			}
+			if err.Error() != tc.err.Error() || !errors.Is(err, apiErr) {
+				t.Fatalf("got error %q, want the original one kept", err)
+			}
+		})
+	}
+}
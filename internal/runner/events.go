@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"time"
+)
+
+// EventKind tells what a ReconcileEvent is about
+type EventKind string
+
+const (
+	EventCycleStarted      EventKind = "cycle_started"
+	EventCycleFinished     EventKind = "cycle_finished"
+	EventMembershipAdded   EventKind = "membership_added"
+	EventMembershipRemoved EventKind = "membership_removed"
+	EventGroupCreated      EventKind = "group_created"
+	EventError             EventKind = "error"
+)
+
+// ReconcileEvent is published on RunnerOptions.Events as a reconcile cycle goes, for commands of this module driving
+// the runner, as it is internal to it.
+// Fields not making sense for its kind are left empty
+type ReconcileEvent struct {
+	Kind    EventKind
+	Time    time.Time
+	CycleID string
+
+	// Realm is the realm the event happened in, empty for cycle events as cycles span every realm
+	Realm string
+
+	// Target is where a membership change was written, AuditTargetKeycloak or AuditTargetGsuite when writing back
+	Target string
+
+	// User and Group are those a membership change or a failure was about. Group holds the role with the roles target
+	User  string
+	Group string
+
+	// Operation is what failed, for error events
+	Operation string
+
+	// Err is the failure of error events, and of the cycle, if any, for finished cycles
+	Err error
+
+	// Duration is how long a finished cycle took
+	Duration time.Duration
+}
+
+// publish sends the event without ever blocking: when the consumer is not keeping up, the event is dropped
+// rather than stalling the cycle. It does nothing when no channel was given
+func (r *Runner) publish(event ReconcileEvent) {
+	if r.events == nil {
+		return
+	}
+
+	event.Time = r.clock.Now()
+	event.CycleID = r.cycleID
+
+	select {
+	case r.events <- event:
+	default:
+		r.appCtx.Logger.Debug("reconcile event dropped, as the consumer is not keeping up", "kind", string(event.Kind))
+	}
+}
+
+// publishChange publishes the change a successful audit record tells about, leaving out those with no event
+func (r *Runner) publishChange(target, action, user, group string, err error) {
+	if r.events == nil || err != nil {
+		return
+	}
+
+	var kind EventKind
+	switch action {
+	case AuditActionAdd:
+		kind = EventMembershipAdded
+	case AuditActionRemove:
+		kind = EventMembershipRemoved
+	case AuditActionCreate:
+		kind = EventGroupCreated
+	default:
+		return
+	}
+	r.publish(ReconcileEvent{Kind: kind, Realm: r.realm, Target: target, User: user, Group: group})
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// drainEvents returns every event buffered on the channel
+func drainEvents(events chan ReconcileEvent) []ReconcileEvent {
+	var got []ReconcileEvent
+	for {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		default:
+			return got
+		}
+	}
+}
+
+// A cycle must publish its start, the changes it applies, its failures and its end, all tagged with its cycle id.
+func TestReconcileOncePublishesEvents(t *testing.T) {
+	tests := map[string]struct {
+		dryRun         bool
+		membershipErrs map[string]error
+		wantKinds      []EventKind
+		wantErr        bool
+	}{
+		"changes applied": {
+			wantKinds: []EventKind{EventCycleStarted, EventGroupCreated, EventMembershipAdded,
+				EventMembershipRemoved, EventCycleFinished},
+		},
+		"failed removal": {
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			wantKinds: []EventKind{EventCycleStarted, EventGroupCreated, EventMembershipAdded,
+				EventError, EventCycleFinished},
+			wantErr: true,
+		},
+		"dry run": {
+			dryRun:    true,
+			wantKinds: []EventKind{EventCycleStarted, EventCycleFinished},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.membershipErrs = tc.membershipErrs
+
+			events := make(chan ReconcileEvent, 16)
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, tc.dryRun)
+			r.events = events
+
+			err := r.ReconcileOnce()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+
+			got := drainEvents(events)
+			var kinds []EventKind
+			for _, event := range got {
+				kinds = append(kinds, event.Kind)
+				if event.CycleID == "" || event.CycleID != got[0].CycleID {
+					t.Fatalf("got cycle id %q on %s, want %q", event.CycleID, event.Kind, got[0].CycleID)
+				}
+			}
+			if !reflect.DeepEqual(kinds, tc.wantKinds) {
+				t.Fatalf("got events %v, want %v", kinds, tc.wantKinds)
+			}
+
+			finished := got[len(got)-1]
+			if (finished.Err != nil) != tc.wantErr {
+				t.Fatalf("got cycle error %v on the finished event, want error %t", finished.Err, tc.wantErr)
+			}
+			for _, event := range got {
+				if event.Kind == EventMembershipRemoved && (event.User != "alice@corp.com" || event.Group != "old@corp.com") {
+					t.Fatalf("got removal of %s from %s, want alice@corp.com from old@corp.com", event.User, event.Group)
+				}
+				if event.Kind == EventError && event.Err == nil {
+					t.Fatalf("expected the failure on the error event")
+				}
+			}
+		})
+	}
+}
+
+// A consumer not reading must never stall the cycle, its events being dropped instead.
+func TestReconcileOnceDoesNotBlockOnEvents(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.events = make(chan ReconcileEvent)
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.additions) != 1 || len(kc.deletions) != 1 {
+		t.Fatalf("got %d additions and %d deletions, want 1 of each", len(kc.additions), len(kc.deletions))
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+// GroupMembers lists the Keycloak users in a group managed by kegos
+type GroupMembers struct {
+	Realm       string   `json:"realm"`
+	Group       string   `json:"group"`
+	SourceGroup string   `json:"source_group"`
+	Members     []string `json:"members"`
+}
+
+// ExportManagedState lists every group managed by kegos under the synced parent group, nested ones included and named
+// with their levels, with the usernames of its
+// Keycloak members, without writing anything. Groups are sorted by realm and name, and members by username.
+// Realms failing are left out of the snapshot and reported in the returned error
+func (r *Runner) ExportManagedState() (groups []GroupMembers, err error) {
+	groups = []GroupMembers{}
+	defer r.startCycle()()
+
+	err = r.forEachRealm(func(realm string) error {
+		realmGroups, err := r.exportRealm()
+		for _, group := range realmGroups {
+			group.Realm = realm
+			groups = append(groups, group)
+		}
+		return err
+	})
+
+	return groups, err
+}
+
+// exportRealm lists the managed groups of the realm the runner currently points to
+func (r *Runner) exportRealm() (groups []GroupMembers, err error) {
+
+	err = r.keycloak.EnsureToken()
+	if err != nil {
+		metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+		return nil, withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
+	}
+
+	// A missing parent simply has no children yet, and is never created here
+	kcChildrenGroups, err := r.findSyncedChildrenGroups()
+	if err != nil {
+		metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+		return nil, err
+	}
+
+	for _, kcGroup := range kcChildrenGroups {
+		if kcGroup.ID == nil || kcGroup.Name == nil || !isManaged(kcGroup) {
+			continue
+		}
+
+		kcMembers, err := r.keycloak.GetGroupMembers(r.keycloak.GetToken().AccessToken, *kcGroup.ID)
+		if err != nil {
+			metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+			return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting members of group %s: %w", *kcGroup.Name, err))
+		}
+
+		members := []string{}
+		for _, kcMember := range kcMembers {
+			members = append(members, gocloak.PString(kcMember.Username))
+		}
+		slices.Sort(members)
+
+		groups = append(groups, GroupMembers{
+			Group:       r.hierarchyName(kcGroup),
+			SourceGroup: sourceGroupOf(kcGroup),
+			Members:     members,
+		})
+	}
+
+	slices.SortFunc(groups, func(a, b GroupMembers) int {
+		return strings.Compare(a.Group, b.Group)
+	})
+	return groups, nil
+}
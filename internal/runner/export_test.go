@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Every managed group must be exported with its members, leaving groups kegos does not own out.
+func TestExportManagedState(t *testing.T) {
+	tests := map[string]struct {
+		parent *gocloak.Group
+		want   []GroupMembers
+	}{
+		"managed groups": {
+			parent: &gocloak.Group{ID: gocloak.StringP("id-parent"), Name: gocloak.StringP("google-workspace")},
+			want: []GroupMembers{
+				{Realm: "test", Group: "eng@corp.com", SourceGroup: "eng@corp.com", Members: []string{"alice@corp.com", "bob@corp.com"}},
+				{Realm: "test", Group: "old@corp.com", SourceGroup: "old@corp.com", Members: []string{"alice@corp.com"}},
+				{Realm: "test", Group: "ops", SourceGroup: "ops@corp.com", Members: []string{}},
+			},
+		},
+		"missing parent": {
+			want: []GroupMembers{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.parent = tc.parent
+			kc.children = append(kc.children,
+				&gocloak.Group{ID: gocloak.StringP("id-ops"), Name: gocloak.StringP("ops"),
+					Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"ops@corp.com"}}},
+				&gocloak.Group{ID: gocloak.StringP("id-eng"), Name: gocloak.StringP("eng@corp.com"),
+					Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}},
+				&gocloak.Group{ID: gocloak.StringP("id-handmade"), Name: gocloak.StringP("handmade")},
+			)
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob@corp.com")})
+			kc.userGroups["alice-id"] = append(kc.userGroups["alice-id"], &gocloak.Group{ID: gocloak.StringP("id-eng")})
+			kc.userGroups["bob-id"] = []*gocloak.Group{{ID: gocloak.StringP("id-eng")}, {ID: gocloak.StringP("id-handmade")}}
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+			got, err := r.ExportManagedState()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+			if len(kc.created)+len(kc.createdParents) > 0 {
+				t.Fatalf("expected nothing to be created, got %v and parents %v", kc.created, kc.createdParents)
+			}
+		})
+	}
+}
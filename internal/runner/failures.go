@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	//
+	"kegos/internal/metrics"
+)
+
+const (
+	// failureSampleSize is how many failures are detailed in the report closing a cycle
+	failureSampleSize = 5
+)
+
+// OperationFailure is a single operation that failed during a reconcile cycle
+type OperationFailure struct {
+	// Stage is the API the operation talked to, as labelled in metrics
+	Stage string
+
+	// Operation names what was attempted, such as "add membership"
+	Operation string
+
+	// User and Group identify what the operation was about. Either may be empty
+	User  string
+	Group string
+
+	Err error
+}
+
+func (f OperationFailure) Error() string {
+	var subject []string
+	if f.User != "" {
+		subject = append(subject, "user "+f.User)
+	}
+	if f.Group != "" {
+		subject = append(subject, "group "+f.Group)
+	}
+
+	if len(subject) == 0 {
+		return fmt.Sprintf("%s: %v", f.Operation, f.Err)
+	}
+	return fmt.Sprintf("%s (%s): %v", f.Operation, strings.Join(subject, ", "), f.Err)
+}
+
+func (f OperationFailure) Unwrap() []error {
+	return []error{f.Err, f.kind()}
+}
+
+// CycleError aggregates every operation that failed during a reconcile cycle which still ran to the end
+type CycleError struct {
+	Failures []OperationFailure
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("%d operations failed during reconcile", len(e.Failures))
+}
+
+func (e *CycleError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// recordError accounts a failed operation both in metrics and in the current cycle
+func (r *Runner) recordError(failure OperationFailure) {
+	metrics.Errors.WithLabelValues(failure.Stage).Inc()
+	r.cycleFailures = append(r.cycleFailures, failure)
+	r.publish(ReconcileEvent{Kind: EventError, Realm: r.realm, User: failure.User, Group: failure.Group,
+		Operation: failure.Operation, Err: failure.Err})
+}
+
+// abortedError marks the error of a cycle aborted in some realm, keeping its message. The failures of the realms
+// that ran to the end may still be found in it, so it must be checked before looking for a *CycleError
+type abortedError struct {
+	err error
+}
+
+func (e *abortedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *abortedError) Unwrap() error {
+	return e.err
+}
+
+// CycleAborted reports whether a cycle ending with err could not run to the end, in any of its realms.
+// Cycles that did, with some failed operations, end with a *CycleError instead
+func CycleAborted(err error) bool {
+	var aborted *abortedError
+	if errors.As(err, &aborted) {
+		return true
+	}
+	var cycleErr *CycleError
+	return err != nil && !errors.As(err, &cycleErr)
+}
+
+// CycleFailed reports whether a cycle ending with err counts as failed. Aborted cycles always do, while
+// those that ran to the end with some failed operations only do with FailOnPartial
+func (r *Runner) CycleFailed(err error) bool {
+	if CycleAborted(err) {
+		return true
+	}
+	return err != nil && r.failOnPartial
+}
+
+// cycleError returns the failures of the current cycle as an error, or nil when there were none
+func (r *Runner) cycleError() error {
+	if len(r.cycleFailures) == 0 {
+		return nil
+	}
+	return &CycleError{Failures: slices.Clone(r.cycleFailures)}
+}
+
+// logFailureReport emits a single error line counting the failures of the current cycle
+// by operation, along with the first few of them
+func (r *Runner) logFailureReport() {
+	if len(r.cycleFailures) == 0 {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, failure := range r.cycleFailures {
+		counts[failure.Operation]++
+	}
+
+	var sample []string
+	for _, failure := range r.cycleFailures[:min(len(r.cycleFailures), failureSampleSize)] {
+		sample = append(sample, failure.Error())
+	}
+
+	r.appCtx.Logger.Error("reconcile cycle failures",
+		"errors", len(r.cycleFailures), "by_operation", counts, "sample", sample)
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+// Failed operations must be collected with their context, returned as an aggregate and reported once.
+func TestReconcileUserGroupsAggregatesFailures(t *testing.T) {
+	tests := map[string]struct {
+		membershipErrs map[string]error
+		gsuiteErr      error
+		wantFailures   []OperationFailure
+	}{
+		"clean cycle": {},
+		"failed membership removal": {
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			wantFailures: []OperationFailure{
+				{Stage: metrics.StageKeycloak, Operation: "remove membership", User: "alice@corp.com", Group: "old@corp.com"},
+			},
+		},
+		"failed membership addition and removal": {
+			membershipErrs: map[string]error{
+				"id-old@corp.com": errors.New("forbidden"),
+				"id-new@corp.com": errors.New("forbidden"),
+			},
+			wantFailures: []OperationFailure{
+				{Stage: metrics.StageKeycloak, Operation: "remove membership", User: "alice@corp.com", Group: "old@corp.com"},
+				{Stage: metrics.StageKeycloak, Operation: "add membership", User: "bob@corp.com", Group: "new@corp.com"},
+			},
+		},
+		"failed gsuite lookups": {
+			gsuiteErr: errors.New("api unavailable"),
+			wantFailures: []OperationFailure{
+				{Stage: metrics.StageGsuite, Operation: "get user groups", User: "alice@corp.com"},
+				{Stage: metrics.StageGsuite, Operation: "get user groups", User: "bob@corp.com"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.children = append(kc.children, &gocloak.Group{ID: gocloak.StringP("id-new@corp.com"),
+				Name: gocloak.StringP("new@corp.com"), Attributes: withProvenance(nil, "new@corp.com", time.Now())})
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"),
+				Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+			kc.userGroups["bob-id"] = nil
+			kc.membershipErrs = tc.membershipErrs
+
+			// Alice is already in the new group, so only Bob gets added to it
+			kc.userGroups["alice-id"] = append(kc.userGroups["alice-id"],
+				&gocloak.Group{ID: gocloak.StringP("id-new@corp.com"), Name: gocloak.StringP("new@corp.com")})
+			if tc.gsuiteErr != nil {
+				gs.errByDomain = map[string]error{"corp.com": tc.gsuiteErr}
+			}
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			err := r.reconcileUserGroups()
+
+			if len(tc.wantFailures) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if strings.Contains(logs.String(), "reconcile cycle failures") {
+					t.Fatalf("unexpected failure report in logs:\n%s", logs.String())
+				}
+				return
+			}
+
+			var cycleErr *CycleError
+			if !errors.As(err, &cycleErr) {
+				t.Fatalf("got error %v, want a *CycleError", err)
+			}
+
+			// Users are reconciled in no particular order
+			slices.SortFunc(cycleErr.Failures, func(a, b OperationFailure) int {
+				return strings.Compare(a.User+a.Operation, b.User+b.Operation)
+			})
+			if len(cycleErr.Failures) != len(tc.wantFailures) {
+				t.Fatalf("got failures %v, want %d", cycleErr.Failures, len(tc.wantFailures))
+			}
+			for i, want := range tc.wantFailures {
+				got := cycleErr.Failures[i]
+				if got.Stage != want.Stage || got.Operation != want.Operation || got.User != want.User || got.Group != want.Group {
+					t.Errorf("failure %d = %+v, want %+v", i, got, want)
+				}
+				if got.Err == nil {
+					t.Errorf("failure %d carries no error", i)
+				}
+			}
+
+			report := findLogLine(t, logs, "reconcile cycle failures")
+			if report["level"] != "ERROR" {
+				t.Errorf("report level = %v, want ERROR", report["level"])
+			}
+			if report["errors"] != float64(len(tc.wantFailures)) {
+				t.Errorf("report errors = %v, want %d", report["errors"], len(tc.wantFailures))
+			}
+			if sample, _ := report["sample"].([]any); len(sample) != len(tc.wantFailures) {
+				t.Errorf("report sample = %v, want %d entries", report["sample"], len(tc.wantFailures))
+			}
+		})
+	}
+}
+
+// The failure report must count every failure but only detail a sample of them.
+func TestLogFailureReportSamplesFailures(t *testing.T) {
+	logs := &bytes.Buffer{}
+	r := &Runner{appCtx: newTestAppCtx(logs)}
+	for range failureSampleSize + 3 {
+		r.cycleFailures = append(r.cycleFailures, OperationFailure{Operation: "add membership", Err: errors.New("boom")})
+	}
+	r.cycleFailures = append(r.cycleFailures, OperationFailure{Operation: "prune group", Err: errors.New("boom")})
+
+	r.logFailureReport()
+
+	report := findLogLine(t, logs, "reconcile cycle failures")
+	if report["errors"] != float64(failureSampleSize+4) {
+		t.Errorf("report errors = %v, want %d", report["errors"], failureSampleSize+4)
+	}
+	counts, _ := report["by_operation"].(map[string]any)
+	if counts["add membership"] != float64(failureSampleSize+3) || counts["prune group"] != float64(1) {
+		t.Errorf("report counts = %v", report["by_operation"])
+	}
+	if sample, _ := report["sample"].([]any); len(sample) != failureSampleSize {
+		t.Errorf("report sample has %d entries, want %d", len(sample), failureSampleSize)
+	}
+}
+
+// findLogLine returns the first JSON log line with the given message.
+func findLogLine(t *testing.T, logs *bytes.Buffer, msg string) map[string]any {
+	t.Helper()
+
+	for line := range strings.SplitSeq(strings.TrimSpace(logs.String()), "\n") {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	t.Fatalf("no %q line in logs:\n%s", msg, logs.String())
+	return nil
+}
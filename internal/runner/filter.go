@@ -0,0 +1,240 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// groupFilter decides which Gsuite groups are mirrored into Keycloak.
+// A group must match an include pattern, when any is set, and no exclude pattern.
+// Excludes always take precedence over includes
+type groupFilter struct {
+	includes []*regexp.Regexp
+	excludes []*regexp.Regexp
+
+	// domains restricts groups to the ones with an email in these domains, lowercased, when any is set
+	domains []string
+
+	// only restricts groups to these lowercased emails when any is set, so a cycle touches nothing else
+	only map[string]struct{}
+}
+
+// newGroupFilter compiles the include and exclude patterns, which are matched unanchored
+// against the Gsuite group email
+func newGroupFilter(includePatterns, excludePatterns []string) (filter groupFilter, err error) {
+	filter.includes, err = compilePatterns(includePatterns)
+	if err != nil {
+		return filter, fmt.Errorf("invalid group include pattern: %v", err)
+	}
+
+	filter.excludes, err = compilePatterns(excludePatterns)
+	if err != nil {
+		return filter, fmt.Errorf("invalid group exclude pattern: %v", err)
+	}
+
+	return filter, nil
+}
+
+func compilePatterns(patterns []string) (compiled []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// restrictToDomains only lets through the groups with an email in one of the given domains, so groups of
+// external domains the users belong to are never mirrored
+func (f *groupFilter) restrictToDomains(domains []string) {
+	for _, domain := range domains {
+		f.domains = append(f.domains, strings.ToLower(strings.TrimSpace(domain)))
+	}
+}
+
+// restrictToGroups only lets through the given groups, by email whatever their case, on top of the patterns
+func (f *groupFilter) restrictToGroups(groups []string) {
+	for _, group := range groups {
+		if group = strings.TrimSpace(group); group == "" {
+			continue
+		}
+		if f.only == nil {
+			f.only = map[string]struct{}{}
+		}
+		f.only[strings.ToLower(group)] = struct{}{}
+	}
+}
+
+// allows reports whether the group passes the filter
+func (f groupFilter) allows(group string) bool {
+	if len(f.only) > 0 {
+		if _, found := f.only[strings.ToLower(group)]; !found {
+			return false
+		}
+	}
+
+	if len(f.domains) > 0 {
+		at := strings.LastIndex(group, "@")
+		if at < 0 || !slices.Contains(f.domains, strings.ToLower(group[at+1:])) {
+			return false
+		}
+	}
+
+	for _, re := range f.excludes {
+		if re.MatchString(group) {
+			return false
+		}
+	}
+
+	if len(f.includes) == 0 {
+		return true
+	}
+
+	for _, re := range f.includes {
+		if re.MatchString(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the groups that pass the filter, preserving their order
+func (f groupFilter) filter(groups []string) (allowed []string) {
+	for _, group := range groups {
+		if f.allows(group) {
+			allowed = append(allowed, group)
+		}
+	}
+	return allowed
+}
+
+// userFilter decides which Keycloak users are reconciled. A user must pass every configured condition
+type userFilter struct {
+	enabledOnly  bool
+	requireEmail bool
+
+	// attributes holds the value each attribute must have, among any others it has
+	attributes map[string]string
+
+	// excluded holds the lowercased usernames and emails of the users never reconciled, such as break-glass accounts
+	excluded map[string]struct{}
+
+	// skipFederated leaves out the users federated from a user storage provider, such as LDAP, whose memberships
+	// may be managed upstream
+	skipFederated bool
+}
+
+// newUserFilter parses the attribute matches, written as key=value
+func newUserFilter(enabledOnly, requireEmail bool, attributeMatches []string) (filter userFilter, err error) {
+	filter = userFilter{
+		enabledOnly:  enabledOnly,
+		requireEmail: requireEmail,
+		attributes:   map[string]string{},
+	}
+
+	for _, match := range attributeMatches {
+		key, value, found := strings.Cut(match, "=")
+		if !found || key == "" {
+			return filter, fmt.Errorf("invalid user attribute match %q: expected key=value", match)
+		}
+		filter.attributes[key] = value
+	}
+
+	return filter, nil
+}
+
+// allows reports whether the user passes the filter
+func (f userFilter) allows(user *gocloak.User) bool {
+	if f.enabledOnly && (user.Enabled == nil || !*user.Enabled) {
+		return false
+	}
+
+	if f.requireEmail && (user.Email == nil || *user.Email == "") {
+		return false
+	}
+
+	if f.skipFederated && isFederated(user) {
+		return false
+	}
+
+	for key, value := range f.attributes {
+		if user.Attributes == nil || !slices.Contains((*user.Attributes)[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// enabled reports whether any condition can leave users out, so the reconciled ones are not the whole realm
+func (f userFilter) enabled() bool {
+	return f.enabledOnly || f.requireEmail || f.skipFederated || len(f.attributes) > 0 || len(f.excluded) > 0
+}
+
+// isFederated reports whether the user is linked to a user storage provider, such as LDAP or Active Directory
+func isFederated(user *gocloak.User) bool {
+	return user.FederationLink != nil && *user.FederationLink != ""
+}
+
+// filter returns the users that pass the filter, preserving their order
+func (f userFilter) filter(users []*gocloak.User) (allowed []*gocloak.User) {
+	for _, user := range users {
+		if f.allows(user) {
+			allowed = append(allowed, user)
+		}
+	}
+	return allowed
+}
+
+// exclude adds users, by username or email, to the ones never reconciled whatever their case
+func (f *userFilter) exclude(users []string) {
+	if f.excluded == nil {
+		f.excluded = map[string]struct{}{}
+	}
+	for _, user := range users {
+		if user = strings.TrimSpace(user); user != "" {
+			f.excluded[strings.ToLower(user)] = struct{}{}
+		}
+	}
+}
+
+// excludes reports whether the user is excluded by either their username or email
+func (f userFilter) excludes(user *gocloak.User) bool {
+	for _, value := range []*string{user.Username, user.Email} {
+		if value == nil {
+			continue
+		}
+		if _, found := f.excluded[strings.ToLower(*value)]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// readListFile reads a file listing one entry per line, such as usernames or group emails. Empty lines and those
+// starting with # are skipped
+func readListFile(path string) (entries []string, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
@@ -0,0 +1,425 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// A group must match an include, when any is set, and excludes must always win over includes.
+func TestGroupFilterAllows(t *testing.T) {
+	tests := map[string]struct {
+		includes []string
+		excludes []string
+		domains  []string
+		only     []string
+		group    string
+		want     bool
+	}{
+		"no patterns allow everything":       {group: "dev@corp.com", want: true},
+		"matching include is allowed":        {includes: []string{"^dev"}, group: "dev@corp.com", want: true},
+		"non matching include is rejected":   {includes: []string{"^dev"}, group: "ops@corp.com", want: false},
+		"any include is enough":              {includes: []string{"^dev", "^ops"}, group: "ops@corp.com", want: true},
+		"exclude rejects without includes":   {excludes: []string{"^announce"}, group: "announce@corp.com", want: false},
+		"exclude wins over matching include": {includes: []string{"@corp\\.com$"}, excludes: []string{"^announce"}, group: "announce@corp.com", want: false},
+		"exclude not matching keeps include": {includes: []string{"@corp\\.com$"}, excludes: []string{"^announce"}, group: "dev@corp.com", want: true},
+		"group in a restricted domain":       {domains: []string{"corp.com", "corp.org"}, group: "dev@corp.org", want: true},
+		"domain matched in any case":         {domains: []string{"Corp.com"}, group: "dev@CORP.COM", want: true},
+		"group of an external domain":        {domains: []string{"corp.com"}, group: "dev@partner.com", want: false},
+		"subdomain is external":              {domains: []string{"corp.com"}, group: "dev@eu.corp.com", want: false},
+		"group without domain":               {domains: []string{"corp.com"}, group: "dev", want: false},
+		"domain restriction keeps excludes":  {domains: []string{"corp.com"}, excludes: []string{"^announce"}, group: "announce@corp.com", want: false},
+		"named group is allowed":             {only: []string{"dev@corp.com", "ops@corp.com"}, group: "ops@corp.com", want: true},
+		"named group matched in any case":    {only: []string{" Dev@Corp.com "}, group: "dev@CORP.com", want: true},
+		"group not named is rejected":        {only: []string{"dev@corp.com"}, group: "ops@corp.com", want: false},
+		"named group keeps excludes":         {only: []string{"announce@corp.com"}, excludes: []string{"^announce"}, group: "announce@corp.com", want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := newGroupFilter(tc.includes, tc.excludes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			filter.restrictToDomains(tc.domains)
+			filter.restrictToGroups(tc.only)
+			if got := filter.allows(tc.group); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Invalid patterns must be reported instead of silently ignored.
+func TestNewGroupFilterRejectsInvalidPatterns(t *testing.T) {
+	if _, err := newGroupFilter([]string{"("}, nil); err == nil {
+		t.Fatalf("expected error for invalid include pattern")
+	}
+	if _, err := newGroupFilter(nil, []string{"["}); err == nil {
+		t.Fatalf("expected error for invalid exclude pattern")
+	}
+}
+
+// Filtered-out groups must be neither added nor removed, so memberships never thrash.
+func TestReconcileUserGroupsLeavesFilteredGroupsAlone(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	filter, err := newGroupFilter(nil, []string{"^old@", "^new@"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.groupFilter = filter
+	r.pruneGroups = true
+
+	r.reconcileUserGroups()
+
+	if len(kc.created)+len(kc.additions)+len(kc.deletions)+len(kc.pruned) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v, pruned %v",
+			kc.created, kc.additions, kc.deletions, kc.pruned)
+	}
+}
+
+// Groups of external domains a user belongs to must be neither mirrored nor, once mirrored, removed.
+func TestReconcileUserGroupsRestrictedToDomain(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.children = append(kc.children, &gocloak.Group{ID: gocloak.StringP("id-ext@partner.com"), Name: gocloak.StringP("ext@partner.com"),
+		Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}})
+	kc.userGroups["alice-id"] = append(kc.userGroups["alice-id"], &gocloak.Group{ID: gocloak.StringP("id-ext@partner.com"), Name: gocloak.StringP("ext@partner.com")})
+	gs.groupsByDomain["corp.com"] = []string{"new@corp.com", "shared@partner.com"}
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.groupFilter.restrictToDomains(r.gsuiteDomains)
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"new@corp.com"}; !reflect.DeepEqual(kc.created, want) {
+		t.Fatalf("created %v, want %v", kc.created, want)
+	}
+	if want := []string{"alice-id:id-new@corp.com"}; !reflect.DeepEqual(kc.additions, want) {
+		t.Fatalf("additions %v, want %v", kc.additions, want)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+	if want := []string{"id-old@corp.com"}; !reflect.DeepEqual(kc.pruned, want) {
+		t.Fatalf("pruned %v, want %v", kc.pruned, want)
+	}
+}
+
+// filter must keep the original order of the allowed groups.
+func TestGroupFilterFilter(t *testing.T) {
+	filter, err := newGroupFilter([]string{"@corp\\.com$"}, []string{"^announce"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := filter.filter([]string{"ops@corp.com", "announce@corp.com", "ext@other.com", "dev@corp.com"})
+	if want := []string{"ops@corp.com", "dev@corp.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// A user must pass every configured condition, and nothing is filtered out without conditions.
+func TestUserFilterAllows(t *testing.T) {
+	user := func(enabled bool, email string, attributes map[string][]string) *gocloak.User {
+		return &gocloak.User{Username: gocloak.StringP("alice"), Enabled: gocloak.BoolP(enabled),
+			Email: gocloak.StringP(email), Attributes: &attributes}
+	}
+
+	tests := map[string]struct {
+		enabledOnly      bool
+		requireEmail     bool
+		attributeMatches []string
+		skipFederated    bool
+		user             *gocloak.User
+		want             bool
+	}{
+		"no conditions allow disabled users":   {user: user(false, "", nil), want: true},
+		"enabled only allows enabled users":    {enabledOnly: true, user: user(true, "", nil), want: true},
+		"enabled only rejects disabled users":  {enabledOnly: true, user: user(false, "", nil), want: false},
+		"enabled only rejects unknown status":  {enabledOnly: true, user: &gocloak.User{}, want: false},
+		"require email allows users with one":  {requireEmail: true, user: user(true, "alice@corp.com", nil), want: true},
+		"require email rejects empty email":    {requireEmail: true, user: user(true, "", nil), want: false},
+		"require email rejects missing email":  {requireEmail: true, user: &gocloak.User{}, want: false},
+		"attribute match allows any value":     {attributeMatches: []string{"source=google"}, user: user(true, "", map[string][]string{"source": {"ldap", "google"}}), want: true},
+		"attribute match rejects other values": {attributeMatches: []string{"source=google"}, user: user(true, "", map[string][]string{"source": {"ldap"}}), want: false},
+		"attribute match rejects missing key":  {attributeMatches: []string{"source=google"}, user: &gocloak.User{}, want: false},
+		"every attribute must match":           {attributeMatches: []string{"source=google", "team=ops"}, user: user(true, "", map[string][]string{"source": {"google"}}), want: false},
+		"every condition must hold":            {enabledOnly: true, requireEmail: true, user: user(true, "", nil), want: false},
+		"skip federated rejects linked users":  {skipFederated: true, user: &gocloak.User{FederationLink: gocloak.StringP("ldap")}, want: false},
+		"skip federated allows local users":    {skipFederated: true, user: &gocloak.User{FederationLink: gocloak.StringP("")}, want: true},
+		"federated users allowed by default":   {user: &gocloak.User{FederationLink: gocloak.StringP("ldap")}, want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := newUserFilter(tc.enabledOnly, tc.requireEmail, tc.attributeMatches)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			filter.skipFederated = tc.skipFederated
+			if got := filter.allows(tc.user); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Attribute matches not written as key=value must be reported instead of silently ignored.
+func TestNewUserFilterRejectsMalformedMatches(t *testing.T) {
+	for _, match := range []string{"source", "=google"} {
+		if _, err := newUserFilter(false, false, []string{match}); err == nil {
+			t.Errorf("expected error for attribute match %q", match)
+		}
+	}
+}
+
+// Filtered-out users must be neither looked up in Gsuite nor have their memberships changed.
+func TestReconcileUserGroupsSkipsFilteredUsers(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users[0].Enabled = gocloak.BoolP(true)
+	kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("robot-id"),
+		Username: gocloak.StringP("robot@corp.com"), Email: gocloak.StringP("robot@corp.com"), Enabled: gocloak.BoolP(false)})
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	filter, err := newUserFilter(true, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.userFilter = filter
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice@corp.com"}; !reflect.DeepEqual(gs.lookups, want) {
+		t.Fatalf("looked up %v in Gsuite, want %v", gs.lookups, want)
+	}
+	for _, change := range append(kc.additions, kc.deletions...) {
+		if strings.HasPrefix(change, "robot-id:") {
+			t.Fatalf("filtered user got membership change %q", change)
+		}
+	}
+}
+
+// Users must be excluded by either their username or email, whatever their case.
+func TestUserFilterExcludes(t *testing.T) {
+	var filter userFilter
+	filter.exclude([]string{"Admin@corp.com", " breakglass ", ""})
+
+	tests := map[string]struct {
+		user *gocloak.User
+		want bool
+	}{
+		"email in another case": {user: &gocloak.User{Username: gocloak.StringP("admin"), Email: gocloak.StringP("admin@CORP.com")}, want: true},
+		"username":              {user: &gocloak.User{Username: gocloak.StringP("BreakGlass")}, want: true},
+		"other user":            {user: &gocloak.User{Username: gocloak.StringP("alice"), Email: gocloak.StringP("alice@corp.com")}},
+		"no username nor email": {user: &gocloak.User{}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := filter.excludes(tc.user); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// List files, such as the excluded users one, must skip empty lines and comments.
+func TestReadListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "excluded")
+	content := "# break-glass accounts\nadmin@corp.com\n\n  root  \r\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	users, err := readListFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"admin@corp.com", "root"}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("got %v, want %v", users, want)
+	}
+}
+
+// Scoping to some groups must leave the memberships of every other synced group untouched, removals and pruning included.
+func TestReconcileUserGroupsScopedToOnlyGroups(t *testing.T) {
+	tests := map[string]struct {
+		only          []string
+		wantCreated   []string
+		wantAdditions []string
+		wantDeletions []string
+		wantPruned    []string
+	}{
+		"only the new group": {
+			only:          []string{"new@corp.com"},
+			wantCreated:   []string{"new@corp.com"},
+			wantAdditions: []string{"alice-id:id-new@corp.com"},
+		},
+		"only the stale group": {
+			only:          []string{"OLD@corp.com"},
+			wantDeletions: []string{"alice-id:id-old@corp.com"},
+			wantPruned:    []string{"id-old@corp.com"},
+		},
+		"group nobody belongs to": {
+			only: []string{"other@corp.com"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.groupFilter.restrictToGroups(tc.only)
+			r.pruneGroups = true
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+			if !reflect.DeepEqual(kc.pruned, tc.wantPruned) {
+				t.Fatalf("pruned %v, want %v", kc.pruned, tc.wantPruned)
+			}
+		})
+	}
+}
+
+// Excluded users must be neither looked up in Gsuite nor have their memberships changed, even to remove stale ones.
+func TestReconcileUserGroupsSkipsExcludedUsers(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("admin-id"),
+		Username: gocloak.StringP("admin"), Email: gocloak.StringP("admin@corp.com")})
+	kc.userGroups["admin-id"] = kc.userGroups["alice-id"]
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+	r.userFilter.exclude([]string{"ADMIN@corp.com"})
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice@corp.com"}; !reflect.DeepEqual(gs.lookups, want) {
+		t.Fatalf("looked up %v in Gsuite, want %v", gs.lookups, want)
+	}
+	for _, change := range append(kc.additions, kc.deletions...) {
+		if strings.HasPrefix(change, "admin-id:") {
+			t.Fatalf("excluded user got membership change %q", change)
+		}
+	}
+	if !strings.Contains(logs.String(), `"msg":"user excluded. Ignoring user...","user":"admin"`) {
+		t.Fatalf("expected the excluded user to be logged, got logs %s", logs.String())
+	}
+}
+
+// Federated users must be left alone when asked to, local ones still reconciled, and both when not asked to.
+func TestReconcileUserGroupsSkipsFederatedUsers(t *testing.T) {
+	tests := map[string]struct {
+		skipFederated bool
+		wantLookups   []string
+	}{
+		"federated users reconciled": {wantLookups: []string{"alice@corp.com", "ldap-bob@corp.com"}},
+		"federated users skipped":    {skipFederated: true, wantLookups: []string{"alice@corp.com"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("ldap-bob@corp.com"),
+				Email: gocloak.StringP("ldap-bob@corp.com"), FederationLink: gocloak.StringP("ldap-provider-id")})
+			kc.userGroups["bob-id"] = kc.userGroups["alice-id"]
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.userFilter.skipFederated = tc.skipFederated
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(gs.lookups, tc.wantLookups) {
+				t.Fatalf("looked up %v in Gsuite, want %v", gs.lookups, tc.wantLookups)
+			}
+			changedFederated := false
+			for _, change := range append(kc.additions, kc.deletions...) {
+				changedFederated = changedFederated || strings.HasPrefix(change, "bob-id:")
+			}
+			if changedFederated == tc.skipFederated {
+				t.Fatalf("got federated user changed %v, want %v; additions %v, deletions %v",
+					changedFederated, !tc.skipFederated, kc.additions, kc.deletions)
+			}
+			if len(kc.additions) == 0 || kc.additions[0] != "alice-id:id-new@corp.com" {
+				t.Fatalf("additions %v, want the local user reconciled", kc.additions)
+			}
+		})
+	}
+}
+
+// Groups only skipped federated users are in are never seen, which must not get them pruned along with
+// the memberships of those users.
+func TestReconcileUserGroupsSkippingFederatedUsersNeverPrunes(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users[0].FederationLink = gocloak.StringP("ldap-provider-id")
+	gs.groupsByDomain["corp.com"] = []string{"old@corp.com"}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.userFilter.skipFederated = true
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.deletions)+len(kc.pruned) > 0 {
+		t.Fatalf("expected no removals, got deletions %v, pruned %v", kc.deletions, kc.pruned)
+	}
+}
+
+// Any user filter, or a user lacking a match key, leaves users out of the cycle, so the groups only they belong
+// to are never seen and pruning must be skipped.
+func TestReconcileUserGroupsPartialUsersNeverPrune(t *testing.T) {
+	tests := map[string]func(r *Runner, kc *fakeKeycloakClient){
+		"enabled only":    func(r *Runner, _ *fakeKeycloakClient) { r.userFilter.enabledOnly = true },
+		"require email":   func(r *Runner, _ *fakeKeycloakClient) { r.userFilter.requireEmail = true },
+		"attribute match": func(r *Runner, _ *fakeKeycloakClient) { r.userFilter.attributes = map[string]string{"team": "a"} },
+		"excluded users":  func(r *Runner, _ *fakeKeycloakClient) { r.userFilter.exclude([]string{"bob"}) },
+		"federated users": func(r *Runner, _ *fakeKeycloakClient) { r.userFilter.skipFederated = true },
+		"missing match key": func(_ *Runner, kc *fakeKeycloakClient) {
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob")})
+		},
+	}
+
+	for name, setup := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.pruneGroups = true
+			setup(r, kc)
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(kc.pruned) > 0 {
+				t.Fatalf("expected no group pruned, got %v", kc.pruned)
+			}
+		})
+	}
+}
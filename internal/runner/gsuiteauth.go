@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	//
+	"kegos/internal/metrics"
+)
+
+const (
+	// credentialsReloadAttempts is how many times rotated credentials are read before giving up until the
+	// next cycle, as a file caught while being written does not parse
+	credentialsReloadAttempts = 3
+)
+
+// fileDigest returns the SHA-256 of the content of a file, telling its versions apart
+func fileDigest(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reloadRotatedGsuiteCredentials rebuilds the Gsuite client once the content of the credentials file changes,
+// such as when a secret manager rotates the key and remounts it, as the token source keeps the key it was
+// built from. A file caught mid-write does not parse, so it is read again after a pause. When it still
+// does not, the current client is kept and the file is read again on the next cycle
+func (r *Runner) reloadRotatedGsuiteCredentials() {
+	if r.gsuiteJsonCredentialsPath == "" {
+		return
+	}
+
+	var err error
+	for attempt := range credentialsReloadAttempts {
+		if attempt > 0 && !r.sleep(r.retryOpts.BaseDelay) {
+			return
+		}
+
+		var digest string
+		digest, err = fileDigest(r.gsuiteJsonCredentialsPath)
+		if err != nil {
+			continue
+		}
+		if digest == r.gsuiteCredentialsDigest {
+			return
+		}
+
+		var gsuiteCli gsuiteClient
+		gsuiteCli, err = r.newGsuiteCli()
+		if err != nil {
+			continue
+		}
+
+		r.gsuiteCli = gsuiteCli
+		r.gsuiteCredentialsDigest = digest
+		r.appCtx.Logger.Info("gsuite credentials file changed. Rebuilt the Gsuite client from the rotated credentials",
+			"path", r.gsuiteJsonCredentialsPath)
+		return
+	}
+
+	r.appCtx.Logger.Warn("failed loading rotated Gsuite credentials. Keeping the current ones...",
+		"path", r.gsuiteJsonCredentialsPath, "error", err.Error())
+}
+
+// ensureGsuiteToken checks the Gsuite token can still be refreshed before a cycle relies on it, once any
+// rotated credentials are loaded. When it can not, even after retrying, the client is rebuilt from the
+// credentials, as a broken token source would otherwise fail every call until kegos is restarted
+func (r *Runner) ensureGsuiteToken() error {
+	r.reloadRotatedGsuiteCredentials()
+
+	err := r.withRetry(r.gsuiteCli.CheckToken)
+	if err == nil {
+		return nil
+	}
+
+	r.appCtx.Logger.Warn("failed refreshing Gsuite token. Re-authenticating from credentials...", "error", err.Error())
+
+	gsuiteCli, err := r.newGsuiteCli()
+	if err == nil {
+		err = gsuiteCli.CheckToken()
+	}
+	if err != nil {
+		metrics.Errors.WithLabelValues(metrics.StageGsuite).Inc()
+		return withStage(ErrGsuite, fmt.Errorf("failed re-authenticating with Gsuite: %w", err))
+	}
+
+	r.gsuiteCli = gsuiteCli
+	r.appCtx.Logger.Info("re-authenticated with Gsuite")
+	return nil
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A Gsuite client whose token can not be refreshed must be rebuilt from the credentials before the cycle
+// goes on, and the cycle must be aborted when even that fails.
+func TestReconcileOnceRecoversGsuiteToken(t *testing.T) {
+	tests := map[string]struct {
+		tokenErr     error
+		rebuildErr   error
+		wantRebuilds int
+		wantErr      bool
+		wantLog      string
+	}{
+		"valid token is kept": {},
+		"broken token source is rebuilt": {
+			tokenErr:     errors.New("invalid_grant"),
+			wantRebuilds: 1,
+			wantLog:      "re-authenticated with Gsuite",
+		},
+		"failed rebuild aborts the cycle": {
+			tokenErr:     errors.New("invalid_grant"),
+			rebuildErr:   errors.New("credentials file missing"),
+			wantRebuilds: 1,
+			wantErr:      true,
+			wantLog:      "failed refreshing Gsuite token",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			gs.tokenErr = tc.tokenErr
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+
+			rebuilt := &fakeGsuiteClient{groupsByDomain: gs.groupsByDomain}
+			rebuilds := 0
+			r.newGsuiteCli = func() (gsuiteClient, error) {
+				rebuilds++
+				if tc.rebuildErr != nil {
+					return nil, tc.rebuildErr
+				}
+				return rebuilt, nil
+			}
+
+			err := r.ReconcileOnce()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if rebuilds != tc.wantRebuilds {
+				t.Fatalf("got %d rebuilds, want %d", rebuilds, tc.wantRebuilds)
+			}
+			if !strings.Contains(logs.String(), tc.wantLog) {
+				t.Fatalf("expected %q in logs, got %s", tc.wantLog, logs.String())
+			}
+
+			if tc.wantErr {
+				if len(kc.additions)+len(kc.deletions) > 0 {
+					t.Fatalf("expected no changes on an aborted cycle, got additions %v, deletions %v", kc.additions, kc.deletions)
+				}
+				return
+			}
+
+			// Users are looked up through whichever client ended up healthy
+			wantClient := gs
+			if tc.wantRebuilds > 0 {
+				wantClient = rebuilt
+			}
+			if r.gsuiteCli != wantClient || len(wantClient.lookups) == 0 {
+				t.Fatalf("expected users to be looked up through the healthy client")
+			}
+		})
+	}
+}
+
+// A credentials file whose content changed must rebuild the Gsuite client before the cycle goes on, reading
+// it again when caught mid-write, and keeping the current client when it never parses.
+func TestReconcileOnceReloadsRotatedGsuiteCredentials(t *testing.T) {
+	const current = `{"private_key_id":"old"}`
+
+	tests := map[string]struct {
+		content       string
+		completeWrite string
+		wantRebuilds  int
+		wantRotated   bool
+		wantLog       string
+	}{
+		"unchanged file keeps the client": {
+			content: current,
+		},
+		"rotated file rebuilds the client": {
+			content:      `{"private_key_id":"new"}`,
+			wantRebuilds: 1,
+			wantRotated:  true,
+			wantLog:      "gsuite credentials file changed",
+		},
+		"file caught mid-write is read again": {
+			content:       `{"private_key_id":"ne`,
+			completeWrite: `{"private_key_id":"new"}`,
+			wantRebuilds:  2,
+			wantRotated:   true,
+			wantLog:       "gsuite credentials file changed",
+		},
+		"file never parsing keeps the client": {
+			content:      `{"private_key_id":"ne`,
+			wantRebuilds: credentialsReloadAttempts,
+			wantLog:      "failed loading rotated Gsuite credentials",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := os.WriteFile(path, []byte(current), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			kc, gs := newFakeRealm()
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			r.gsuiteJsonCredentialsPath = path
+			digest, err := fileDigest(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r.gsuiteCredentialsDigest = digest
+
+			// The new client parses the file as the real one does, which finishes being written right after
+			rotated := &fakeGsuiteClient{groupsByDomain: gs.groupsByDomain}
+			rebuilds := 0
+			r.newGsuiteCli = func() (gsuiteClient, error) {
+				rebuilds++
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				if !json.Valid(content) {
+					if tc.completeWrite != "" {
+						os.WriteFile(path, []byte(tc.completeWrite), 0o600)
+					}
+					return nil, errors.New("invalid Gsuite credentials: not valid JSON")
+				}
+				return rotated, nil
+			}
+
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := r.ReconcileOnce(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rebuilds != tc.wantRebuilds {
+				t.Fatalf("got %d rebuilds, want %d", rebuilds, tc.wantRebuilds)
+			}
+			if !strings.Contains(logs.String(), tc.wantLog) {
+				t.Fatalf("expected %q in logs, got %s", tc.wantLog, logs.String())
+			}
+
+			wantClient := gs
+			if tc.wantRotated {
+				wantClient = rotated
+			}
+			if r.gsuiteCli != wantClient || len(wantClient.lookups) == 0 {
+				t.Fatalf("expected users to be looked up through the client of the loaded credentials")
+			}
+
+			// The rotated credentials are only loaded once
+			if err := r.ReconcileOnce(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.wantRotated && rebuilds != tc.wantRebuilds {
+				t.Fatalf("got %d rebuilds after another cycle, want %d", rebuilds, tc.wantRebuilds)
+			}
+		})
+	}
+}
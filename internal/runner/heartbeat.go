@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	//
+	"kegos/internal/metrics"
+)
+
+// recordHeartbeat marks the cycle as the last successful one when it ran to the end. Cycles that finished
+// with some failed operations still count, while those aborted on a stage error do not
+func (r *Runner) recordHeartbeat(err error) {
+	if CycleAborted(err) {
+		return
+	}
+
+	now := r.clock.Now()
+	metrics.LastSuccess.Set(float64(now.Unix()))
+
+	if r.heartbeatFile == "" {
+		return
+	}
+	if err := writeFileAtomically(r.heartbeatFile, []byte(now.UTC().Format(time.RFC3339)+"\n")); err != nil {
+		r.appCtx.Logger.Error("failed writing heartbeat file", "path", r.heartbeatFile, "error", err.Error())
+	}
+}
+
+// writeFileAtomically stores the content through a temporary file renamed over the target,
+// so readers never see it half written
+func writeFileAtomically(path string, content []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
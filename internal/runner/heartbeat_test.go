@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The heartbeat must be written after every cycle that ran to the end, and left alone after aborted ones.
+func TestReconcileOnceWritesHeartbeat(t *testing.T) {
+	tests := map[string]struct {
+		breakRealm func(kc *fakeKeycloakClient)
+		wantFile   bool
+	}{
+		"successful cycle": {
+			breakRealm: func(*fakeKeycloakClient) {},
+			wantFile:   true,
+		},
+		"cycle with failed operations": {
+			breakRealm: func(kc *fakeKeycloakClient) {
+				kc.membershipErrs = map[string]error{"id-old@corp.com": errors.New("boom")}
+			},
+			wantFile: true,
+		},
+		"aborted cycle": {
+			breakRealm: func(kc *fakeKeycloakClient) {
+				kc.tokenErr = errors.New("invalid client credentials")
+			},
+			wantFile: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			tc.breakRealm(kc)
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.clock = newFakeClock()
+			r.heartbeatFile = filepath.Join(t.TempDir(), "heartbeat")
+
+			r.ReconcileOnce()
+
+			content, err := os.ReadFile(r.heartbeatFile)
+			if !tc.wantFile {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected no heartbeat file, got %q (error %v)", content, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := "2026-01-01T00:00:00Z\n"; string(content) != want {
+				t.Fatalf("got %q, want %q", content, want)
+			}
+		})
+	}
+}
+
+// A failed cycle must keep the time of the last successful one, and no temporary file may be left behind.
+func TestReconcileOnceKeepsHeartbeatOnFailure(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.clock = newFakeClock()
+	r.heartbeatFile = filepath.Join(t.TempDir(), "heartbeat")
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kc.tokenErr = errors.New("invalid client credentials")
+	if err := r.ReconcileOnce(); err == nil {
+		t.Fatalf("expected the second cycle to fail")
+	}
+
+	content, err := os.ReadFile(r.heartbeatFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "2026-01-01T00:00:00Z\n"; string(content) != want {
+		t.Fatalf("got %q, want %q", content, want)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(r.heartbeatFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the heartbeat file, got %d entries", len(entries))
+	}
+}
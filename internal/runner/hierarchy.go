@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/keycloak"
+)
+
+// groupHierarchy remembers where the synced groups of a realm hang when their names are split into levels.
+// Groups not managed by kegos under the synced parent group are containers: they hold the nested levels
+type groupHierarchy struct {
+	// containers holds the ID of every container, keyed by its levels joined by the delimiter
+	containers map[string]string
+
+	// paths holds the levels of the containers nesting every synced group, joined by the delimiter.
+	// Groups at the top of the synced parent group are left out
+	paths map[*gocloak.Group]string
+}
+
+// reset forgets the containers and paths of the previous listing
+func (h *groupHierarchy) reset() {
+	h.containers = map[string]string{}
+	h.paths = map[*gocloak.Group]string{}
+}
+
+// remember records the containers nesting a group. Groups at the top of the synced parent group need no record
+func (h *groupHierarchy) remember(group *gocloak.Group, path string) {
+	if h.paths == nil {
+		h.reset()
+	}
+	if path != "" {
+		h.paths[group] = path
+	}
+}
+
+// rememberContainer records the ID of the container the given levels lead to
+func (h *groupHierarchy) rememberContainer(path, id string) {
+	if h.containers == nil {
+		h.reset()
+	}
+	h.containers[path] = id
+}
+
+// hierarchyName returns the name of a group, nesting levels included, as given by the group namer
+func (r *Runner) hierarchyName(group *gocloak.Group) string {
+	if path := r.groupHierarchy.paths[group]; path != "" {
+		return path + r.groupNamer.hierarchyDelimiter + *group.Name
+	}
+	return *group.Name
+}
+
+// getGroupHierarchy lists the groups under the synced parent group, walking down every container. Synced groups
+// are never walked, as kegos creates nothing under them. Containers are returned along, so their names can not be
+// taken by a synced group
+func (r *Runner) getGroupHierarchy(parentGroupID string) (groups []*gocloak.Group, err error) {
+	r.groupHierarchy.reset()
+
+	type level struct {
+		id   string
+		path string
+	}
+	pending := []level{{id: parentGroupID}}
+
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		// Each page is retried by the client itself
+		children, err := r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, current.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting children groups: %w", err)
+		}
+
+		for _, child := range children {
+			if child.ID == nil || child.Name == nil {
+				continue
+			}
+			groups = append(groups, child)
+			r.groupHierarchy.remember(child, current.path)
+			if isManaged(child) {
+				continue
+			}
+
+			path := r.hierarchyName(child)
+			r.groupHierarchy.rememberContainer(path, *child.ID)
+			pending = append(pending, level{id: *child.ID, path: path})
+		}
+	}
+
+	return groups, nil
+}
+
+// ensureGroupContainers returns the ID of the group a synced group with the given containers hangs from, creating
+// the missing containers level by level. A container created meanwhile by someone else is looked up and reused,
+// but a synced group holding its name is never turned into one
+func (r *Runner) ensureGroupContainers(parentGroupID string, containers []string) (containerID string, err error) {
+	containerID = parentGroupID
+
+	for i, name := range containers {
+		path := strings.Join(containers[:i+1], r.groupNamer.hierarchyDelimiter)
+		if id, found := r.groupHierarchy.containers[path]; found {
+			containerID = id
+			continue
+		}
+
+		r.appCtx.Logger.Debug("creating missing container group in Keycloak", "group", path)
+
+		var id string
+		err = r.withRetry(func() (err error) {
+			id, err = r.keycloak.CreateChildGroup(r.keycloak.GetToken().AccessToken, containerID, gocloak.Group{Name: gocloak.StringP(name)})
+			return err
+		})
+		r.audit(AuditTargetKeycloak, AuditActionCreate, "", path, err)
+
+		if keycloak.IsConflictError(err) {
+			id, err = r.getRacedContainerGroup(containerID, name)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed creating container group %s: %w", path, err)
+		}
+
+		r.groupHierarchy.rememberContainer(path, id)
+		containerID = id
+	}
+
+	return containerID, nil
+}
+
+// getRacedContainerGroup returns the ID of the container with the given name someone else created under a group
+// right before kegos tried to
+func (r *Runner) getRacedContainerGroup(parentID, name string) (string, error) {
+	children, err := r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, parentID)
+	if err != nil {
+		return "", fmt.Errorf("failed looking up group created meanwhile: %w", err)
+	}
+
+	group, err := groupNamed(children, name)
+	if err != nil {
+		return "", err
+	}
+	if group == nil {
+		return "", fmt.Errorf("group %s already exists, but not under the expected group", name)
+	}
+	if isManaged(group) {
+		return "", fmt.Errorf("group %s is a synced group, so it can not nest other groups", name)
+	}
+	return *group.ID, nil
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newHierarchyRealm returns a realm where alice belongs in Google to groups nested two levels deep, and to one
+// whose own name is used at another level too.
+func newHierarchyRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc := &fakeKeycloakClient{
+		parent: &gocloak.Group{ID: gocloak.StringP("id-parent"), Name: gocloak.StringP("google-workspace")},
+		users: []*gocloak.User{
+			{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice@corp.com"), Email: gocloak.StringP("alice@corp.com")},
+		},
+		userGroups:       map[string][]*gocloak.Group{},
+		childrenByParent: map[string][]*gocloak.Group{},
+	}
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{
+		"corp.com": {"eng.backend.api@corp.com", "eng.web@corp.com", "ops.api@corp.com"},
+	}}
+	return kc, gs
+}
+
+// newHierarchyTestRunner returns a runner nesting groups on dots, with the domain stripped.
+func newHierarchyTestRunner(kc keycloakClient, gs gsuiteClient) *Runner {
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.groupNamer = groupNamer{mapper: StripDomainGroupNameMapper{}, hierarchyDelimiter: "."}
+	return r
+}
+
+// Groups must be nested under containers named after the levels of their name, creating only the missing ones.
+func TestReconcileUserGroupsNestsGroups(t *testing.T) {
+	tests := map[string]struct {
+		existing      map[string][]*gocloak.Group
+		wantCreated   []string
+		wantParents   []string
+		wantAdditions []string
+	}{
+		"whole hierarchy missing": {
+			wantCreated:   []string{"eng", "backend", "api", "web", "ops", "api"},
+			wantParents:   []string{"id-parent", "id-eng", "id-backend", "id-eng", "id-parent", "id-ops"},
+			wantAdditions: []string{"alice-id:id-api", "alice-id:id-web", "alice-id:id-api"},
+		},
+		"two levels already there": {
+			existing: map[string][]*gocloak.Group{
+				"id-parent": {{ID: gocloak.StringP("id-eng"), Name: gocloak.StringP("eng")}},
+				"id-eng":    {{ID: gocloak.StringP("id-backend"), Name: gocloak.StringP("backend")}},
+				"id-backend": {{ID: gocloak.StringP("id-eng-backend-api"), Name: gocloak.StringP("api"),
+					Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"eng.backend.api@corp.com"}}}},
+			},
+			wantCreated:   []string{"web", "ops", "api"},
+			wantParents:   []string{"id-eng", "id-parent", "id-ops"},
+			wantAdditions: []string{"alice-id:id-eng-backend-api", "alice-id:id-web", "alice-id:id-api"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newHierarchyRealm()
+			kc.childrenByParent["id-parent"] = nil
+			for parentID, children := range tc.existing {
+				kc.childrenByParent[parentID] = children
+			}
+			r := newHierarchyTestRunner(kc, gs)
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.createdParents, tc.wantParents) {
+				t.Fatalf("created under %v, want %v", kc.createdParents, tc.wantParents)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+
+			// Synced groups carry the name of their own level, and containers are never marked as synced
+			for _, group := range kc.createdGroups {
+				if isManaged(&group) == (*group.Name == "eng" || *group.Name == "backend" || *group.Name == "ops") {
+					t.Fatalf("group %s is managed %t", *group.Name, isManaged(&group))
+				}
+			}
+		})
+	}
+}
+
+// Diffs must name nested groups with all their levels, whether they exist already or not.
+func TestDiffNamesNestedGroups(t *testing.T) {
+	kc, gs := newHierarchyRealm()
+	api := &gocloak.Group{ID: gocloak.StringP("id-eng-backend-api"), Name: gocloak.StringP("api"),
+		Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"eng.backend.api@corp.com"}}}
+	kc.childrenByParent = map[string][]*gocloak.Group{
+		"id-parent":  {{ID: gocloak.StringP("id-eng"), Name: gocloak.StringP("eng")}},
+		"id-eng":     {{ID: gocloak.StringP("id-backend"), Name: gocloak.StringP("backend")}},
+		"id-backend": {api},
+	}
+	kc.userGroups["alice-id"] = []*gocloak.Group{api}
+	gs.groupsByDomain["corp.com"] = []string{"eng.web@corp.com"}
+
+	r := newHierarchyTestRunner(kc, gs)
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []UserDiff{{Realm: "test", User: "alice@corp.com", Add: []string{"eng.web"}, Remove: []string{"eng.backend.api"}}}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("got %+v, want %+v", diffs, want)
+	}
+	if len(kc.created) > 0 {
+		t.Fatalf("expected nothing to be created, got %v", kc.created)
+	}
+}
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// userSnapshot summarizes what a user is compared on: their Gsuite groups and the synced Keycloak groups they belong to.
+// The Directory API offers no change feed without a public webhook, so a user whose snapshot matches the one taken
+// when they were last found in sync needs no comparison at all
+func (r *Runner) userSnapshot(gsuiteGroups []string, kcUserGroups KeycloakUserGroups, kcChildrenGroupsByID map[string]*gocloak.Group) string {
+	var identities []string
+	for _, gsuiteGroup := range gsuiteGroups {
+		identities = append(identities, groupIdentity(gsuiteGroup))
+	}
+	slices.Sort(identities)
+
+	var managedGroupIDs []string
+	for groupID := range kcUserGroups.Groups {
+		if managedGroup, found := kcChildrenGroupsByID[groupID]; found && isManaged(managedGroup) {
+			managedGroupIDs = append(managedGroupIDs, groupID)
+		}
+	}
+	slices.Sort(managedGroupIDs)
+
+	return strings.Join(slices.Compact(identities), ",") + "|" + strings.Join(managedGroupIDs, ",")
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newInSyncRealm returns a realm where alice already belongs to the synced group matching her only Gsuite group.
+func newInSyncRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc := &fakeKeycloakClient{
+		parent: &gocloak.Group{ID: gocloak.StringP("id-parent"), Name: gocloak.StringP("google-workspace")},
+		children: []*gocloak.Group{
+			{ID: gocloak.StringP("id-dev@corp.com"), Name: gocloak.StringP("dev@corp.com"),
+				Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"dev@corp.com"}}},
+		},
+		users: []*gocloak.User{
+			{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice@corp.com"), Email: gocloak.StringP("alice@corp.com")},
+		},
+		userGroups: map[string][]*gocloak.Group{
+			"alice-id": {
+				{ID: gocloak.StringP("id-dev@corp.com"), Name: gocloak.StringP("dev@corp.com"), Path: gocloak.StringP("/google-workspace/dev@corp.com")},
+			},
+		},
+	}
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{"corp.com": {"dev@corp.com"}}}
+	return kc, gs
+}
+
+// Users found in sync must be skipped on the next cycle unless either side of their groups changed.
+func TestReconcileUserGroupsIncremental(t *testing.T) {
+	tests := map[string]struct {
+		incremental   bool
+		change        func(kc *fakeKeycloakClient, gs *fakeGsuiteClient)
+		wantUnchanged int
+		wantAdditions []string
+	}{
+		"unchanged user is skipped": {
+			incremental:   true,
+			change:        func(*fakeKeycloakClient, *fakeGsuiteClient) {},
+			wantUnchanged: 1,
+		},
+		"new Gsuite group is reconciled": {
+			incremental: true,
+			change: func(_ *fakeKeycloakClient, gs *fakeGsuiteClient) {
+				gs.groupsByDomain["corp.com"] = []string{"dev@corp.com", "ops@corp.com"}
+			},
+			wantAdditions: []string{"alice-id:id-ops@corp.com"},
+		},
+		"membership removed in Keycloak is restored": {
+			incremental: true,
+			change: func(kc *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				kc.userGroups["alice-id"] = nil
+			},
+			wantAdditions: []string{"alice-id:id-dev@corp.com"},
+		},
+		"disabled compares every user": {
+			incremental: false,
+			change:      func(*fakeKeycloakClient, *fakeGsuiteClient) {},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newInSyncRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.incremental = tc.incremental
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.cycleStats.usersUnchanged != 0 {
+				t.Fatalf("expected the first cycle to compare every user, %d were skipped", r.cycleStats.usersUnchanged)
+			}
+
+			tc.change(kc, gs)
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if r.cycleStats.usersUnchanged != tc.wantUnchanged {
+				t.Fatalf("got %d unchanged users, want %d", r.cycleStats.usersUnchanged, tc.wantUnchanged)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+		})
+	}
+}
+
+// A user whose changes were applied is compared again on the next cycle, as the outcome is not verified yet.
+func TestReconcileUserGroupsIncrementalRecomparesChangedUsers(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.incremental = true
+
+	r.reconcileUserGroups()
+	r.reconcileUserGroups()
+
+	if r.cycleStats.usersUnchanged != 0 {
+		t.Fatalf("expected the changed user to be compared again, %d were skipped", r.cycleStats.usersUnchanged)
+	}
+}
+
+// Dry-runs change nothing, so they must never mark users as in sync.
+func TestReconcileUserGroupsIncrementalIgnoresDryRun(t *testing.T) {
+	kc, gs := newInSyncRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	r.incremental = true
+
+	r.reconcileUserGroups()
+	r.reconcileUserGroups()
+
+	if r.cycleStats.usersUnchanged != 0 {
+		t.Fatalf("expected no user skipped on dry-run, got %d", r.cycleStats.usersUnchanged)
+	}
+}
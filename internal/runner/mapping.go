@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"gopkg.in/yaml.v3"
+)
+
+// groupMapping maps Gsuite groups, by identity, to the names of the Keycloak groups they grant membership in
+// instead of the single group mirroring them
+type groupMapping map[string][]string
+
+// loadGroupMapping reads a YAML file mapping Gsuite group emails to one or more Keycloak group names.
+// A Keycloak group may only be granted by a single Gsuite group, so its members always follow one source
+func loadGroupMapping(path string) (groupMapping, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string][]string
+	if err := yaml.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+
+	mapping := groupMapping{}
+	owners := map[string]string{}
+	for _, gsuiteGroup := range slices.Sorted(maps.Keys(entries)) {
+		identity := groupIdentity(strings.TrimSpace(gsuiteGroup))
+		if identity == "" {
+			return nil, fmt.Errorf("mapping without Gsuite group")
+		}
+		if _, found := mapping[identity]; found {
+			return nil, fmt.Errorf("mapping of %s given more than once", gsuiteGroup)
+		}
+		if len(entries[gsuiteGroup]) == 0 {
+			return nil, fmt.Errorf("mapping of %s without Keycloak groups", gsuiteGroup)
+		}
+
+		for _, target := range entries[gsuiteGroup] {
+			target = strings.TrimSpace(target)
+			if target == "" || strings.Contains(target, "/") {
+				return nil, fmt.Errorf("invalid Keycloak group %q for %s: names must be non-empty and without /", target, gsuiteGroup)
+			}
+			if owner, found := owners[target]; found && owner != identity {
+				return nil, fmt.Errorf("mapping of both %s and %s to Keycloak group %s", owner, identity, target)
+			}
+			owners[target] = identity
+
+			if !slices.Contains(mapping[identity], target) {
+				mapping[identity] = append(mapping[identity], target)
+			}
+		}
+	}
+
+	return mapping, nil
+}
+
+// groupTarget is a Keycloak group a Gsuite group grants membership in
+type groupTarget struct {
+	identity string
+	name     string
+
+	// mapped is the name given by the group mapping, empty for the group mirroring the Gsuite group
+	mapped string
+}
+
+// mappedGroupIdentity is the key of a Keycloak group granted through the group mapping. Emails hold no colon,
+// so it never matches the identity of a group mirroring a Gsuite group
+func mappedGroupIdentity(gsuiteGroup, target string) string {
+	return groupIdentity(gsuiteGroup) + ":" + target
+}
+
+// groupTargets returns the Keycloak groups a Gsuite group grants membership in: the ones it is mapped to,
+// or the single group mirroring it otherwise. When that group can not be named, it is returned without name
+// along with the error, as it may already exist
+func (r *Runner) groupTargets(gsuiteGroup string) (targets []groupTarget, err error) {
+	mapped, found := r.groupMapping[groupIdentity(gsuiteGroup)]
+	if !found {
+		name, err := r.groupNamer.name(gsuiteGroup)
+		return []groupTarget{{identity: groupIdentity(gsuiteGroup), name: name}}, err
+	}
+
+	for _, target := range mapped {
+		targets = append(targets, groupTarget{identity: mappedGroupIdentity(gsuiteGroup, target), name: target, mapped: target})
+	}
+	return targets, nil
+}
+
+// newSyncedGroup returns the Keycloak group to create for a target of a Gsuite group, carrying its provenance
+func (r *Runner) newSyncedGroup(gsuiteGroup string, target groupTarget) *gocloak.Group {
+	attributes := withProvenance(nil, gsuiteGroup, r.clock.Now())
+	if target.mapped != "" {
+		(*attributes)[GroupAttributeMappedGroup] = []string{target.mapped}
+	}
+	return &gocloak.Group{
+		Name:       gocloak.StringP(target.name),
+		Attributes: attributes,
+	}
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// The mapping file must be keyed by identity, and rejected when a Keycloak group would follow several sources.
+func TestLoadGroupMapping(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    groupMapping
+		wantErr string
+	}{
+		"one to many": {
+			content: "New@corp.com: [developers, deployers, developers]\nops@corp.com: [operators]\n",
+			want:    groupMapping{"new@corp.com": {"developers", "deployers"}, "ops@corp.com": {"operators"}},
+		},
+		"empty file": {
+			want: groupMapping{},
+		},
+		"group shared by two sources": {
+			content: "new@corp.com: [developers]\nops@corp.com: [developers]\n",
+			wantErr: "mapping of both new@corp.com and ops@corp.com to Keycloak group developers",
+		},
+		"same source in another case": {
+			content: "new@corp.com: [developers]\nNEW@corp.com: [deployers]\n",
+			wantErr: "given more than once",
+		},
+		"no target": {
+			content: "new@corp.com: []\n",
+			wantErr: "without Keycloak groups",
+		},
+		"nested target": {
+			content: "new@corp.com: [teams/developers]\n",
+			wantErr: "without /",
+		},
+		"not a mapping": {
+			content: "- new@corp.com\n",
+			wantErr: "cannot unmarshal",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mapping.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := loadGroupMapping(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// newMappedGroup returns the synced group granted by the Gsuite group under the given name of the mapping.
+func newMappedGroup(gsuiteGroup, target string) *gocloak.Group {
+	return &gocloak.Group{
+		ID:   gocloak.StringP("id-" + target),
+		Name: gocloak.StringP(target),
+		Attributes: &map[string][]string{
+			GroupAttributeManaged:     {"true"},
+			GroupAttributeSourceGroup: {gsuiteGroup},
+			GroupAttributeMappedGroup: {target},
+		},
+	}
+}
+
+// A Gsuite group mapped to several Keycloak groups must grant all of them, and take all of them away once
+// the user leaves it, while unmapped groups keep being mirrored.
+func TestReconcileUserGroupsWithGroupMapping(t *testing.T) {
+	mapping := groupMapping{"new@corp.com": {"developers", "deployers"}}
+
+	tests := map[string]struct {
+		children      []*gocloak.Group
+		userGroups    []*gocloak.Group
+		gsuiteGroups  []string
+		wantCreated   []string
+		wantAdditions []string
+		wantDeletions []string
+	}{
+		"mapped groups created and joined": {
+			gsuiteGroups:  []string{"new@corp.com", "other@corp.com"},
+			wantCreated:   []string{"developers", "deployers", "other@corp.com"},
+			wantAdditions: []string{"alice-id:id-developers", "alice-id:id-deployers", "alice-id:id-other@corp.com"},
+		},
+		"only missing mapped groups joined": {
+			children:      []*gocloak.Group{newMappedGroup("new@corp.com", "developers"), newMappedGroup("new@corp.com", "deployers")},
+			userGroups:    []*gocloak.Group{newMappedGroup("new@corp.com", "developers")},
+			gsuiteGroups:  []string{"new@corp.com"},
+			wantAdditions: []string{"alice-id:id-deployers"},
+		},
+		"mapped groups renamed by hand still match": {
+			children: []*gocloak.Group{
+				{ID: gocloak.StringP("id-developers"), Name: gocloak.StringP("devs"), Attributes: newMappedGroup("new@corp.com", "developers").Attributes},
+				newMappedGroup("new@corp.com", "deployers"),
+			},
+			userGroups:   []*gocloak.Group{{ID: gocloak.StringP("id-developers"), Name: gocloak.StringP("devs")}, newMappedGroup("new@corp.com", "deployers")},
+			gsuiteGroups: []string{"new@corp.com"},
+		},
+		"every mapped group left with the Gsuite group": {
+			children:      []*gocloak.Group{newMappedGroup("new@corp.com", "developers"), newMappedGroup("new@corp.com", "deployers")},
+			userGroups:    []*gocloak.Group{newMappedGroup("new@corp.com", "developers"), newMappedGroup("new@corp.com", "deployers")},
+			wantDeletions: []string{"alice-id:id-deployers", "alice-id:id-developers"},
+		},
+		"mirrored group left once mapped": {
+			children: []*gocloak.Group{
+				{ID: gocloak.StringP("id-new@corp.com"), Name: gocloak.StringP("new@corp.com"),
+					Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"new@corp.com"}}},
+			},
+			userGroups:    []*gocloak.Group{{ID: gocloak.StringP("id-new@corp.com"), Name: gocloak.StringP("new@corp.com")}},
+			gsuiteGroups:  []string{"new@corp.com"},
+			wantCreated:   []string{"developers", "deployers"},
+			wantAdditions: []string{"alice-id:id-developers", "alice-id:id-deployers"},
+			wantDeletions: []string{"alice-id:id-new@corp.com"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.children = tc.children
+			kc.userGroups = map[string][]*gocloak.Group{"alice-id": tc.userGroups}
+			gs.groupsByDomain = map[string][]string{"corp.com": tc.gsuiteGroups}
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.groupMapping = mapping
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+		})
+	}
+}
+
+// Mapped groups must be reported by the diff under the names the mapping gives them.
+func TestDiffWithGroupMapping(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.groupMapping = groupMapping{"new@corp.com": {"developers", "deployers"}}
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []UserDiff{{Realm: "test", User: "alice@corp.com", Add: []string{"deployers", "developers"}, Remove: []string{"old@corp.com"}}}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("got %+v, want %+v", diffs, want)
+	}
+}
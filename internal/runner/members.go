@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	//
+	"github.com/prometheus/client_golang/prometheus"
+	"kegos/internal/metrics"
+)
+
+// recordGroupMembers publishes how many realm users Gsuite puts in every synced group, so a group suddenly
+// emptied by a broken Google group stands out. Synced groups nobody belongs to anymore count zero. Counts come
+// from the memberships already gathered during the cycle, so the caller skips it when any user was left out
+func (r *Runner) recordGroupMembers(syncedGroups []string, memberCounts map[string]int) {
+	counts := map[string]int{}
+	for _, identity := range syncedGroups {
+		counts[identity] = 0
+	}
+	for identity, count := range memberCounts {
+		counts[identity] = count
+	}
+
+	// Groups gone since the previous cycle must not linger with their last count
+	metrics.GroupMembers.DeletePartialMatch(prometheus.Labels{"realm": r.realm})
+	for identity, count := range counts {
+		metrics.GroupMembers.WithLabelValues(r.realm, identity).Set(float64(count))
+	}
+
+	r.appCtx.Logger.Debug("synced group members", "members", counts)
+}
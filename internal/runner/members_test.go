@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// reportedGroupMembers returns the counts of the last synced group members report in the logs, or nil when there is none.
+func reportedGroupMembers(t *testing.T, logs *bytes.Buffer) (members map[string]int) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line struct {
+			Msg     string         `json:"msg"`
+			Members map[string]int `json:"members"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if line.Msg == "synced group members" {
+			members = line.Members
+		}
+	}
+	return members
+}
+
+// Every synced group must be reported with the users Gsuite puts in it, emptied ones counting zero,
+// and nothing must be reported when a user could not be looked up.
+func TestReconcileUserGroupsReportsGroupMembers(t *testing.T) {
+	tests := map[string]struct {
+		gsuite gsuiteClient
+		want   map[string]int
+	}{
+		"every user looked up": {
+			gsuite: &fakeDirectory{membersByDomain: map[string]map[string][]string{
+				"corp.com": {
+					"new@corp.com":  {"alice@corp.com", "bob@corp.com"},
+					"team@corp.com": {"bob@corp.com", "external@partner.com"},
+				},
+			}},
+			want: map[string]int{"new@corp.com": 2, "old@corp.com": 0, "team@corp.com": 1},
+		},
+		"failed lookup": {
+			gsuite: &fakeGsuiteClient{errByDomain: map[string]error{"corp.com": errors.New("boom")}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, _ := newFakeRealm()
+			kc.users = append(kc.users, &gocloak.User{
+				ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com"),
+			})
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, tc.gsuite, logs, false)
+			r.reconcileUserGroups()
+
+			if got := reportedGroupMembers(t, logs); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+var invalidGroupNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+const (
+	GroupNameMapperEmail       = "email"
+	GroupNameMapperStripDomain = "strip-domain"
+)
+
+// GroupNameMapper turns a Gsuite group email into the name of the Keycloak group, or role, mirroring it.
+// Organizations naming groups their own way can compile an implementation in through RunnerOptions.
+// A group failing to be named is neither created nor joined, while the existing one is kept
+type GroupNameMapper interface {
+	Map(googleGroup string) (keycloakName string, err error)
+}
+
+// EmailGroupNameMapper names Keycloak groups after the Gsuite group email as-is
+type EmailGroupNameMapper struct{}
+
+func (EmailGroupNameMapper) Map(googleGroup string) (string, error) {
+	return googleGroup, nil
+}
+
+// StripDomainGroupNameMapper names Keycloak groups after the local part of the Gsuite group email
+type StripDomainGroupNameMapper struct{}
+
+func (StripDomainGroupNameMapper) Map(googleGroup string) (string, error) {
+	if at := strings.LastIndex(googleGroup, "@"); at > 0 {
+		return googleGroup[:at], nil
+	}
+	return googleGroup, nil
+}
+
+// NewGroupNameMapper returns the built-in mapper with the given name
+func NewGroupNameMapper(name string) (GroupNameMapper, error) {
+	switch name {
+	case GroupNameMapperEmail:
+		return EmailGroupNameMapper{}, nil
+	case GroupNameMapperStripDomain:
+		return StripDomainGroupNameMapper{}, nil
+	default:
+		return nil, fmt.Errorf("unknown group name mapper %q", name)
+	}
+}
+
+// groupNamer turns Gsuite group emails into Keycloak group names
+type groupNamer struct {
+	// mapper names the group first. Emails are used as-is when nil
+	mapper GroupNameMapper
+
+	sanitize bool
+
+	// prefix is prepended once every other transformation is done, so it is never sanitized
+	prefix string
+
+	// hierarchyDelimiter splits names into the levels of nested groups, the last one naming the group itself.
+	// Names are flat when empty
+	hierarchyDelimiter string
+}
+
+// name returns the Keycloak group name for a Gsuite group email. With no transformation
+// enabled the email is used as-is. Every level of a nested name is sanitized on its own
+func (n groupNamer) name(email string) (string, error) {
+	name := email
+
+	if n.mapper != nil {
+		var err error
+		name, err = n.mapper.Map(email)
+		if err != nil {
+			return "", fmt.Errorf("failed naming group %s: %w", email, err)
+		}
+	}
+
+	if n.sanitize {
+		levels := n.split(name)
+		for i, level := range levels {
+			level = invalidGroupNameChars.ReplaceAllString(strings.ToLower(level), "-")
+			levels[i] = strings.Trim(level, "-")
+		}
+		name = strings.Join(levels, n.hierarchyDelimiter)
+	}
+
+	name = n.prefix + name
+	for _, level := range n.split(name) {
+		if level == "" || strings.Contains(level, "/") {
+			return "", fmt.Errorf("failed naming group %s: got %q, names must be non-empty and without /", email, name)
+		}
+	}
+	return name, nil
+}
+
+// split returns the levels of a name, outermost first, or the name alone when names are flat
+func (n groupNamer) split(name string) []string {
+	if n.hierarchyDelimiter == "" {
+		return []string{name}
+	}
+	return strings.Split(name, n.hierarchyDelimiter)
+}
+
+// levels returns the names of the groups nesting a group named by name, outermost first, and the name of the
+// group itself
+func (n groupNamer) levels(name string) (containers []string, leaf string) {
+	levels := n.split(name)
+	return levels[:len(levels)-1], levels[len(levels)-1]
+}
+
+// sourceGroupOf returns the Gsuite group email a Keycloak group mirrors. Groups created before
+// the attribute existed were named after the email itself, so the name is the fallback
+func sourceGroupOf(group *gocloak.Group) string {
+	return sourceGroupFrom(group.Attributes, *group.Name)
+}
+
+// sourceGroupFrom returns the Gsuite group email held in the attributes of a Keycloak group or role,
+// or the given name when there is none
+func sourceGroupFrom(attributes *map[string][]string, name string) string {
+	if attributes != nil {
+		if values := (*attributes)[GroupAttributeSourceGroup]; len(values) > 0 {
+			return values[0]
+		}
+	}
+	return name
+}
+
+// groupIdentity is the single mapping from a Gsuite group email to the key its Keycloak group is known by.
+// Names depend on the naming options and may be changed by hand, so they never identify a group
+func groupIdentity(email string) string {
+	return strings.ToLower(email)
+}
+
+// uniqueGroups drops the groups repeated in the list, with the same or a different case, keeping the first spelling
+// of each one. Google may list a group twice, such as when pages overlap, which would otherwise mean repeated changes
+func uniqueGroups(groups []string) (unique []string) {
+	seen := map[string]struct{}{}
+	for _, group := range groups {
+		identity := groupIdentity(group)
+		if _, found := seen[identity]; found {
+			continue
+		}
+		seen[identity] = struct{}{}
+		unique = append(unique, group)
+	}
+	return unique
+}
+
+// identityOf returns the key of the Gsuite group a Keycloak group mirrors, as given by groupIdentity,
+// or the one given by mappedGroupIdentity for groups granted through the group mapping
+func identityOf(group *gocloak.Group) string {
+	if group.Attributes != nil {
+		if values := (*group.Attributes)[GroupAttributeMappedGroup]; len(values) > 0 {
+			return mappedGroupIdentity(sourceGroupOf(group), values[0])
+		}
+	}
+	return groupIdentity(sourceGroupOf(group))
+}
+
+// resolveGroupNames maps the Gsuite groups of a user to the identities of the Keycloak groups they must
+// belong to, every group they are mapped to included. Existing groups are matched by identity whatever
+// their name. A new group whose name is already owned by a different Gsuite group, either in Keycloak or
+// earlier in the list, is a collision: it is dropped and reported instead of silently merged into the other one.
+// Names are only compared among the groups synced under the same parent group
+func (r *Runner) resolveGroupNames(gsuiteGroups []string, kcChildrenGroups map[string]*gocloak.Group) (desiredGroups map[string]string) {
+	desiredGroups = map[string]string{}
+
+	// Names of the groups about to be created by parent, mapped to the Gsuite group claiming each one
+	plannedNames := map[string]map[string]string{}
+
+	for _, gsuiteGroup := range gsuiteGroups {
+		parent := groupPathString(r.parentOf(gsuiteGroup))
+		if plannedNames[parent] == nil {
+			plannedNames[parent] = map[string]string{}
+		}
+
+		targets, err := r.groupTargets(gsuiteGroup)
+		for _, target := range targets {
+			if _, found := desiredGroups[target.identity]; found {
+				continue
+			}
+
+			if _, found := kcChildrenGroups[target.identity]; found {
+				desiredGroups[target.identity] = gsuiteGroup
+				continue
+			}
+
+			// Groups that can not be named are only a problem when they have to be created
+			if err != nil {
+				r.appCtx.Logger.Error("failed naming group. Ignoring group...", "group", gsuiteGroup, "error", err.Error())
+				r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "name group", Group: gsuiteGroup, Err: err})
+				continue
+			}
+
+			if owner := r.groupNameOwner(target.name, parent, kcChildrenGroups, plannedNames[parent]); owner != "" {
+				r.appCtx.Logger.Error("group name collision. Ignoring group...",
+					"group", gsuiteGroup, "name", target.name, "owner", owner)
+				continue
+			}
+
+			desiredGroups[target.identity] = gsuiteGroup
+			plannedNames[parent][target.name] = gsuiteGroup
+		}
+	}
+
+	return desiredGroups
+}
+
+// groupNameOwner returns the Gsuite group holding a Keycloak group name under the given parent group, either
+// as an existing group or as one about to be created, or an empty string when the name is free. Nested groups
+// are compared by their whole name, so the same name is free at different levels
+func (r *Runner) groupNameOwner(groupName, parent string, kcChildrenGroups map[string]*gocloak.Group, plannedNames map[string]string) string {
+	for _, kcGroup := range kcChildrenGroups {
+		if len(r.parentRoutes) > 0 && groupPathString(r.parentOf(sourceGroupOf(kcGroup))) != parent {
+			continue
+		}
+		if r.hierarchyName(kcGroup) == groupName {
+			return sourceGroupOf(kcGroup)
+		}
+	}
+	return plannedNames[groupName]
+}
@@ -0,0 +1,341 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Group names must only be transformed when asked to, and always the same way.
+func TestGroupNamerName(t *testing.T) {
+	tests := map[string]struct {
+		namer   groupNamer
+		email   string
+		want    string
+		wantErr string
+	}{
+		"no transformation keeps the email": {email: "Dev.Team@corp.com", want: "Dev.Team@corp.com"},
+		"email mapper keeps the email":      {namer: groupNamer{mapper: EmailGroupNameMapper{}}, email: "Dev.Team@corp.com", want: "Dev.Team@corp.com"},
+		"strip domain":                      {namer: groupNamer{mapper: StripDomainGroupNameMapper{}}, email: "dev@corp.com", want: "dev"},
+		"sanitize lowercases and replaces":  {namer: groupNamer{sanitize: true}, email: "Dev Team+ops@corp.com", want: "dev-team-ops-corp.com"},
+		"sanitize trims dashes":             {namer: groupNamer{sanitize: true}, email: "+dev@corp.com+", want: "dev-corp.com"},
+		"strip domain then sanitize":        {namer: groupNamer{mapper: StripDomainGroupNameMapper{}, sanitize: true}, email: "Dev/Team@corp.com", want: "dev-team"},
+		"prefix":                            {namer: groupNamer{prefix: "g-suite:"}, email: "dev@corp.com", want: "g-suite:dev@corp.com"},
+		"prefix is never sanitized":         {namer: groupNamer{mapper: StripDomainGroupNameMapper{}, sanitize: true, prefix: "G-Suite:"}, email: "Dev@corp.com", want: "G-Suite:dev"},
+		"custom mapper":                     {namer: groupNamer{mapper: teamGroupNameMapper{}, prefix: "g-"}, email: "team-backend@corp.com", want: "g-backend"},
+		"custom mapper failing":             {namer: groupNamer{mapper: teamGroupNameMapper{}}, email: "backend@corp.com", wantErr: "not a team group"},
+		"nothing left once sanitized":       {namer: groupNamer{mapper: StripDomainGroupNameMapper{}, sanitize: true}, email: "+@corp.com", wantErr: "names must be non-empty"},
+		"slash left unsanitized":            {namer: groupNamer{mapper: StripDomainGroupNameMapper{}}, email: "dev/ops@corp.com", wantErr: "without /"},
+		"levels sanitized on their own":     {namer: groupNamer{mapper: StripDomainGroupNameMapper{}, sanitize: true, hierarchyDelimiter: "."}, email: "Eng.Back End@corp.com", want: "eng.back-end"},
+		"slash as the delimiter":            {namer: groupNamer{mapper: teamGroupNameMapper{}, hierarchyDelimiter: "/"}, email: "team-eng/api@corp.com", want: "eng/api"},
+		"empty level":                       {namer: groupNamer{mapper: StripDomainGroupNameMapper{}, hierarchyDelimiter: "."}, email: "eng..api@corp.com", wantErr: "names must be non-empty"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.namer.name(tc.email)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// teamGroupNameMapper is a custom mapper naming team-* groups after the team, and refusing any other group.
+type teamGroupNameMapper struct{}
+
+func (teamGroupNameMapper) Map(googleGroup string) (string, error) {
+	local, _, _ := strings.Cut(googleGroup, "@")
+	team, found := strings.CutPrefix(local, "team-")
+	if !found {
+		return "", errors.New("not a team group")
+	}
+	return team, nil
+}
+
+// Built-in mappers must be picked by name, and unknown names rejected.
+func TestNewGroupNameMapper(t *testing.T) {
+	tests := map[string]struct {
+		name    string
+		want    GroupNameMapper
+		wantErr bool
+	}{
+		"email":        {name: GroupNameMapperEmail, want: EmailGroupNameMapper{}},
+		"strip domain": {name: GroupNameMapperStripDomain, want: StripDomainGroupNameMapper{}},
+		"unknown":      {name: "taxonomy", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := NewGroupNameMapper(tc.name)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Fatalf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+// The source attribute must win over the name, which is only a fallback for older groups.
+func TestSourceGroupOf(t *testing.T) {
+	withAttribute := &gocloak.Group{
+		Name:       gocloak.StringP("dev"),
+		Attributes: &map[string][]string{GroupAttributeSourceGroup: {"dev@corp.com"}},
+	}
+	if got := sourceGroupOf(withAttribute); got != "dev@corp.com" {
+		t.Fatalf("got %q, want %q", got, "dev@corp.com")
+	}
+
+	withoutAttribute := &gocloak.Group{Name: gocloak.StringP("dev@corp.com")}
+	if got := sourceGroupOf(withoutAttribute); got != "dev@corp.com" {
+		t.Fatalf("got %q, want %q", got, "dev@corp.com")
+	}
+}
+
+// Two Gsuite groups mapping to the same name must never be merged: the first one keeps it,
+// the other is skipped and reported.
+func TestReconcileUserGroupsSkipsNameCollisions(t *testing.T) {
+	tests := map[string]struct {
+		children    []*gocloak.Group
+		wantCreated []string
+		wantAdded   []string
+	}{
+		"both groups are new": {
+			wantCreated: []string{"dev"},
+			wantAdded:   []string{"alice-id:id-dev"},
+		},
+		"name owned by another group in Keycloak": {
+			children: []*gocloak.Group{{
+				ID:         gocloak.StringP("id-dev"),
+				Name:       gocloak.StringP("dev"),
+				Attributes: &map[string][]string{GroupAttributeSourceGroup: {"dev@other.com"}},
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, _ := newFakeRealm()
+			kc.children = tc.children
+			kc.userGroups["alice-id"] = nil
+			gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{
+				"corp.com": {"dev@corp.com"},
+				"corp.org": {"dev@corp.org"},
+			}}
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			r.gsuiteDomains = []string{"corp.com", "corp.org"}
+			r.groupNamer = groupNamer{mapper: StripDomainGroupNameMapper{}}
+
+			r.reconcileUserGroups()
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdded) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdded)
+			}
+			if !strings.Contains(logs.String(), "group name collision") {
+				t.Fatalf("expected the collision to be reported, got logs %s", logs.String())
+			}
+		})
+	}
+}
+
+// Groups must be matched by the Gsuite group they mirror, never by name, so a group renamed by hand
+// or created under other naming options is neither duplicated nor left with stale members.
+func TestReconcileUserGroupsMatchesGroupsByIdentity(t *testing.T) {
+	renamed := &gocloak.Group{
+		ID:   gocloak.StringP("id-developers"),
+		Name: gocloak.StringP("Developers"),
+		Attributes: &map[string][]string{
+			GroupAttributeManaged:     {"true"},
+			GroupAttributeSourceGroup: {"dev@corp.com"},
+		},
+	}
+	membership := &gocloak.Group{ID: renamed.ID, Name: renamed.Name, Path: gocloak.StringP("/google-workspace/Developers")}
+
+	tests := map[string]struct {
+		gsuiteGroups  []string
+		userGroups    []*gocloak.Group
+		wantAdditions []string
+		wantDeletions []string
+	}{
+		"member stays":               {gsuiteGroups: []string{"dev@corp.com"}, userGroups: []*gocloak.Group{membership}},
+		"email case does not matter": {gsuiteGroups: []string{"DEV@corp.com"}, userGroups: []*gocloak.Group{membership}},
+		"new member joins the group": {gsuiteGroups: []string{"dev@corp.com"}, wantAdditions: []string{"alice-id:id-developers"}},
+		"former member leaves":       {userGroups: []*gocloak.Group{membership}, wantDeletions: []string{"alice-id:id-developers"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.children = []*gocloak.Group{renamed}
+			kc.userGroups["alice-id"] = tc.userGroups
+			gs.groupsByDomain["corp.com"] = tc.gsuiteGroups
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(kc.created) > 0 {
+				t.Fatalf("expected no group to be created, got %v", kc.created)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+		})
+	}
+}
+
+// With a prefix set or not, synced groups must be found again by identity, new ones named with the prefix,
+// and groups already holding a prefixed name never taken over.
+func TestReconcileUserGroupsWithNamePrefix(t *testing.T) {
+	synced := &gocloak.Group{
+		ID:   gocloak.StringP("id-dev"),
+		Name: gocloak.StringP("g-suite:dev"),
+		Attributes: &map[string][]string{
+			GroupAttributeManaged:     {"true"},
+			GroupAttributeSourceGroup: {"dev@corp.com"},
+		},
+	}
+	handMade := &gocloak.Group{ID: gocloak.StringP("id-hand-made"), Name: gocloak.StringP("g-suite:ops")}
+	membership := &gocloak.Group{ID: synced.ID, Name: synced.Name, Path: gocloak.StringP("/google-workspace/g-suite:dev")}
+
+	tests := map[string]struct {
+		prefix        string
+		userGroups    []*gocloak.Group
+		wantCreated   []string
+		wantAdditions []string
+		wantDeletions []string
+	}{
+		"prefix set": {
+			prefix:        "g-suite:",
+			userGroups:    []*gocloak.Group{membership},
+			wantCreated:   []string{"g-suite:qa"},
+			wantAdditions: []string{"alice-id:id-g-suite:qa"},
+			wantDeletions: []string{"alice-id:id-dev"},
+		},
+		"prefix unset": {
+			userGroups:    []*gocloak.Group{membership},
+			wantCreated:   []string{"ops", "qa"},
+			wantAdditions: []string{"alice-id:id-ops", "alice-id:id-qa"},
+			wantDeletions: []string{"alice-id:id-dev"},
+		},
+		"prefix set on a new member": {
+			prefix:        "g-suite:",
+			wantCreated:   []string{"g-suite:qa"},
+			wantAdditions: []string{"alice-id:id-g-suite:qa"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.children = []*gocloak.Group{synced, handMade}
+			kc.userGroups["alice-id"] = tc.userGroups
+			gs.groupsByDomain["corp.com"] = []string{"ops@corp.com", "qa@corp.com"}
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.groupNamer = groupNamer{mapper: StripDomainGroupNameMapper{}, prefix: tc.prefix}
+			r.reconcileUserGroups()
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+		})
+	}
+}
+
+// A custom mapper must name what is created on every path, while groups it can not name are neither created
+// nor joined, and the existing ones are kept.
+func TestGroupNameMapperOnEveryPath(t *testing.T) {
+	existing := &gocloak.Group{ID: gocloak.StringP("id-ops"), Name: gocloak.StringP("ops"),
+		Attributes: &map[string][]string{GroupAttributeManaged: {"true"}, GroupAttributeSourceGroup: {"ops@corp.com"}}}
+
+	newRunner := func(kc *fakeKeycloakClient) *Runner {
+		gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{"corp.com": {"ops@corp.com", "team-backend@corp.com", "misc@corp.com"}}}
+		r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+		r.groupNamer = groupNamer{mapper: teamGroupNameMapper{}}
+		return r
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		kc, _ := newFakeRealm()
+		kc.children = []*gocloak.Group{existing}
+		kc.userGroups["alice-id"] = []*gocloak.Group{{ID: gocloak.StringP("id-ops"), Name: gocloak.StringP("ops")}}
+		r := newRunner(kc)
+
+		var cycleErr *CycleError
+		if err := r.reconcileUserGroups(); !errors.As(err, &cycleErr) || len(cycleErr.Failures) != 1 {
+			t.Fatalf("expected the group that can not be named to fail, got %v", err)
+		}
+		if want := []string{"backend"}; !reflect.DeepEqual(kc.created, want) {
+			t.Fatalf("created %v, want %v", kc.created, want)
+		}
+		if want := []string{"alice-id:id-backend"}; !reflect.DeepEqual(kc.additions, want) {
+			t.Fatalf("additions %v, want %v", kc.additions, want)
+		}
+		if len(kc.deletions) != 0 {
+			t.Fatalf("deletions %v, want none", kc.deletions)
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		kc, _ := newFakeRealm()
+		kc.children = []*gocloak.Group{existing}
+		kc.userGroups["alice-id"] = []*gocloak.Group{{ID: gocloak.StringP("id-ops"), Name: gocloak.StringP("ops")}}
+		r := newRunner(kc)
+
+		diffs, _ := r.Diff()
+		want := []UserDiff{{Realm: "test", User: "alice@corp.com", Add: []string{"backend"}, Remove: []string{}}}
+		if !reflect.DeepEqual(diffs, want) {
+			t.Fatalf("got %+v, want %+v", diffs, want)
+		}
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		kc, _ := newFakeRoleRealm()
+		r := newRunner(kc)
+
+		r.reconcileUserRoles()
+
+		var created []string
+		for _, role := range kc.createdRoles {
+			created = append(created, *role.Name)
+		}
+		if want := []string{"backend"}; !reflect.DeepEqual(created, want) {
+			t.Fatalf("created roles %v, want %v", created, want)
+		}
+	})
+}
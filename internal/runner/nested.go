@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"strings"
+)
+
+// maxGroupNestingDepth caps how many levels of groups inside groups are followed
+const maxGroupNestingDepth = 10
+
+// expandNestedGroups returns the direct groups followed by every group reachable from them
+// through group-in-group membership. Each group is visited once, so membership cycles end on their own,
+// and nesting deeper than maxGroupNestingDepth is ignored with a warning
+func (r *Runner) expandNestedGroups(directGroups []string, parentsOf func(group string) ([]string, error)) (groups []string, err error) {
+	seen := map[string]struct{}{}
+
+	visit := func(candidates []string) (unseen []string) {
+		for _, group := range candidates {
+			identity := groupIdentity(group)
+			if _, found := seen[identity]; found {
+				continue
+			}
+			seen[identity] = struct{}{}
+			unseen = append(unseen, group)
+		}
+		return unseen
+	}
+
+	level := visit(directGroups)
+	groups = append(groups, level...)
+
+	for depth := 1; len(level) > 0; depth++ {
+		if depth > maxGroupNestingDepth {
+			r.appCtx.Logger.Warn("groups nested too deep. Ignoring further levels...",
+				"max_depth", maxGroupNestingDepth, "groups", level)
+			break
+		}
+
+		var nextLevel []string
+		for _, group := range level {
+			parents, err := parentsOf(group)
+			if err != nil {
+				return nil, err
+			}
+			nextLevel = append(nextLevel, visit(parents)...)
+		}
+
+		groups = append(groups, nextLevel...)
+		level = nextLevel
+	}
+
+	return groups, nil
+}
+
+// getGsuiteParentGroups returns the groups a Gsuite group is a direct member of, asking Google
+// only once per group and cycle
+func (r *Runner) getGsuiteParentGroups(group string) (parents []string, err error) {
+	if r.gsuiteParentGroups == nil {
+		r.gsuiteParentGroups = map[string][]string{}
+	}
+
+	key := strings.ToLower(group)
+	if parents, found := r.gsuiteParentGroups[key]; found {
+		return parents, nil
+	}
+
+	parents, err = r.getGsuiteDirectGroups(group)
+	if err != nil {
+		return nil, err
+	}
+
+	r.gsuiteParentGroups[key] = parents
+	return parents, nil
+}
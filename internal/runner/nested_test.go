@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newNestedDirectory returns a directory where alice is in backend@, which is in engineering@,
+// which is in everyone@. everyone@ is also in backend@, closing a cycle.
+func newNestedDirectory() *fakeDirectory {
+	return &fakeDirectory{membersByDomain: map[string]map[string][]string{
+		"corp.com": {
+			"backend@corp.com":     {"alice@corp.com", "everyone@corp.com"},
+			"engineering@corp.com": {"backend@corp.com"},
+			"everyone@corp.com":    {"engineering@corp.com"},
+			"sales@corp.com":       {"bob@corp.com"},
+		},
+	}}
+}
+
+// Nested groups must only be expanded when asked to, on both lookup modes, and cycles must end.
+func TestReconcileUserGroupsResolvesNestedGroups(t *testing.T) {
+	tests := map[string]struct {
+		prefetch bool
+		nested   bool
+		want     []string
+	}{
+		"per-user without nesting": {want: []string{"backend@corp.com"}},
+		"per-user with nesting":    {nested: true, want: []string{"backend@corp.com", "engineering@corp.com", "everyone@corp.com"}},
+		"prefetch without nesting": {prefetch: true, want: []string{"backend@corp.com"}},
+		"prefetch with nesting":    {prefetch: true, nested: true, want: []string{"backend@corp.com", "engineering@corp.com", "everyone@corp.com"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, _ := newFakeRealm()
+			r := newTestRunner(kc, newNestedDirectory(), &bytes.Buffer{}, false)
+			r.gsuitePrefetch = tc.prefetch
+			r.resolveNestedGroups = tc.nested
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			created := slices.Sorted(slices.Values(kc.created))
+			if !reflect.DeepEqual(created, tc.want) {
+				t.Fatalf("created %v, want %v", created, tc.want)
+			}
+		})
+	}
+}
+
+// Parents of a nested group must be asked to Google once per cycle, whatever the number of users.
+func TestGetGsuiteParentGroupsCachesLookups(t *testing.T) {
+	calls := 0
+	directory := newNestedDirectory()
+	r := newTestRunner(nil, &countingGsuiteClient{gsuiteClient: directory, calls: &calls}, &bytes.Buffer{}, false)
+	r.resolveNestedGroups = true
+
+	for range 3 {
+		if _, err := r.getGsuiteGroupsForUser("alice@corp.com"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// One direct lookup per user query, plus one per nested group the first time only
+	if want := 3 + 3; calls != want {
+		t.Fatalf("got %d calls, want %d", calls, want)
+	}
+}
+
+// Chains deeper than the cap must be cut and reported instead of followed forever.
+func TestExpandNestedGroupsCapsDepth(t *testing.T) {
+	logs := &bytes.Buffer{}
+	r := newTestRunner(nil, nil, logs, false)
+
+	parentsOf := func(group string) ([]string, error) {
+		var level int
+		fmt.Sscanf(group, "level-%d@corp.com", &level)
+		return []string{fmt.Sprintf("level-%d@corp.com", level+1)}, nil
+	}
+
+	groups, err := r.expandNestedGroups([]string{"level-0@corp.com"}, parentsOf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(groups) != maxGroupNestingDepth+1 {
+		t.Fatalf("got %d groups, want %d", len(groups), maxGroupNestingDepth+1)
+	}
+	if !strings.Contains(logs.String(), "groups nested too deep") {
+		t.Fatalf("expected the cut to be reported, got logs %s", logs.String())
+	}
+}
+
+// countingGsuiteClient counts the per-user lookups reaching the wrapped client.
+type countingGsuiteClient struct {
+	gsuiteClient
+	calls *int
+}
+
+func (c *countingGsuiteClient) GetGroupsFromUser(domain string, user string) ([]string, error) {
+	*c.calls++
+	return c.gsuiteClient.GetGroupsFromUser(domain, user)
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CycleNotification is the payload posted to the notification webhook once a reconcile cycle ends.
+// Text is a human readable summary, so Slack compatible webhooks can show it as is
+type CycleNotification struct {
+	Text     string        `json:"text"`
+	CycleID  string        `json:"cycle_id"`
+	Success  bool          `json:"success"`
+	DryRun   bool          `json:"dry_run"`
+	Duration string        `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Realms   []RealmReport `json:"realms"`
+}
+
+// RealmReport counts what a reconcile cycle changed in a single realm, along with the first few failures
+type RealmReport struct {
+	Realm                string   `json:"realm"`
+	UsersProcessed       int      `json:"users_processed"`
+	UsersUnchanged       int      `json:"users_unchanged"`
+	GroupsCreated        int      `json:"groups_created"`
+	MembershipsAdded     int      `json:"memberships_added"`
+	MembershipsRemoved   int      `json:"memberships_removed"`
+	GroupsPruned         int      `json:"groups_pruned"`
+	GsuiteMembersAdded   int      `json:"gsuite_members_added"`
+	GsuiteMembersRemoved int      `json:"gsuite_members_removed"`
+	Errors               int      `json:"errors"`
+	FailureSample        []string `json:"failure_sample,omitempty"`
+}
+
+// notifier posts a CycleNotification to a webhook after every cycle, or only after failed ones
+type notifier struct {
+	url           string
+	onFailureOnly bool
+	client        *http.Client
+}
+
+// newNotifier returns a notifier posting to the webhook through the same proxy as the other outbound calls,
+// each post bound by timeout. A zero timeout leaves posts without deadline
+func newNotifier(webhookURL string, onFailureOnly bool, timeout time.Duration, proxy func(*http.Request) (*url.URL, error)) *notifier {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	return &notifier{
+		url:           webhookURL,
+		onFailureOnly: onFailureOnly,
+		client:        &http.Client{Timeout: timeout, Transport: transport},
+	}
+}
+
+// recordRealmReport keeps the outcome of the realm just reconciled, to be sent once the whole cycle ends
+func (r *Runner) recordRealmReport() {
+	if r.notifier == nil {
+		return
+	}
+
+	report := RealmReport{
+		Realm:                r.realm,
+		UsersProcessed:       r.cycleStats.usersProcessed,
+		UsersUnchanged:       r.cycleStats.usersUnchanged,
+		GroupsCreated:        r.cycleStats.groupsCreated,
+		MembershipsAdded:     r.cycleStats.membershipsAdded,
+		MembershipsRemoved:   r.cycleStats.membershipsRemoved,
+		GroupsPruned:         r.cycleStats.groupsPruned,
+		GsuiteMembersAdded:   r.cycleStats.gsuiteMembersAdded,
+		GsuiteMembersRemoved: r.cycleStats.gsuiteMembersRemoved,
+		Errors:               len(r.cycleFailures),
+	}
+	for _, failure := range r.cycleFailures[:min(len(r.cycleFailures), failureSampleSize)] {
+		report.FailureSample = append(report.FailureSample, failure.Error())
+	}
+
+	r.realmReports = append(r.realmReports, report)
+}
+
+// notifyCycle posts the outcome of the cycle just ended to the webhook, if any. A notification that
+// can not be delivered is only logged, as it must never fail the cycle it tells about
+func (r *Runner) notifyCycle(duration time.Duration, err error) {
+	if r.notifier == nil || (err == nil && r.notifier.onFailureOnly) {
+		return
+	}
+
+	notification := CycleNotification{
+		CycleID:  r.cycleID,
+		Success:  err == nil,
+		DryRun:   r.dryRun,
+		Duration: duration.String(),
+		Realms:   r.realmReports,
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	notification.Text = notification.summary()
+
+	if err := r.notifier.post(r.appCtx.Context, notification); err != nil {
+		r.appCtx.Logger.Error("failed sending cycle notification", "error", err.Error())
+	}
+}
+
+// summary writes the notification as a single line, such as the ones shown by chat webhooks
+func (n CycleNotification) summary() string {
+	var added, removed, errs int
+	for _, realm := range n.Realms {
+		added += realm.MembershipsAdded
+		removed += realm.MembershipsRemoved
+		errs += realm.Errors
+	}
+
+	outcome := "succeeded"
+	if !n.Success {
+		outcome = "failed"
+	}
+	text := fmt.Sprintf("kegos reconcile cycle %s in %s: %d memberships added, %d removed, %d errors",
+		outcome, n.Duration, added, removed, errs)
+	if n.Error != "" {
+		text += ". " + n.Error
+	}
+	return text
+}
+
+// post sends the notification, bound to the context so a shutdown never waits for the webhook
+func (n *notifier) post(ctx context.Context, notification CycleNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebhookServer returns a webhook answering with status and recording every notification posted to it.
+func newWebhookServer(t *testing.T, status int) (server *httptest.Server, received *[]CycleNotification) {
+	t.Helper()
+
+	received = new([]CycleNotification)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request with content type %q", req.Method, req.Header.Get("Content-Type"))
+		}
+
+		var notification CycleNotification
+		if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
+			t.Errorf("unexpected payload: %v", err)
+		}
+		*received = append(*received, notification)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, received
+}
+
+// A summary of every cycle must be posted to the webhook, or only of failed ones when asked to.
+func TestReconcileOnceNotifiesWebhook(t *testing.T) {
+	tests := map[string]struct {
+		breakRealm    func(kc *fakeKeycloakClient)
+		onFailureOnly bool
+		want          []CycleNotification
+	}{
+		"successful cycle": {
+			breakRealm: func(*fakeKeycloakClient) {},
+			want: []CycleNotification{{
+				Text:     "kegos reconcile cycle succeeded in 0s: 1 memberships added, 1 removed, 0 errors",
+				Success:  true,
+				Duration: "0s",
+				Realms: []RealmReport{{
+					Realm: "test", UsersProcessed: 1, GroupsCreated: 1, MembershipsAdded: 1, MembershipsRemoved: 1,
+				}},
+			}},
+		},
+		"cycle with failed operations": {
+			breakRealm: func(kc *fakeKeycloakClient) {
+				kc.membershipErrs = map[string]error{"id-old@corp.com": errors.New("boom")}
+			},
+			want: []CycleNotification{{
+				Text:     "kegos reconcile cycle failed in 0s: 1 memberships added, 0 removed, 1 errors. 1 operations failed during reconcile",
+				Duration: "0s",
+				Error:    "1 operations failed during reconcile",
+				Realms: []RealmReport{{
+					Realm: "test", UsersProcessed: 1, GroupsCreated: 1, MembershipsAdded: 1, Errors: 1,
+					FailureSample: []string{"remove membership (user alice@corp.com, group old@corp.com): boom"},
+				}},
+			}},
+		},
+		"aborted cycle": {
+			breakRealm: func(kc *fakeKeycloakClient) {
+				kc.tokenErr = errors.New("invalid client credentials")
+			},
+			want: []CycleNotification{{
+				Text:     "kegos reconcile cycle failed in 0s: 0 memberships added, 0 removed, 0 errors. realm test: failed renewing Keycloak token: invalid client credentials",
+				Duration: "0s",
+				Error:    "realm test: failed renewing Keycloak token: invalid client credentials",
+			}},
+		},
+		"successful cycle on failure only": {
+			breakRealm:    func(*fakeKeycloakClient) {},
+			onFailureOnly: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			tc.breakRealm(kc)
+			server, received := newWebhookServer(t, http.StatusOK)
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.clock = newFakeClock()
+			r.notifier = newNotifier(server.URL, tc.onFailureOnly, time.Second, nil)
+
+			r.ReconcileOnce()
+
+			for i := range *received {
+				if (*received)[i].CycleID == "" {
+					t.Fatalf("expected the notification to carry the cycle id")
+				}
+				(*received)[i].CycleID = ""
+			}
+			if !reflect.DeepEqual(*received, tc.want) {
+				t.Fatalf("got %+v, want %+v", *received, tc.want)
+			}
+		})
+	}
+}
+
+// A webhook failing must only be logged, leaving the outcome of the cycle alone.
+func TestReconcileOnceIgnoresWebhookFailures(t *testing.T) {
+	kc, gs := newFakeRealm()
+	server, received := newWebhookServer(t, http.StatusInternalServerError)
+
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+	r.notifier = newNotifier(server.URL, false, time.Second, nil)
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*received) != 1 {
+		t.Fatalf("expected a single notification, got %d", len(*received))
+	}
+	if !strings.Contains(logs.String(), "failed sending cycle notification") {
+		t.Fatalf("expected the failed notification to be logged, got logs %s", logs.String())
+	}
+}
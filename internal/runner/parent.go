@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// groupPathSegments splits a Keycloak group path, such as /corp/external/google, into the names of its levels
+func groupPathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// rememberSyncedParentGroup caches the ID of a synced parent group of the current realm, so following cycles
+// do not walk its path again
+func (r *Runner) rememberSyncedParentGroup(parentPath []string, id string) {
+	if r.syncedParentIDs == nil {
+		r.syncedParentIDs = map[string]map[string]string{}
+	}
+	if r.syncedParentIDs[r.realm] == nil {
+		r.syncedParentIDs[r.realm] = map[string]string{}
+	}
+	r.syncedParentIDs[r.realm][groupPathString(parentPath)] = id
+}
+
+// forgetSyncedParentGroup drops the cached synced parent groups of the current realm once Keycloak does not
+// find one of them, along with the synced groups cached under them, so they are resolved again
+func (r *Runner) forgetSyncedParentGroup() {
+	if _, found := r.syncedParentIDs[r.realm]; !found {
+		return
+	}
+
+	r.appCtx.Logger.Info("synced parent group not found in Keycloak. Resolving it again...")
+	delete(r.syncedParentIDs, r.realm)
+	r.invalidateGroupState()
+}
+
+// findSyncedParentGroup walks the path of a synced parent group from the top level by exact names. It returns
+// the deepest group found along with the number of levels it covers, so the parent exists when all of them are
+func (r *Runner) findSyncedParentGroup(parentPath []string) (group *gocloak.Group, depth int, err error) {
+
+	for level, name := range parentPath {
+		var next *gocloak.Group
+		if level == 0 {
+			err = r.withRetry(func() (err error) {
+				next, err = r.keycloak.GetTopLevelGroup(r.keycloak.GetToken().AccessToken, name)
+				return err
+			})
+		} else {
+			// Each page of children is retried by the client itself
+			var children []*gocloak.Group
+			children, err = r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, *group.ID)
+			if err == nil {
+				next, err = groupNamed(children, name)
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if next == nil {
+			return group, level, nil
+		}
+		group = next
+	}
+
+	return group, len(parentPath), nil
+}
+
+// createSyncedParentGroup creates the levels of a synced parent path missing under the deepest existing one,
+// as returned by findSyncedParentGroup, and returns the last level
+func (r *Runner) createSyncedParentGroup(parentPath []string, existing *gocloak.Group, depth int) (group *gocloak.Group, err error) {
+	group = existing
+
+	for _, name := range parentPath[depth:] {
+		newGroup := gocloak.Group{Name: gocloak.StringP(name)}
+
+		var groupID string
+		err = r.withRetry(func() (err error) {
+			if group == nil {
+				groupID, err = r.keycloak.CreateGroup(r.keycloak.GetToken().AccessToken, newGroup)
+				return err
+			}
+			groupID, err = r.keycloak.CreateChildGroup(r.keycloak.GetToken().AccessToken, *group.ID, newGroup)
+			return err
+		})
+		r.audit(AuditTargetKeycloak, AuditActionCreate, "", name, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating group %s: %v", name, err)
+		}
+
+		newGroup.ID = gocloak.StringP(groupID)
+		group = &newGroup
+	}
+
+	return group, nil
+}
+
+// findSyncedChildrenGroups returns the children of every synced parent group keyed by identity, as routed by
+// addRoutedChildren, without creating anything: a missing parent simply has no children yet
+func (r *Runner) findSyncedChildrenGroups() (map[string]*gocloak.Group, error) {
+	kcChildrenGroups := map[string]*gocloak.Group{}
+
+	for _, parentPath := range r.syncedParents() {
+		kcParentGroup, depth, err := r.findSyncedParentGroup(parentPath)
+		if err != nil {
+			return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting parent group %s: %w", groupPathString(parentPath), err))
+		}
+		if depth < len(parentPath) {
+			continue
+		}
+
+		parentChildren, err := r.getChildrenGroupsByIdentity(*kcParentGroup.ID)
+		if err != nil {
+			return nil, err
+		}
+		r.addRoutedChildren(parentPath, parentChildren, kcChildrenGroups)
+	}
+
+	return kcChildrenGroups, nil
+}
+
+// groupNamed returns the group named exactly as given, or nil when there is none.
+// Several groups sharing the name is reported as an error rather than picking one
+func groupNamed(groups []*gocloak.Group, name string) (found *gocloak.Group, err error) {
+	for _, group := range groups {
+		if group.Name == nil || *group.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several groups are named %q", name)
+		}
+		found = group
+	}
+	return found, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newNestedParentRealm returns the usual fake realm with its synced groups moved under /corp/google,
+// next to a decoy /corp/google-old.
+func newNestedParentRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc, gs := newFakeRealm()
+	kc.parent = &gocloak.Group{ID: gocloak.StringP("id-corp"), Name: gocloak.StringP("corp")}
+	kc.childrenByParent = map[string][]*gocloak.Group{
+		"id-corp": {
+			{ID: gocloak.StringP("id-google-old"), Name: gocloak.StringP("google-old")},
+			{ID: gocloak.StringP("id-google"), Name: gocloak.StringP("google")},
+		},
+		"id-google": kc.children,
+	}
+	kc.children = nil
+	return kc, gs
+}
+
+// A nested parent must be resolved level by level, and groups synced under its last level.
+func TestReconcileUserGroupsUnderNestedParent(t *testing.T) {
+	kc, gs := newNestedParentRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.syncedParentPath = groupPathSegments("/corp/google")
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"id-google"}; !reflect.DeepEqual(kc.createdParents, want) {
+		t.Fatalf("created groups under %v, want %v", kc.createdParents, want)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+}
+
+// Missing levels of the parent path must be created under the deepest existing one, except on dry-run.
+func TestReconcileUserGroupsCreatesMissingParentLevels(t *testing.T) {
+	tests := map[string]struct {
+		dryRun      bool
+		wantCreated []string
+		wantParents []string
+	}{
+		"creates the missing levels": {
+			wantCreated: []string{"external", "google", "new@corp.com"},
+			wantParents: []string{"id-corp", "id-external", "id-google"},
+		},
+		"dry-run creates nothing": {dryRun: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newNestedParentRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, tc.dryRun)
+			r.syncedParentPath = groupPathSegments("/corp/external/google/")
+
+			r.reconcileUserGroups()
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.createdParents, tc.wantParents) {
+				t.Fatalf("created groups under %v, want %v", kc.createdParents, tc.wantParents)
+			}
+		})
+	}
+}
+
+// Two groups sharing the name of a level make the parent ambiguous, which must abort the cycle.
+func TestReconcileUserGroupsRejectsAmbiguousParent(t *testing.T) {
+	kc, gs := newNestedParentRealm()
+	kc.childrenByParent["id-corp"] = append(kc.childrenByParent["id-corp"],
+		&gocloak.Group{ID: gocloak.StringP("id-google-2"), Name: gocloak.StringP("google")})
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.syncedParentPath = groupPathSegments("/corp/google")
+
+	if err := r.reconcileUserGroups(); err == nil {
+		t.Fatalf("expected an error for the ambiguous parent")
+	}
+	if len(kc.created)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v", kc.created, kc.additions, kc.deletions)
+	}
+}
+
+// The synced parent group must be resolved once across cycles, and again only after Keycloak no longer finds it.
+func TestSyncedParentGroupResolvedOnce(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	for range 3 {
+		if err := r.ReconcileOnce(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if kc.topLevelLookups != 1 {
+		t.Fatalf("parent looked up %d times, want once", kc.topLevelLookups)
+	}
+
+	// The parent is deleted and created again by someone else, under a new ID
+	kc.goneGroups = map[string]bool{"id-parent": true}
+	kc.parent = &gocloak.Group{ID: gocloak.StringP("id-parent-new"), Name: gocloak.StringP("google-workspace")}
+
+	for range 2 {
+		if err := r.ReconcileOnce(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if kc.topLevelLookups != 2 {
+		t.Fatalf("parent looked up %d times, want twice", kc.topLevelLookups)
+	}
+	if got := r.syncedParentIDs["test"]["/google-workspace"]; got != "id-parent-new" {
+		t.Fatalf("cached parent %q, want id-parent-new", got)
+	}
+}
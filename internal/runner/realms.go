@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	//
+	"kegos/internal/keycloak"
+)
+
+// KeycloakRealm is a realm to reconcile along with the service account logging into it.
+// Empty credentials fall back to the ones shared by every realm in RunnerOptions
+type KeycloakRealm struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+}
+
+// realmClient is the Keycloak client logged into a single realm
+type realmClient struct {
+	name     string
+	keycloak keycloakClient
+}
+
+// keycloakRequiredRoles returns the realm management client roles the service account of every realm needs
+// for what the runner is configured to do. Only reads are needed when nothing is written to Keycloak
+func (r *Runner) keycloakRequiredRoles(readOnly bool) []string {
+	roles := []string{keycloak.RoleViewUsers}
+	if r.syncTarget == SyncTargetRoles {
+		roles = append(roles, keycloak.RoleViewRealm)
+	}
+	if readOnly || r.dryRun || r.direction == DirectionKeycloakToGoogle {
+		return roles
+	}
+
+	roles = append(roles, keycloak.RoleManageUsers)
+	if r.syncTarget == SyncTargetRoles {
+		roles = append(roles, keycloak.RoleManageRealm)
+	}
+	return roles
+}
+
+// forEachRealm runs fn once per realm, pointing the runner to the realm's client and tagging logs with its name.
+// A realm failing never stops the following ones. When every failing realm ran to the end, their failures are
+// merged into a single *CycleError. Otherwise the errors of all failing realms are joined, as told by CycleAborted
+func (r *Runner) forEachRealm(fn func(realm string) error) error {
+	appCtx := r.appCtx
+	defer func() {
+		r.appCtx = appCtx
+	}()
+
+	var errs []error
+	var failures []OperationFailure
+	aborted := false
+
+	for _, realm := range r.realms {
+		if appCtx.Context.Err() != nil {
+			errs = append(errs, appCtx.Context.Err())
+			aborted = true
+			break
+		}
+
+		r.appCtx = appCtx.WithLogAttrs("realm", realm.name)
+		r.realm = realm.name
+		r.keycloak = realm.keycloak
+
+		err := fn(realm.name)
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("realm %s: %w", realm.name, err))
+		var cycleErr *CycleError
+		if !CycleAborted(err) && errors.As(err, &cycleErr) {
+			failures = append(failures, cycleErr.Failures...)
+			continue
+		}
+		aborted = true
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if !aborted {
+		return &CycleError{Failures: failures}
+	}
+	return &abortedError{err: errors.Join(errs...)}
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newTwoRealmRunner returns a runner over two realms: "engineering", shaped like newFakeRealm, and "sales",
+// where alice is already in sync and bob still has to join.
+func newTwoRealmRunner(logs *bytes.Buffer) (r *Runner, engineering, sales *fakeKeycloakClient) {
+	engineering, gs := newFakeRealm()
+
+	sales, _ = newFakeRealm()
+	sales.children = []*gocloak.Group{
+		{ID: gocloak.StringP("sales-new@corp.com"), Name: gocloak.StringP("new@corp.com"),
+			Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}},
+	}
+	sales.users = append(sales.users, &gocloak.User{ID: gocloak.StringP("bob-id"),
+		Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+	sales.userGroups = map[string][]*gocloak.Group{
+		"alice-id": {{ID: gocloak.StringP("sales-new@corp.com"), Name: gocloak.StringP("new@corp.com")}},
+	}
+
+	r = newTestRunner(engineering, gs, logs, false)
+	r.realms = []realmClient{
+		{name: "engineering", keycloak: engineering},
+		{name: "sales", keycloak: sales},
+	}
+	return r, engineering, sales
+}
+
+// Every realm must be reconciled against its own membership state.
+func TestReconcileOnceReconcilesEveryRealm(t *testing.T) {
+	r, engineering, sales := newTwoRealmRunner(&bytes.Buffer{})
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(engineering.deletions, want) {
+		t.Errorf("engineering deletions = %v, want %v", engineering.deletions, want)
+	}
+	if want := []string{"new@corp.com"}; !reflect.DeepEqual(engineering.created, want) {
+		t.Errorf("engineering created = %v, want %v", engineering.created, want)
+	}
+	if len(engineering.additions) != 1 || !strings.HasPrefix(engineering.additions[0], "alice-id:") {
+		t.Errorf("engineering additions = %v, want alice only", engineering.additions)
+	}
+
+	if len(sales.created)+len(sales.deletions) > 0 {
+		t.Errorf("sales created %v and deleted %v, want nothing", sales.created, sales.deletions)
+	}
+	if want := []string{"bob-id:sales-new@corp.com"}; !reflect.DeepEqual(sales.additions, want) {
+		t.Errorf("sales additions = %v, want %v", sales.additions, want)
+	}
+}
+
+// A realm failing, even before reconciling anything, must not keep the following ones from being reconciled.
+func TestReconcileOnceIsolatesRealmFailures(t *testing.T) {
+	tests := map[string]struct {
+		engineeringTokenErr error
+		membershipErrs      map[string]error
+		wantCycleErr        bool
+	}{
+		"failed login": {
+			engineeringTokenErr: errors.New("invalid client"),
+		},
+		"failed operations": {
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			wantCycleErr:   true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			r, engineering, sales := newTwoRealmRunner(logs)
+			engineering.tokenErr = tc.engineeringTokenErr
+			engineering.membershipErrs = tc.membershipErrs
+
+			err := r.ReconcileOnce()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			_, isCycleErr := err.(*CycleError)
+			if isCycleErr != tc.wantCycleErr || CycleAborted(err) == tc.wantCycleErr {
+				t.Fatalf("got error %T, want a *CycleError: %v", err, tc.wantCycleErr)
+			}
+			if !isCycleErr && !strings.Contains(err.Error(), "realm engineering") {
+				t.Fatalf("got error %v, want it to name the failing realm", err)
+			}
+
+			if want := []string{"bob-id:sales-new@corp.com"}; !reflect.DeepEqual(sales.additions, want) {
+				t.Errorf("sales additions = %v, want %v", sales.additions, want)
+			}
+			if !strings.Contains(logs.String(), `"realm":"sales"`) {
+				t.Errorf("expected logs tagged with the realm, got %s", logs.String())
+			}
+		})
+	}
+}
+
+// Diff must report the drift of every realm, tagged with it.
+func TestDiffCoversEveryRealm(t *testing.T) {
+	r, _, _ := newTwoRealmRunner(&bytes.Buffer{})
+
+	diffs, err := r.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []UserDiff{
+		{Realm: "engineering", User: "alice@corp.com", Add: []string{"new@corp.com"}, Remove: []string{"old@corp.com"}},
+		{Realm: "sales", User: "bob@corp.com", Add: []string{"new@corp.com"}, Remove: []string{}},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("got %+v, want %+v", diffs, want)
+	}
+}
+
+// Only the roles needed by what the runner writes to Keycloak must be required from its service account.
+func TestKeycloakRequiredRoles(t *testing.T) {
+	tests := map[string]struct {
+		syncTarget string
+		direction  string
+		dryRun     bool
+		readOnly   bool
+		want       []string
+	}{
+		"groups":             {want: []string{"view-users", "manage-users"}},
+		"groups on dry-run":  {dryRun: true, want: []string{"view-users"}},
+		"diff":               {readOnly: true, want: []string{"view-users"}},
+		"roles":              {syncTarget: SyncTargetRoles, want: []string{"view-users", "view-realm", "manage-users", "manage-realm"}},
+		"roles on dry-run":   {syncTarget: SyncTargetRoles, dryRun: true, want: []string{"view-users", "view-realm"}},
+		"keycloak to google": {direction: DirectionKeycloakToGoogle, want: []string{"view-users"}},
+		"bidirectional":      {direction: DirectionBidirectional, want: []string{"view-users", "manage-users"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{syncTarget: tc.syncTarget, direction: tc.direction, dryRun: tc.dryRun}
+			if got := r.keycloakRequiredRoles(tc.readOnly); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
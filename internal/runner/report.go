@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// ReportFormatJSON prints the diff report as an array of UserDiff objects, and the export as an array of
+	// GroupMembers objects
+	ReportFormatJSON = "json"
+
+	// ReportFormatTable prints the diff report as aligned columns, one row per user, and the export one row per group
+	ReportFormatTable = "table"
+
+	// ReportFormatCSV prints the diff report as CSV with a header, one row per group a user joins or leaves, and
+	// the export one row per group member
+	ReportFormatCSV = "csv"
+)
+
+// WriteDiffReport writes the diff report in the given format. Rows keep the order of diffs
+func WriteDiffReport(w io.Writer, format string, diffs []UserDiff) error {
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diffs)
+
+	case ReportFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "REALM\tUSER\tADD\tREMOVE")
+		for _, userDiff := range diffs {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", userDiff.Realm, userDiff.User,
+				joinOrDash(userDiff.Add), joinOrDash(userDiff.Remove))
+		}
+		return table.Flush()
+
+	case ReportFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"realm", "user", "action", "group"})
+		for _, userDiff := range diffs {
+			for _, group := range userDiff.Add {
+				writer.Write([]string{userDiff.Realm, userDiff.User, "add", group})
+			}
+			for _, group := range userDiff.Remove {
+				writer.Write([]string{userDiff.Realm, userDiff.User, "remove", group})
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// WriteExportReport writes the managed groups and their members in the given format. Rows keep the order of groups,
+// and CSV gives groups without members a single row with an empty member so they are not lost
+func WriteExportReport(w io.Writer, format string, groups []GroupMembers) error {
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+
+	case ReportFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "REALM\tGROUP\tSOURCE GROUP\tMEMBERS")
+		for _, group := range groups {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", group.Realm, group.Group, group.SourceGroup, joinOrDash(group.Members))
+		}
+		return table.Flush()
+
+	case ReportFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"realm", "group", "source_group", "member"})
+		for _, group := range groups {
+			if len(group.Members) == 0 {
+				writer.Write([]string{group.Realm, group.Group, group.SourceGroup, ""})
+			}
+			for _, member := range group.Members {
+				writer.Write([]string{group.Realm, group.Group, group.SourceGroup, member})
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// joinOrDash joins values with commas, or returns a dash so empty cells stay visible in a table
+func joinOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The same diff report must be rendered in every format, keeping the order of the users.
+func TestWriteDiffReport(t *testing.T) {
+	diffs := []UserDiff{
+		{Realm: "corp", User: "alice", Add: []string{"eng@corp.com", "ops@corp.com"}, Remove: []string{}},
+		{Realm: "corp", User: "bob", Add: []string{}, Remove: []string{"sales, emea@corp.com"}},
+	}
+
+	tests := map[string]struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		"json": {
+			format: ReportFormatJSON,
+			want: `[
+  {
+    "realm": "corp",
+    "user": "alice",
+    "add": [
+      "eng@corp.com",
+      "ops@corp.com"
+    ],
+    "remove": []
+  },
+  {
+    "realm": "corp",
+    "user": "bob",
+    "add": [],
+    "remove": [
+      "sales, emea@corp.com"
+    ]
+  }
+]
+`,
+		},
+		"table": {
+			format: ReportFormatTable,
+			want: "REALM  USER   ADD                        REMOVE\n" +
+				"corp   alice  eng@corp.com,ops@corp.com  -\n" +
+				"corp   bob    -                          sales, emea@corp.com\n",
+		},
+		"csv": {
+			format: ReportFormatCSV,
+			want: "realm,user,action,group\n" +
+				"corp,alice,add,eng@corp.com\n" +
+				"corp,alice,add,ops@corp.com\n" +
+				"corp,bob,remove,\"sales, emea@corp.com\"\n",
+		},
+		"unknown": {
+			format:  "yaml",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := WriteDiffReport(&out, tc.format, diffs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+			if out.String() != tc.want {
+				t.Fatalf("got report:\n%s\nwant:\n%s", out.String(), tc.want)
+			}
+		})
+	}
+}
+
+// The export must be rendered in every format, groups without members included.
+func TestWriteExportReport(t *testing.T) {
+	groups := []GroupMembers{
+		{Realm: "corp", Group: "eng", SourceGroup: "eng@corp.com", Members: []string{"alice", "bob"}},
+		{Realm: "corp", Group: "ops", SourceGroup: "ops@corp.com", Members: []string{}},
+	}
+
+	tests := map[string]struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		"json": {
+			format: ReportFormatJSON,
+			want: `[
+  {
+    "realm": "corp",
+    "group": "eng",
+    "source_group": "eng@corp.com",
+    "members": [
+      "alice",
+      "bob"
+    ]
+  },
+  {
+    "realm": "corp",
+    "group": "ops",
+    "source_group": "ops@corp.com",
+    "members": []
+  }
+]
+`,
+		},
+		"table": {
+			format: ReportFormatTable,
+			want: "REALM  GROUP  SOURCE GROUP  MEMBERS\n" +
+				"corp   eng    eng@corp.com  alice,bob\n" +
+				"corp   ops    ops@corp.com  -\n",
+		},
+		"csv": {
+			format: ReportFormatCSV,
+			want: "realm,group,source_group,member\n" +
+				"corp,eng,eng@corp.com,alice\n" +
+				"corp,eng,eng@corp.com,bob\n" +
+				"corp,ops,ops@corp.com,\n",
+		},
+		"unknown": {
+			format:  "yaml",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := WriteExportReport(&out, tc.format, groups)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+			if out.String() != tc.want {
+				t.Fatalf("got report:\n%s\nwant:\n%s", out.String(), tc.want)
+			}
+		})
+	}
+}
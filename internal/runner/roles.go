@@ -0,0 +1,344 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+// roleProvenance returns the attributes marking a realm role as mirrored from a Gsuite group by kegos
+func roleProvenance(sourceGroup string) *map[string][]string {
+	return &map[string][]string{
+		GroupAttributeManaged:     {"true"},
+		GroupAttributeSourceGroup: {sourceGroup},
+	}
+}
+
+// roleIdentityOf returns the key of the Gsuite group a realm role mirrors, as given by groupIdentity
+func roleIdentityOf(role *gocloak.Role) string {
+	return groupIdentity(sourceGroupFrom(role.Attributes, *role.Name))
+}
+
+// reconcileUserRoles runs a full reconcile cycle mirroring Gsuite groups as realm roles instead of groups.
+// Realm roles share a single namespace with the ones created by hand, so only roles marked as managed are
+// ever unassigned, and a name already taken is never adopted
+func (r *Runner) reconcileUserRoles() (err error) {
+
+	r.cycleFailures = nil
+	r.cycleStats = cycleStats{}
+	r.caps.reset()
+	r.gsuiteParentGroups = nil
+	r.gsuiteGroupDescriptions = nil
+	metrics.ReconcileRuns.Inc()
+	reconcileStart := r.clock.Now()
+	defer func() {
+		duration := r.clock.Now().Sub(reconcileStart)
+		metrics.ReconcileDuration.Observe(duration.Seconds())
+		r.logPartialSync()
+		r.logCycleSummary(duration, err)
+	}()
+
+	// 1. Retrieve realm roles, by name and, for the managed ones, by identity
+	var kcRoles []*gocloak.Role
+	err = r.withRetry(func() (err error) {
+		kcRoles, err = r.keycloak.GetRealmRoles(r.keycloak.GetToken().AccessToken)
+		return err
+	})
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get realm roles", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting realm roles from Keycloak: %w", err))
+	}
+
+	kcRolesByName := map[string]*gocloak.Role{}
+	kcManagedRoles := map[string]*gocloak.Role{}
+	for _, kcRole := range kcRoles {
+		kcRolesByName[*kcRole.Name] = kcRole
+		if hasManagedMark(kcRole.Attributes) {
+			kcManagedRoles[roleIdentityOf(kcRole)] = kcRole
+		}
+	}
+	metrics.ManagedGroups.Set(float64(len(kcManagedRoles)))
+
+	// 2. Retrieve users
+	kcUsers, err := r.getKeycloakUsers()
+	if err != nil {
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get users", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting users from Keycloak: %w", err))
+	}
+
+	// 3. Optionally resolve the whole Gsuite membership upfront instead of querying per user
+	var gsuiteMemberships map[string][]string
+	if r.gsuitePrefetch {
+		gsuiteMemberships, err = r.prefetchGsuiteMemberships()
+		if err != nil {
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "prefetch memberships", Err: err})
+			return withStage(ErrGsuite, fmt.Errorf("failed prefetching groups from Gsuite: %w", err))
+		}
+		r.readiness.MarkGsuiteAuthenticated()
+	}
+
+	// Every Gsuite group seen this cycle by identity
+	seenGroups := map[string]string{}
+
+	// 4. Reconcile role assignments in Keycloak having Gsuite as source of truth
+	for _, kcUser := range kcUsers {
+		kcUsername := *kcUser.Username
+
+		userKey := r.getUserMatchKey(kcUser)
+		if userKey == "" {
+			r.appCtx.Logger.Warn("user has no value for the match attribute. Ignoring user...",
+				"user", kcUsername, "attribute", r.userMatchAttribute)
+			continue
+		}
+
+		userDelay := r.userDelay
+		if r.gsuitePrefetch {
+			userDelay = 0
+		}
+
+		// Stop between users so a shutdown never leaves a user half reconciled
+		if !r.sleep(userDelay) {
+			r.appCtx.Logger.Info("context cancelled. stopping reconcile cycle")
+			return r.appCtx.Context.Err()
+		}
+
+		err = r.keycloak.EnsureToken()
+		if err != nil {
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "renew token", Err: err})
+			return withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
+		}
+
+		r.appCtx.Logger.Info("reconciling user roles", "user", kcUsername)
+
+		var gsuiteGroups []string
+		gsuiteGroups, err = r.getGsuiteGroups(userKey, gsuiteMemberships)
+		if err != nil {
+			r.appCtx.Logger.Error("failed getting groups from Gsuite. Ignoring user...", "user", kcUsername, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "get user groups", User: kcUsername, Err: err})
+			continue
+		}
+		r.readiness.MarkGsuiteAuthenticated()
+
+		// Ignored groups are neither added nor, below, removed
+		gsuiteGroups = r.groupFilter.filter(gsuiteGroups)
+		slices.Sort(gsuiteGroups)
+		gsuiteGroups = r.caps.capGroups(gsuiteGroups)
+
+		var kcUserRoles []*gocloak.Role
+		err = r.withRetry(func() (err error) {
+			kcUserRoles, err = r.keycloak.GetUserRealmRoles(*kcUser.ID, r.keycloak.GetToken().AccessToken)
+			return err
+		})
+		if err != nil {
+			r.appCtx.Logger.Error("failed getting user roles. Ignoring user...", "user", kcUsername, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get user roles", User: kcUsername, Err: err})
+			continue
+		}
+
+		// Identities of the roles the user must hold, mapped to the Gsuite group each one is
+		desiredRoles := r.resolveRoleNames(gsuiteGroups, kcManagedRoles, kcRolesByName)
+
+		maps.Copy(seenGroups, desiredRoles)
+
+		// Changes that would be applied, reported per user on dry-run
+		var plannedDeletions, plannedAdditions, plannedCreations []string
+
+		// Deletions
+		// Managed roles assigned in Keycloak whose group is not attached in Gsuite
+		userRoleNames := map[string]struct{}{}
+		for _, kcUserRole := range kcUserRoles {
+			userRoleNames[*kcUserRole.Name] = struct{}{}
+
+			// Role mappings come without attributes, so ownership is checked on the realm role
+			managedRole, found := kcRolesByName[*kcUserRole.Name]
+			if !found || !hasManagedMark(managedRole.Attributes) {
+				continue
+			}
+
+			// Ignore roles filtered out or beyond the cap, their assignments are left as they are
+			sourceGroup := sourceGroupFrom(managedRole.Attributes, *managedRole.Name)
+			if !r.groupFilter.allows(sourceGroup) || !r.caps.admitsGroup(sourceGroup) {
+				continue
+			}
+
+			if _, desired := desiredRoles[roleIdentityOf(managedRole)]; desired {
+				continue
+			}
+
+			if r.dryRun {
+				plannedDeletions = append(plannedDeletions, *managedRole.Name)
+				continue
+			}
+
+			r.appCtx.Logger.Debug("deleting role from user", "user", kcUsername, "role", *managedRole.Name)
+
+			delUserRoleErr := r.withRetry(func() error {
+				return r.keycloak.DeleteRealmRoleFromUser(r.keycloak.GetToken().AccessToken, *kcUser.ID, *managedRole)
+			})
+			r.audit(AuditTargetKeycloak, AuditActionRemove, kcUsername, *managedRole.Name, delUserRoleErr)
+			if delUserRoleErr != nil {
+				r.appCtx.Logger.Error("failed deleting role from user", "user", kcUsername,
+					"role", *managedRole.Name, "error", delUserRoleErr.Error())
+				r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "remove role", User: kcUsername, Group: *managedRole.Name, Err: delUserRoleErr})
+				continue
+			}
+			metrics.GroupDeletions.Inc()
+			r.cycleStats.membershipsRemoved++
+		}
+
+		// Additions
+		// Groups attached in Gsuite whose role is not assigned in Keycloak
+		for _, gsuiteGroup := range gsuiteGroups {
+
+			// Ignore groups dropped because of a name collision, or repeated with another case
+			identity := groupIdentity(gsuiteGroup)
+			if desiredRoles[identity] != gsuiteGroup {
+				continue
+			}
+
+			kcRole, found := kcManagedRoles[identity]
+			if found {
+				if _, assigned := userRoleNames[*kcRole.Name]; assigned {
+					continue
+				}
+			}
+
+			if !found {
+				// Naming failures were reported while resolving role names, which dropped the group
+				roleName, err := r.groupNamer.name(gsuiteGroup)
+				if err != nil {
+					continue
+				}
+
+				if r.dryRun {
+					// Remember the role so it is reported as a creation only once per cycle
+					plannedCreations = append(plannedCreations, roleName)
+					kcRole = &gocloak.Role{Name: gocloak.StringP(roleName), Attributes: roleProvenance(gsuiteGroup)}
+				} else {
+					kcRole, err = r.createRealmRole(roleName, gsuiteGroup)
+					if err != nil {
+						r.appCtx.Logger.Error("failed creating role in Keycloak", "role", roleName, "error", err.Error())
+						r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "create role", Group: roleName, Err: err})
+						continue
+					}
+					metrics.GroupCreations.Inc()
+					r.cycleStats.groupsCreated++
+				}
+
+				kcManagedRoles[identity] = kcRole
+				kcRolesByName[roleName] = kcRole
+			}
+
+			if r.dryRun {
+				plannedAdditions = append(plannedAdditions, *kcRole.Name)
+				continue
+			}
+
+			r.appCtx.Logger.Debug("adding role to user", "user", kcUsername, "role", *kcRole.Name)
+			addUserRoleErr := r.withRetry(func() error {
+				return r.keycloak.AddRealmRoleToUser(r.keycloak.GetToken().AccessToken, *kcUser.ID, *kcRole)
+			})
+			r.audit(AuditTargetKeycloak, AuditActionAdd, kcUsername, *kcRole.Name, addUserRoleErr)
+			if addUserRoleErr != nil {
+				r.appCtx.Logger.Error("failed adding role to user",
+					"user", kcUsername, "role", *kcRole.Name, "error", addUserRoleErr.Error())
+				r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "add role", User: kcUsername, Group: *kcRole.Name, Err: addUserRoleErr})
+				continue
+			}
+			metrics.GroupAdditions.Inc()
+			r.cycleStats.membershipsAdded++
+		}
+
+		metrics.UsersProcessed.Inc()
+		r.cycleStats.usersProcessed++
+
+		if r.dryRun && len(plannedDeletions)+len(plannedAdditions)+len(plannedCreations) > 0 {
+			r.appCtx.Logger.Info("dry-run: would reconcile user roles", "user", kcUsername,
+				"additions", plannedAdditions, "deletions", plannedDeletions, "creations", plannedCreations)
+		}
+	}
+
+	// 5. Point out Gsuite members the assignments above could not reach. Users left out by the caps would show up
+	if r.reportUnmatchedMembers && !r.caps.partial() {
+		r.logUnmatchedMembers(slices.Sorted(maps.Values(seenGroups)), kcUsers, gsuiteMemberships)
+	}
+
+	return r.cycleError()
+}
+
+// createRealmRole creates the realm role mirroring a Gsuite group and reads it back, as assigning it needs its ID
+func (r *Runner) createRealmRole(roleName, gsuiteGroup string) (kcRole *gocloak.Role, err error) {
+	r.appCtx.Logger.Debug("creating missing role in Keycloak", "role", roleName)
+
+	newRole := gocloak.Role{
+		Name:       gocloak.StringP(roleName),
+		Attributes: roleProvenance(gsuiteGroup),
+	}
+	if r.copyGroupDescription {
+		if description := r.gsuiteGroupDescription(gsuiteGroup); description != "" {
+			newRole.Description = gocloak.StringP(description)
+		}
+	}
+
+	err = r.withRetry(func() error {
+		return r.keycloak.CreateRealmRole(r.keycloak.GetToken().AccessToken, newRole)
+	})
+	r.audit(AuditTargetKeycloak, AuditActionCreate, "", roleName, err)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.withRetry(func() (err error) {
+		kcRole, err = r.keycloak.GetRealmRole(r.keycloak.GetToken().AccessToken, roleName)
+		return err
+	})
+	return kcRole, err
+}
+
+// resolveRoleNames maps the Gsuite groups of a user to the identities of the realm roles they must hold.
+// Managed roles are matched by identity whatever their name. A new role whose name is already taken, by any
+// realm role or by a group earlier in the list, is a collision: it is dropped and reported instead of adopted
+func (r *Runner) resolveRoleNames(gsuiteGroups []string, kcManagedRoles, kcRolesByName map[string]*gocloak.Role) (desiredRoles map[string]string) {
+	desiredRoles = map[string]string{}
+	newRoleOwners := map[string]string{}
+
+	for _, gsuiteGroup := range gsuiteGroups {
+		identity := groupIdentity(gsuiteGroup)
+		if _, found := desiredRoles[identity]; found {
+			continue
+		}
+
+		if _, found := kcManagedRoles[identity]; found {
+			desiredRoles[identity] = gsuiteGroup
+			continue
+		}
+
+		roleName, err := r.groupNamer.name(gsuiteGroup)
+		if err != nil {
+			r.appCtx.Logger.Error("failed naming role. Ignoring group...", "group", gsuiteGroup, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "name role", Group: gsuiteGroup, Err: err})
+			continue
+		}
+		owner := newRoleOwners[roleName]
+		if kcRole, found := kcRolesByName[roleName]; found {
+			owner = sourceGroupFrom(kcRole.Attributes, roleName)
+		}
+		if owner != "" {
+			r.appCtx.Logger.Error("role name collision. Ignoring group...",
+				"group", gsuiteGroup, "name", roleName, "owner", owner)
+			continue
+		}
+
+		newRoleOwners[roleName] = gsuiteGroup
+		desiredRoles[identity] = gsuiteGroup
+	}
+
+	return desiredRoles
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newFakeRoleRealm returns a realm where alice holds one stale managed role and one manual role,
+// and belongs in Google to a group that has no role yet.
+func newFakeRoleRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc, gs := newFakeRealm()
+	kc.realmRoles = []*gocloak.Role{
+		{ID: gocloak.StringP("role-id-old@corp.com"), Name: gocloak.StringP("old@corp.com"),
+			Attributes: roleProvenance("old@corp.com")},
+		{ID: gocloak.StringP("role-id-admin"), Name: gocloak.StringP("admin")},
+	}
+	kc.userRoles = map[string][]*gocloak.Role{
+		"alice-id": {
+			{ID: gocloak.StringP("role-id-old@corp.com"), Name: gocloak.StringP("old@corp.com")},
+			{ID: gocloak.StringP("role-id-admin"), Name: gocloak.StringP("admin")},
+		},
+	}
+	return kc, gs
+}
+
+// Roles must be created and assigned for new Gsuite groups, and only managed ones unassigned.
+func TestReconcileUserRolesAppliesChanges(t *testing.T) {
+	kc, gs := newFakeRoleRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	if err := r.reconcileUserRoles(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.createdRoles) != 1 || *kc.createdRoles[0].Name != "new@corp.com" {
+		t.Fatalf("created roles %v, want new@corp.com only", kc.createdRoles)
+	}
+	if !hasManagedMark(kc.createdRoles[0].Attributes) || roleIdentityOf(&kc.createdRoles[0]) != "new@corp.com" {
+		t.Fatalf("expected the created role to carry its provenance, got %v", *kc.createdRoles[0].Attributes)
+	}
+	if want := []string{"alice-id:new@corp.com"}; !reflect.DeepEqual(kc.roleAdditions, want) {
+		t.Fatalf("role additions %v, want %v", kc.roleAdditions, want)
+	}
+	if want := []string{"alice-id:old@corp.com"}; !reflect.DeepEqual(kc.roleDeletions, want) {
+		t.Fatalf("role deletions %v, want %v", kc.roleDeletions, want)
+	}
+	if len(kc.created)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected groups to be left alone, got created %v, additions %v, deletions %v", kc.created, kc.additions, kc.deletions)
+	}
+}
+
+// Users already holding the role of each of their groups must not be touched.
+func TestReconcileUserRolesKeepsAssignedRoles(t *testing.T) {
+	kc, _ := newFakeRoleRealm()
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{"corp.com": {"old@corp.com"}}}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	if err := r.reconcileUserRoles(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.createdRoles)+len(kc.roleAdditions)+len(kc.roleDeletions) > 0 {
+		t.Fatalf("expected no changes, got created %v, additions %v, deletions %v", kc.createdRoles, kc.roleAdditions, kc.roleDeletions)
+	}
+}
+
+// A role created by hand must never be adopted by a Gsuite group mapping to its name.
+func TestReconcileUserRolesSkipsNameCollisions(t *testing.T) {
+	kc, _ := newFakeRoleRealm()
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{"corp.com": {"admin@corp.com"}}}
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+	r.groupNamer = groupNamer{mapper: StripDomainGroupNameMapper{}}
+
+	r.reconcileUserRoles()
+
+	if len(kc.createdRoles)+len(kc.roleAdditions) > 0 {
+		t.Fatalf("expected the colliding role to be skipped, got created %v, additions %v", kc.createdRoles, kc.roleAdditions)
+	}
+	if !strings.Contains(logs.String(), `"msg":"role name collision. Ignoring group..."`) {
+		t.Fatalf("expected the collision to be reported, got %s", logs.String())
+	}
+}
+
+// On dry-run no role must be created, assigned or unassigned, but every planned change must be reported.
+func TestReconcileUserRolesDryRunOnlyReports(t *testing.T) {
+	kc, gs := newFakeRoleRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+
+	r.reconcileUserRoles()
+
+	if len(kc.createdRoles)+len(kc.roleAdditions)+len(kc.roleDeletions) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v", kc.createdRoles, kc.roleAdditions, kc.roleDeletions)
+	}
+
+	output := logs.String()
+	for _, want := range []string{
+		`"msg":"dry-run: would reconcile user roles"`,
+		`"additions":["new@corp.com"]`,
+		`"deletions":["old@corp.com"]`,
+		`"creations":["new@corp.com"]`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected logs to contain %s, got %s", want, output)
+		}
+	}
+}
+
+// ReconcileOnce must reconcile roles instead of groups when they are the sync target.
+func TestReconcileOnceHonoursSyncTarget(t *testing.T) {
+	kc, gs := newFakeRoleRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.syncTarget = SyncTargetRoles
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.roleAdditions) == 0 {
+		t.Fatalf("expected roles to be reconciled")
+	}
+	if len(kc.created)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected groups to be left alone, got created %v, additions %v, deletions %v", kc.created, kc.additions, kc.deletions)
+	}
+}
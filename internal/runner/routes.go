@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"gopkg.in/yaml.v3"
+)
+
+// parentRoute syncs the Gsuite groups whose email starts with a prefix under a parent group of their own
+type parentRoute struct {
+	prefix string
+	path   []string
+}
+
+// loadParentRoutes reads a YAML file mapping Gsuite group email prefixes to the paths of the Keycloak groups
+// their groups are synced under, such as eng-: /engineering. Routes are returned longest prefix first,
+// so the most specific one wins
+func loadParentRoutes(path string) ([]parentRoute, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]string
+	if err := yaml.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no parent group routes")
+	}
+
+	var routes []parentRoute
+	seen := map[string]struct{}{}
+	for _, prefix := range slices.Sorted(maps.Keys(entries)) {
+		normalized := groupIdentity(strings.TrimSpace(prefix))
+		if normalized == "" {
+			return nil, fmt.Errorf("route without prefix")
+		}
+		if _, found := seen[normalized]; found {
+			return nil, fmt.Errorf("route of prefix %s given more than once", prefix)
+		}
+		seen[normalized] = struct{}{}
+
+		parentPath := strings.TrimSpace(entries[prefix])
+		segments := groupPathSegments(parentPath)
+		if !strings.HasPrefix(parentPath, "/") || slices.Contains(segments, "") {
+			return nil, fmt.Errorf("invalid parent group %q for prefix %s: it must be a group path like /engineering", parentPath, prefix)
+		}
+		routes = append(routes, parentRoute{prefix: normalized, path: segments})
+	}
+
+	slices.SortStableFunc(routes, func(a, b parentRoute) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return routes, nil
+}
+
+// groupPathString returns the levels of a group path as a Keycloak group path, such as /corp/google
+func groupPathString(path []string) string {
+	return "/" + strings.Join(path, "/")
+}
+
+// parentOf returns the path of the synced parent group a Gsuite group is synced under: the one of the longest
+// prefix its email starts with, or the default synced parent group. It is nil when the group matches no prefix
+// and there is no default
+func (r *Runner) parentOf(gsuiteGroup string) []string {
+	identity := groupIdentity(gsuiteGroup)
+	for _, route := range r.parentRoutes {
+		if strings.HasPrefix(identity, route.prefix) {
+			return route.path
+		}
+	}
+	return r.syncedParentPath
+}
+
+// syncedParents returns the paths of every synced parent group, each one once and sorted: those routed to,
+// and the default synced parent group when there is one
+func (r *Runner) syncedParents() (parents [][]string) {
+	byPath := map[string][]string{}
+	for _, route := range r.parentRoutes {
+		byPath[groupPathString(route.path)] = route.path
+	}
+	if r.syncedParentPath != nil {
+		byPath[groupPathString(r.syncedParentPath)] = r.syncedParentPath
+	}
+
+	for _, parent := range slices.Sorted(maps.Keys(byPath)) {
+		parents = append(parents, byPath[parent])
+	}
+	return parents
+}
+
+// routedGroups drops the Gsuite groups matching no parent route when there is no default synced parent group.
+// They are left alone, as groups filtered out are
+func (r *Runner) routedGroups(gsuiteGroups []string) (routed []string) {
+	if r.syncedParentPath != nil {
+		return gsuiteGroups
+	}
+	for _, gsuiteGroup := range gsuiteGroups {
+		if r.parentOf(gsuiteGroup) != nil {
+			routed = append(routed, gsuiteGroup)
+		}
+	}
+	return routed
+}
+
+// addRoutedChildren copies the children of a synced parent group into the given groups, skipping those whose
+// Gsuite group is routed to another parent, so every parent only manages the groups routed to it.
+// Groups left behind by a route changed since are thus neither changed nor pruned
+func (r *Runner) addRoutedChildren(parentPath []string, parentChildren, groups map[string]*gocloak.Group) {
+	parent := groupPathString(parentPath)
+	for identity, kcGroup := range parentChildren {
+		if len(r.parentRoutes) > 0 && groupPathString(r.parentOf(sourceGroupOf(kcGroup))) != parent {
+			continue
+		}
+		groups[identity] = kcGroup
+	}
+}
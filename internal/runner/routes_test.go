@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Routes must be lowercased and tried longest prefix first, and rejected when ambiguous or not group paths.
+func TestLoadParentRoutes(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    []parentRoute
+		wantErr string
+	}{
+		"longest prefix first": {
+			content: "eng-: /engineering\nENG-PLATFORM-: /engineering/platform\nsales-: /sales\n",
+			want: []parentRoute{
+				{prefix: "eng-platform-", path: []string{"engineering", "platform"}},
+				{prefix: "sales-", path: []string{"sales"}},
+				{prefix: "eng-", path: []string{"engineering"}},
+			},
+		},
+		"empty file": {
+			wantErr: "no parent group routes",
+		},
+		"same prefix in another case": {
+			content: "eng-: /engineering\nENG-: /eng\n",
+			wantErr: "given more than once",
+		},
+		"relative parent": {
+			content: "eng-: engineering\n",
+			wantErr: "must be a group path",
+		},
+		"empty parent level": {
+			content: "eng-: /corp//engineering\n",
+			wantErr: "must be a group path",
+		},
+		"not a mapping": {
+			content: "- eng-\n",
+			wantErr: "cannot unmarshal",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "parents.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := loadParentRoutes(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Routing to several parents must be rejected along with the options tracking groups for a single one,
+// as embedders skip the flag validation.
+func TestNewRunnerRejectsParentRoutesWithSingleParentOptions(t *testing.T) {
+	tests := map[string]RunnerOptions{
+		"state file":          {StateFile: "state.json"},
+		"hierarchy delimiter": {GroupHierarchyDelimiter: "."},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts.AppCtx = newTestAppCtx(&bytes.Buffer{})
+			opts.SyncedParentGroupsFile = filepath.Join(t.TempDir(), "parents.yaml")
+			if err := os.WriteFile(opts.SyncedParentGroupsFile, []byte("eng-: /engineering\n"), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := NewRunner(opts); err == nil || !strings.Contains(err.Error(), "not supported") {
+				t.Fatalf("got error %v, want the combination rejected", err)
+			}
+		})
+	}
+}
+
+// Groups must be synced under the parent of the longest prefix they start with, or the default one.
+func TestParentOf(t *testing.T) {
+	routes := []parentRoute{
+		{prefix: "eng-platform-", path: []string{"engineering", "platform"}},
+		{prefix: "eng-", path: []string{"engineering"}},
+	}
+
+	tests := map[string]struct {
+		defaultParent []string
+		group         string
+		want          []string
+	}{
+		"matching prefix":         {group: "eng-backend@corp.com", want: []string{"engineering"}},
+		"longest prefix":          {group: "eng-platform-sre@corp.com", want: []string{"engineering", "platform"}},
+		"prefix in another case":  {group: "ENG-Backend@corp.com", want: []string{"engineering"}},
+		"no prefix, default":      {defaultParent: []string{"google"}, group: "misc@corp.com", want: []string{"google"}},
+		"no prefix, skipped":      {group: "misc@corp.com"},
+		"prefix not at the start": {group: "team-eng-x@corp.com"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{parentRoutes: routes, syncedParentPath: tc.defaultParent}
+			if got := r.parentOf(tc.group); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// newRoutedParentsRealm returns a realm where /corp/engineering and /corp/sales hold synced groups, alice
+// belonging to a stale engineering group and to one of sales left under engineering by an older route.
+func newRoutedParentsRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	managed := func(name string) *gocloak.Group {
+		return &gocloak.Group{ID: gocloak.StringP("id-" + name), Name: gocloak.StringP(name),
+			Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}}
+	}
+
+	kc := &fakeKeycloakClient{
+		parent: &gocloak.Group{ID: gocloak.StringP("id-corp"), Name: gocloak.StringP("corp")},
+		childrenByParent: map[string][]*gocloak.Group{
+			"id-corp": {
+				{ID: gocloak.StringP("id-engineering"), Name: gocloak.StringP("engineering")},
+				{ID: gocloak.StringP("id-sales"), Name: gocloak.StringP("sales")},
+				{ID: gocloak.StringP("id-google"), Name: gocloak.StringP("google")},
+			},
+			"id-engineering": {managed("eng-old@corp.com"), managed("sales-legacy@corp.com")},
+			"id-sales":       {managed("sales-team@corp.com")},
+			"id-google":      {},
+		},
+		users: []*gocloak.User{
+			{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice@corp.com"), Email: gocloak.StringP("alice@corp.com")},
+		},
+		userGroups: map[string][]*gocloak.Group{
+			"alice-id": {managed("eng-old@corp.com"), managed("sales-legacy@corp.com")},
+		},
+	}
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{
+		"corp.com": {"eng-new@corp.com", "sales-team@corp.com", "misc@corp.com"},
+	}}
+	return kc, gs
+}
+
+// Groups must be created and joined under the parent they are routed to, and every parent must only remove
+// memberships of the groups routed to it.
+func TestReconcileUserGroupsRoutesParents(t *testing.T) {
+	tests := map[string]struct {
+		defaultParent string
+		wantCreated   []string
+		wantParents   []string
+		wantAdditions []string
+	}{
+		"default parent": {
+			defaultParent: "/corp/google",
+			wantCreated:   []string{"eng-new@corp.com", "misc@corp.com"},
+			wantParents:   []string{"id-engineering", "id-google"},
+			wantAdditions: []string{"alice-id:id-eng-new@corp.com", "alice-id:id-misc@corp.com", "alice-id:id-sales-team@corp.com"},
+		},
+		"groups matching no prefix skipped": {
+			wantCreated:   []string{"eng-new@corp.com"},
+			wantParents:   []string{"id-engineering"},
+			wantAdditions: []string{"alice-id:id-eng-new@corp.com", "alice-id:id-sales-team@corp.com"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newRoutedParentsRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.parentRoutes = []parentRoute{
+				{prefix: "eng-", path: groupPathSegments("/corp/engineering")},
+				{prefix: "sales-", path: groupPathSegments("/corp/sales")},
+			}
+			r.syncedParentPath = nil
+			if tc.defaultParent != "" {
+				r.syncedParentPath = groupPathSegments(tc.defaultParent)
+			}
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if !reflect.DeepEqual(kc.createdParents, tc.wantParents) {
+				t.Fatalf("created groups under %v, want %v", kc.createdParents, tc.wantParents)
+			}
+			slices.Sort(kc.additions)
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+
+			// The sales group left under engineering is not routed there, so its membership is left alone
+			if want := []string{"alice-id:id-eng-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, want)
+			}
+		})
+	}
+}
@@ -4,38 +4,265 @@
 package runner
 
 import (
-	"context"
+	"cmp"
 	"fmt"
+	"maps"
+	"math/rand/v2"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	//
 	"github.com/Nerzal/gocloak/v13"
 	"kegos/internal/globals"
 	"kegos/internal/gsuite"
+	"kegos/internal/health"
 	"kegos/internal/keycloak"
+	"kegos/internal/metrics"
+	"kegos/internal/proxy"
+	"kegos/internal/retry"
+)
+
+const (
+	UserMatchAttributeUsername = "username"
+	UserMatchAttributeEmail    = "email"
+
+	SyncTargetGroups = "groups"
+	SyncTargetRoles  = "roles"
 )
 
 // gsuiteClient is the subset of the Gsuite admin API the runner depends on.
 type gsuiteClient interface {
 	GetGroupsFromUser(domain string, user string) (groups []string, err error)
+	GetAllGroups(domain string) (groups []string, err error)
+	GetGroupsMembers(groups []string) (groupsMembers []gsuite.GroupMembers, err error)
+	GetUsersFromGroup(group string) (memberList []string, err error)
+	GetAllGroupsDetailed(domain string) (groups []gsuite.Group, err error)
+	InsertMember(group, email string) error
+	DeleteMember(group, email string) error
+	CheckToken() error
+	Validate(domain string) error
+}
+
+// keycloakClient is the subset of the Keycloak helper the runner depends on.
+type keycloakClient interface {
+	EnsureToken() error
+	GetToken() *gocloak.JWT
+	GetTopLevelGroup(accessToken, name string) (*gocloak.Group, error)
+	CreateGroup(accessToken string, group gocloak.Group) (string, error)
+	CreateChildGroup(accessToken, parentID string, group gocloak.Group) (string, error)
+	GetChildrenGroups(accessToken, groupID string) ([]*gocloak.Group, error)
+	GetUsers(accessToken string) ([]*gocloak.User, error)
+	GetUserByUsername(accessToken, username string) (*gocloak.User, error)
+	GetUserByEmail(accessToken, email string) (*gocloak.User, error)
+	EnsureUserAttributes(accessToken, userID string, attributes map[string][]string) (bool, error)
+	GetUserGroups(userID, accessToken string) ([]*gocloak.Group, error)
+	GetGroupMembers(accessToken, groupID string) ([]*gocloak.User, error)
+	UpdateGroupMemberships(accessToken string, changes []keycloak.MembershipChange, retryOpts retry.Options) []error
+	DeleteGroup(accessToken, groupID string) error
+	EnsureGroupAttributes(accessToken, groupID string, attributes map[string][]string) (bool, error)
+	GetRealmRoles(accessToken string) ([]*gocloak.Role, error)
+	GetRealmRole(accessToken, name string) (*gocloak.Role, error)
+	CreateRealmRole(accessToken string, role gocloak.Role) error
+	GetUserRealmRoles(userID, accessToken string) ([]*gocloak.Role, error)
+	AddRealmRoleToUser(accessToken, userID string, role gocloak.Role) error
+	DeleteRealmRoleFromUser(accessToken, userID string, role gocloak.Role) error
 }
 
 type RunnerOptions struct {
 	AppCtx *globals.ApplicationContext
 
 	GsuiteJsonCredentialsPath string
+	GsuiteJsonCredentials     string
+	GsuiteImpersonateSubject  string
 	GsuiteDomains             []string
+	GsuitePrefetch            bool
+	GsuiteGroupQuery          string
+	GsuiteMemberRoles         []string
+	GsuiteQPS                 float64
+	GsuiteConcurrency         int
+	GsuiteEndpoint            string
+	ResolveNestedGroups       bool
+	GroupIncludePatterns      []string
+	GroupExcludePatterns      []string
+	RestrictToDomain          bool
+	GroupNameStripDomain      bool
+	GroupNameSanitize         bool
+	GroupNamePrefix           string
 	UserRateLimit             int
+	UserMatchAttribute        string
+	ReportUnmatchedMembers    bool
+	UserEnabledOnly           bool
+	UserRequireEmail          bool
+
+	// SkipFederatedUsers leaves out the users federated from a user storage provider, whose memberships
+	// may be managed upstream
+	SkipFederatedUsers bool
+
+	// GroupNameMapper names the groups before GroupNameSanitize and GroupNamePrefix apply, for custom naming
+	// compiled in. When nil, the built-in mapper named by GroupNameMapperName is used, or the one stripping the
+	// domain with GroupNameStripDomain
+	GroupNameMapper     GroupNameMapper
+	GroupNameMapperName string
+
+	// GroupMappingFile is a YAML file mapping Gsuite group emails to the names of the Keycloak groups they grant
+	// membership in, instead of the group mirroring them. Gsuite groups left out keep being mirrored
+	GroupMappingFile string
+
+	// CopyGroupDescription sets the description of the Gsuite group on the groups and roles created for it
+	CopyGroupDescription bool
+
+	// GroupHierarchyDelimiter splits group names into levels, nesting every synced group under groups named
+	// after the levels before its own, such as eng.backend.api with a dot. Names are flat when empty
+	GroupHierarchyDelimiter string
+
+	// UserAttributeMatches restrict the reconciled users to those having every attribute, written as key=value
+	UserAttributeMatches []string
+
+	// ExcludedUsers are never reconciled, matched by username or email whatever their case. ExcludedUsersFile
+	// adds those it lists, one per line
+	ExcludedUsers     []string
+	ExcludedUsersFile string
+
+	// OnlyGroups scopes every cycle to these Gsuite groups, by email whatever their case, so memberships of any
+	// other synced group are neither added nor removed. OnlyGroupsFile adds those it lists, one per line
+	OnlyGroups     []string
+	OnlyGroupsFile string
 
-	KeycloakURI          string
-	KeycloakRealm        string
+	KeycloakURI string
+
+	// KeycloakRealms are reconciled one after another on every cycle
+	KeycloakRealms       []KeycloakRealm
 	KeycloakClientID     string
 	KeycloakClientSecret string
 
+	// KeycloakAuthRealm is the realm the shared client logs in to, when it lives apart from the realms it
+	// administers. Realms given their own client log in to themselves
+	KeycloakAuthRealm string
+
+	// KeycloakClientJWTKeyPath points to the PEM private key the realms without their own secret
+	// log in with, through a signed JWT instead of a client secret
+	KeycloakClientJWTKeyPath string
+
+	KeycloakTimeout     time.Duration
+	KeycloakCACertPath  string
+	KeycloakInsecure    bool
+	KeycloakConcurrency int
+
+	// KeycloakMaxRetryAfterWait caps how long a single Keycloak request may wait for the Retry-After of the 429
+	// responses sent under load before being retried. Zero fails those requests right away
+	KeycloakMaxRetryAfterWait time.Duration
+
+	// KeycloakGroupSearchExact looks the top-level parent group up by an exact search instead of listing every
+	// top-level group, still comparing the results by name and path
+	KeycloakGroupSearchExact bool
+
+	// KeycloakUserBatchSize and KeycloakGroupBatchSize are the page sizes of Keycloak listings.
+	// They default to keycloak.DefaultBatchSize when zero
+	KeycloakUserBatchSize  int
+	KeycloakGroupBatchSize int
+
 	ReconcileLoopDuration time.Duration
+	ReconcileJitter       time.Duration
 	SyncedParentGroup     string
+
+	// BreakerFailures is how many aborted cycles in a row make the loop back off, doubling the wait
+	// between cycles up to BreakerMaxBackoff until one runs to the end. Zero disables it
+	BreakerFailures   int
+	BreakerMaxBackoff time.Duration
+
+	// SyncedParentGroupPath is the full path of a parent group, such as /corp/google, which may be nested.
+	// It is used instead of SyncedParentGroup when set
+	SyncedParentGroupPath string
+
+	// SyncedParentGroupsFile is a YAML file mapping Gsuite group email prefixes to the paths of the parent groups
+	// their groups are synced under, such as eng-: /engineering. Groups matching no prefix are synced under
+	// SyncedParentGroup or SyncedParentGroupPath, or skipped when neither is set
+	SyncedParentGroupsFile string
+
+	// SyncTarget is what Gsuite groups become in Keycloak: SyncTargetGroups or SyncTargetRoles.
+	// It defaults to SyncTargetGroups when empty
+	SyncTarget string
+
+	// Direction is where memberships are written: DirectionGoogleToKeycloak, DirectionKeycloakToGoogle or
+	// DirectionBidirectional. It defaults to DirectionGoogleToKeycloak when empty
+	Direction string
+
+	DryRun      bool
+	PruneGroups bool
+
+	// MaxUsers and MaxGroups cap the users, and the Gsuite groups, processed per cycle, taking the first ones
+	// in sorted order. Meant to experiment on part of a realm, they disable pruning. Zero disables them
+	MaxUsers  int
+	MaxGroups int
+
+	// ReadOnly tells the runner is only used to Diff, so the Keycloak write permissions are not checked at startup
+	ReadOnly bool
+
+	// Incremental skips, after the first cycle, the users whose Gsuite and Keycloak groups are the same
+	// as when they were last found in sync
+	Incremental bool
+
+	// MaxDeletionsPerCycle holds back every membership deletion of a cycle planning more than this many,
+	// written as a count such as 50 or as a percentage of the managed memberships such as 10%.
+	// Empty or zero disables the guard
+	MaxDeletionsPerCycle string
+
+	// SoftDelete defers every membership removal until Gsuite has left the user out of the group for
+	// SoftDeleteGrace, cancelling it when the user is back meanwhile
+	SoftDelete      bool
+	SoftDeleteGrace time.Duration
+
+	// Events receives a ReconcileEvent for every cycle started and finished, change applied and failure, for
+	// commands of this module driving the runner, as it can not be imported from others. Events are sent without blocking, so those a slow consumer has no room
+	// for are dropped rather than stalling cycles: give it a buffer and drain it. Nothing is published when nil
+	Events chan<- ReconcileEvent
+
+	// AnnotateUsers stamps the time of the cycle on every user reconciled without failures, in the
+	// UserAttributeLastSynced attribute
+	AnnotateUsers bool
+
+	// DebounceCycles holds back every membership change until it has been planned on this many consecutive
+	// cycles, so memberships flapping in Gsuite are left alone. Zero or one applies changes right away
+	DebounceCycles int
+
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+
+	// APITimeout bounds every call to Keycloak and Gsuite on its own. Zero leaves calls without deadline
+	APITimeout time.Duration
+
+	// HTTPProxy and HTTPSProxy are the proxies calls to Keycloak and Gsuite go through.
+	// Empty values fall back to the proxy environment variables
+	HTTPProxy  string
+	HTTPSProxy string
+
+	// HeartbeatFile receives the RFC3339 time of the last cycle that ran to the end. Disabled when empty
+	HeartbeatFile string
+
+	// NotifyWebhookURL receives a CycleNotification, as JSON, after every cycle. Disabled when empty
+	NotifyWebhookURL string
+
+	// NotifyOnFailureOnly restricts the notifications to the cycles that failed
+	NotifyOnFailureOnly bool
+
+	// StateFile caches the synced Keycloak groups between cycles and restarts. Disabled when empty
+	StateFile string
+
+	// AuditLogFile receives an AuditRecord, as a JSON line, for every change sent to Keycloak. Disabled when empty.
+	// It is kept open until Close, which PleaseDoYourStuffForever calls on return
+	AuditLogFile string
+
+	// Readiness receives the outcome of every cycle. It is optional
+	Readiness *health.Readiness
+
+	// FailOnPartial counts cycles that ran to the end with some failed operations as failed, as aborted
+	// ones are, both for Readiness and for CycleFailed
+	FailOnPartial bool
+
+	// Clock tells the time and paces the reconcile loop. It defaults to the system clock when nil
+	Clock Clock
 }
 
 type Runner struct {
@@ -44,15 +271,115 @@ type Runner struct {
 	//
 	gsuiteJsonCredentialsPath string
 	gsuiteDomains             []string
+	gsuitePrefetch            bool
+	resolveNestedGroups       bool
+	groupFilter               groupFilter
+	userFilter                userFilter
+	groupNamer                groupNamer
+	groupHierarchy            groupHierarchy
+	groupMapping              groupMapping
 	userDelay                 time.Duration
+	userMatchAttribute        string
+	reportUnmatchedMembers    bool
+	copyGroupDescription      bool
 
 	//
 	reconcileLoopDuration time.Duration
-	syncedParentGroup     string
+	reconcileJitter       time.Duration
+	syncedParentPath      []string
+	syncTarget            string
+	direction             string
+	dryRun                bool
+	pruneGroups           bool
+	deletionLimit         deletionLimit
+	incremental           bool
+	retryOpts             retry.Options
+
+	// softDeleteGrace defers membership removals for that long, removing them right away when zero
+	softDeleteGrace time.Duration
+
+	// pendingRemovals holds, per realm, when every deferred membership removal was first planned, keyed
+	// as given by removalKey. It is kept in the state file when enabled, so restarts do not reset the grace
+	pendingRemovals map[string]map[string]time.Time
+
+	// events receives the ReconcileEvent of the cycles when set
+	events chan<- ReconcileEvent
+
+	// annotateUsers stamps the users reconciled without failures with UserAttributeLastSynced
+	annotateUsers bool
+
+	// debounceCycles is the number of consecutive cycles a membership change must be planned on to be applied
+	debounceCycles int
+
+	// pendingChanges holds, per realm, the consecutive cycles every debounced membership change has been
+	// planned on, keyed as given by changeKey. It only lives in memory
+	pendingChanges map[string]map[string]int
+
+	// cycleMu serializes reconcile cycles, as single users can be reconciled while the loop runs
+	cycleMu sync.Mutex
+
+	// caps bounds the users and groups processed per cycle
+	caps cycleCaps
+
+	// syncedParentIDs caches, per realm, the ID of every synced parent group by path once resolved, until
+	// Keycloak answers that one of them is not found
+	syncedParentIDs map[string]map[string]string
+
+	// parentRoutes sync the Gsuite groups matching their prefix under their own parent group instead of
+	// syncedParentPath, which is nil when groups matching none are skipped
+	parentRoutes []parentRoute
+
+	// scopedUser is the only user the running cycle reconciles, nil for cycles over the whole realm
+	scopedUser *gocloak.User
+
+	// cycleFailures collects the failed operations of the running reconcile cycle
+	cycleFailures []OperationFailure
+	cycleStats    cycleStats
+	cycleID       string
+
+	// realmReports collects the outcome of every realm reconciled by the running cycle, to be notified
+	realmReports []RealmReport
+
+	// userSnapshots holds, per realm, the snapshot of every user found in sync by the last cycle
+	userSnapshots map[string]map[string]string
+
+	// memberBaselines holds, per realm and group identity, the members both sides shared after the last cycle
+	memberBaselines map[string]map[string]memberSet
+
+	// gsuiteParentGroups caches, for the running cycle, the groups each nested group belongs to
+	gsuiteParentGroups map[string][]string
+
+	// gsuiteGroupDescriptions caches, for the running cycle, the description of every Gsuite group by identity
+	gsuiteGroupDescriptions map[string]string
+
+	// groupState caches the synced groups between cycles when enabled. groupStateKey is the entry of the
+	// realm being reconciled, and childrenFromState tells whether its groups were read from it this cycle
+	groupState        *groupState
+	groupStateKey     string
+	childrenFromState bool
+
+	readiness     *health.Readiness
+	breaker       circuitBreaker
+	heartbeatFile string
+	auditLog      *auditLog
+	notifier      *notifier
+	clock         Clock
+
+	// failOnPartial counts cycles with some failed operations as failed, see CycleFailed
+	failOnPartial bool
 
 	//
-	gsuiteCli gsuiteClient
-	keycloak  *keycloak.Keycloak
+	gsuiteCli    gsuiteClient
+	newGsuiteCli func() (gsuiteClient, error)
+
+	// gsuiteCredentialsDigest is the digest of the credentials file the Gsuite client was built from, as given
+	// by fileDigest, so its rotations are noticed
+	gsuiteCredentialsDigest string
+
+	// realms holds a client per reconciled realm. realm and keycloak are the ones being reconciled
+	realms   []realmClient
+	realm    string
+	keycloak keycloakClient
 }
 
 func NewRunner(opts RunnerOptions) (*Runner, error) {
@@ -61,113 +388,444 @@ func NewRunner(opts RunnerOptions) (*Runner, error) {
 		appCtx:                    opts.AppCtx,
 		gsuiteJsonCredentialsPath: opts.GsuiteJsonCredentialsPath,
 		gsuiteDomains:             opts.GsuiteDomains,
-		userDelay:                 userDelayFromRate(opts.UserRateLimit),
+		gsuitePrefetch:            opts.GsuitePrefetch,
+		resolveNestedGroups:       opts.ResolveNestedGroups,
+		groupNamer: groupNamer{
+			mapper:             opts.GroupNameMapper,
+			sanitize:           opts.GroupNameSanitize,
+			prefix:             opts.GroupNamePrefix,
+			hierarchyDelimiter: opts.GroupHierarchyDelimiter,
+		},
+		userDelay:              userDelayFromRate(opts.UserRateLimit),
+		userMatchAttribute:     opts.UserMatchAttribute,
+		reportUnmatchedMembers: opts.ReportUnmatchedMembers,
+		copyGroupDescription:   opts.CopyGroupDescription,
 
 		reconcileLoopDuration: opts.ReconcileLoopDuration,
-		syncedParentGroup:     opts.SyncedParentGroup,
+		reconcileJitter:       opts.ReconcileJitter,
+		syncedParentPath:      []string{opts.SyncedParentGroup},
+		syncTarget:            opts.SyncTarget,
+		direction:             cmp.Or(opts.Direction, DirectionGoogleToKeycloak),
+		dryRun:                opts.DryRun,
+		pruneGroups:           opts.PruneGroups,
+		caps:                  cycleCaps{maxUsers: opts.MaxUsers, maxGroups: opts.MaxGroups},
+		incremental:           opts.Incremental,
+		userSnapshots:         map[string]map[string]string{},
+		memberBaselines:       map[string]map[string]memberSet{},
+		pendingRemovals:       map[string]map[string]time.Time{},
+		debounceCycles:        opts.DebounceCycles,
+		annotateUsers:         opts.AnnotateUsers,
+		events:                opts.Events,
+		pendingChanges:        map[string]map[string]int{},
+		retryOpts: retry.Options{
+			MaxRetries: opts.MaxRetries,
+			BaseDelay:  opts.RetryBaseDelay,
+		},
+		readiness:     opts.Readiness,
+		failOnPartial: opts.FailOnPartial,
+		breaker:       circuitBreaker{threshold: opts.BreakerFailures, maxBackoff: opts.BreakerMaxBackoff},
+		heartbeatFile: opts.HeartbeatFile,
+		clock:         opts.Clock,
+	}
+
+	if runner.clock == nil {
+		runner.clock = systemClock{}
 	}
 
-	gsuiteCli, err := gsuite.NewAdmin(context.Background(), runner.gsuiteJsonCredentialsPath)
+	if opts.SyncedParentGroupPath != "" {
+		runner.syncedParentPath = groupPathSegments(opts.SyncedParentGroupPath)
+	} else if opts.SyncedParentGroup == "" {
+		runner.syncedParentPath = nil
+	}
+
+	groupFilter, err := newGroupFilter(opts.GroupIncludePatterns, opts.GroupExcludePatterns)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating gsuite client: %v", err)
+		return nil, err
+	}
+	runner.groupFilter = groupFilter
+	if opts.RestrictToDomain {
+		runner.groupFilter.restrictToDomains(opts.GsuiteDomains)
+	}
 
+	runner.groupFilter.restrictToGroups(opts.OnlyGroups)
+	if opts.OnlyGroupsFile != "" {
+		onlyGroups, err := readListFile(opts.OnlyGroupsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading only groups file: %v", err)
+		}
+		runner.groupFilter.restrictToGroups(onlyGroups)
 	}
 
-	keycloakObj, err := keycloak.NewKeycloak(keycloak.KeycloakOptions{
-		AppCtx: opts.AppCtx,
+	userFilter, err := newUserFilter(opts.UserEnabledOnly, opts.UserRequireEmail, opts.UserAttributeMatches)
+	if err != nil {
+		return nil, err
+	}
+	runner.userFilter = userFilter
+	runner.userFilter.skipFederated = opts.SkipFederatedUsers
 
-		URI:          opts.KeycloakURI,
-		Realm:        opts.KeycloakRealm,
-		ClientID:     opts.KeycloakClientID,
-		ClientSecret: opts.KeycloakClientSecret,
-	})
+	runner.userFilter.exclude(opts.ExcludedUsers)
+	if opts.ExcludedUsersFile != "" {
+		excludedUsers, err := readListFile(opts.ExcludedUsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading excluded users file: %v", err)
+		}
+		runner.userFilter.exclude(excludedUsers)
+	}
+
+	if runner.groupNamer.mapper == nil {
+		mapperName := cmp.Or(opts.GroupNameMapperName, GroupNameMapperEmail)
+		if opts.GroupNameStripDomain {
+			mapperName = GroupNameMapperStripDomain
+		}
+		runner.groupNamer.mapper, err = NewGroupNameMapper(mapperName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runner.deletionLimit, err = parseDeletionLimit(opts.MaxDeletionsPerCycle)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating keycloak client: %v", err)
+		return nil, err
+	}
 
+	if opts.GroupMappingFile != "" {
+		runner.groupMapping, err = loadGroupMapping(opts.GroupMappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading group mapping file: %v", err)
+		}
 	}
 
-	runner.gsuiteCli = &gsuiteCli
-	runner.keycloak = keycloakObj
+	if opts.SyncedParentGroupsFile != "" {
+		// Cached and nested groups are tracked for a single parent group, so routing to several ones would mix them up
+		if opts.StateFile != "" {
+			return nil, fmt.Errorf("synced parent groups file is not supported along with a state file")
+		}
+		if opts.GroupHierarchyDelimiter != "" {
+			return nil, fmt.Errorf("synced parent groups file is not supported along with a group hierarchy delimiter")
+		}
+		runner.parentRoutes, err = loadParentRoutes(opts.SyncedParentGroupsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading synced parent groups file: %v", err)
+		}
+	}
 
-	return runner, nil
-}
+	if opts.StateFile != "" {
+		runner.groupState, err = loadGroupState(opts.StateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading state file: %v", err)
+		}
+		runner.pendingRemovals = runner.groupState.PendingRemovals
+	}
 
-// getKeycloakChildrenGroups TODO
-func (r *Runner) getKeycloakChildrenGroups() (parentGroup *string, childrenGroups map[string]*gocloak.Group, err error) {
+	if opts.SoftDelete {
+		runner.softDeleteGrace = opts.SoftDeleteGrace
+		opts.AppCtx.Logger.Info("soft delete is active. Membership removals are deferred", "grace", opts.SoftDeleteGrace.String())
+	}
 
-	// 1. Try retrieving Keycloak parent group
-	kcExistingGroups, err := r.keycloak.GetGocloakClient().GetGroups(
-		r.appCtx.Context, r.keycloak.GetToken().AccessToken, r.keycloak.Realm, gocloak.GetGroupsParams{
-			Full:   gocloak.BoolP(true),
-			Exact:  gocloak.BoolP(true),
-			Max:    gocloak.IntP(1),
-			Search: gocloak.StringP(r.syncedParentGroup),
+	if opts.DebounceCycles > 1 {
+		opts.AppCtx.Logger.Info("debouncing is active. Membership changes wait for consecutive cycles", "cycles", opts.DebounceCycles)
+	}
+
+	proxyFunc := proxy.Func(proxy.Options{HTTPProxy: opts.HTTPProxy, HTTPSProxy: opts.HTTPSProxy})
+
+	if opts.NotifyWebhookURL != "" {
+		runner.notifier = newNotifier(opts.NotifyWebhookURL, opts.NotifyOnFailureOnly, opts.APITimeout, proxyFunc)
+	}
+
+	// The Gsuite client is rebuilt from the credentials whenever its token can not be refreshed anymore
+	runner.newGsuiteCli = func() (gsuiteClient, error) {
+		gsuiteCli, err := gsuite.NewAdmin(gsuite.AdminOptions{
+			Ctx:                   opts.AppCtx.Context,
+			JsonFilepath:          runner.gsuiteJsonCredentialsPath,
+			JsonCredentials:       []byte(opts.GsuiteJsonCredentials),
+			ImpersonateSubject:    opts.GsuiteImpersonateSubject,
+			MemberRoles:           opts.GsuiteMemberRoles,
+			GroupQuery:            opts.GsuiteGroupQuery,
+			QPS:                   opts.GsuiteQPS,
+			MaxConcurrentRequests: opts.GsuiteConcurrency,
+			Endpoint:              opts.GsuiteEndpoint,
+			CallTimeout:           opts.APITimeout,
+			Proxy:                 proxyFunc,
+			Logger:                opts.AppCtx.Logger,
+			Writable:              runner.writesToGsuite(),
 		})
+		if err != nil {
+			return nil, err
+		}
+		return &gsuiteCli, nil
+	}
+
+	runner.gsuiteCli, err = runner.newGsuiteCli()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed getting parent group: %v", err)
+		return nil, fmt.Errorf("failed creating gsuite client: %v", err)
+	}
+	if runner.gsuiteJsonCredentialsPath != "" {
+		runner.gsuiteCredentialsDigest, err = fileDigest(runner.gsuiteJsonCredentialsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading gsuite credentials: %v", err)
+		}
+	}
+
+	// Missing scopes or delegation would otherwise only show up deep in the first cycle
+	for _, domain := range runner.gsuiteDomains {
+		if err = runner.gsuiteCli.Validate(domain); err != nil {
+			return nil, withStage(ErrGsuite, fmt.Errorf("failed validating gsuite access: %w", err))
+		}
 	}
 
-	// 2. Retrieve children groups for the found parent.
-	// When the parent is not found, create it
-	kcParentGroup := gocloak.Group{}
-	kcChildrenGroups := []*gocloak.Group{}
+	// Every realm gets its own client, as the service account may differ between them
+	for _, realm := range opts.KeycloakRealms {
+		clientID := cmp.Or(realm.ClientID, opts.KeycloakClientID)
+		clientSecret := cmp.Or(realm.ClientSecret, opts.KeycloakClientSecret)
+
+		// A secret given for the realm itself wins over the shared private key
+		clientJWTKeyPath := opts.KeycloakClientJWTKeyPath
+		if realm.ClientSecret != "" {
+			clientJWTKeyPath = ""
+		}
+
+		// A client given for the realm itself lives in that realm
+		authRealm := opts.KeycloakAuthRealm
+		if realm.ClientID != "" || realm.ClientSecret != "" {
+			authRealm = ""
+		}
+
+		keycloakObj, err := keycloak.NewKeycloak(keycloak.KeycloakOptions{
+			AppCtx: opts.AppCtx,
 
-	if len(kcExistingGroups) == 0 {
-		kcParentGroup.Name = gocloak.StringP(r.syncedParentGroup)
+			URI:          opts.KeycloakURI,
+			Realm:        realm.Name,
+			AuthRealm:    authRealm,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Timeout:      opts.KeycloakTimeout,
+			CallTimeout:  opts.APITimeout,
 
-		gCreationResult, err := r.keycloak.GetGocloakClient().CreateGroup(r.appCtx.Context,
-			r.keycloak.GetToken().AccessToken, r.keycloak.Realm, kcParentGroup)
+			ClientJWTKeyPath: clientJWTKeyPath,
 
+			CACertPath:         opts.KeycloakCACertPath,
+			InsecureSkipVerify: opts.KeycloakInsecure,
+			Proxy:              proxyFunc,
+
+			MaxConcurrentRequests: opts.KeycloakConcurrency,
+			UserBatchSize:         opts.KeycloakUserBatchSize,
+			GroupBatchSize:        opts.KeycloakGroupBatchSize,
+
+			MaxRetryAfterWait: opts.KeycloakMaxRetryAfterWait,
+			GroupSearchExact:  opts.KeycloakGroupSearchExact,
+
+			Retry: retry.Options{
+				MaxRetries: opts.MaxRetries,
+				BaseDelay:  opts.RetryBaseDelay,
+			},
+		})
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed creating parent group: %v", err)
+			return nil, fmt.Errorf("failed creating keycloak client for realm %s: %v", realm.Name, err)
 		}
 
-		kcParentGroup.ID = gocloak.StringP(gCreationResult)
-	} else {
-		kcParentGroup = *kcExistingGroups[0]
+		// Missing client roles would otherwise only show up on the first write, halfway through a cycle
+		err = keycloakObj.ValidatePermissions(runner.keycloakRequiredRoles(opts.ReadOnly))
+		if err != nil {
+			return nil, withStage(ErrKeycloakAuth, fmt.Errorf("failed validating keycloak access for realm %s: %w", realm.Name, err))
+		}
+
+		runner.realms = append(runner.realms, realmClient{name: realm.Name, keycloak: keycloakObj})
+	}
+	if len(runner.realms) == 0 {
+		return nil, fmt.Errorf("no Keycloak realm to reconcile")
+	}
+	runner.keycloak = runner.realms[0].keycloak
+
+	// Opened last, so no failure building the runner leaves it open. Close releases it
+	if opts.AuditLogFile != "" {
+		runner.auditLog, err = openAuditLog(opts.AuditLogFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed opening audit log: %v", err)
+		}
 	}
 
-	kcChildrenGroups, err = r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, *kcParentGroup.ID)
+	if runner.caps.enabled() {
+		opts.AppCtx.Logger.Warn("caps are active. Cycles only process part of the realm and never prune groups",
+			"max_users", opts.MaxUsers, "max_groups", opts.MaxGroups)
+	}
+
+	return runner, nil
+}
+
+// getKeycloakChildrenGroups return the IDs of the synced parent groups keyed by path, creating those missing,
+// and their children keyed by the identity of the Gsuite group each one mirrors. With parent routes,
+// every parent only brings the children routed to it
+func (r *Runner) getKeycloakChildrenGroups() (parentGroups map[string]string, childrenGroups map[string]*gocloak.Group, err error) {
+	r.groupStateKey, r.childrenFromState = "", false
+
+	parentGroups = map[string]string{}
+	childrenGroups = map[string]*gocloak.Group{}
+	for _, parentPath := range r.syncedParents() {
+		parentID, parentChildren, err := r.getParentChildrenGroups(parentPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		parentGroups[groupPathString(parentPath)] = parentID
+		r.addRoutedChildren(parentPath, parentChildren, childrenGroups)
+	}
+
+	return parentGroups, childrenGroups, nil
+}
+
+// getParentChildrenGroups return the ID of a synced parent group, creating it when missing,
+// and its children keyed by the identity of the Gsuite group each one mirrors
+func (r *Runner) getParentChildrenGroups(parentPath []string) (parentGroup string, childrenGroups map[string]*gocloak.Group, err error) {
+
+	// 1. Reuse the parent group resolved by a previous cycle, unless it is gone since
+	if parentID, found := r.syncedParentIDs[r.realm][groupPathString(parentPath)]; found {
+		kcChildrenGroups, err := r.getSyncedChildrenGroups(parentID)
+		if !keycloak.IsNotFoundError(err) {
+			if err != nil {
+				return "", nil, err
+			}
+			return parentID, kcChildrenGroups, nil
+		}
+		r.forgetSyncedParentGroup()
+	}
+
+	// 2. Try retrieving Keycloak parent group, walking its path level by level
+	kcParentGroup, depth, err := r.findSyncedParentGroup(parentPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed getting children groups: %v", err)
+		return "", nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting parent group: %w", err))
 	}
 
-	kcChildrenGroupsMap := map[string]*gocloak.Group{}
-	for _, kcGroup := range kcChildrenGroups {
-		kcChildrenGroupsMap[*kcGroup.Name] = kcGroup
+	// 3. Retrieve children groups for the found parent.
+	// When the parent, or any level above it, is not found, create it
+	if depth < len(parentPath) {
+
+		// Nothing hangs from a parent that does not exist yet
+		if r.dryRun {
+			r.appCtx.Logger.Info("dry-run: would create parent group", "group", groupPathString(parentPath))
+			return "", map[string]*gocloak.Group{}, nil
+		}
+
+		kcParentGroup, err = r.createSyncedParentGroup(parentPath, kcParentGroup, depth)
+		if err != nil {
+			return "", nil, withStage(ErrKeycloakWrite, fmt.Errorf("failed creating parent group: %w", err))
+		}
+	}
+
+	kcChildrenGroups, err := r.getSyncedChildrenGroups(*kcParentGroup.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	r.rememberSyncedParentGroup(parentPath, *kcParentGroup.ID)
+	return *kcParentGroup.ID, kcChildrenGroups, nil
+}
+
+// getChildrenGroupsByIdentity return the children of a group keyed by the identity of the Gsuite group each one mirrors.
+// With nested names, the groups nested in its containers are returned as well
+func (r *Runner) getChildrenGroupsByIdentity(parentGroupID string) (childrenGroups map[string]*gocloak.Group, err error) {
+	if r.groupNamer.hierarchyDelimiter != "" {
+		kcGroups, err := r.getGroupHierarchy(parentGroupID)
+		if err != nil {
+			return nil, err
+		}
+		return groupsByIdentity(kcGroups), nil
+	}
+
+	// Each page is retried by the client itself
+	kcChildrenGroups, err := r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, parentGroupID)
+	if err != nil {
+		return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting children groups: %w", err))
 	}
 
-	return kcParentGroup.ID, kcChildrenGroupsMap, nil
+	return groupsByIdentity(kcChildrenGroups), nil
 }
 
 // KeycloakUserGroups represents the merge between a user and its groups
 type KeycloakUserGroups struct {
-	User   *gocloak.User
+	User *gocloak.User
+
+	// Groups are keyed by group ID, as names are only unique among siblings
 	Groups map[string]*gocloak.Group
 }
 
+// sortedByName returns the groups sorted by name, then by ID for siblings of different parents sharing it
+func sortedByName(groups map[string]*gocloak.Group) []*gocloak.Group {
+	return slices.SortedFunc(maps.Values(groups), func(a, b *gocloak.Group) int {
+		return cmp.Or(strings.Compare(*a.Name, *b.Name), strings.Compare(*a.ID, *b.ID))
+	})
+}
+
+// getKeycloakUsers returns the Keycloak users passing the user filter. Users filtered out are never
+// looked up anywhere else
+func (r *Runner) getKeycloakUsers() (kcUsers []*gocloak.User, err error) {
+	if r.scopedUser != nil {
+		kcUsers = []*gocloak.User{r.scopedUser}
+	} else {
+		err = r.withRetry(func() (err error) {
+			kcUsers, err = r.keycloak.GetUsers(r.keycloak.GetToken().AccessToken)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Some federation setups hand over users without username, which every later stage keys them by
+	kcUsers = slices.DeleteFunc(kcUsers, func(user *gocloak.User) bool {
+		if user.ID != nil && user.Username != nil && *user.Username != "" {
+			return false
+		}
+		r.appCtx.Logger.Warn("user has no ID or username. Ignoring user...", "id", gocloak.PString(user.ID))
+		return true
+	})
+
+	// Excluded users are left out before anything else, so their memberships are never touched
+	kcUsers = slices.DeleteFunc(kcUsers, func(user *gocloak.User) bool {
+		if !r.userFilter.excludes(user) {
+			return false
+		}
+		r.appCtx.Logger.Debug("user excluded. Ignoring user...", "user", *user.Username)
+		return true
+	})
+
+	allowedUsers := r.userFilter.filter(kcUsers)
+	if skipped := len(kcUsers) - len(allowedUsers); skipped > 0 {
+		r.appCtx.Logger.Debug("users filtered out", "users", skipped)
+	}
+
+	// Users come sorted by username, so every cycle processes and logs them in the same order
+	slices.SortFunc(allowedUsers, func(a, b *gocloak.User) int {
+		return strings.Compare(*a.Username, *b.Username)
+	})
+	return r.caps.capUsers(allowedUsers), nil
+}
+
 // getKeycloakUsersGroups return a map of username->{user, groups}
 func (r *Runner) getKeycloakUsersGroups() (usersGroups map[string]KeycloakUserGroups, err error) {
 
 	kcUsersGroups := map[string]KeycloakUserGroups{}
 
-	kcUsers, err := r.keycloak.GetUsers(r.keycloak.GetToken().AccessToken)
+	kcUsers, err := r.getKeycloakUsers()
 	if err != nil {
-		return nil, fmt.Errorf("failed getting users: %v", err)
+		return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed getting users: %w", err))
 	}
 
 	// Create a map to merge a user and its groups into a unique object.
 	for _, user := range kcUsers {
 
-		kcUserGroups, err := r.keycloak.GetUserGroups(*user.ID, r.keycloak.GetToken().AccessToken)
+		var kcUserGroups []*gocloak.Group
+		err = r.withRetry(func() (err error) {
+			kcUserGroups, err = r.keycloak.GetUserGroups(*user.ID, r.keycloak.GetToken().AccessToken)
+			return err
+		})
 		if err != nil {
-			r.appCtx.Logger.Error("failed getting user groups. Ignoring user...", "user", *user.Email, "error", err)
+			r.appCtx.Logger.Error("failed getting user groups. Ignoring user...", "user", *user.Username, "error", err)
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get user groups", User: *user.Username, Err: err})
 			continue
 		}
 
 		tmpGroupsMap := map[string]*gocloak.Group{}
 		for _, kcGroup := range kcUserGroups {
-			tmpGroupsMap[*kcGroup.Name] = kcGroup
+			tmpGroupsMap[*kcGroup.ID] = kcGroup
 		}
 
 		kcUsersGroups[*user.Username] = KeycloakUserGroups{
@@ -188,90 +846,434 @@ func userDelayFromRate(usersPerMinute int) time.Duration {
 	return time.Minute / time.Duration(usersPerMinute)
 }
 
+// withRetry runs an API call through the configured retry policy, so transient failures
+// do not cost a whole user or cycle
+func (r *Runner) withRetry(fn func() error) error {
+	return retry.Do(r.appCtx.Context, r.retryOpts, fn)
+}
+
+// applyMembershipChanges sends the membership changes of a user to Keycloak at once, logging and
+// counting every change on its own. groupNames holds the name of the group of each change
+func (r *Runner) applyMembershipChanges(username string, changes []keycloak.MembershipChange, groupNames []string) {
+	if len(changes) == 0 {
+		return
+	}
+
+	errs := r.keycloak.UpdateGroupMemberships(r.keycloak.GetToken().AccessToken, changes, r.retryOpts)
+	for i, change := range changes {
+
+		// The user may have joined the group since memberships were listed, such as by another process.
+		// Keycloak usually accepts such adds, but some setups answer with a conflict, so nothing changed
+		if !change.Remove && keycloak.IsConflictError(errs[i]) {
+			r.logSkippedMembership(skipReasonAlreadyMember, username, groupNames[i])
+			continue
+		}
+
+		action := AuditActionAdd
+		if change.Remove {
+			action = AuditActionRemove
+		}
+		r.audit(AuditTargetKeycloak, action, username, groupNames[i], errs[i])
+
+		// The group may be gone since its ID was cached
+		if keycloak.IsNotFoundError(errs[i]) {
+			r.invalidateGroupState()
+		}
+
+		switch {
+		case errs[i] != nil && change.Remove:
+			r.appCtx.Logger.Error("failed deleting user from group", "user", username,
+				"group", groupNames[i], "error", errs[i].Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "remove membership", User: username, Group: groupNames[i], Err: errs[i]})
+		case errs[i] != nil:
+			r.appCtx.Logger.Error("failed adding user to the group", "user", username,
+				"group", groupNames[i], "error", errs[i].Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "add membership", User: username, Group: groupNames[i], Err: errs[i]})
+		case change.Remove:
+			metrics.GroupDeletions.Inc()
+			r.cycleStats.membershipsRemoved++
+		default:
+			metrics.GroupAdditions.Inc()
+			r.cycleStats.membershipsAdded++
+		}
+	}
+}
+
+// withJitter adds a random offset in [0, jitter) to a duration, so replicas sharing an interval
+// spread their load instead of hitting the APIs at once
+func withJitter(duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return duration
+	}
+	return duration + rand.N(jitter)
+}
+
+// sleep pauses for the given duration, returning false as soon as the application context is done.
+func (r *Runner) sleep(duration time.Duration) bool {
+	if r.appCtx.Context.Err() != nil {
+		return false
+	}
+	if duration <= 0 {
+		return true
+	}
+
+	select {
+	case <-r.appCtx.Context.Done():
+		return false
+	case <-r.clock.After(duration):
+		return true
+	}
+}
+
+// getUserMatchKey returns the Keycloak user field passed to Google as userKey, or an empty
+// string when the user has no value for the configured attribute.
+func (r *Runner) getUserMatchKey(user *gocloak.User) string {
+	var value *string
+	switch r.userMatchAttribute {
+	case UserMatchAttributeUsername:
+		value = user.Username
+	default:
+		value = user.Email
+	}
+
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(*value)
+}
+
+// prefetchGsuiteMemberships lists every group of the configured domains along with its members once,
+// returning a map of lowercased member email -> groups. Any failure aborts the whole prefetch so a
+// partial view never triggers spurious removals. Members are reported by the address they were added
+// with, so unlike the per-user lookup, aliases are not resolved.
+func (r *Runner) prefetchGsuiteMemberships() (memberships map[string][]string, err error) {
+	memberships = map[string][]string{}
+	seen := map[string]struct{}{}
+
+	for _, domain := range r.gsuiteDomains {
+		var domainGroups []string
+		err = r.withRetry(func() (err error) {
+			domainGroups, err = r.gsuiteCli.GetAllGroups(domain)
+			return err
+		})
+		if err != nil {
+			return nil, withStage(ErrGsuite, fmt.Errorf("failed getting groups in domain %s: %w", domain, err))
+		}
+
+		var groupsMembers []gsuite.GroupMembers
+		err = r.withRetry(func() (err error) {
+			groupsMembers, err = r.gsuiteCli.GetGroupsMembers(domainGroups)
+			return err
+		})
+		if err != nil {
+			return nil, withStage(ErrGsuite, fmt.Errorf("failed getting group members in domain %s: %w", domain, err))
+		}
+
+		for _, groupMembers := range groupsMembers {
+			if _, found := seen[groupIdentity(groupMembers.Group)]; found {
+				continue
+			}
+			seen[groupIdentity(groupMembers.Group)] = struct{}{}
+
+			// A member listed twice, or with another case, must not count the group twice
+			groupMemberKeys := map[string]struct{}{}
+			for _, member := range groupMembers.Users {
+				memberKey := strings.ToLower(member)
+				if _, found := groupMemberKeys[memberKey]; found {
+					continue
+				}
+				groupMemberKeys[memberKey] = struct{}{}
+				memberships[memberKey] = append(memberships[memberKey], groupMembers.Group)
+			}
+		}
+	}
+
+	return memberships, nil
+}
+
 // getGsuiteGroupsForUser returns the union of the user's Gsuite groups across every configured
 // domain, deduplicated. A user's login email is passed as userKey directly; Google accepts either
 // the primary email or an alias, so no alias resolution is needed. The domain filter selects the
 // domain where the groups themselves live, which is an account-level setting rather than a per-user
 // property (e.g. groups may live under one domain while users log in through another).
+// A user unknown to a domain simply has no groups there, which must not fail the whole lookup.
 func (r *Runner) getGsuiteGroupsForUser(username string) (groups []string, err error) {
-	seen := map[string]struct{}{}
+	groups, err = r.getGsuiteDirectGroups(username)
+	if err != nil || !r.resolveNestedGroups {
+		return groups, err
+	}
+	return r.expandNestedGroups(groups, r.getGsuiteParentGroups)
+}
+
+// getGsuiteGroups returns the Gsuite groups of a user, read from the prefetched memberships when
+// prefetching is enabled, or queried from Google otherwise
+func (r *Runner) getGsuiteGroups(userKey string, prefetchedMemberships map[string][]string) (groups []string, err error) {
+	if !r.gsuitePrefetch {
+		return r.getGsuiteGroupsForUser(userKey)
+	}
 
+	groups = uniqueGroups(prefetchedMemberships[strings.ToLower(userKey)])
+	if !r.resolveNestedGroups {
+		return groups, nil
+	}
+	return r.expandNestedGroups(groups, func(group string) ([]string, error) {
+		return prefetchedMemberships[strings.ToLower(group)], nil
+	})
+}
+
+// getGsuiteDirectGroups returns the groups a user or group is a direct member of across every
+// configured domain, deduplicated whatever their case
+func (r *Runner) getGsuiteDirectGroups(username string) (groups []string, err error) {
 	for _, domain := range r.gsuiteDomains {
-		domainGroups, err := r.gsuiteCli.GetGroupsFromUser(domain, username)
+		var domainGroups []string
+		err = r.withRetry(func() (err error) {
+			domainGroups, err = r.gsuiteCli.GetGroupsFromUser(domain, username)
+			return err
+		})
+		if gsuite.IsNotFoundError(err) {
+			r.appCtx.Logger.Debug("user not found in domain", "user", username, "domain", domain)
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed getting groups for %s in domain %s: %v", username, domain, err)
+			return nil, withStage(ErrGsuite, fmt.Errorf("failed getting groups for %s in domain %s: %w", username, domain, err))
 		}
 
-		for _, group := range domainGroups {
-			if _, found := seen[group]; found {
-				continue
-			}
-			seen[group] = struct{}{}
-			groups = append(groups, group)
-		}
+		groups = append(groups, domainGroups...)
 	}
 
-	return groups, nil
+	return uniqueGroups(groups), nil
 }
 
-// TODO
-func (r *Runner) reconcileUserGroups() {
+// reconcileUserGroups runs a full reconcile cycle. It returns an error when the cycle had to be
+// aborted, or when any user could not be fully reconciled
+func (r *Runner) reconcileUserGroups() (err error) {
+
+	r.cycleFailures = nil
+	r.cycleStats = cycleStats{}
+	r.caps.reset()
+	r.gsuiteParentGroups = nil
+	r.gsuiteGroupDescriptions = nil
+	metrics.ReconcileRuns.Inc()
+	reconcileStart := r.clock.Now()
+	defer func() {
+		duration := r.clock.Now().Sub(reconcileStart)
+		metrics.ReconcileDuration.Observe(duration.Seconds())
+		r.logPartialSync()
+		r.logCycleSummary(duration, err)
+	}()
 
 	// 1. Retrieve Keycloak groups
-	kcParentGroupID, kcChildrenGroups, err := r.getKeycloakChildrenGroups()
+	kcParentGroupIDs, kcChildrenGroups, err := r.getKeycloakChildrenGroups()
 	if err != nil {
-		r.appCtx.Logger.Error("failed getting groups from Keycloak", "error", err.Error())
-		return
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get synced groups", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting groups from Keycloak: %w", err))
+	}
+	metrics.ManagedGroups.Set(float64(len(kcChildrenGroups)))
+
+	// User groups only carry IDs, so synced groups are also reachable that way
+	kcChildrenGroupsByID := map[string]*gocloak.Group{}
+	for _, kcGroup := range kcChildrenGroups {
+		kcChildrenGroupsByID[*kcGroup.ID] = kcGroup
 	}
 
 	// 2. Get users groups in a map like: username->{userProfile, userGroups}
 	kcUsersGroupsMap, err := r.getKeycloakUsersGroups()
 	if err != nil {
-		r.appCtx.Logger.Error("failed getting users groups from Keycloak", "error", err.Error())
-		return
+		r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get users groups", Err: err})
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting users groups from Keycloak: %w", err))
+	}
+
+	// 3. Optionally resolve the whole Gsuite membership upfront instead of querying per user
+	var gsuiteMemberships map[string][]string
+	if r.gsuitePrefetch {
+		gsuiteMemberships, err = r.prefetchGsuiteMemberships()
+		if err != nil {
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "prefetch memberships", Err: err})
+			return withStage(ErrGsuite, fmt.Errorf("failed prefetching groups from Gsuite: %w", err))
+		}
+		r.readiness.MarkGsuiteAuthenticated()
 	}
 
-	// 3. Reconcile group memberships in Keycloak having Gsuite as source of truth.
-	for kcUsername, kcUserGroups := range kcUsersGroupsMap {
+	// Every Gsuite group seen this cycle by identity, used to detect orphaned Keycloak groups.
+	// Pruning is only safe when no user lookup failed, as the union would be partial
+	seenGroups := map[string]string{}
+	gsuiteLookupFailed := false
 
-		if r.userDelay > 0 {
-			time.Sleep(r.userDelay)
+	// Groups only users without a match key belong to are never seen, so they must not be taken as orphaned
+	usersWithoutKey := false
+
+	// Users Gsuite puts in each group by identity, unchanged users included
+	memberCounts := map[string]int{}
+
+	// Deletions are held back until every user is compared, so their total can be checked against the limit
+	var pending []pendingDeletions
+	totalDeletions := 0
+	managedMemberships := 0
+	if r.deletionLimit.enabled() && r.scopedUser == nil {
+		managedMemberships = r.countManagedMemberships(kcUsersGroupsMap, kcChildrenGroupsByID)
+	}
+
+	// Snapshots of the users found in sync, replacing the ones of the previous cycle once this one ends
+	previousSnapshots := r.userSnapshots[r.realm]
+	snapshots := map[string]string{}
+
+	// Users compared this cycle by ID, and the removals and changes planned for them, so deferred removals
+	// and debounced changes no longer planned are dropped
+	comparedUsers := map[string]struct{}{}
+	plannedRemovals := map[string]struct{}{}
+	plannedChanges := map[string]struct{}{}
+
+	// IDs of the users reconciled without failures by username, annotated once deletions are applied
+	syncedUsers := map[string]string{}
+
+	// 4. Reconcile group memberships in Keycloak having Gsuite as source of truth.
+	// Users are processed sorted by username, so logs of different cycles can be compared
+	for _, kcUsername := range slices.Sorted(maps.Keys(kcUsersGroupsMap)) {
+		kcUserGroups := kcUsersGroupsMap[kcUsername]
+
+		// Users without a usable key never reach Google, so they do not consume the rate budget
+		userKey := r.getUserMatchKey(kcUserGroups.User)
+		if userKey == "" {
+			r.appCtx.Logger.Warn("user has no value for the match attribute. Ignoring user...",
+				"user", kcUsername, "attribute", r.userMatchAttribute, "reason", skipReasonNoMatchKey)
+			usersWithoutKey = true
+			continue
+		}
+
+		// Prefetched memberships need no Google calls per user, so there is nothing to throttle
+		userDelay := r.userDelay
+		if r.gsuitePrefetch {
+			userDelay = 0
+		}
+
+		// Stop between users so a shutdown never leaves a user half reconciled
+		if !r.sleep(userDelay) {
+			r.appCtx.Logger.Info("context cancelled. stopping reconcile cycle")
+			return r.appCtx.Context.Err()
+		}
+
+		// Throttled cycles can outlive the token, so check it before touching Keycloak again
+		err = r.keycloak.EnsureToken()
+		if err != nil {
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "renew token", Err: err})
+			return withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
 		}
 
 		r.appCtx.Logger.Info("reconciling user groups", "user", kcUsername)
 
-		gsuiteGroups, err := r.getGsuiteGroupsForUser(kcUsername)
+		var gsuiteGroups []string
+		gsuiteGroups, err = r.getGsuiteGroups(userKey, gsuiteMemberships)
 		if err != nil {
-			r.appCtx.Logger.Error("failed getting groups from Gsuite. Ignoring user...", "user", kcUsername, "error", err.Error())
+			r.appCtx.Logger.Error("failed getting groups from Gsuite. Ignoring user...", "user", kcUsername,
+				"error", err.Error(), "reason", skipReasonGsuiteLookupFailed)
+			r.recordError(OperationFailure{Stage: metrics.StageGsuite, Operation: "get user groups", User: kcUsername, Err: err})
+			gsuiteLookupFailed = true
 			continue
 		}
+		r.readiness.MarkGsuiteAuthenticated()
+
+		// Ignored groups, and those routed to no parent, are neither added nor, below, removed
+		gsuiteGroups = r.routedGroups(r.groupFilter.filter(gsuiteGroups))
+		slices.Sort(gsuiteGroups)
+		gsuiteGroups = r.caps.capGroups(gsuiteGroups)
+
+		// Identities of the groups the user must belong to, mapped to the Gsuite group each one is
+		desiredGroups := r.resolveGroupNames(gsuiteGroups, kcChildrenGroups)
+
+		maps.Copy(seenGroups, desiredGroups)
+		for identity := range desiredGroups {
+			memberCounts[identity]++
+		}
+
+		// Unchanged users still count their groups as seen above, so pruning keeps working
+		var snapshot string
+		if r.incremental && r.scopedUser == nil {
+			snapshot = r.userSnapshot(gsuiteGroups, kcUserGroups, kcChildrenGroupsByID)
+			if previous, found := previousSnapshots[kcUsername]; found && previous == snapshot {
+				r.appCtx.Logger.Debug("user unchanged since last cycle. Skipping user...", "user", kcUsername, "reason", skipReasonUnchanged)
+				snapshots[kcUsername] = snapshot
+				syncedUsers[kcUsername] = *kcUserGroups.User.ID
+				r.cycleStats.usersUnchanged++
+				continue
+			}
+		}
 
 		if len(gsuiteGroups) == 0 {
 			r.appCtx.Logger.Debug("user has no groups in any configured domain", "user", kcUsername)
 		}
 
+		// Changes that would be applied, reported per user on dry-run
+		var plannedDeletions, plannedAdditions, plannedCreations []string
+
+		// Membership changes, sent together once the user is fully compared
+		var changes, deletions []keycloak.MembershipChange
+		var changedGroups, deletedGroups []string
+
+		// A user is only known to be in sync when nothing had to change, nor failed, nor was deferred
+		userFailed := false
+		userDeferred := false
+		comparedUsers[*kcUserGroups.User.ID] = struct{}{}
+
 		// Deletions
 		// Groups attached in Keycloak and not attached in Gsuite
 		// will be deleted. This is only true for auto-managed groups
-		for _, kcUserGroup := range kcUserGroups.Groups {
+		for _, kcUserGroup := range sortedByName(kcUserGroups.Groups) {
 
-			// Ignore not auto-managed groups
-			if !strings.HasPrefix(*kcUserGroup.Path, "/"+r.syncedParentGroup+"/") {
+			// Ignore not auto-managed groups. User groups come without attributes,
+			// so ownership is checked on the synced group with the same ID
+			managedGroup, found := kcChildrenGroupsByID[*kcUserGroup.ID]
+			if !found || !isManaged(managedGroup) {
+				r.logSkippedMembership(skipReasonNotManaged, kcUsername, gocloak.PString(kcUserGroup.Name))
 				continue
 			}
 
-			// Existing groups not present in Google
-			if !slices.Contains(gsuiteGroups, *kcUserGroup.Name) {
+			// Ignore groups filtered out or beyond the cap, their memberships are left as they are
+			if !r.groupFilter.allows(sourceGroupOf(managedGroup)) {
+				r.logSkippedMembership(skipReasonFilteredOut, kcUsername, *managedGroup.Name)
+				continue
+			}
+			if !r.caps.admitsGroup(sourceGroupOf(managedGroup)) {
+				r.logSkippedMembership(skipReasonCapped, kcUsername, *managedGroup.Name)
+				continue
+			}
 
-				r.appCtx.Logger.Debug("deleting user from group", "user", kcUsername, "group", *kcUserGroup.Name)
+			// Existing groups not present in Google
+			removal := removalKey(*kcUserGroups.User.ID, *managedGroup.ID)
+			if _, desired := desiredGroups[identityOf(managedGroup)]; !desired {
+
+				// Debouncing holds the removal back until it has been planned on enough consecutive cycles
+				change := changeKey(*kcUserGroups.User.ID, identityOf(managedGroup), true)
+				plannedChanges[change] = struct{}{}
+				if settled, seen := r.changeSettled(change); !settled {
+					r.logDebouncedChange(kcUsername, *kcUserGroup.Name, seen)
+					userDeferred = true
+					continue
+				}
 
-				delUserGroupErr := r.keycloak.GetGocloakClient().DeleteUserFromGroup(r.appCtx.Context, r.keycloak.GetToken().AccessToken,
-					r.keycloak.Realm, *kcUserGroups.User.ID, *kcChildrenGroups[*kcUserGroup.Name].ID)
+				// Soft delete holds the removal back until it has been planned for the whole grace period
+				plannedRemovals[removal] = struct{}{}
+				if due, remaining := r.removalDue(removal); !due {
+					r.appCtx.Logger.Debug("membership removal deferred", "user", kcUsername, "group", *kcUserGroup.Name,
+						"remaining", remaining.String(), "reason", skipReasonRemovalDeferred)
+					r.cycleStats.membershipsDeferred++
+					userDeferred = true
+					continue
+				}
 
-				if delUserGroupErr != nil {
-					r.appCtx.Logger.Error("failed deleting user from group", "user", kcUsername,
-						"group", *kcUserGroup.Name, "error", delUserGroupErr.Error())
+				totalDeletions++
+				if r.dryRun {
+					r.logSkippedMembership(skipReasonDryRun, kcUsername, *kcUserGroup.Name)
+					plannedDeletions = append(plannedDeletions, *kcUserGroup.Name)
+					continue
 				}
+
+				r.appCtx.Logger.Debug("deleting user from group", "user", kcUsername, "group", *kcUserGroup.Name)
+				deletions = append(deletions, keycloak.MembershipChange{
+					UserID: *kcUserGroups.User.ID, GroupID: *managedGroup.ID, Remove: true})
+				deletedGroups = append(deletedGroups, *kcUserGroup.Name)
+			} else if r.cancelRemoval(removal) {
+				r.appCtx.Logger.Info("membership wanted again in Gsuite. Pending removal cancelled",
+					"user", kcUsername, "group", *kcUserGroup.Name)
 			}
 		}
 
@@ -279,64 +1281,421 @@ func (r *Runner) reconcileUserGroups() {
 		// Groups attached in Gsuite and not attached in Keycloak
 		// will be attached in Keycloak
 		for _, gsuiteGroup := range gsuiteGroups {
+			// Groups that can not be named were reported while resolving names, and are only joined when they exist
+			targets, _ := r.groupTargets(gsuiteGroup)
+			for _, target := range targets {
+
+				// Ignore groups dropped because of a name collision, or repeated with another case
+				identity := target.identity
+				if desiredGroups[identity] != gsuiteGroup {
+					r.logSkippedMembership(skipReasonNameCollision, kcUsername, gsuiteGroup)
+					continue
+				}
 
-			// Ignore user groups from Gsuite that are already present in Keycloak user profile
-			_, groupFound := kcUserGroups.Groups[gsuiteGroup]
-			if groupFound {
-				continue
-			}
+				// Groups missing from the state file may have been created since, so Keycloak is checked before creating them
+				kcGroup, groupFoundInGlobalMap := kcChildrenGroups[identity]
+				if !groupFoundInGlobalMap && r.childrenFromState {
+					err = r.refreshSyncedChildrenGroups(kcParentGroupIDs[groupPathString(r.syncedParentPath)],
+						kcChildrenGroups, kcChildrenGroupsByID)
+					if err != nil {
+						r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "get synced groups", Err: err})
+						return withStage(ErrKeycloakRead, fmt.Errorf("failed getting groups from Keycloak: %w", err))
+					}
+					kcGroup, groupFoundInGlobalMap = kcChildrenGroups[identity]
+				}
 
-			//
-			tmpGroup := &gocloak.Group{
-				Name: gocloak.StringP(gsuiteGroup),
-			}
+				// Ignore user groups from Gsuite that are already present in Keycloak user profile.
+				// Groups planned on dry-run have no ID yet
+				if groupFoundInGlobalMap && kcGroup.ID != nil {
+					if _, groupFound := kcUserGroups.Groups[*kcGroup.ID]; groupFound {
+						r.logSkippedMembership(skipReasonAlreadyMember, kcUsername, *kcGroup.Name)
+						continue
+					}
+				}
 
-			_, groupFoundInGlobalMap := kcChildrenGroups[*tmpGroup.Name]
-			if !groupFoundInGlobalMap {
-				r.appCtx.Logger.Debug("creating missing group in Keycloak", "group", *tmpGroup.Name)
+				// Debouncing holds the addition back, and the creation of its group, until it has been planned
+				// on enough consecutive cycles
+				change := changeKey(*kcUserGroups.User.ID, identity, false)
+				plannedChanges[change] = struct{}{}
+				if settled, seen := r.changeSettled(change); !settled {
+					r.logDebouncedChange(kcUsername, target.name, seen)
+					userDeferred = true
+					continue
+				}
 
-				childGroupID, err := r.keycloak.GetGocloakClient().CreateChildGroup(r.appCtx.Context,
-					r.keycloak.GetToken().AccessToken, r.keycloak.Realm, *kcParentGroupID, *tmpGroup)
+				//
+				tmpGroup := kcGroup
+				if !groupFoundInGlobalMap {
+					tmpGroup = r.newSyncedGroup(gsuiteGroup, target)
+					if r.copyGroupDescription {
+						if description := r.gsuiteGroupDescription(gsuiteGroup); description != "" {
+							(*tmpGroup.Attributes)[GroupAttributeDescription] = []string{description}
+						}
+					}
+				}
 
-				if err != nil {
-					r.appCtx.Logger.Error("failed creating group in Keycloak", "group", *tmpGroup.Name, "error", err.Error())
+				if !groupFoundInGlobalMap && r.dryRun {
+					// Remember the group so it is reported as a creation only once per cycle
+					plannedCreations = append(plannedCreations, *tmpGroup.Name)
+					kcChildrenGroups[identity] = tmpGroup
+				} else if !groupFoundInGlobalMap {
+					r.appCtx.Logger.Debug("creating missing group in Keycloak", "group", *tmpGroup.Name)
+
+					// Nested groups are created with the name of their own level, under containers created first
+					// in the parent the group is routed to. Mapped groups are named by the mapping as is
+					containers, leafName := []string(nil), *tmpGroup.Name
+					if target.mapped == "" {
+						containers, leafName = r.groupNamer.levels(*tmpGroup.Name)
+					}
+					var groupParentID string
+					groupParentID, err = r.ensureGroupContainers(kcParentGroupIDs[groupPathString(r.parentOf(gsuiteGroup))], containers)
+
+					var childGroupID string
+					if err == nil {
+						leafGroup := *tmpGroup
+						leafGroup.Name = gocloak.StringP(leafName)
+						err = r.withRetry(func() (err error) {
+							childGroupID, err = r.keycloak.CreateChildGroup(r.keycloak.GetToken().AccessToken, groupParentID, leafGroup)
+							return err
+						})
+						r.audit(AuditTargetKeycloak, AuditActionCreate, "", *tmpGroup.Name, err)
+					}
+
+					// The cached parent group, or a container, may be gone since it was resolved
+					if keycloak.IsNotFoundError(err) {
+						r.forgetSyncedParentGroup()
+					}
+
+					// Another instance, or an overlapping cycle, may have created the group in the meantime
+					var racedGroup *gocloak.Group
+					if keycloak.IsConflictError(err) {
+						r.appCtx.Logger.Info("group created meanwhile in Keycloak. Looking it up...", "group", *tmpGroup.Name)
+						racedGroup, err = r.getRacedChildGroup(groupParentID, leafName, identity)
+					}
+
+					if err != nil {
+						r.appCtx.Logger.Error("failed creating group in Keycloak", "group", *tmpGroup.Name, "error", err.Error())
+						r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "create group", Group: *tmpGroup.Name, Err: err})
+						r.logSkippedMembership(skipReasonGroupCreationFailed, kcUsername, *tmpGroup.Name)
+						userFailed = true
+
+						// When group creation fail, we don't want this membership to be added to the user.
+						// It would also fail.
+						continue
+					}
+
+					if racedGroup != nil {
+						tmpGroup = racedGroup
+					} else {
+						tmpGroup.ID = &childGroupID
+						tmpGroup.Name = gocloak.StringP(leafName)
+						metrics.GroupCreations.Inc()
+						r.cycleStats.groupsCreated++
+					}
+					r.groupHierarchy.remember(tmpGroup, strings.Join(containers, r.groupNamer.hierarchyDelimiter))
+					kcChildrenGroups[identity] = tmpGroup
+				}
 
-					// When group creation fail, we don't want this membership to be added to the user.
-					// It would also fail.
+				if r.dryRun {
+					r.logSkippedMembership(skipReasonDryRun, kcUsername, *tmpGroup.Name)
+					plannedAdditions = append(plannedAdditions, *tmpGroup.Name)
 					continue
 				}
 
-				tmpGroup.ID = &childGroupID
-				kcChildrenGroups[*tmpGroup.Name] = tmpGroup
+				r.appCtx.Logger.Debug("adding user to group", "user", kcUsername, "group", *tmpGroup.Name)
+				changes = append(changes, keycloak.MembershipChange{
+					UserID: *kcUserGroups.User.ID, GroupID: *tmpGroup.ID})
+				changedGroups = append(changedGroups, *tmpGroup.Name)
 			}
+		}
+
+		r.applyMembershipChanges(kcUsername, changes, changedGroups)
+		if len(deletions) > 0 {
+			pending = append(pending, pendingDeletions{username: kcUsername, changes: deletions, groupNames: deletedGroups})
+		}
+		if r.incremental && !r.dryRun && !userFailed && !userDeferred && len(changes)+len(deletions) == 0 {
+			snapshots[kcUsername] = snapshot
+		}
+		if !userFailed {
+			syncedUsers[kcUsername] = *kcUserGroups.User.ID
+		}
 
-			r.appCtx.Logger.Debug("adding user to group", "user", kcUsername, "group", *tmpGroup.Name)
-			addUserGroupErr := r.keycloak.GetGocloakClient().AddUserToGroup(r.appCtx.Context, r.keycloak.GetToken().AccessToken,
-				r.keycloak.Realm, *kcUserGroups.User.ID, *kcChildrenGroups[*tmpGroup.Name].ID)
+		metrics.UsersProcessed.Inc()
+		r.cycleStats.usersProcessed++
 
-			if addUserGroupErr != nil {
-				r.appCtx.Logger.Error("failed adding user to the group",
-					"user", kcUsername, "group", *tmpGroup.Name, "error", addUserGroupErr.Error())
+		if r.dryRun && len(plannedDeletions)+len(plannedAdditions)+len(plannedCreations) > 0 {
+			r.appCtx.Logger.Info("dry-run: would reconcile user groups", "user", kcUsername,
+				"additions", plannedAdditions, "deletions", plannedDeletions, "creations", plannedCreations)
+		}
+	}
+
+	// 5. Remove stale memberships, unless there are so many that Gsuite is more likely wrong than the realm
+	deletionsBlocked := r.applyDeletions(pending, totalDeletions, managedMemberships)
+	r.forgetStaleRemovals(comparedUsers, plannedRemovals)
+	r.forgetUnplannedChanges(comparedUsers, plannedChanges)
+
+	// Users gone since are never compared again, so what is held back for them is dropped on full cycles
+	if r.scopedUser == nil {
+		listedUsers := map[string]struct{}{}
+		for _, kcUserGroups := range kcUsersGroupsMap {
+			listedUsers[*kcUserGroups.User.ID] = struct{}{}
+		}
+		r.forgetOrphanedRemovals(listedUsers, kcChildrenGroupsByID)
+		r.forgetUnlistedChanges(listedUsers)
+	}
+	r.annotateSyncedUsers(syncedUsers, pending, deletionsBlocked)
+
+	// What follows spans the whole realm, so it is left to the next full cycle when scoped to a single user.
+	// That user is compared again then, as its last snapshot may be stale now
+	if r.scopedUser != nil {
+		delete(r.userSnapshots[r.realm], *r.scopedUser.Username)
+		r.saveGroupState(kcChildrenGroups)
+		return r.cycleError()
+	}
+
+	// Counts are partial when the caps left users or groups out
+	if !gsuiteLookupFailed && !r.caps.partial() {
+		var syncedGroups []string
+		for identity, kcGroup := range kcChildrenGroups {
+			if isManaged(kcGroup) && r.groupFilter.allows(sourceGroupOf(kcGroup)) {
+				syncedGroups = append(syncedGroups, identity)
 			}
 		}
+		r.recordGroupMembers(syncedGroups, memberCounts)
+	}
 
+	// 6. Record when and from where every synced group was last reconciled
+	if !r.dryRun {
+		r.refreshProvenance(kcChildrenGroups, seenGroups)
 	}
+
+	// 7. Delete synced groups without Gsuite counterpart
+	if r.pruneGroups {
+		if gsuiteLookupFailed {
+			r.appCtx.Logger.Warn("some users could not be looked up in Gsuite. Skipping groups pruning...")
+		} else if r.caps.enabled() {
+			r.appCtx.Logger.Warn("caps are active. Skipping groups pruning...")
+		} else if r.userFilter.enabled() {
+			// Groups only users filtered out are in are never seen, which does not make them orphans
+			r.appCtx.Logger.Warn("user filters are active. Skipping groups pruning...")
+		} else if usersWithoutKey {
+			r.appCtx.Logger.Warn("some users have no value for the match attribute. Skipping groups pruning...")
+		} else if deletionsBlocked {
+			r.appCtx.Logger.Warn("membership deletions were held back. Skipping groups pruning...")
+		} else {
+			r.pruneOrphanGroups(kcChildrenGroups, seenGroups)
+		}
+	}
+
+	if r.incremental && !r.dryRun {
+		r.userSnapshots[r.realm] = snapshots
+	}
+
+	r.saveGroupState(kcChildrenGroups)
+
+	// 8. Point out Gsuite members the memberships above could not reach. Users left out by the caps would show up
+	if r.reportUnmatchedMembers && !r.caps.partial() {
+		var kcUsers []*gocloak.User
+		for _, kcUserGroups := range kcUsersGroupsMap {
+			kcUsers = append(kcUsers, kcUserGroups.User)
+		}
+		// Gsuite groups mapped to several Keycloak groups are seen once per group
+		r.logUnmatchedMembers(slices.Compact(slices.Sorted(maps.Values(seenGroups))), kcUsers, gsuiteMemberships)
+	}
+
+	return r.cycleError()
 }
 
-func (r *Runner) PleaseDoYourStuffForever() {
-	for {
-		// Renew Keycloak JWT
-		err := r.keycloak.RenewToken()
+// cycleStats counts the changes applied by the running reconcile cycle
+type cycleStats struct {
+	usersProcessed     int
+	usersUnchanged     int
+	groupsCreated      int
+	membershipsAdded   int
+	membershipsRemoved int
+	groupsPruned       int
+
+	// membershipsDeferred counts the removals soft delete held back
+	membershipsDeferred int
+
+	// changesDebounced counts the membership changes held back until planned on enough consecutive cycles
+	changesDebounced int
+
+	// gsuiteMembersAdded and gsuiteMembersRemoved count the changes written back to Gsuite
+	gsuiteMembersAdded   int
+	gsuiteMembersRemoved int
+}
+
+// logCycleSummary emits the single line telling whether a reconcile cycle worked
+func (r *Runner) logCycleSummary(duration time.Duration, err error) {
+	attrs := []any{
+		"users_processed", r.cycleStats.usersProcessed,
+		"users_unchanged", r.cycleStats.usersUnchanged,
+		"groups_created", r.cycleStats.groupsCreated,
+		"memberships_added", r.cycleStats.membershipsAdded,
+		"memberships_removed", r.cycleStats.membershipsRemoved,
+		"memberships_deferred", r.cycleStats.membershipsDeferred,
+		"changes_debounced", r.cycleStats.changesDebounced,
+		"groups_pruned", r.cycleStats.groupsPruned,
+		"gsuite_members_added", r.cycleStats.gsuiteMembersAdded,
+		"gsuite_members_removed", r.cycleStats.gsuiteMembersRemoved,
+		"errors", len(r.cycleFailures),
+		"duration", duration.String(),
+		"dry_run", r.dryRun,
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+
+	r.appCtx.Logger.Info("reconcile cycle summary", attrs...)
+	r.logFailureReport()
+	r.recordRealmReport()
+}
+
+// getRacedChildGroup returns the synced group someone else created under the parent with the given name right
+// before kegos tried to. A group holding the name without mirroring the same Gsuite group is never taken over
+func (r *Runner) getRacedChildGroup(parentID, name, identity string) (*gocloak.Group, error) {
+	children, err := r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, parentID)
+	if err != nil {
+		return nil, withStage(ErrKeycloakRead, fmt.Errorf("failed looking up group created meanwhile: %w", err))
+	}
+
+	group, err := groupNamed(children, name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("group %s already exists, but not under the synced parent group", name)
+	}
+	if !isManaged(group) || identityOf(group) != identity {
+		return nil, fmt.Errorf("group %s was created meanwhile for another Gsuite group", name)
+	}
+	return group, nil
+}
+
+// pruneOrphanGroups deletes the children of the synced parent group that no Gsuite group maps to anymore
+func (r *Runner) pruneOrphanGroups(kcChildrenGroups map[string]*gocloak.Group, seenGroups map[string]string) {
+
+	for _, identity := range slices.Sorted(maps.Keys(kcChildrenGroups)) {
+		kcGroup := kcChildrenGroups[identity]
+
+		if _, found := seenGroups[identity]; found {
+			continue
+		}
+
+		// Groups created by hand under the synced parent are never pruned
+		if !isManaged(kcGroup) {
+			continue
+		}
+
+		// Groups filtered out are never seen, which does not make them orphans
+		if !r.groupFilter.allows(sourceGroupOf(kcGroup)) {
+			continue
+		}
+
+		// Groups still holding deferred or debounced removals keep their members until those are applied
+		if r.removalPending(*kcGroup.ID) || r.removalDebounced(identity) {
+			r.appCtx.Logger.Info("orphaned group has deferred membership removals. Skipping its pruning...", "group", *kcGroup.Name)
+			continue
+		}
+
+		if r.dryRun {
+			r.appCtx.Logger.Info("dry-run: would delete orphaned group", "group", *kcGroup.Name)
+			continue
+		}
+
+		r.appCtx.Logger.Info("deleting orphaned group", "group", *kcGroup.Name)
+
+		err := r.withRetry(func() error {
+			return r.keycloak.DeleteGroup(r.keycloak.GetToken().AccessToken, *kcGroup.ID)
+		})
+		r.audit(AuditTargetKeycloak, AuditActionDelete, "", *kcGroup.Name, err)
 		if err != nil {
-			r.appCtx.Logger.Info("failed renewing Keycloak token", "error", err.Error())
-			goto takeANap
+			r.appCtx.Logger.Error("failed deleting orphaned group", "group", *kcGroup.Name, "error", err.Error())
+			r.recordError(OperationFailure{Stage: metrics.StageKeycloak, Operation: "prune group", Group: *kcGroup.Name, Err: err})
+			continue
 		}
 
-		//
-		r.reconcileUserGroups()
+		delete(kcChildrenGroups, identity)
+		metrics.GroupPrunes.Inc()
+		r.cycleStats.groupsPruned++
+	}
+}
+
+// ReconcileOnce runs a single reconcile cycle over every realm, returning an error when anything failed in it
+func (r *Runner) ReconcileOnce() (err error) {
+	r.cycleMu.Lock()
+	defer r.cycleMu.Unlock()
+
+	defer r.startCycle()()
+	cycleStart := r.clock.Now()
+	r.realmReports = nil
+	r.publish(ReconcileEvent{Kind: EventCycleStarted})
+	defer func() {
+		if r.CycleFailed(err) {
+			r.readiness.RecordCycle(err)
+		} else {
+			r.readiness.RecordCycle(nil)
+		}
+		r.recordHeartbeat(err)
+		r.notifyCycle(r.clock.Now().Sub(cycleStart), err)
+		r.publish(ReconcileEvent{Kind: EventCycleFinished, Err: err, Duration: r.clock.Now().Sub(cycleStart)})
+	}()
+
+	err = r.ensureGsuiteToken()
+	if err != nil {
+		return err
+	}
+
+	return r.forEachRealm(func(_ string) error {
+		return r.reconcileRealm()
+	})
+}
+
+// reconcileRealm runs a reconcile cycle on the realm the runner currently points to
+func (r *Runner) reconcileRealm() (err error) {
+
+	// Renew Keycloak JWT when it is missing or close to expiring
+	err = r.keycloak.EnsureToken()
+	if err != nil {
+		metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+		return withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
+	}
+	r.readiness.MarkKeycloakAuthenticated()
 
-	takeANap:
-		r.appCtx.Logger.Info(fmt.Sprintf("reconcile group finished. waiting for the next loop in %s", r.reconcileLoopDuration.String()))
-		time.Sleep(r.reconcileLoopDuration)
+	if r.syncTarget == SyncTargetRoles {
+		return r.reconcileUserRoles()
+	}
+	if r.writesToGsuite() {
+		return r.reconcileGroupMembers()
+	}
+	return r.reconcileUserGroups()
+}
+
+func (r *Runner) PleaseDoYourStuffForever() {
+	defer r.Close()
+
+	// Single users reconciled while the loop waits swap the logger, so the wait relies on a copy of its own
+	r.cycleMu.Lock()
+	appCtx := r.appCtx
+	r.cycleMu.Unlock()
+
+	for {
+		err := r.ReconcileOnce()
+
+		r.cycleMu.Lock()
+		if err != nil {
+			r.appCtx.Logger.Error("reconcile cycle failed", "error", err.Error())
+		}
+		wait := r.nextWait(err)
+		r.appCtx.Logger.Info(fmt.Sprintf("reconcile group finished. waiting for the next loop in %s", wait.String()))
+		r.cycleMu.Unlock()
+
+		select {
+		case <-appCtx.Context.Done():
+			appCtx.Logger.Info("context cancelled. stopping reconcile loop")
+			return
+		case <-r.clock.After(wait):
+		}
 	}
 }
@@ -4,25 +4,700 @@
 package runner
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"google.golang.org/api/googleapi"
+	"kegos/internal/globals"
+	"kegos/internal/gsuite"
+	"kegos/internal/health"
+	"kegos/internal/keycloak"
+	"kegos/internal/retry"
 )
 
-// fakeGsuiteClient returns canned groups or an error per domain.
+// fakeGsuiteClient returns canned groups or an error per domain, and canned members per group.
+// Users looked up are recorded in lookups.
 type fakeGsuiteClient struct {
 	groupsByDomain map[string][]string
 	errByDomain    map[string]error
+	membersByGroup map[string][]string
+
+	// detailsByDomain holds the metadata of the groups of each domain
+	detailsByDomain map[string][]gsuite.Group
+
+	// tokenErr fails every token check
+	tokenErr error
+
+	// validateErr fails every access validation
+	validateErr error
+
+	// memberWriteErr fails every member insertion and deletion
+	memberWriteErr error
+
+	lookups []string
+
+	// insertedMembers and deletedMembers record member writes as "group:email"
+	insertedMembers []string
+	deletedMembers  []string
 }
 
-func (f *fakeGsuiteClient) GetGroupsFromUser(domain string, _ string) ([]string, error) {
+func (f *fakeGsuiteClient) GetGroupsFromUser(domain string, user string) ([]string, error) {
+	f.lookups = append(f.lookups, user)
 	if err := f.errByDomain[domain]; err != nil {
 		return nil, err
 	}
 	return f.groupsByDomain[domain], nil
 }
 
+func (f *fakeGsuiteClient) GetAllGroups(domain string) ([]string, error) {
+	if err := f.errByDomain[domain]; err != nil {
+		return nil, err
+	}
+	return f.groupsByDomain[domain], nil
+}
+
+func (f *fakeGsuiteClient) GetGroupsMembers(_ []string) ([]gsuite.GroupMembers, error) {
+	return nil, nil
+}
+
+func (f *fakeGsuiteClient) GetUsersFromGroup(group string) ([]string, error) {
+	return f.membersByGroup[group], nil
+}
+
+func (f *fakeGsuiteClient) GetAllGroupsDetailed(domain string) ([]gsuite.Group, error) {
+	if err := f.errByDomain[domain]; err != nil {
+		return nil, err
+	}
+	return f.detailsByDomain[domain], nil
+}
+
+func (f *fakeGsuiteClient) InsertMember(group, email string) error {
+	if f.memberWriteErr != nil {
+		return f.memberWriteErr
+	}
+	f.insertedMembers = append(f.insertedMembers, group+":"+email)
+	return nil
+}
+
+func (f *fakeGsuiteClient) DeleteMember(group, email string) error {
+	if f.memberWriteErr != nil {
+		return f.memberWriteErr
+	}
+	f.deletedMembers = append(f.deletedMembers, group+":"+email)
+	return nil
+}
+
+func (f *fakeGsuiteClient) CheckToken() error {
+	return f.tokenErr
+}
+
+func (f *fakeGsuiteClient) Validate(string) error {
+	return f.validateErr
+}
+
+// fakeDirectory models a whole Gsuite directory as domain -> group -> members and answers
+// both the per-user and the prefetch queries from it.
+type fakeDirectory struct {
+	membersByDomain map[string]map[string][]string
+}
+
+func (f *fakeDirectory) GetGroupsFromUser(domain string, user string) (groups []string, err error) {
+	for group, members := range f.membersByDomain[domain] {
+		for _, member := range members {
+			if strings.EqualFold(member, user) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (f *fakeDirectory) GetAllGroups(domain string) (groups []string, err error) {
+	for group := range f.membersByDomain[domain] {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (f *fakeDirectory) GetGroupsMembers(groups []string) (groupsMembers []gsuite.GroupMembers, err error) {
+	for _, group := range groups {
+		for _, domainGroups := range f.membersByDomain {
+			if members, found := domainGroups[group]; found {
+				groupsMembers = append(groupsMembers, gsuite.GroupMembers{Group: group, Users: members})
+			}
+		}
+	}
+	return groupsMembers, nil
+}
+
+func (f *fakeDirectory) GetUsersFromGroup(group string) (members []string, err error) {
+	for _, domainGroups := range f.membersByDomain {
+		members = append(members, domainGroups[group]...)
+	}
+	return members, nil
+}
+
+func (f *fakeDirectory) GetAllGroupsDetailed(_ string) ([]gsuite.Group, error) {
+	return nil, nil
+}
+
+func (f *fakeDirectory) InsertMember(_, _ string) error {
+	return nil
+}
+
+func (f *fakeDirectory) DeleteMember(_, _ string) error {
+	return nil
+}
+
+func (f *fakeDirectory) CheckToken() error {
+	return nil
+}
+
+func (f *fakeDirectory) Validate(string) error {
+	return nil
+}
+
+// fakeKeycloakClient serves a canned realm and records every mutating call.
+type fakeKeycloakClient struct {
+	parent   *gocloak.Group
+	children []*gocloak.Group
+
+	// childrenByParent overrides children for the given parent IDs, to model nested parents
+	childrenByParent map[string][]*gocloak.Group
+	users            []*gocloak.User
+	userGroups       map[string][]*gocloak.Group
+
+	// childrenListings counts the calls listing children groups, and topLevelLookups those looking up top level ones
+	childrenListings int
+	topLevelLookups  int
+
+	// goneGroups are group IDs deleted behind kegos' back: listing or creating their children fails with a 404
+	goneGroups map[string]bool
+
+	created        []string
+	createdGroups  []gocloak.Group
+	createdParents []string
+	updated        []gocloak.Group
+	additions      []string
+	deletions      []string
+	pruned         []string
+
+	// membershipErrs fails membership changes on the given group IDs
+	membershipErrs map[string]error
+
+	// createErrs fails the creation of the groups with the given names
+	createErrs map[string]error
+
+	// tokenErr fails every login
+	tokenErr error
+
+	// usersErr fails every listing of users
+	usersErr error
+
+	// userAttributes holds the attributes set on every user ID, merged as Keycloak would
+	userAttributes map[string]map[string][]string
+
+	// racedGroups are created by someone else right before kegos tries to: creating one of them fails
+	// with a conflict, and it shows up among the children from then on
+	racedGroups []*gocloak.Group
+
+	realmRoles    []*gocloak.Role
+	userRoles     map[string][]*gocloak.Role
+	createdRoles  []gocloak.Role
+	roleAdditions []string
+	roleDeletions []string
+}
+
+func (f *fakeKeycloakClient) EnsureToken() error     { return f.tokenErr }
+func (f *fakeKeycloakClient) GetToken() *gocloak.JWT { return &gocloak.JWT{AccessToken: "token"} }
+
+func (f *fakeKeycloakClient) GetTopLevelGroup(_, name string) (*gocloak.Group, error) {
+	f.topLevelLookups++
+	if f.parent == nil || *f.parent.Name != name {
+		return nil, nil
+	}
+	return f.parent, nil
+}
+
+func (f *fakeKeycloakClient) CreateGroup(_ string, group gocloak.Group) (string, error) {
+	f.created = append(f.created, *group.Name)
+	return "id-" + *group.Name, nil
+}
+
+func (f *fakeKeycloakClient) CreateChildGroup(_, parentID string, group gocloak.Group) (string, error) {
+	if f.goneGroups[parentID] {
+		return "", &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found: Could not find parent group"}
+	}
+	if err := f.createErrs[*group.Name]; err != nil {
+		return "", err
+	}
+	for i, raced := range f.racedGroups {
+		if *raced.Name == *group.Name {
+			f.children = append(f.children, raced)
+			f.racedGroups = slices.Delete(f.racedGroups, i, i+1)
+			return "", &gocloak.APIError{Code: http.StatusConflict, Message: "409 Conflict: Sibling group named '" + *group.Name + "' already exists."}
+		}
+	}
+	f.created = append(f.created, *group.Name)
+	f.createdParents = append(f.createdParents, parentID)
+	f.createdGroups = append(f.createdGroups, group)
+	return "id-" + *group.Name, nil
+}
+
+func (f *fakeKeycloakClient) GetChildrenGroups(_, groupID string) ([]*gocloak.Group, error) {
+	f.childrenListings++
+	if f.goneGroups[groupID] {
+		return nil, &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found: Could not find group by id"}
+	}
+	if children, found := f.childrenByParent[groupID]; found {
+		return children, nil
+	}
+	return f.children, nil
+}
+
+func (f *fakeKeycloakClient) GetUsers(_ string) ([]*gocloak.User, error) {
+	if f.usersErr != nil {
+		return nil, f.usersErr
+	}
+	return f.users, nil
+}
+
+func (f *fakeKeycloakClient) EnsureUserAttributes(_, userID string, attributes map[string][]string) (bool, error) {
+	if f.userAttributes == nil {
+		f.userAttributes = map[string]map[string][]string{}
+	}
+	if f.userAttributes[userID] == nil {
+		f.userAttributes[userID] = map[string][]string{}
+	}
+	maps.Copy(f.userAttributes[userID], attributes)
+	return true, nil
+}
+
+func (f *fakeKeycloakClient) GetUserByUsername(_, username string) (*gocloak.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(gocloak.PString(user.Username), username) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeKeycloakClient) GetUserByEmail(_, email string) (*gocloak.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(gocloak.PString(user.Email), email) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeKeycloakClient) GetUserGroups(userID, _ string) ([]*gocloak.Group, error) {
+	return f.userGroups[userID], nil
+}
+
+// GetGroupMembers returns the users holding the group, in the order of users.
+func (f *fakeKeycloakClient) GetGroupMembers(_, groupID string) (members []*gocloak.User, err error) {
+	for _, user := range f.users {
+		for _, group := range f.userGroups[*user.ID] {
+			if *group.ID == groupID {
+				members = append(members, user)
+			}
+		}
+	}
+	return members, nil
+}
+
+func (f *fakeKeycloakClient) UpdateGroupMemberships(_ string, changes []keycloak.MembershipChange, _ retry.Options) []error {
+	errs := make([]error, len(changes))
+	for i, change := range changes {
+		if err := f.membershipErrs[change.GroupID]; err != nil {
+			errs[i] = err
+			continue
+		}
+		if change.Remove {
+			f.deletions = append(f.deletions, change.UserID+":"+change.GroupID)
+			continue
+		}
+		f.additions = append(f.additions, change.UserID+":"+change.GroupID)
+	}
+	return errs
+}
+
+func (f *fakeKeycloakClient) DeleteGroup(_, groupID string) error {
+	f.pruned = append(f.pruned, groupID)
+	return nil
+}
+
+func (f *fakeKeycloakClient) EnsureGroupAttributes(_, groupID string, attributes map[string][]string) (bool, error) {
+	group := f.findGroup(groupID)
+	if group == nil {
+		return false, &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found: Could not find group by id"}
+	}
+
+	merged := map[string][]string{}
+	if group.Attributes != nil {
+		maps.Copy(merged, *group.Attributes)
+	}
+	maps.Copy(merged, attributes)
+	group.Attributes = &merged
+
+	f.updated = append(f.updated, *group)
+	return true, nil
+}
+
+// findGroup returns the group with the given ID as the realm holds it, created ones included
+func (f *fakeKeycloakClient) findGroup(groupID string) *gocloak.Group {
+	groups := slices.Concat(f.children, slices.Concat(slices.Collect(maps.Values(f.childrenByParent))...))
+	for _, group := range groups {
+		if gocloak.PString(group.ID) == groupID {
+			return group
+		}
+	}
+	for i := range f.createdGroups {
+		if "id-"+*f.createdGroups[i].Name == groupID {
+			f.createdGroups[i].ID = gocloak.StringP(groupID)
+			return &f.createdGroups[i]
+		}
+	}
+	return nil
+}
+
+func (f *fakeKeycloakClient) GetRealmRoles(_ string) ([]*gocloak.Role, error) {
+	return f.realmRoles, nil
+}
+
+func (f *fakeKeycloakClient) GetRealmRole(_, name string) (*gocloak.Role, error) {
+	for _, role := range f.createdRoles {
+		if *role.Name == name {
+			return &gocloak.Role{ID: gocloak.StringP("role-id-" + name), Name: role.Name, Attributes: role.Attributes}, nil
+		}
+	}
+	return nil, errors.New("role not found")
+}
+
+func (f *fakeKeycloakClient) CreateRealmRole(_ string, role gocloak.Role) error {
+	f.createdRoles = append(f.createdRoles, role)
+	return nil
+}
+
+func (f *fakeKeycloakClient) GetUserRealmRoles(userID, _ string) ([]*gocloak.Role, error) {
+	return f.userRoles[userID], nil
+}
+
+func (f *fakeKeycloakClient) AddRealmRoleToUser(_, userID string, role gocloak.Role) error {
+	f.roleAdditions = append(f.roleAdditions, userID+":"+*role.Name)
+	return nil
+}
+
+func (f *fakeKeycloakClient) DeleteRealmRoleFromUser(_, userID string, role gocloak.Role) error {
+	f.roleDeletions = append(f.roleDeletions, userID+":"+*role.Name)
+	return nil
+}
+
+// newFakeRealm returns a realm where alice holds one stale managed group, one manual group,
+// and belongs in Google to a group that does not exist in Keycloak yet.
+func newFakeRealm() (*fakeKeycloakClient, *fakeGsuiteClient) {
+	kc := &fakeKeycloakClient{
+		parent: &gocloak.Group{ID: gocloak.StringP("id-parent"), Name: gocloak.StringP("google-workspace")},
+		children: []*gocloak.Group{
+			{ID: gocloak.StringP("id-old@corp.com"), Name: gocloak.StringP("old@corp.com"),
+				Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}},
+		},
+		users: []*gocloak.User{
+			{ID: gocloak.StringP("alice-id"), Username: gocloak.StringP("alice@corp.com"), Email: gocloak.StringP("alice@corp.com")},
+		},
+		userGroups: map[string][]*gocloak.Group{
+			"alice-id": {
+				{ID: gocloak.StringP("id-old@corp.com"), Name: gocloak.StringP("old@corp.com"), Path: gocloak.StringP("/google-workspace/old@corp.com")},
+				{ID: gocloak.StringP("id-manual"), Name: gocloak.StringP("manual"), Path: gocloak.StringP("/manual")},
+			},
+		},
+	}
+	gs := &fakeGsuiteClient{groupsByDomain: map[string][]string{"corp.com": {"new@corp.com"}}}
+	return kc, gs
+}
+
+func newTestAppCtx(logs io.Writer) *globals.ApplicationContext {
+	return &globals.ApplicationContext{
+		Context: context.Background(),
+		Logger:  slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+}
+
+func newTestRunner(kc keycloakClient, gs gsuiteClient, logs *bytes.Buffer, dryRun bool) *Runner {
+	return &Runner{
+		appCtx:           newTestAppCtx(logs),
+		gsuiteDomains:    []string{"corp.com"},
+		syncedParentPath: []string{"google-workspace"},
+		dryRun:           dryRun,
+		gsuiteCli:        gs,
+		realms:           []realmClient{{name: "test", keycloak: kc}},
+		keycloak:         kc,
+		clock:            systemClock{},
+		userSnapshots:    map[string]map[string]string{},
+		pendingRemovals:  map[string]map[string]time.Time{},
+		pendingChanges:   map[string]map[string]int{},
+	}
+}
+
+// withJitter must stay within [duration, duration+jitter) and leave the duration alone without jitter.
+func TestWithJitter(t *testing.T) {
+	if got := withJitter(time.Minute, 0); got != time.Minute {
+		t.Fatalf("got %s, want %s without jitter", got, time.Minute)
+	}
+
+	for range 100 {
+		got := withJitter(time.Minute, time.Second)
+		if got < time.Minute || got >= time.Minute+time.Second {
+			t.Fatalf("got %s, want it within [1m, 1m1s)", got)
+		}
+	}
+}
+
+// A regular run must create missing groups, add new memberships and drop stale managed ones only.
+func TestReconcileUserGroupsAppliesChanges(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	r.reconcileUserGroups()
+
+	if want := []string{"new@corp.com"}; !reflect.DeepEqual(kc.created, want) {
+		t.Fatalf("created %v, want %v", kc.created, want)
+	}
+	if want := []string{"alice-id:id-new@corp.com"}; !reflect.DeepEqual(kc.additions, want) {
+		t.Fatalf("additions %v, want %v", kc.additions, want)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+}
+
+// Every cycle must end with a single summary line counting what it did.
+func TestReconcileUserGroupsLogsSummary(t *testing.T) {
+	kc, gs := newFakeRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := logs.String()
+	for _, want := range []string{
+		`"msg":"reconcile cycle summary"`,
+		`"users_processed":1`,
+		`"groups_created":1`,
+		`"memberships_added":1`,
+		`"memberships_removed":1`,
+		`"groups_pruned":0`,
+		`"errors":0`,
+		`"duration":`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected logs to contain %s, got %s", want, output)
+		}
+	}
+}
+
+// Realms larger than a Keycloak page must be reconciled whole, not only their first 100 users.
+func TestReconcileUserGroupsProcessesEveryUser(t *testing.T) {
+	const total = 150
+
+	kc, gs := newFakeRealm()
+	kc.users = nil
+	for i := range total {
+		username := fmt.Sprintf("user%d@corp.com", i)
+		kc.users = append(kc.users, &gocloak.User{
+			ID: gocloak.StringP(fmt.Sprintf("user%d-id", i)), Username: gocloak.StringP(username), Email: gocloak.StringP(username),
+		})
+	}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r.cycleStats.usersProcessed != total {
+		t.Fatalf("processed %d users, want %d", r.cycleStats.usersProcessed, total)
+	}
+	if len(kc.additions) != total {
+		t.Fatalf("got %d additions, want %d", len(kc.additions), total)
+	}
+}
+
+// Users and their groups must be processed in sorted order, whatever order Keycloak and Gsuite return them in.
+func TestReconcileUserGroupsProcessesInSortedOrder(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users = nil
+	for _, name := range []string{"zoe", "mike", "alice", "bob", "yann", "carol"} {
+		kc.users = append(kc.users, &gocloak.User{
+			ID: gocloak.StringP(name + "-id"), Username: gocloak.StringP(name), Email: gocloak.StringP(name + "@corp.com"),
+		})
+	}
+	gs.groupsByDomain = map[string][]string{"corp.com": {"zeta@corp.com", "alpha@corp.com", "mu@corp.com"}}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantLookups := []string{"alice@corp.com", "bob@corp.com", "carol@corp.com", "mike@corp.com", "yann@corp.com", "zoe@corp.com"}
+	if !reflect.DeepEqual(gs.lookups, wantLookups) {
+		t.Fatalf("processed users %v, want %v", gs.lookups, wantLookups)
+	}
+	if want := []string{"alpha@corp.com", "mu@corp.com", "zeta@corp.com"}; !reflect.DeepEqual(kc.created, want) {
+		t.Fatalf("created %v, want %v", kc.created, want)
+	}
+}
+
+// Users without username or email must be skipped or ignored with a warning, while the rest are still reconciled.
+func TestReconcileUserGroupsToleratesIncompleteUsers(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.users = append(kc.users,
+		&gocloak.User{ID: gocloak.StringP("nameless-id"), Email: gocloak.StringP("nameless@corp.com")},
+		&gocloak.User{ID: gocloak.StringP("mailless-id"), Username: gocloak.StringP("mailless")},
+		&gocloak.User{Username: gocloak.StringP("idless")},
+	)
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice@corp.com"}; !reflect.DeepEqual(gs.lookups, want) {
+		t.Fatalf("looked up %v, want %v", gs.lookups, want)
+	}
+	for _, want := range []string{`"msg":"user has no ID or username. Ignoring user...","id":"nameless-id"`,
+		`"msg":"user has no value for the match attribute. Ignoring user...","user":"mailless"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %s in logs, got %s", want, logs.String())
+		}
+	}
+}
+
+// User groups without path, as brief representations come, must still be told managed or not by their ID.
+func TestReconcileUserGroupsWithoutGroupPaths(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.userGroups["alice-id"] = []*gocloak.Group{
+		{ID: gocloak.StringP("id-old@corp.com"), Name: gocloak.StringP("old@corp.com")},
+		{ID: gocloak.StringP("id-manual"), Name: gocloak.StringP("manual")},
+	}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+}
+
+// A group created by someone else right before kegos must be looked up and joined, unless it mirrors another Gsuite group.
+func TestReconcileUserGroupsHandlesGroupCreationRaces(t *testing.T) {
+	tests := map[string]struct {
+		sourceGroup   string
+		wantAdditions []string
+		wantErr       bool
+	}{
+		"group created for the same Gsuite group": {
+			sourceGroup:   "new@corp.com",
+			wantAdditions: []string{"alice-id:id-raced"},
+		},
+		"group created for another Gsuite group": {
+			sourceGroup: "other@corp.com",
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.racedGroups = []*gocloak.Group{{
+				ID:   gocloak.StringP("id-raced"),
+				Name: gocloak.StringP("new@corp.com"),
+				Attributes: &map[string][]string{
+					GroupAttributeManaged:     {"true"},
+					GroupAttributeSourceGroup: {tc.sourceGroup},
+				},
+			}}
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+			err := r.reconcileUserGroups()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if r.cycleStats.groupsCreated != 0 {
+				t.Fatalf("expected no group to be counted as created, got %d", r.cycleStats.groupsCreated)
+			}
+		})
+	}
+}
+
+// An add Keycloak rejects because the user joined the group meanwhile must be taken as done, not as a failure.
+func TestReconcileUserGroupsToleratesAlreadyMemberAdds(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.membershipErrs = map[string]error{"id-new@corp.com": &gocloak.APIError{Code: http.StatusConflict}}
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, false)
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.cycleFailures) > 0 {
+		t.Fatalf("expected no failure recorded, got %v", r.cycleFailures)
+	}
+	if r.cycleStats.membershipsAdded != 0 {
+		t.Fatalf("got %d memberships added, want none", r.cycleStats.membershipsAdded)
+	}
+	if !strings.Contains(logs.String(), `"reason":"`+skipReasonAlreadyMember+`"`) {
+		t.Fatalf("expected the add to be logged as already done, logs:\n%s", logs.String())
+	}
+}
+
+// On dry-run nothing must reach Keycloak, but every planned change must be reported for the user.
+func TestReconcileUserGroupsDryRunOnlyReports(t *testing.T) {
+	kc, gs := newFakeRealm()
+	logs := &bytes.Buffer{}
+	r := newTestRunner(kc, gs, logs, true)
+
+	r.reconcileUserGroups()
+
+	if len(kc.created)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v", kc.created, kc.additions, kc.deletions)
+	}
+
+	output := logs.String()
+	for _, want := range []string{
+		`"msg":"dry-run: would reconcile user groups"`,
+		`"additions":["new@corp.com"]`,
+		`"deletions":["old@corp.com"]`,
+		`"creations":["new@corp.com"]`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected logs to contain %s, got %s", want, output)
+		}
+	}
+}
+
 // getGsuiteGroupsForUser must union the user's groups across every configured domain and deduplicate them.
 func TestGetGsuiteGroupsForUserUnionsAndDeduplicates(t *testing.T) {
 	tests := map[string]struct {
@@ -51,6 +726,19 @@ func TestGetGsuiteGroupsForUserUnionsAndDeduplicates(t *testing.T) {
 			},
 			want: []string{"shared@corp.example", "dev@example.com"},
 		},
+		"a group listed twice appears once": {
+			domains:        []string{"example.com"},
+			groupsByDomain: map[string][]string{"example.com": {"dev@example.com", "all@example.com", "dev@example.com"}},
+			want:           []string{"dev@example.com", "all@example.com"},
+		},
+		"a group spelled with another case keeps its first spelling": {
+			domains: []string{"example.com", "example.org"},
+			groupsByDomain: map[string][]string{
+				"example.com": {"Dev@Example.com"},
+				"example.org": {"dev@example.com"},
+			},
+			want: []string{"Dev@Example.com"},
+		},
 		"user with no groups anywhere yields nothing": {
 			domains:        []string{"example.com", "example.org"},
 			groupsByDomain: map[string][]string{},
@@ -61,6 +749,7 @@ func TestGetGsuiteGroupsForUserUnionsAndDeduplicates(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			r := &Runner{
+				appCtx:        newTestAppCtx(io.Discard),
 				gsuiteDomains: tc.domains,
 				gsuiteCli:     &fakeGsuiteClient{groupsByDomain: tc.groupsByDomain},
 			}
@@ -76,11 +765,55 @@ func TestGetGsuiteGroupsForUserUnionsAndDeduplicates(t *testing.T) {
 	}
 }
 
+// Groups repeated by Google, with the same or another case, must end in a single creation and addition
+// whether they come from per-user lookups or from the prefetched members.
+func TestReconcileUserGroupsIgnoresDuplicatedGroups(t *testing.T) {
+	tests := map[string]struct {
+		prefetch bool
+		gsuite   gsuiteClient
+	}{
+		"per-user lookups": {
+			gsuite: &fakeGsuiteClient{groupsByDomain: map[string][]string{
+				"corp.com": {"new@corp.com", "NEW@corp.com", "new@corp.com"},
+			}},
+		},
+		"prefetched members": {
+			prefetch: true,
+			gsuite: &fakeDirectory{membersByDomain: map[string]map[string][]string{
+				"corp.com": {"new@corp.com": {"alice@corp.com", "ALICE@corp.com", "alice@corp.com"}},
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, _ := newFakeRealm()
+			r := newTestRunner(kc, tc.gsuite, &bytes.Buffer{}, false)
+			r.gsuitePrefetch = tc.prefetch
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want := []string{"new@corp.com"}; !reflect.DeepEqual(kc.created, want) {
+				t.Fatalf("created %v, want %v", kc.created, want)
+			}
+			if want := []string{"alice-id:id-new@corp.com"}; !reflect.DeepEqual(kc.additions, want) {
+				t.Fatalf("additions %v, want %v", kc.additions, want)
+			}
+			if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, want)
+			}
+		})
+	}
+}
+
 // A failure on any domain must abort the union so a transient error never yields a partial
 // list that would trigger spurious group removals during reconcile.
 func TestGetGsuiteGroupsForUserPropagatesDomainError(t *testing.T) {
 	boom := errors.New("api unavailable")
 	r := &Runner{
+		appCtx:        newTestAppCtx(io.Discard),
 		gsuiteDomains: []string{"example.com", "example.org"},
 		gsuiteCli: &fakeGsuiteClient{
 			groupsByDomain: map[string][]string{"example.com": {"dev@example.com"}},
@@ -97,6 +830,26 @@ func TestGetGsuiteGroupsForUserPropagatesDomainError(t *testing.T) {
 	}
 }
 
+// A user unknown to one domain must still get the groups of the others instead of failing the lookup.
+func TestGetGsuiteGroupsForUserSkipsDomainsWithoutUser(t *testing.T) {
+	r := &Runner{
+		appCtx:        newTestAppCtx(io.Discard),
+		gsuiteDomains: []string{"example.com", "example.org"},
+		gsuiteCli: &fakeGsuiteClient{
+			groupsByDomain: map[string][]string{"example.org": {"ops@example.org"}},
+			errByDomain:    map[string]error{"example.com": &googleapi.Error{Code: http.StatusNotFound}},
+		},
+	}
+
+	got, err := r.getGsuiteGroupsForUser("user@example.org")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"ops@example.org"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
 // userDelayFromRate must convert users-per-minute into a pause and never divide by zero.
 func TestUserDelayFromRate(t *testing.T) {
 	tests := map[string]struct {
@@ -117,3 +870,253 @@ func TestUserDelayFromRate(t *testing.T) {
 		})
 	}
 }
+
+// getUserMatchKey must read the configured attribute and report missing values as empty.
+func TestGetUserMatchKey(t *testing.T) {
+	tests := map[string]struct {
+		attribute string
+		user      *gocloak.User
+		want      string
+	}{
+		"email is used by default": {
+			attribute: "",
+			user:      &gocloak.User{Username: gocloak.StringP("8f1c"), Email: gocloak.StringP("alice@corp.com")},
+			want:      "alice@corp.com",
+		},
+		"username when configured": {
+			attribute: UserMatchAttributeUsername,
+			user:      &gocloak.User{Username: gocloak.StringP("alice@corp.com"), Email: gocloak.StringP("other@corp.com")},
+			want:      "alice@corp.com",
+		},
+		"missing email yields nothing": {
+			attribute: UserMatchAttributeEmail,
+			user:      &gocloak.User{Username: gocloak.StringP("8f1c")},
+			want:      "",
+		},
+		"blank email yields nothing": {
+			attribute: UserMatchAttributeEmail,
+			user:      &gocloak.User{Username: gocloak.StringP("8f1c"), Email: gocloak.StringP("  ")},
+			want:      "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{userMatchAttribute: tc.attribute}
+			if got := r.getUserMatchKey(tc.user); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// A cancelled context must stop the cycle before any user is touched.
+func TestReconcileUserGroupsStopsOnCancelledContext(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.appCtx.Context = ctx
+
+	r.reconcileUserGroups()
+
+	if len(kc.created)+len(kc.additions)+len(kc.deletions) > 0 {
+		t.Fatalf("expected no mutations, got created %v, additions %v, deletions %v", kc.created, kc.additions, kc.deletions)
+	}
+}
+
+// The reconcile loop must return promptly once the context is cancelled instead of sleeping it out.
+func TestPleaseDoYourStuffForeverReturnsOnCancel(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.reconcileLoopDuration = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.appCtx.Context = ctx
+
+	done := make(chan struct{})
+	go func() {
+		r.PleaseDoYourStuffForever()
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("reconcile loop did not stop after cancellation")
+	}
+}
+
+// Pruning must delete synced groups that no Gsuite group maps to, and only when enabled.
+func TestReconcileUserGroupsPrunesOrphanGroups(t *testing.T) {
+	tests := map[string]struct {
+		pruneGroups bool
+		dryRun      bool
+		want        []string
+	}{
+		"disabled keeps orphaned groups":     {pruneGroups: false, want: nil},
+		"enabled deletes orphaned groups":    {pruneGroups: true, want: []string{"id-old@corp.com"}},
+		"dry-run only reports the deletions": {pruneGroups: true, dryRun: true, want: nil},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, tc.dryRun)
+			r.pruneGroups = tc.pruneGroups
+
+			r.reconcileUserGroups()
+
+			if !reflect.DeepEqual(kc.pruned, tc.want) {
+				t.Fatalf("pruned %v, want %v", kc.pruned, tc.want)
+			}
+		})
+	}
+}
+
+// A failed Gsuite lookup leaves a partial view of groups, so nothing must be pruned.
+func TestReconcileUserGroupsSkipsPruningOnGsuiteFailure(t *testing.T) {
+	kc, _ := newFakeRealm()
+	gs := &fakeGsuiteClient{errByDomain: map[string]error{"corp.com": errors.New("api unavailable")}}
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.pruneGroups = true
+
+	r.reconcileUserGroups()
+
+	if len(kc.pruned) > 0 {
+		t.Fatalf("expected no pruning, got %v", kc.pruned)
+	}
+}
+
+// The prefetch path must lead to exactly the same membership decisions as the per-user lookups.
+func TestReconcileUserGroupsPrefetchMatchesPerUserLookups(t *testing.T) {
+	directory := &fakeDirectory{membersByDomain: map[string]map[string][]string{
+		"corp.com": {
+			"new@corp.com":  {"Alice@corp.com", "bob@corp.com"},
+			"team@corp.com": {"bob@corp.com"},
+		},
+		"corp.org": {
+			"ops@corp.org": {"alice@corp.com"},
+		},
+	}}
+
+	newRealm := func() *fakeKeycloakClient {
+		kc, _ := newFakeRealm()
+		kc.users = append(kc.users, &gocloak.User{
+			ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com"),
+		})
+		return kc
+	}
+
+	run := func(prefetch bool) *fakeKeycloakClient {
+		kc := newRealm()
+		r := newTestRunner(kc, directory, &bytes.Buffer{}, false)
+		r.gsuiteDomains = []string{"corp.com", "corp.org"}
+		r.gsuitePrefetch = prefetch
+		r.reconcileUserGroups()
+
+		slices.Sort(kc.created)
+		slices.Sort(kc.additions)
+		slices.Sort(kc.deletions)
+		return kc
+	}
+
+	perUser, prefetched := run(false), run(true)
+
+	if !reflect.DeepEqual(perUser.created, prefetched.created) {
+		t.Fatalf("created differs: per-user %v, prefetch %v", perUser.created, prefetched.created)
+	}
+	if !reflect.DeepEqual(perUser.additions, prefetched.additions) {
+		t.Fatalf("additions differ: per-user %v, prefetch %v", perUser.additions, prefetched.additions)
+	}
+	if !reflect.DeepEqual(perUser.deletions, prefetched.deletions) {
+		t.Fatalf("deletions differ: per-user %v, prefetch %v", perUser.deletions, prefetched.deletions)
+	}
+	if len(prefetched.additions) != 4 {
+		t.Fatalf("expected 4 additions, got %v", prefetched.additions)
+	}
+}
+
+// ReconcileOnce must succeed on a clean cycle and report failures so a one-shot run can exit non-zero.
+// Cycles with some failed operations only count as failed with failOnPartial.
+func TestReconcileOnceReportsFailures(t *testing.T) {
+	tests := map[string]struct {
+		gsuiteErr      error
+		membershipErrs map[string]error
+		failOnPartial  bool
+		wantErr        bool
+		wantFailed     bool
+		wantReady      bool
+	}{
+		"clean cycle succeeds":             {gsuiteErr: nil, wantErr: false, wantReady: true},
+		"per-user gsuite failure is error": {gsuiteErr: errors.New("api unavailable"), wantErr: true, wantReady: false},
+		"partial failure, lenient": {
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			wantErr:        true,
+			wantReady:      true,
+		},
+		"partial failure, strict": {
+			membershipErrs: map[string]error{"id-old@corp.com": errors.New("forbidden")},
+			failOnPartial:  true,
+			wantErr:        true,
+			wantFailed:     true,
+			wantReady:      false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			if tc.gsuiteErr != nil {
+				gs.errByDomain = map[string]error{"corp.com": tc.gsuiteErr}
+			}
+			kc.membershipErrs = tc.membershipErrs
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.readiness = health.NewReadiness(1)
+			r.failOnPartial = tc.failOnPartial
+
+			err := r.ReconcileOnce()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := r.CycleFailed(err); got != tc.wantFailed {
+				t.Fatalf("got failed %v, want %v", got, tc.wantFailed)
+			}
+			if got := r.readiness.Ready(); got != tc.wantReady {
+				t.Fatalf("got ready %v, want %v", got, tc.wantReady)
+			}
+		})
+	}
+}
+
+// Aborted cycles must count as failed whatever failOnPartial says.
+func TestCycleFailed(t *testing.T) {
+	partial := &CycleError{Failures: []OperationFailure{{Operation: "add membership", Err: errors.New("forbidden")}}}
+	aborted := withStage(ErrKeycloakAuth, errors.New("unauthorized"))
+
+	tests := map[string]struct {
+		err           error
+		failOnPartial bool
+		want          bool
+	}{
+		"success":                  {},
+		"partial, lenient":         {err: partial},
+		"partial, strict":          {err: partial, failOnPartial: true, want: true},
+		"aborted, lenient":         {err: aborted, want: true},
+		"aborted, strict":          {err: aborted, failOnPartial: true, want: true},
+		"partial, wrapped":         {err: fmt.Errorf("realm test: %w", partial)},
+		"several realms, one lost": {err: &abortedError{err: errors.Join(partial, aborted)}, want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{failOnPartial: tc.failOnPartial}
+			if got := r.CycleFailed(tc.err); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+// Reasons why a reconcile cycle leaves a user, or one of its memberships, as it is. They are logged as the
+// reason field, so finding out why a user is not in a group can start from the logs
+const (
+	skipReasonNoMatchKey          = "no_match_key"
+	skipReasonGsuiteLookupFailed  = "gsuite_lookup_failed"
+	skipReasonUnchanged           = "unchanged"
+	skipReasonNotManaged          = "not_managed"
+	skipReasonFilteredOut         = "filtered_out"
+	skipReasonCapped              = "capped"
+	skipReasonNameCollision       = "name_collision"
+	skipReasonAlreadyMember       = "already_member"
+	skipReasonGroupCreationFailed = "group_creation_failed"
+	skipReasonDryRun              = "dry_run"
+	skipReasonRemovalDeferred     = "removal_deferred"
+	skipReasonDebounced           = "debounced"
+)
+
+// logSkippedMembership logs at debug why a membership of a user is neither added nor removed
+func (r *Runner) logSkippedMembership(reason, user, group string) {
+	r.appCtx.Logger.Debug("membership left as it is", "reason", reason, "user", user, "group", group)
+}
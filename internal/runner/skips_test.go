@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// loggedSkipReasons returns the reason of every log line carrying one, in order.
+func loggedSkipReasons(t *testing.T, logs *bytes.Buffer) (reasons []string) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if line.Reason != "" {
+			reasons = append(reasons, line.Reason)
+		}
+	}
+	return reasons
+}
+
+// Every user or membership left as it is must be logged with the reason why.
+func TestReconcileUserGroupsLogsSkipReasons(t *testing.T) {
+	tests := map[string]struct {
+		setup      func(r *Runner, kc *fakeKeycloakClient, gs *fakeGsuiteClient)
+		wantReason string
+	}{
+		"user without match key": {
+			setup: func(_ *Runner, kc *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				kc.users[0].Email = nil
+			},
+			wantReason: skipReasonNoMatchKey,
+		},
+		"failed Gsuite lookup": {
+			setup: func(_ *Runner, _ *fakeKeycloakClient, gs *fakeGsuiteClient) {
+				gs.errByDomain = map[string]error{"corp.com": errors.New("boom")}
+			},
+			wantReason: skipReasonGsuiteLookupFailed,
+		},
+		"unchanged user": {
+			setup: func(r *Runner, _ *fakeKeycloakClient, gs *fakeGsuiteClient) {
+				gs.groupsByDomain["corp.com"] = []string{"old@corp.com"}
+				r.incremental = true
+				r.reconcileUserGroups()
+			},
+			wantReason: skipReasonUnchanged,
+		},
+		"group not managed": {
+			setup:      func(*Runner, *fakeKeycloakClient, *fakeGsuiteClient) {},
+			wantReason: skipReasonNotManaged,
+		},
+		"group filtered out": {
+			setup: func(r *Runner, _ *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				r.groupFilter, _ = newGroupFilter(nil, []string{"^old@"})
+			},
+			wantReason: skipReasonFilteredOut,
+		},
+		"group beyond the cap": {
+			setup: func(r *Runner, _ *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				r.caps = cycleCaps{maxGroups: 1}
+			},
+			wantReason: skipReasonCapped,
+		},
+		"group name collision": {
+			setup: func(r *Runner, _ *fakeKeycloakClient, gs *fakeGsuiteClient) {
+				gs.groupsByDomain = map[string][]string{"corp.com": {"dev@corp.com"}, "corp.org": {"dev@corp.org"}}
+				r.gsuiteDomains = []string{"corp.com", "corp.org"}
+				r.groupNamer = groupNamer{mapper: StripDomainGroupNameMapper{}}
+			},
+			wantReason: skipReasonNameCollision,
+		},
+		"already member": {
+			setup: func(_ *Runner, _ *fakeKeycloakClient, gs *fakeGsuiteClient) {
+				gs.groupsByDomain["corp.com"] = []string{"old@corp.com"}
+			},
+			wantReason: skipReasonAlreadyMember,
+		},
+		"group creation failed": {
+			setup: func(_ *Runner, kc *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				kc.createErrs = map[string]error{"new@corp.com": errors.New("boom")}
+			},
+			wantReason: skipReasonGroupCreationFailed,
+		},
+		"dry run": {
+			setup: func(r *Runner, _ *fakeKeycloakClient, _ *fakeGsuiteClient) {
+				r.dryRun = true
+			},
+			wantReason: skipReasonDryRun,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"),
+				Username: gocloak.StringP("bob@corp.com"), Email: gocloak.StringP("bob@corp.com")})
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			tc.setup(r, kc, gs)
+			r.reconcileUserGroups()
+
+			if reasons := loggedSkipReasons(t, logs); !slices.Contains(reasons, tc.wantReason) {
+				t.Fatalf("got reasons %v, want %q among them", reasons, tc.wantReason)
+			}
+		})
+	}
+}
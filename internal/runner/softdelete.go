@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"strings"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// removalKey returns the key a deferred membership removal is tracked by
+func removalKey(userID, groupID string) string {
+	return userID + "/" + groupID
+}
+
+// removalDue reports whether the removal of a membership has been planned for the whole grace period. Removals
+// planned for the first time are recorded, except on dry-run, and are never due unless there is no grace period.
+// The time left is returned for those not due yet
+func (r *Runner) removalDue(key string) (due bool, remaining time.Duration) {
+	if r.softDeleteGrace <= 0 {
+		return true, 0
+	}
+
+	now := r.clock.Now()
+	plannedAt, found := r.pendingRemovals[r.realm][key]
+	if !found {
+		if r.dryRun {
+			return false, r.softDeleteGrace
+		}
+		if r.pendingRemovals[r.realm] == nil {
+			r.pendingRemovals[r.realm] = map[string]time.Time{}
+		}
+		r.pendingRemovals[r.realm][key] = now
+		plannedAt = now
+	}
+
+	remaining = plannedAt.Add(r.softDeleteGrace).Sub(now)
+	return remaining <= 0, remaining
+}
+
+// removalPending reports whether the removal of any membership of the group is deferred. Such a group must
+// not be pruned meanwhile, as that would drop the membership the grace period is holding back
+func (r *Runner) removalPending(groupID string) bool {
+	for key := range r.pendingRemovals[r.realm] {
+		if _, pendingGroupID, _ := strings.Cut(key, "/"); pendingGroupID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelRemoval forgets the deferred removal of a membership wanted again. It reports whether there was one
+func (r *Runner) cancelRemoval(key string) bool {
+	if _, found := r.pendingRemovals[r.realm][key]; !found || r.dryRun {
+		return false
+	}
+	delete(r.pendingRemovals[r.realm], key)
+	return true
+}
+
+// forgetStaleRemovals drops the deferred removals of the compared users the cycle did not plan again, such as
+// memberships removed by hand meanwhile or already removed. Users not compared keep theirs
+func (r *Runner) forgetStaleRemovals(comparedUsers map[string]struct{}, plannedRemovals map[string]struct{}) {
+	if r.dryRun {
+		return
+	}
+	for key := range r.pendingRemovals[r.realm] {
+		userID, _, _ := strings.Cut(key, "/")
+		if _, compared := comparedUsers[userID]; !compared {
+			continue
+		}
+		if _, planned := plannedRemovals[key]; !planned {
+			delete(r.pendingRemovals[r.realm], key)
+		}
+	}
+}
+
+// forgetOrphanedRemovals drops the deferred removals of the users no longer listed, such as those deleted meanwhile,
+// and of the groups no longer synced. Neither is compared again, so their removals would be pending forever,
+// holding the pruning of their groups back. It must only run on cycles listing every user
+func (r *Runner) forgetOrphanedRemovals(listedUsers map[string]struct{}, kcChildrenGroupsByID map[string]*gocloak.Group) {
+	if r.dryRun {
+		return
+	}
+	for key := range r.pendingRemovals[r.realm] {
+		userID, groupID, _ := strings.Cut(key, "/")
+		_, listed := listedUsers[userID]
+		_, synced := kcChildrenGroupsByID[groupID]
+		if !listed || !synced {
+			delete(r.pendingRemovals[r.realm], key)
+		}
+	}
+}
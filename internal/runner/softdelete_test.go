@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Stale memberships must only be removed once Gsuite has left them out for the whole grace period.
+func TestReconcileUserGroupsSoftDeleteDefersRemovals(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	clock := newFakeClock()
+	r.clock = clock
+	r.softDeleteGrace = time.Hour
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.deletions) > 0 {
+		t.Fatalf("expected the removal to be deferred, got deletions %v", kc.deletions)
+	}
+	if r.cycleStats.membershipsDeferred != 1 {
+		t.Fatalf("got %d deferred removals, want 1", r.cycleStats.membershipsDeferred)
+	}
+
+	// Additions are never deferred
+	if want := []string{"alice-id:id-new@corp.com"}; !reflect.DeepEqual(kc.additions, want) {
+		t.Fatalf("additions %v, want %v", kc.additions, want)
+	}
+
+	clock.now = clock.now.Add(59 * time.Minute)
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.deletions) > 0 {
+		t.Fatalf("expected the removal to be deferred within the grace period, got deletions %v", kc.deletions)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v once the grace period expired", kc.deletions, want)
+	}
+
+	// Once removed, the membership is no longer planned, so nothing is left pending
+	kc.userGroups["alice-id"] = kc.userGroups["alice-id"][1:]
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.pendingRemovals[r.realm]) > 0 {
+		t.Fatalf("expected no pending removals left, got %v", r.pendingRemovals[r.realm])
+	}
+}
+
+// A membership wanted again in Gsuite before the grace period expires must be kept, and its removal
+// planned from scratch if it goes away once more.
+func TestReconcileUserGroupsSoftDeleteCancelsRemovals(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	clock := newFakeClock()
+	r.clock = clock
+	r.softDeleteGrace = time.Hour
+
+	r.reconcileUserGroups()
+	if len(r.pendingRemovals[r.realm]) != 1 {
+		t.Fatalf("expected one pending removal, got %v", r.pendingRemovals[r.realm])
+	}
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	gs.groupsByDomain["corp.com"] = []string{"new@corp.com", "old@corp.com"}
+	r.reconcileUserGroups()
+	if len(r.pendingRemovals[r.realm]) > 0 {
+		t.Fatalf("expected the pending removal to be cancelled, got %v", r.pendingRemovals[r.realm])
+	}
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	gs.groupsByDomain["corp.com"] = []string{"new@corp.com"}
+	r.reconcileUserGroups()
+	if len(kc.deletions) > 0 {
+		t.Fatalf("expected the grace period to start over, got deletions %v", kc.deletions)
+	}
+}
+
+// Dry-runs must report deferred removals without recording them, so they never bring a removal closer.
+func TestReconcileUserGroupsSoftDeleteIgnoresDryRun(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	r.clock = newFakeClock()
+	r.softDeleteGrace = time.Hour
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.cycleStats.membershipsDeferred != 1 {
+		t.Fatalf("got %d deferred removals, want 1", r.cycleStats.membershipsDeferred)
+	}
+	if len(r.pendingRemovals[r.realm]) > 0 {
+		t.Fatalf("expected nothing recorded on dry-run, got %v", r.pendingRemovals[r.realm])
+	}
+}
+
+// A group Gsuite no longer has must not be pruned while the removal of its members is deferred, as pruning
+// would drop the memberships the grace period is holding back.
+func TestReconcileUserGroupsSoftDeleteDefersPruning(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	clock := newFakeClock()
+	r.clock = clock
+	r.softDeleteGrace = 24 * time.Hour
+	r.pruneGroups = true
+
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kc.deletions)+len(kc.pruned) > 0 {
+		t.Fatalf("expected the removal and the pruning to be deferred, got deletions %v, pruned %v", kc.deletions, kc.pruned)
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v once the grace period expired", kc.deletions, want)
+	}
+
+	// Once the membership is gone, nothing holds the group back anymore
+	kc.userGroups["alice-id"] = kc.userGroups["alice-id"][1:]
+	if err := r.reconcileUserGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"id-old@corp.com"}; !reflect.DeepEqual(kc.pruned, want) {
+		t.Fatalf("pruned %v, want %v", kc.pruned, want)
+	}
+}
+
+// Removals deferred for users or groups gone meanwhile must be dropped, so they never hold the pruning back.
+func TestReconcileUserGroupsSoftDeleteForgetsOrphanedRemovals(t *testing.T) {
+	tests := map[string]struct {
+		remove     func(kc *fakeKeycloakClient)
+		wantPruned []string
+	}{
+		"user deleted": {
+			remove:     func(kc *fakeKeycloakClient) { kc.users = nil },
+			wantPruned: []string{"id-old@corp.com"},
+		},
+		"group deleted": {
+			remove: func(kc *fakeKeycloakClient) { kc.children = nil },
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.clock = newFakeClock()
+			r.softDeleteGrace = time.Hour
+			r.pruneGroups = true
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(r.pendingRemovals[r.realm]) != 1 {
+				t.Fatalf("expected the removal to be deferred, got %v", r.pendingRemovals[r.realm])
+			}
+
+			tc.remove(kc)
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(r.pendingRemovals[r.realm]) > 0 {
+				t.Fatalf("expected no deferred removals left, got %v", r.pendingRemovals[r.realm])
+			}
+			if !reflect.DeepEqual(kc.pruned, tc.wantPruned) {
+				t.Fatalf("pruned %v, want %v", kc.pruned, tc.wantPruned)
+			}
+		})
+	}
+}
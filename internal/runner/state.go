@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// groupState caches the synced groups of every realm between cycles and restarts, so warm cycles skip listing
+// them. Whole groups are kept rather than their IDs alone, as reconciling needs their attributes too
+type groupState struct {
+	path string
+
+	// Groups are keyed by realm and synced parent group ID, as given by groupStateKey
+	Groups map[string][]*gocloak.Group `json:"groups"`
+
+	// PendingRemovals is shared with the runner, which keeps its deferred membership removals in it
+	PendingRemovals map[string]map[string]time.Time `json:"pending_removals,omitempty"`
+}
+
+// loadGroupState reads the state file, starting empty when it does not exist yet
+func loadGroupState(path string) (*groupState, error) {
+	state := &groupState{path: path}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, state); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.Groups == nil {
+		state.Groups = map[string][]*gocloak.Group{}
+	}
+	if state.PendingRemovals == nil {
+		state.PendingRemovals = map[string]map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// save writes the whole state to its file
+func (s *groupState) save() error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, content)
+}
+
+// groupStateKey returns the key the synced groups hanging from a parent group of a realm are cached by
+func groupStateKey(realm, parentGroupID string) string {
+	return realm + "/" + parentGroupID
+}
+
+// groupsByIdentity keys the groups by the identity of the Gsuite group each one mirrors
+func groupsByIdentity(groups []*gocloak.Group) map[string]*gocloak.Group {
+	byIdentity := map[string]*gocloak.Group{}
+	for _, group := range groups {
+		byIdentity[identityOf(group)] = group
+	}
+	return byIdentity
+}
+
+// getSyncedChildrenGroups returns the children of the synced parent group keyed by identity. They are read
+// from the state file when it holds them, and listed from Keycloak otherwise
+func (r *Runner) getSyncedChildrenGroups(parentGroupID string) (map[string]*gocloak.Group, error) {
+	r.groupStateKey = groupStateKey(r.realm, parentGroupID)
+	r.childrenFromState = false
+
+	if r.groupState != nil {
+		if cached, found := r.groupState.Groups[r.groupStateKey]; found {
+			r.appCtx.Logger.Debug("synced groups read from state file", "groups", len(cached))
+			r.childrenFromState = true
+			return groupsByIdentity(cached), nil
+		}
+	}
+
+	return r.getChildrenGroupsByIdentity(parentGroupID)
+}
+
+// refreshSyncedChildrenGroups lists the synced groups from Keycloak when they were read from the state file,
+// as a group missing there may have been created since. Both maps are updated in place
+func (r *Runner) refreshSyncedChildrenGroups(parentGroupID string, byIdentity, byID map[string]*gocloak.Group) error {
+	if !r.childrenFromState {
+		return nil
+	}
+
+	r.appCtx.Logger.Debug("group missing from state file. Listing synced groups again...")
+	listed, err := r.getChildrenGroupsByIdentity(parentGroupID)
+	if err != nil {
+		return err
+	}
+	r.childrenFromState = false
+
+	// Groups only planned on dry-run are not in Keycloak, so they are kept
+	for identity, kcGroup := range byIdentity {
+		if _, found := listed[identity]; !found && kcGroup.ID == nil {
+			listed[identity] = kcGroup
+		}
+	}
+
+	clear(byIdentity)
+	maps.Copy(byIdentity, listed)
+	clear(byID)
+	for _, kcGroup := range listed {
+		if kcGroup.ID != nil {
+			byID[*kcGroup.ID] = kcGroup
+		}
+	}
+	return nil
+}
+
+// saveGroupState caches the synced groups of the current realm as the cycle left them. Nothing is saved on
+// dry-run, as planned groups do not exist, nor after the cached ones were invalidated during the cycle
+func (r *Runner) saveGroupState(groups map[string]*gocloak.Group) {
+	if r.groupState == nil || r.dryRun || r.groupStateKey == "" {
+		return
+	}
+
+	r.groupState.Groups[r.groupStateKey] = sortedByName(groups)
+	if err := r.groupState.save(); err != nil {
+		r.appCtx.Logger.Error("failed writing state file", "path", r.groupState.path, "error", err.Error())
+	}
+}
+
+// invalidateGroupState drops the cached groups of the current realm, such as when one of them is gone,
+// so the next cycle lists them from Keycloak again
+func (r *Runner) invalidateGroupState() {
+	if r.groupState == nil || r.groupStateKey == "" {
+		return
+	}
+
+	r.appCtx.Logger.Info("synced group not found in Keycloak. Dropping cached groups...")
+	delete(r.groupState.Groups, r.groupStateKey)
+	r.groupStateKey = ""
+	if err := r.groupState.save(); err != nil {
+		r.appCtx.Logger.Error("failed writing state file", "path", r.groupState.path, "error", err.Error())
+	}
+}
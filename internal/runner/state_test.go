@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// newSyncedGroup returns a synced group with the given ID mirroring the Gsuite group.
+func newSyncedGroup(id, gsuiteGroup string) *gocloak.Group {
+	return &gocloak.Group{
+		ID:   gocloak.StringP(id),
+		Name: gocloak.StringP(gsuiteGroup),
+		Attributes: &map[string][]string{
+			GroupAttributeManaged:     {"true"},
+			GroupAttributeSourceGroup: {gsuiteGroup},
+		},
+	}
+}
+
+// cachedGroupIDs returns the IDs of the groups cached in the state file for the test realm, or nil when there are none.
+func cachedGroupIDs(t *testing.T, path string) (ids []string) {
+	t.Helper()
+
+	state, err := loadGroupState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, group := range state.Groups[groupStateKey("test", "id-parent")] {
+		ids = append(ids, *group.ID)
+	}
+	return ids
+}
+
+// Synced groups must be read from the state file when it holds them, listed again when a group misses there,
+// and dropped from it when a membership change finds a group gone.
+func TestReconcileUserGroupsWithStateFile(t *testing.T) {
+	oldGroup := &gocloak.Group{ID: gocloak.StringP("id-old@corp.com"), Name: gocloak.StringP("old@corp.com"),
+		Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}}
+
+	tests := map[string]struct {
+		cached         []*gocloak.Group
+		children       []*gocloak.Group
+		membershipErrs map[string]error
+		wantListings   int
+		wantCreated    []string
+		wantAdditions  []string
+		wantCachedIDs  []string
+	}{
+		"cold start": {
+			children:      []*gocloak.Group{oldGroup},
+			wantListings:  1,
+			wantCreated:   []string{"new@corp.com"},
+			wantAdditions: []string{"alice-id:id-new@corp.com"},
+			wantCachedIDs: []string{"id-new@corp.com", "id-old@corp.com"},
+		},
+		"cache hit": {
+			cached:        []*gocloak.Group{oldGroup, newSyncedGroup("id-cached", "new@corp.com")},
+			children:      []*gocloak.Group{oldGroup},
+			wantAdditions: []string{"alice-id:id-cached"},
+			wantCachedIDs: []string{"id-cached", "id-old@corp.com"},
+		},
+		"cache miss": {
+			cached:        []*gocloak.Group{oldGroup},
+			children:      []*gocloak.Group{oldGroup, newSyncedGroup("id-created-meanwhile", "new@corp.com")},
+			wantListings:  1,
+			wantAdditions: []string{"alice-id:id-created-meanwhile"},
+			wantCachedIDs: []string{"id-created-meanwhile", "id-old@corp.com"},
+		},
+		"cached group gone": {
+			cached:         []*gocloak.Group{oldGroup, newSyncedGroup("id-cached", "new@corp.com")},
+			children:       []*gocloak.Group{oldGroup},
+			membershipErrs: map[string]error{"id-cached": &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.children = tc.children
+			kc.membershipErrs = tc.membershipErrs
+
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tc.cached != nil {
+				state := &groupState{path: path, Groups: map[string][]*gocloak.Group{groupStateKey("test", "id-parent"): tc.cached}}
+				if err := state.save(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			var err error
+			if r.groupState, err = loadGroupState(path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r.ReconcileOnce()
+
+			if kc.childrenListings != tc.wantListings {
+				t.Fatalf("listed children groups %d times, want %d", kc.childrenListings, tc.wantListings)
+			}
+			if !reflect.DeepEqual(kc.created, tc.wantCreated) {
+				t.Fatalf("created %v, want %v", kc.created, tc.wantCreated)
+			}
+			if tc.membershipErrs == nil && !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if got := cachedGroupIDs(t, path); !reflect.DeepEqual(got, tc.wantCachedIDs) {
+				t.Fatalf("cached %v, want %v", got, tc.wantCachedIDs)
+			}
+		})
+	}
+}
+
+// Refreshing the provenance of a group read from the state file must merge into the group as Keycloak has it,
+// so renames and attributes changed by hand since it was cached are kept.
+func TestReconcileUserGroupsStateFileKeepsServerChanges(t *testing.T) {
+	kc, gs := newFakeRealm()
+	serverGroup := newSyncedGroup("id-old@corp.com", "old@corp.com")
+	serverGroup.Name = gocloak.StringP("legacy")
+	(*serverGroup.Attributes)["owner"] = []string{"platform"}
+	kc.children = []*gocloak.Group{serverGroup}
+	gs.groupsByDomain["corp.com"] = []string{"old@corp.com"}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	cached := newSyncedGroup("id-old@corp.com", "old@corp.com")
+	state := &groupState{path: path, Groups: map[string][]*gocloak.Group{groupStateKey("test", "id-parent"): {cached}}}
+	if err := state.save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	var err error
+	if r.groupState, err = loadGroupState(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kc.childrenListings != 0 {
+		t.Fatalf("listed children groups %d times, want the cached ones used", kc.childrenListings)
+	}
+	if len(kc.updated) != 1 {
+		t.Fatalf("updated %d groups, want 1", len(kc.updated))
+	}
+
+	updated := kc.updated[0]
+	if *updated.Name != "legacy" {
+		t.Fatalf("group renamed back to %q", *updated.Name)
+	}
+	if owner := (*updated.Attributes)["owner"]; !reflect.DeepEqual(owner, []string{"platform"}) {
+		t.Fatalf("hand-made attributes were lost: %v", updated.Attributes)
+	}
+	assertProvenance(t, updated, "old@corp.com")
+}
+
+// Dry-runs must leave the state file alone, as the groups they plan do not exist.
+func TestReconcileUserGroupsDryRunLeavesStateFile(t *testing.T) {
+	kc, gs := newFakeRealm()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, true)
+	var err error
+	if r.groupState, err = loadGroupState(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.ReconcileOnce(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no state file, got %v", err)
+	}
+}
+
+// A state file that can not be decoded must be reported rather than silently replaced.
+func TestLoadGroupStateRejectsInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := loadGroupState(path); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
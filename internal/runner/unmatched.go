@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// logUnmatchedMembers warns, once per synced Gsuite group, about the members that have no Keycloak user
+// and therefore never get the membership. Prefetched memberships are reused when available; otherwise
+// members are listed per group. Being a diagnostic, a failed listing is logged without failing the cycle
+func (r *Runner) logUnmatchedMembers(gsuiteGroups []string, kcUsers []*gocloak.User, prefetchedMemberships map[string][]string) {
+
+	kcUserKeys := map[string]struct{}{}
+	for _, kcUser := range kcUsers {
+		if userKey := r.getUserMatchKey(kcUser); userKey != "" {
+			kcUserKeys[strings.ToLower(userKey)] = struct{}{}
+		}
+	}
+
+	var prefetchedMembers map[string][]string
+	if prefetchedMemberships != nil {
+		prefetchedMembers = map[string][]string{}
+		for member, groups := range prefetchedMemberships {
+			for _, group := range groups {
+				prefetchedMembers[groupIdentity(group)] = append(prefetchedMembers[groupIdentity(group)], member)
+			}
+		}
+	}
+
+	for _, gsuiteGroup := range gsuiteGroups {
+		var members []string
+		if prefetchedMembers != nil {
+			members = prefetchedMembers[groupIdentity(gsuiteGroup)]
+		} else {
+			err := r.withRetry(func() (err error) {
+				members, err = r.gsuiteCli.GetUsersFromGroup(gsuiteGroup)
+				return err
+			})
+			if err != nil {
+				r.appCtx.Logger.Warn("failed listing group members to report unmatched ones", "group", gsuiteGroup, "error", err.Error())
+				continue
+			}
+		}
+
+		unmatched := map[string]struct{}{}
+		for _, member := range members {
+			if _, found := kcUserKeys[strings.ToLower(member)]; !found {
+				unmatched[strings.ToLower(member)] = struct{}{}
+			}
+		}
+
+		if len(unmatched) > 0 {
+			r.appCtx.Logger.Warn("group members without Keycloak user", "group", gsuiteGroup,
+				"members", slices.Sorted(maps.Keys(unmatched)))
+		}
+	}
+}
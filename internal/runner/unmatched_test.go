@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Google members without a Keycloak user must be reported once per synced group, and only when asked.
+func TestReconcileUserGroupsReportsUnmatchedMembers(t *testing.T) {
+	tests := map[string]struct {
+		enabled  bool
+		prefetch bool
+	}{
+		"disabled":                   {},
+		"listing members per group":  {enabled: true},
+		"reusing prefetched members": {enabled: true, prefetch: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, _ := newFakeRealm()
+			gs := &fakeDirectory{membersByDomain: map[string]map[string][]string{
+				"corp.com": {"new@corp.com": {"alice@corp.com", "Carol@corp.com"}},
+			}}
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+			r.reportUnmatchedMembers = tc.enabled
+			r.gsuitePrefetch = tc.prefetch
+
+			if err := r.reconcileUserGroups(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			output := logs.String()
+			reported := strings.Count(output, `"msg":"group members without Keycloak user"`)
+			if !tc.enabled {
+				if reported != 0 {
+					t.Fatalf("expected no report, got %s", output)
+				}
+				return
+			}
+
+			if reported != 1 {
+				t.Fatalf("got %d reports, want 1: %s", reported, output)
+			}
+			if !strings.Contains(output, `"group":"new@corp.com","members":["carol@corp.com"]`) {
+				t.Fatalf("expected carol to be reported alone, got %s", output)
+			}
+		})
+	}
+}
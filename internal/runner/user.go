@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+	"kegos/internal/metrics"
+)
+
+// ErrUserNotFound is returned by ReconcileUser when no realm holds the given user
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserReconcileUnsupported is returned by ReconcileUser when groups are not synced from Gsuite into Keycloak
+var ErrUserReconcileUnsupported = errors.New("single user reconcile only supports syncing groups from Gsuite into Keycloak")
+
+// ReconcileUser reconciles the groups of a single user, given by username or email, on every realm holding it,
+// without waiting for the next cycle. Steps spanning the whole realm, such as pruning, are left to full cycles.
+// It waits for the running cycle, if any, to finish
+func (r *Runner) ReconcileUser(identifier string) (err error) {
+	if r.syncTarget == SyncTargetRoles || r.writesToGsuite() {
+		return ErrUserReconcileUnsupported
+	}
+
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return errors.New("user identifier is empty")
+	}
+
+	r.cycleMu.Lock()
+	defer r.cycleMu.Unlock()
+
+	defer r.startCycle()()
+	r.realmReports = nil
+
+	err = r.ensureGsuiteToken()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	err = r.forEachRealm(func(_ string) error {
+		err := r.keycloak.EnsureToken()
+		if err != nil {
+			metrics.Errors.WithLabelValues(metrics.StageKeycloak).Inc()
+			return fmt.Errorf("failed renewing Keycloak token: %w", err)
+		}
+
+		user, err := r.lookupUser(identifier)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			r.appCtx.Logger.Debug("user not found in realm. Skipping realm...", "user", identifier)
+			return nil
+		}
+		found = true
+
+		r.scopedUser = user
+		defer func() {
+			r.scopedUser = nil
+		}()
+		return r.reconcileUserGroups()
+	})
+	if err == nil && !found {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, identifier)
+	}
+	return err
+}
+
+// lookupUser returns the user of the realm with the given username, or with the given email otherwise.
+// It returns nil when there is none
+func (r *Runner) lookupUser(identifier string) (user *gocloak.User, err error) {
+	err = r.withRetry(func() (err error) {
+		user, err = r.keycloak.GetUserByUsername(r.keycloak.GetToken().AccessToken, identifier)
+		if err != nil || user != nil {
+			return err
+		}
+		user, err = r.keycloak.GetUserByEmail(r.keycloak.GetToken().AccessToken, identifier)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed looking up user: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// A single user must be reconciled when given by username or email, leaving every other user alone.
+func TestReconcileUser(t *testing.T) {
+	tests := map[string]struct {
+		identifier    string
+		wantErr       error
+		wantAdditions []string
+		wantDeletions []string
+	}{
+		"by username": {
+			identifier:    "alice@corp.com",
+			wantAdditions: []string{"alice-id:id-new@corp.com"},
+			wantDeletions: []string{"alice-id:id-old@corp.com"},
+		},
+		"by email": {
+			identifier:    "BOB@corp.com",
+			wantAdditions: []string{"bob-id:id-new@corp.com"},
+			wantDeletions: []string{"bob-id:id-old@corp.com"},
+		},
+		"unknown user": {
+			identifier: "carol@corp.com",
+			wantErr:    ErrUserNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			kc.users = append(kc.users, &gocloak.User{ID: gocloak.StringP("bob-id"), Username: gocloak.StringP("bob"), Email: gocloak.StringP("bob@corp.com")})
+			kc.userGroups["bob-id"] = kc.userGroups["alice-id"]
+
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+			err := r.ReconcileUser(tc.identifier)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(kc.additions, tc.wantAdditions) {
+				t.Fatalf("additions %v, want %v", kc.additions, tc.wantAdditions)
+			}
+			if !reflect.DeepEqual(kc.deletions, tc.wantDeletions) {
+				t.Fatalf("deletions %v, want %v", kc.deletions, tc.wantDeletions)
+			}
+			if r.scopedUser != nil {
+				t.Fatalf("the runner is still scoped to %s", *r.scopedUser.Username)
+			}
+		})
+	}
+}
+
+// Steps spanning the whole realm must be left to full cycles: nothing is pruned nor held back by the deletion
+// limit, and the user is compared again by the next incremental cycle.
+func TestReconcileUserSkipsRealmWideSteps(t *testing.T) {
+	kc, gs := newFakeRealm()
+	kc.children = append(kc.children, &gocloak.Group{ID: gocloak.StringP("id-gone@corp.com"), Name: gocloak.StringP("gone@corp.com"),
+		Attributes: &map[string][]string{GroupAttributeManaged: {"true"}}})
+
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+	r.pruneGroups = true
+	r.deletionLimit = deletionLimit{percent: 10}
+	r.incremental = true
+	r.userSnapshots["test"] = map[string]string{"alice@corp.com": "stale", "bob": "kept"}
+
+	if err := r.ReconcileUser("alice@corp.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(kc.pruned) != 0 {
+		t.Fatalf("pruned %v, want nothing pruned", kc.pruned)
+	}
+	if want := []string{"alice-id:id-old@corp.com"}; !reflect.DeepEqual(kc.deletions, want) {
+		t.Fatalf("deletions %v, want %v", kc.deletions, want)
+	}
+	if want := map[string]string{"bob": "kept"}; !reflect.DeepEqual(r.userSnapshots["test"], want) {
+		t.Fatalf("snapshots %v, want %v", r.userSnapshots["test"], want)
+	}
+}
+
+// Only syncing groups from Gsuite into Keycloak can be done one user at a time.
+func TestReconcileUserUnsupported(t *testing.T) {
+	tests := map[string]struct {
+		syncTarget string
+		direction  string
+	}{
+		"roles":              {syncTarget: SyncTargetRoles},
+		"keycloak to google": {direction: DirectionKeycloakToGoogle},
+		"bidirectional":      {direction: DirectionBidirectional},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+			r.syncTarget = tc.syncTarget
+			r.direction = tc.direction
+
+			if err := r.ReconcileUser("alice@corp.com"); !errors.Is(err, ErrUserReconcileUnsupported) {
+				t.Fatalf("got error %v, want %v", err, ErrUserReconcileUnsupported)
+			}
+		})
+	}
+}
+
+// Users must be reconcilable while the loop waits for its next cycle, which keeps running afterwards.
+func TestReconcileUserWhileLooping(t *testing.T) {
+	kc, gs := newFakeRealm()
+	r := newTestRunner(kc, gs, &bytes.Buffer{}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.appCtx.Context = ctx
+
+	clock := newFakeClock()
+	r.clock = clock
+	r.reconcileLoopDuration = 10 * time.Minute
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.PleaseDoYourStuffForever()
+	}()
+
+	for cycle := range 2 {
+		select {
+		case wait := <-clock.waits:
+			if err := r.ReconcileUser("alice@corp.com"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cycle == 1 {
+				cancel()
+				break
+			}
+			clock.advance(wait)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cycle %d never finished", cycle+1)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the loop did not stop once cancelled")
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"fmt"
+
+	//
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Validate checks every credential and permission a reconcile relies on, without diffing nor changing anything:
+// the Gsuite token and a directory read for each domain, then a Keycloak login and a read of the users and
+// of the synced parent groups, or of the realm roles, for each realm.
+// A synced parent group not created yet is no failure, as the first cycle creates it
+func (r *Runner) Validate() error {
+	err := r.ensureGsuiteToken()
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range r.gsuiteDomains {
+		if err = r.withRetry(func() error { return r.gsuiteCli.Validate(domain) }); err != nil {
+			return withStage(ErrGsuite, fmt.Errorf("failed validating gsuite access: %w", err))
+		}
+		r.appCtx.Logger.Info("gsuite directory readable", "domain", domain)
+	}
+
+	return r.forEachRealm(func(string) error {
+		return r.validateRealm()
+	})
+}
+
+// validateRealm checks the Keycloak realm the runner currently points to can be logged into and read
+func (r *Runner) validateRealm() error {
+	err := r.keycloak.EnsureToken()
+	if err != nil {
+		return withStage(ErrKeycloakAuth, fmt.Errorf("failed renewing Keycloak token: %w", err))
+	}
+
+	var kcUsers []*gocloak.User
+	err = r.withRetry(func() (err error) {
+		kcUsers, err = r.keycloak.GetUsers(r.keycloak.GetToken().AccessToken)
+		return err
+	})
+	if err != nil {
+		return withStage(ErrKeycloakRead, fmt.Errorf("failed getting users from Keycloak: %w", err))
+	}
+	r.appCtx.Logger.Info("keycloak users readable", "users", len(kcUsers))
+
+	if r.syncTarget == SyncTargetRoles {
+		var kcRoles []*gocloak.Role
+		err = r.withRetry(func() (err error) {
+			kcRoles, err = r.keycloak.GetRealmRoles(r.keycloak.GetToken().AccessToken)
+			return err
+		})
+		if err != nil {
+			return withStage(ErrKeycloakRead, fmt.Errorf("failed getting realm roles from Keycloak: %w", err))
+		}
+		r.appCtx.Logger.Info("keycloak realm roles readable", "roles", len(kcRoles))
+		return nil
+	}
+
+	for _, parentPath := range r.syncedParents() {
+		kcParentGroup, depth, err := r.findSyncedParentGroup(parentPath)
+		if err != nil {
+			return withStage(ErrKeycloakRead, fmt.Errorf("failed getting parent group: %w", err))
+		}
+		if depth < len(parentPath) {
+			r.appCtx.Logger.Info("synced parent group not found. The first cycle creates it", "group", groupPathString(parentPath))
+			continue
+		}
+
+		kcChildrenGroups, err := r.keycloak.GetChildrenGroups(r.keycloak.GetToken().AccessToken, *kcParentGroup.ID)
+		if err != nil {
+			return withStage(ErrKeycloakRead, fmt.Errorf("failed getting children groups: %w", err))
+		}
+		r.appCtx.Logger.Info("synced parent group resolved", "group", groupPathString(parentPath),
+			"children", len(kcChildrenGroups))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Validation must check every credential and read, failing with the kind of the first one broken,
+// and never write anything.
+func TestValidate(t *testing.T) {
+	apiErr := errors.New("boom")
+	tests := map[string]struct {
+		setup    func(kc *fakeKeycloakClient, gs *fakeGsuiteClient)
+		wantKind error
+		wantLog  string
+	}{
+		"everything reachable": {
+			setup:   func(*fakeKeycloakClient, *fakeGsuiteClient) {},
+			wantLog: "synced parent group resolved",
+		},
+		"parent group not created yet": {
+			setup:   func(kc *fakeKeycloakClient, _ *fakeGsuiteClient) { kc.parent = nil },
+			wantLog: "synced parent group not found",
+		},
+		"gsuite token broken": {
+			setup:    func(_ *fakeKeycloakClient, gs *fakeGsuiteClient) { gs.tokenErr = apiErr },
+			wantKind: ErrGsuite,
+		},
+		"gsuite directory unreadable": {
+			setup:    func(_ *fakeKeycloakClient, gs *fakeGsuiteClient) { gs.validateErr = apiErr },
+			wantKind: ErrGsuite,
+		},
+		"keycloak login rejected": {
+			setup:    func(kc *fakeKeycloakClient, _ *fakeGsuiteClient) { kc.tokenErr = apiErr },
+			wantKind: ErrKeycloakAuth,
+		},
+		"keycloak users unreadable": {
+			setup:    func(kc *fakeKeycloakClient, _ *fakeGsuiteClient) { kc.usersErr = apiErr },
+			wantKind: ErrKeycloakRead,
+		},
+		"keycloak groups unreadable": {
+			setup:    func(kc *fakeKeycloakClient, _ *fakeGsuiteClient) { kc.goneGroups = map[string]bool{"id-parent": true} },
+			wantKind: ErrKeycloakRead,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc, gs := newFakeRealm()
+			tc.setup(kc, gs)
+
+			logs := &bytes.Buffer{}
+			r := newTestRunner(kc, gs, logs, false)
+
+			// Rebuilding the Gsuite client from the same credentials does not fix them
+			r.newGsuiteCli = func() (gsuiteClient, error) { return gs, nil }
+
+			err := r.Validate()
+
+			if tc.wantKind == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.Contains(logs.String(), tc.wantLog) {
+					t.Fatalf("expected %q in logs:\n%s", tc.wantLog, logs.String())
+				}
+			} else if !errors.Is(err, tc.wantKind) {
+				t.Fatalf("got error %v, want kind %v", err, tc.wantKind)
+			}
+
+			if len(kc.created)+len(kc.additions)+len(kc.deletions)+len(kc.pruned) > 0 {
+				t.Fatalf("unexpected writes: created %v, additions %v, deletions %v, pruned %v",
+					kc.created, kc.additions, kc.deletions, kc.pruned)
+			}
+		})
+	}
+}
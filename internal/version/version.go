@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package version
+
+import (
+	"fmt"
+)
+
+// Build metadata, set at build time through -ldflags, such as:
+// -X kegos/internal/version.Version=v1.2.3 -X kegos/internal/version.Commit=abc1234 -X kegos/internal/version.Date=2026-01-01T00:00:00Z
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String returns the build metadata on a single line, as printed by the version command
+func String() string {
+	return fmt.Sprintf("kegos %s (commit %s, built %s)", Version, Commit, Date)
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package version
+
+import (
+	"testing"
+)
+
+// The version line must name every piece of build metadata, whether set at build time or left as defaults.
+func TestString(t *testing.T) {
+	tests := map[string]struct {
+		version, commit, date string
+		want                  string
+	}{
+		"defaults": {
+			version: "dev", commit: "unknown", date: "unknown",
+			want: "kegos dev (commit unknown, built unknown)",
+		},
+		"set at build time": {
+			version: "v1.2.3", commit: "abc1234", date: "2026-01-01T00:00:00Z",
+			want: "kegos v1.2.3 (commit abc1234, built 2026-01-01T00:00:00Z)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			previousVersion, previousCommit, previousDate := Version, Commit, Date
+			t.Cleanup(func() { Version, Commit, Date = previousVersion, previousCommit, previousDate })
+			Version, Commit, Date = tc.version, tc.commit, tc.date
+
+			if got := String(); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}